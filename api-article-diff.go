@@ -0,0 +1,153 @@
+/*
+   /article/diff  GET  [draft/version/publish (read) x 2]  no lock
+
+   from/to is either a version number or "draft"/"publish", e.g.
+   /api/article/diff?id=guid&from=1500000000000000000&to=draft
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+type ArticleFieldDiff struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Changed bool   `json:"changed"`
+}
+
+type ArticleTagDiff struct {
+	From    []string `json:"from"`
+	To      []string `json:"to"`
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed bool     `json:"changed"`
+}
+
+type ArticleContentDiff struct {
+	Changed bool      `json:"changed"`
+	Lines   []*DiffOp `json:"lines"`
+	Words   []*DiffOp `json:"words"`
+}
+
+type ArticleDiff struct {
+	Guid     string              `json:"guid"`
+	From     string              `json:"from"`
+	To       string              `json:"to"`
+	Headline *ArticleFieldDiff   `json:"headline"`
+	Summary  *ArticleFieldDiff   `json:"summary"`
+	Note     *ArticleFieldDiff   `json:"note"`
+	Tag      *ArticleTagDiff     `json:"tag"`
+	Content  *ArticleContentDiff `json:"content"`
+}
+
+func diffArticleField(from, to string) *ArticleFieldDiff {
+	return &ArticleFieldDiff{
+		From:    from,
+		To:      to,
+		Changed: from != to,
+	}
+}
+
+func diffArticleTag(from, to []string) *ArticleTagDiff {
+	if from == nil {
+		from = []string{}
+	}
+	if to == nil {
+		to = []string{}
+	}
+	fromSet := make(map[string]bool)
+	for _, t := range from {
+		fromSet[t] = true
+	}
+	toSet := make(map[string]bool)
+	for _, t := range to {
+		toSet[t] = true
+	}
+	d := &ArticleTagDiff{
+		From:    from,
+		To:      to,
+		Added:   make([]string, 0),
+		Removed: make([]string, 0),
+	}
+	for t := range toSet {
+		if !fromSet[t] {
+			d.Added = append(d.Added, t)
+		}
+	}
+	for t := range fromSet {
+		if !toSet[t] {
+			d.Removed = append(d.Removed, t)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	d.Changed = len(d.Added) > 0 || len(d.Removed) > 0
+	return d
+}
+
+func diffArticles(from, to *Article) *ArticleDiff {
+	lines := DiffLines(from.Content, to.Content)
+	return &ArticleDiff{
+		Headline: diffArticleField(from.Headline, to.Headline),
+		Summary:  diffArticleField(from.Summary, to.Summary),
+		Note:     diffArticleField(from.Note, to.Note),
+		Tag:      diffArticleTag(from.Tag, to.Tag),
+		Content: &ArticleContentDiff{
+			Changed: from.Content != to.Content,
+			Lines:   lines,
+			Words:   DiffWordsInLines(lines),
+		},
+	}
+}
+
+// loads the draft, the publish or the given version of the article
+func getArticleToDiff(app *AppRuntime, guid, ver string, logger *JsonLogger) (*Article, *HttpResponseData) {
+	types := app.Conf.ArticleIndexTypes
+	switch ver {
+	case types.Draft:
+		return getStoredArticle(app, types.Draft, guid, logger)
+	case types.Publish:
+		return getStoredArticle(app, types.Publish, guid, logger)
+	}
+	if n, err := strconv.ParseInt(ver, 10, 64); err != nil || n <= 0 {
+		return nil, CreateBadRequestRespData(fmt.Sprintf("invalid article version %v, must be a version number, %v or %v!", ver, types.Draft, types.Publish))
+	}
+	return getStoredArticle(app, types.Version, fmt.Sprintf("%v:%v", guid, ver), logger)
+}
+
+func getArticleDiff(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	guid := StringFromReq(r, CtxKeyId)
+	args := r.URL.Query()
+	fromVer, d := ParseQueryStringValue(args, "from", true, "")
+	if d != nil {
+		return d
+	}
+	toVer, d := ParseQueryStringValue(args, "to", true, "")
+	if d != nil {
+		return d
+	}
+	from, d := getArticleToDiff(app, guid, fromVer, logger)
+	if d != nil {
+		return d
+	}
+	to, d := getArticleToDiff(app, guid, toVer, logger)
+	if d != nil {
+		return d
+	}
+	diff := diffArticles(from, to)
+	diff.Guid = guid
+	diff.From = fromVer
+	diff.To = toVer
+	return CreateJsonRespData(http.StatusOK, diff)
+}
+
+func ArticleDiffGet() EndpointHandler {
+	h := GetRequiredStringArg("id", CtxKeyId, getArticleDiff)
+	return RequireAuth(h)
+}
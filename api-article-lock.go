@@ -0,0 +1,178 @@
+/*
+   /article/lock/heartbeat GET   [draft (update lock_heartbeat)]             lock holder only
+   /article/lock/release   GET   [draft (clear locked_by)]                   lock holder or article:unlock
+   /article/lock/steal     GET   [draft (set locked_by to the current user)] expired lock or article:unlock
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Lock state of an article draft
+type ArticleLock struct {
+	LockedBy  string    `json:"locked_by"`
+	LockedAt  *JSONTime `json:"locked_at,omitempty"`
+	Heartbeat *JSONTime `json:"heartbeat,omitempty"`
+	ExpireAt  *JSONTime `json:"expire_at,omitempty"` // nil if it never expires
+	Expired   bool      `json:"expired"`
+}
+
+// last time the lock holder showed up, drafts locked before lock
+// metadata existed only have revised_at
+func draftLockHeartbeat(draft *Article) *JSONTime {
+	if draft.LockHeartbeat != nil {
+		return draft.LockHeartbeat
+	} else if draft.LockedAt != nil {
+		return draft.LockedAt
+	}
+	return draft.RevisedAt
+}
+
+func draftLockExpired(conf *AppConf, draft *Article) bool {
+	heartbeat := draftLockHeartbeat(draft)
+	if conf.DraftLockExpiry <= 0 || heartbeat == nil {
+		return false
+	}
+	return heartbeat.T.Add(conf.DraftLockExpiry).Before(time.Now().UTC())
+}
+
+// returns nil if the draft is nil or not locked
+func getArticleLock(conf *AppConf, draft *Article) *ArticleLock {
+	if draft == nil || draft.LockedBy == "" {
+		return nil
+	}
+	lock := &ArticleLock{
+		LockedBy:  draft.LockedBy,
+		LockedAt:  draft.LockedAt,
+		Heartbeat: draftLockHeartbeat(draft),
+		Expired:   draftLockExpired(conf, draft),
+	}
+	if conf.DraftLockExpiry > 0 && lock.Heartbeat != nil {
+		lock.ExpireAt = &JSONTime{lock.Heartbeat.T.Add(conf.DraftLockExpiry)}
+	}
+	return lock
+}
+
+// Loads the draft, lets check() decide if the current user may change its
+// lock and then stores the lock set by check(), all under the draft lock.
+func updateArticleLock(app *AppRuntime, r *http.Request, check func(*CmsUser, *Article) *HttpResponseData) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	articleId := StringFromReq(r, CtxKeyId)
+	user := CmsUserFromReq(r)
+	lock, d := lockArticle(app.DraftLock, articleId, logger)
+	if d != nil {
+		return d
+	}
+	defer lock.Unlock()
+	draft, d := getStoredArticle(app, app.Conf.ArticleIndexTypes.Draft, articleId, logger)
+	if d != nil {
+		return d
+	}
+	lockedBy := draft.LockedBy
+	if d = check(user, draft); d != nil {
+		return d
+	}
+	draft, err := app.Articles.UpdateDraftLock(context.Background(), draft, lockedBy)
+	if err == ErrStoreNotFound {
+		body := fmt.Sprintf("article draft %v not found!", articleId)
+		logger.Perror(body)
+		return CreateNotFoundRespData(body)
+	} else if err == ErrStoreConflict {
+		// only happens with a lock changed by another api server
+		body := fmt.Sprintf("lock of article draft %v has been changed, please try again!", articleId)
+		logger.Perror(body)
+		return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to update lock of article draft %v, error: %v", articleId, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	logger.AddFields(LogFields{
+		"locked_by_before": lockedBy,
+		"locked_by_after":  draft.LockedBy,
+	})
+	d = CreateJsonRespData(http.StatusOK, draft)
+	d.Header[HeaderETag] = []string{fmt.Sprintf(`"%v"`, draft.Rev)}
+	return d
+}
+
+func heartbeatArticleLock(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	return updateArticleLock(app, r, func(user *CmsUser, draft *Article) *HttpResponseData {
+		if draft.LockedBy != user.Username {
+			body := fmt.Sprintf("article draft %v is not locked by %v!", draft.Id, user.Username)
+			logger.Perror(body)
+			return CreateForbiddenRespData(body)
+		}
+		draft.LockHeartbeat = &JSONTime{time.Now().UTC()}
+		return nil
+	})
+}
+
+func releaseArticleLock(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	return updateArticleLock(app, r, func(user *CmsUser, draft *Article) *HttpResponseData {
+		if draft.LockedBy != user.Username && CmsRoleArticleUnlock&user.Role == 0 {
+			body := "release article draft locked by another user is not allowed!"
+			logger.Perror(body)
+			return CreateForbiddenRespData(body)
+		}
+		logger.Pinfof("user %v released article draft %v locked by %v", user.Username, draft.Id, draft.LockedBy)
+		draft.LockedBy = ""
+		draft.LockedAt = nil
+		draft.LockHeartbeat = nil
+		return nil
+	})
+}
+
+func stealArticleLock(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	return updateArticleLock(app, r, func(user *CmsUser, draft *Article) *HttpResponseData {
+		if CmsRoleArticleUnlock&user.Role == 0 {
+			// without article:unlock only a released or expired lock
+			// can be taken over, by a user who could edit the article
+			if draft.LockedBy != "" && draft.LockedBy != user.Username && !draftLockExpired(app.Conf, draft) {
+				body := fmt.Sprintf("article draft %v is locked by %v!", draft.Id, draft.LockedBy)
+				logger.Perror(body)
+				return CreateForbiddenRespData(body)
+			}
+			if user.Username != draft.RevisedBy && CmsRoleArticleEditOther&user.Role == 0 {
+				body := "You're not allowed to edit article created by another user!"
+				logger.Perror(body)
+				return CreateForbiddenRespData(body)
+			}
+		}
+		logger.Pinfof("user %v took over article draft %v locked by %v", user.Username, draft.Id, draft.LockedBy)
+		jt := &JSONTime{time.Now().UTC()}
+		draft.LockedBy = user.Username
+		draft.LockedAt = jt
+		draft.LockHeartbeat = jt
+		return nil
+	})
+}
+
+func ArticleLockHeartbeat() EndpointHandler {
+	h := addArticleAuditLogFields("lock-heartbeat", heartbeatArticleLock)
+	h = GetRequiredStringArg("id", CtxKeyId, h)
+	h = RequireOneRole(CmsRoleArticleCreate|CmsRoleArticleEditSelf|CmsRoleArticleEditOther, h)
+	return RequireAuth(h)
+}
+
+func ArticleLockRelease() EndpointHandler {
+	h := addArticleAuditLogFields("lock-release", releaseArticleLock)
+	h = GetRequiredStringArg("id", CtxKeyId, h)
+	h = RequireOneRole(CmsRoleArticleCreate|CmsRoleArticleEditSelf|CmsRoleArticleEditOther|CmsRoleArticleUnlock, h)
+	return RequireAuth(h)
+}
+
+func ArticleLockSteal() EndpointHandler {
+	h := addArticleAuditLogFields("lock-steal", stealArticleLock)
+	h = GetRequiredStringArg("id", CtxKeyId, h)
+	h = RequireOneRole(CmsRoleArticleEditSelf|CmsRoleArticleEditOther|CmsRoleArticleUnlock, h)
+	return RequireAuth(h)
+}
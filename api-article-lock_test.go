@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDraftLockExpired(t *testing.T) {
+	conf := &AppConf{DraftLockExpiry: time.Hour}
+	now := time.Now().UTC()
+	draft := &Article{
+		LockedBy:      "alice",
+		LockedAt:      &JSONTime{now.Add(-3 * time.Hour)},
+		LockHeartbeat: &JSONTime{now.Add(-2 * time.Hour)},
+	}
+	if !draftLockExpired(conf, draft) {
+		t.Errorf("expecting lock with heartbeat at %v expired", draft.LockHeartbeat.T)
+		return
+	}
+	draft.LockHeartbeat = &JSONTime{now.Add(-time.Minute)}
+	lock := getArticleLock(conf, draft)
+	if lock == nil || lock.Expired || lock.LockedBy != "alice" {
+		t.Errorf("expecting lock held by alice not expired, but got %+v", lock)
+		return
+	}
+	if !lock.ExpireAt.T.Equal(draft.LockHeartbeat.T.Add(time.Hour)) {
+		t.Errorf("expecting lock expire at %v, but got %v", draft.LockHeartbeat.T.Add(time.Hour), lock.ExpireAt.T)
+		return
+	}
+	// never expires
+	conf.DraftLockExpiry = 0
+	draft.LockHeartbeat = &JSONTime{now.Add(-1000 * time.Hour)}
+	if lock = getArticleLock(conf, draft); lock.Expired || lock.ExpireAt != nil {
+		t.Errorf("expecting lock never expire, but got %+v", lock)
+		return
+	}
+	// not locked
+	draft.LockedBy = ""
+	if lock = getArticleLock(conf, draft); lock != nil {
+		t.Errorf("expecting no lock, but got %+v", lock)
+		return
+	}
+}
@@ -0,0 +1,150 @@
+/*
+   /article/revert  GET  [version (read) --> version (create) --> publish (upsert)]  lock on draft (and publish)
+
+   creates a new version with the content of the given version, e.g.
+   /api/article/revert?id=guid:1500000000000000000&publish=true
+   with publish=true the new version is also published if the latest
+   version (the one reverted from) is the live one, unless -require-approval
+   is on as the new version has to be approved first.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type ArticleRevertResponseBody struct {
+	Version   *Article `json:"version"`
+	Published bool     `json:"published"`
+}
+
+func revertArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	types := app.Conf.ArticleIndexTypes
+	id := StringFromReq(r, CtxKeyId)
+	guid, ver, err := parseArticleId(id)
+	if err != nil || ver <= 0 {
+		return CreateBadRequestRespData(fmt.Sprintf("invalid article version id %v, must be guid:version!", id))
+	}
+	publishStr, d := ParseQueryStringValue(r.URL.Query(), "publish", false, "false")
+	if d != nil {
+		return d
+	}
+	publish, err := strconv.ParseBool(publishStr)
+	if err != nil {
+		return CreateBadRequestRespData(fmt.Sprintf("invalid publish=%v, must be true or false!", publishStr))
+	}
+	user := CmsUserFromReq(r)
+	if publish && CmsRoleArticlePublish&user.Role == 0 {
+		return CreateForbiddenRespData(fmt.Sprintf("Require %v to publish the reverted version!", CmsRoleArticlePublishName))
+	}
+	old, d := getStoredArticle(app, types.Version, id, logger)
+	if d != nil {
+		return d
+	}
+	// same check as editArticle
+	if user.Username != old.RevisedBy && CmsRoleArticleEditOther&user.Role == 0 {
+		body := "You're not allowed to revert article version created by another user!"
+		return CreateForbiddenRespData(body)
+	}
+
+	lock, d := lockArticle(app.DraftLock, guid, logger)
+	if d != nil {
+		return d
+	}
+	defer lock.Unlock()
+	ctx := context.Background()
+	if draft, err := app.Articles.Get(ctx, types.Draft, guid); err == nil {
+		// the draft would be based on a version older than the reverted one
+		body := fmt.Sprintf("article %v has a draft (locked by %v), submit or discard it first!", guid, draft.LockedBy)
+		logger.Perror(body)
+		return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+	} else if err != ErrStoreNotFound {
+		body := fmt.Sprintf("failed to get article draft %v, error: %v", guid, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	latest, err := app.Articles.GetLatestVersion(ctx, guid)
+	if err != nil {
+		body := fmt.Sprintf("failed to get latest version of article %v, error: %v", guid, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	latestVer := articleVersionNumber(latest)
+	live, err := app.Articles.Get(ctx, types.Publish, guid)
+	if err == ErrStoreNotFound {
+		live = nil
+	} else if err != nil {
+		body := fmt.Sprintf("failed to get published article %v, error: %v", guid, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	if latestVer == ver {
+		return CreateBadRequestRespData(fmt.Sprintf("article version %v is already the latest version!", id))
+	}
+
+	// create the new version from the old one
+	jt := &JSONTime{time.Now().UTC().Truncate(time.Millisecond)}
+	newVer := strconv.FormatInt(jt.T.UnixNano(), 10)
+	version := *old
+	version.Id = fmt.Sprintf("%v:%v", guid, newVer)
+	version.Version = newVer
+	version.FromVersion = old.Version
+	version.RevisedAt = jt
+	version.RevisedBy = user.Username
+	version.Note = fmt.Sprintf("Reverted to version %v (from version %v) by %v", old.Version, latestVer, user.Username)
+	version.LockedBy = ""
+	version.LockedAt = nil
+	version.LockHeartbeat = nil
+	version.Rev = 0
+	version.State = ArticleStateSubmitted
+	version.StateBy = user.Username
+	version.StateAt = jt
+	version.StateReason = ""
+	err = app.Articles.CreateVersion(ctx, &version)
+	if err == ErrStoreConflict {
+		body := fmt.Sprintf("article version %v already exists!", version.Id)
+		logger.Perror(body)
+		return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to create article version %v, error: %v", version.Id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	logger.AddFields(LogFields{"article_new_version": newVer})
+	logger.Pinfof("user %v reverted article %v to version %v as version %v", user.Username, guid, old.Version, newVer)
+
+	// republish if the version reverted from is live
+	published := false
+	if publish && !app.Conf.RequireApproval && live != nil && live.Version == latest.Version {
+		toPublish := version
+		if d = publishArticleVersion(app, user.Username, &toPublish, logger); d != nil {
+			return d
+		}
+		published = true
+	}
+	version.Headline = ""
+	version.Summary = ""
+	version.Content = ""
+	version.Body = nil
+	version.ContentFormat = ""
+	version.ContentText = ""
+	version.LeadImage = nil
+	version.Tag = nil
+	return CreateJsonRespData(http.StatusOK, &ArticleRevertResponseBody{
+		Version:   &version,
+		Published: published,
+	})
+}
+
+func ArticleRevert() EndpointHandler {
+	h := addArticleAuditLogFields("revert", revertArticle)
+	h = GetRequiredStringArg("id", CtxKeyId, h)
+	h = RequireOneRole(CmsRoleArticleEditSelf|CmsRoleArticleEditOther, h)
+	return RequireAuth(h)
+}
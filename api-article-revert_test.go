@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testRevertRequest(user *CmsUser, id string, publish bool) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/article/revert?id=%v&publish=%v", id, publish), nil)
+	ctx := WithCtxLogger(r.Context(), NewJsonLogger(ioutil.Discard), "test")
+	return r.WithContext(context.WithValue(ctx, CtxKeyCmsUser, user))
+}
+
+func TestRevertArticle(t *testing.T) {
+	app := &AppRuntime{
+		Conf:        &AppConf{ArticleIndexTypes: articleIndexTypes},
+		Articles:    NewMemoryArticleStore(articleIndexTypes),
+		DraftLock:   NewUniqStrMutex(),
+		PublishLock: NewUniqStrMutex(),
+	}
+	ctx := context.Background()
+	user := &CmsUser{Username: "alice", Role: CmsRoleArticleEditSelf | CmsRoleArticlePublish}
+	revert := GetRequiredStringArg("id", CtxKeyId, revertArticle)
+	revertResponse := func(d *HttpResponseData) *ArticleRevertResponseBody {
+		body, _ := ioutil.ReadAll(d.Body)
+		resp := &ArticleRevertResponseBody{}
+		if d.Status != http.StatusOK || json.Unmarshal(body, resp) != nil {
+			t.Fatalf("unexpected revert response %v: %s", d.Status, body)
+		}
+		return resp
+	}
+
+	// more versions than a search returns by default
+	base := time.Now().UTC().Add(-time.Hour).UnixNano()
+	versions := make([]string, 0)
+	for i := 0; i < 15; i++ {
+		ver := fmt.Sprintf("%v", base+int64(i))
+		versions = append(versions, ver)
+		version := &Article{
+			Id:        "g:" + ver,
+			Guid:      "g",
+			Version:   ver,
+			Headline:  "headline " + ver,
+			RevisedBy: "alice",
+			RevisedAt: &JSONTime{time.Now().UTC()},
+		}
+		if err := app.Articles.CreateVersion(ctx, version); err != nil {
+			t.Fatalf("failed to create version, error: %v", err)
+		}
+	}
+	latest := versions[len(versions)-1]
+	live := &Article{Id: "g", Guid: "g", Version: latest, Headline: "headline " + latest}
+	if err := app.Articles.UpsertPublish(ctx, live); err != nil {
+		t.Fatalf("failed to publish, error: %v", err)
+	}
+
+	if d := revert(app, nil, testRevertRequest(user, "g:"+latest, false)); d.Status != http.StatusBadRequest {
+		t.Errorf("expecting 400 for reverting to the latest version, but got %v", d.Status)
+	}
+
+	resp := revertResponse(revert(app, nil, testRevertRequest(user, "g:"+versions[2], true)))
+	if !resp.Published || resp.Version.FromVersion != versions[2] || !strings.Contains(resp.Version.Note, "(from version "+latest+")") {
+		t.Errorf("unexpected revert response %+v", resp)
+	}
+	published, err := app.Articles.Get(ctx, articleIndexTypes.Publish, "g")
+	if err != nil || published.Version != resp.Version.Version || published.Headline != "headline "+versions[2] {
+		t.Errorf("expecting the reverted version published, but got %+v (error: %v)", published, err)
+	}
+	if v, err := app.Articles.GetLatestVersion(ctx, "g"); err != nil || v.Version != resp.Version.Version {
+		t.Errorf("expecting the reverted version as the latest, but got %+v (error: %v)", v, err)
+	}
+
+	// the live version isn't the latest one, no republish
+	reverted := resp.Version.Version
+	if err := app.Articles.UpsertPublish(ctx, &Article{Id: "g", Guid: "g", Version: versions[0]}); err != nil {
+		t.Fatalf("failed to publish, error: %v", err)
+	}
+	// new versions are named by the millisecond
+	time.Sleep(2 * time.Millisecond)
+	resp = revertResponse(revert(app, nil, testRevertRequest(user, "g:"+versions[5], true)))
+	if resp.Published || !strings.Contains(resp.Version.Note, "(from version "+reverted+")") {
+		t.Errorf("unexpected revert response %+v", resp)
+	}
+
+	// not with a draft
+	if _, err := app.Articles.CreateDraft(ctx, &Article{Id: "g", Guid: "g", LockedBy: "bob"}); err != nil {
+		t.Fatalf("failed to create draft, error: %v", err)
+	}
+	if d := revert(app, nil, testRevertRequest(user, "g:"+versions[1], false)); d.Status != http.StatusConflict {
+		t.Errorf("expecting 409 for an article with a draft, but got %v", d.Status)
+	}
+}
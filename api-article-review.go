@@ -0,0 +1,121 @@
+/*
+   /article/review/start    GET  [version (update state)]  submitted/approved/rejected --> in_review
+   /article/review/approve  GET  [version (update state)]  submitted/in_review --> approved
+   /article/review/reject   GET  [version (update state)]  submitted/in_review --> rejected (reason required)
+
+   id=guid:version, with -require-approval only approved versions can be published
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Review workflow state of an article version
+type ArticleState string
+
+const (
+	ArticleStateSubmitted ArticleState = "submitted"
+	ArticleStateInReview  ArticleState = "in_review"
+	ArticleStateApproved  ArticleState = "approved"
+	ArticleStateRejected  ArticleState = "rejected"
+)
+
+// allowed state transitions, to state --> from states
+var articleStateTransitions = map[ArticleState][]ArticleState{
+	ArticleStateInReview: []ArticleState{ArticleStateSubmitted, ArticleStateApproved, ArticleStateRejected},
+	ArticleStateApproved: []ArticleState{ArticleStateSubmitted, ArticleStateInReview},
+	ArticleStateRejected: []ArticleState{ArticleStateSubmitted, ArticleStateInReview},
+}
+
+// versions created before the review workflow have no state,
+// they are considered submitted
+func (a *Article) GetState() ArticleState {
+	if a.State == "" {
+		return ArticleStateSubmitted
+	}
+	return a.State
+}
+
+func canTransitArticleState(from, to ArticleState) bool {
+	for _, s := range articleStateTransitions[to] {
+		if s == from {
+			return true
+		}
+	}
+	return false
+}
+
+func reviewArticle(to ArticleState) EndpointHandler {
+	return func(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+		logger := CtxLoggerFromReq(r)
+		id := StringFromReq(r, CtxKeyId)
+		reason, d := ParseQueryStringValue(r.URL.Query(), "reason", false, "")
+		if d != nil {
+			return d
+		}
+		reason = strings.TrimSpace(reason)
+		if to == ArticleStateRejected && reason == "" {
+			return CreateBadRequestRespData("reason is required to reject an article version!")
+		}
+		version, d := getStoredArticle(app, app.Conf.ArticleIndexTypes.Version, id, logger)
+		if d != nil {
+			return d
+		}
+		from := version.GetState()
+		if !canTransitArticleState(from, to) {
+			body := fmt.Sprintf("article version %v is %v, can't change it to %v!", id, from, to)
+			logger.Perror(body)
+			return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+		}
+		user := CmsUserFromReq(r)
+		version.State = to
+		version.StateBy = user.Username
+		version.StateAt = &JSONTime{time.Now().UTC()}
+		version.StateReason = reason
+		version, err := app.Articles.UpdateVersionState(context.Background(), version, from)
+		if err == ErrStoreNotFound {
+			body := fmt.Sprintf("article version %v not found!", id)
+			logger.Perror(body)
+			return CreateNotFoundRespData(body)
+		} else if err == ErrStoreConflict {
+			body := fmt.Sprintf("article version %v is no longer %v, please reload it!", id, from)
+			logger.Perror(body)
+			return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+		} else if err != nil {
+			body := fmt.Sprintf("failed to update state of article version %v, error: %v", id, err)
+			logger.Perror(body)
+			return CreateInternalServerErrorRespData(body)
+		}
+		logger.AddFields(LogFields{
+			"state_before": from,
+			"state_after":  to,
+		})
+		logger.Pinfof("user %v changed article version %v from %v to %v", user.Username, id, from, to)
+		return CreateJsonRespData(http.StatusOK, version)
+	}
+}
+
+func articleReviewHandler(action string, to ArticleState) EndpointHandler {
+	h := addArticleAuditLogFields(action, reviewArticle(to))
+	h = GetRequiredStringArg("id", CtxKeyId, h)
+	h = RequireOneRole(CmsRoleArticleReview, h)
+	return RequireAuth(h)
+}
+
+func ArticleReviewStart() EndpointHandler {
+	return articleReviewHandler("review-start", ArticleStateInReview)
+}
+
+func ArticleReviewApprove() EndpointHandler {
+	return articleReviewHandler("review-approve", ArticleStateApproved)
+}
+
+func ArticleReviewReject() EndpointHandler {
+	return articleReviewHandler("review-reject", ArticleStateRejected)
+}
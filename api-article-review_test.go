@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestArticleStateTransitions(t *testing.T) {
+	allowed := [][2]ArticleState{
+		{ArticleStateSubmitted, ArticleStateInReview},
+		{ArticleStateSubmitted, ArticleStateApproved},
+		{ArticleStateInReview, ArticleStateRejected},
+		{ArticleStateRejected, ArticleStateInReview},
+	}
+	for _, c := range allowed {
+		if !canTransitArticleState(c[0], c[1]) {
+			t.Errorf("expecting %v --> %v allowed", c[0], c[1])
+		}
+	}
+	denied := [][2]ArticleState{
+		{ArticleStateApproved, ArticleStateApproved},
+		{ArticleStateRejected, ArticleStateApproved},
+		{ArticleStateInReview, ArticleStateSubmitted},
+	}
+	for _, c := range denied {
+		if canTransitArticleState(c[0], c[1]) {
+			t.Errorf("expecting %v --> %v denied", c[0], c[1])
+		}
+	}
+}
+
+func TestUpdateVersionState(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryArticleStore(articleIndexTypes)
+	// version without state (created before the review workflow)
+	if err := s.CreateVersion(ctx, &Article{Id: "a:1", Guid: "a", Version: "1"}); err != nil {
+		t.Errorf("failed to create version, error: %v", err)
+		return
+	}
+	approve := &Article{
+		Id:      "a:1",
+		State:   ArticleStateApproved,
+		StateBy: "bob",
+		StateAt: &JSONTime{time.Now().UTC()},
+	}
+	version, err := s.UpdateVersionState(ctx, approve, ArticleStateSubmitted)
+	if err != nil {
+		t.Errorf("failed to approve version, error: %v", err)
+		return
+	}
+	if version.State != ArticleStateApproved || version.StateBy != "bob" || version.Version != "1" {
+		t.Errorf("unexpected approved version %+v", version)
+		return
+	}
+	// someone else changed the state in between
+	approve.State = ArticleStateRejected
+	if _, err = s.UpdateVersionState(ctx, approve, ArticleStateInReview); err != ErrStoreConflict {
+		t.Errorf("expecting %v, but got %v", ErrStoreConflict, err)
+		return
+	}
+}
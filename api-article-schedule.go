@@ -0,0 +1,161 @@
+/*
+   /article/schedule         GET  [schedule (search)]                    list schedules, optionally of one article (id=guid)
+   /article/schedule/create  GET  [version (read) --> schedule (create)]  id=guid:version for publish, id=guid for unpublish
+   /article/schedule/cancel  GET  [schedule (update)]                    id=schedule id
+
+   e.g. /api/article/schedule/create?id=guid:1500000000000000000&action=publish&at=2017-07-14T02:40:00.000Z
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+type ArticleSchedulesResponseBody struct {
+	Schedules []*ArticleSchedule `json:"schedules"`
+}
+
+func createArticleSchedule(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	args := r.URL.Query()
+	id := StringFromReq(r, CtxKeyId)
+	actionStr, d := ParseQueryStringValue(args, "action", true, "")
+	if d != nil {
+		return d
+	}
+	atStr, d := ParseQueryStringValue(args, "at", true, "")
+	if d != nil {
+		return d
+	}
+	at, err := time.Parse(time.RFC3339, atStr)
+	if err != nil {
+		return CreateBadRequestRespData(fmt.Sprintf("invalid at=%v, must be a RFC3339 date/time!", atStr))
+	}
+	guid, ver, err := parseArticleId(id)
+	if err != nil {
+		return CreateBadRequestRespData(fmt.Sprintf("invalid article id %v!", id))
+	}
+	user := CmsUserFromReq(r)
+	now := &JSONTime{time.Now().UTC()}
+	schedule := &ArticleSchedule{
+		Guid:      guid,
+		Action:    ScheduleAction(actionStr),
+		At:        &JSONTime{at.UTC()},
+		Status:    ScheduleStatusPending,
+		CreatedAt: now,
+		CreatedBy: user.Username,
+	}
+	switch schedule.Action {
+	case ScheduleActionPublish:
+		if ver <= 0 {
+			return CreateBadRequestRespData(fmt.Sprintf("article version (guid:version) is required to schedule %v!", schedule.Action))
+		}
+		// make sure the version exists
+		version, d := getStoredArticle(app, app.Conf.ArticleIndexTypes.Version, id, logger)
+		if d != nil {
+			return d
+		}
+		schedule.Version = version.Version
+	case ScheduleActionUnpublish:
+		if ver > 0 {
+			return CreateBadRequestRespData(fmt.Sprintf("article guid (not version) is required to schedule %v!", schedule.Action))
+		}
+	default:
+		return CreateBadRequestRespData(fmt.Sprintf("invalid action %v, must be %v or %v!", actionStr, ScheduleActionPublish, ScheduleActionUnpublish))
+	}
+	schedule, err = app.Schedules.CreateSchedule(context.Background(), schedule)
+	if err != nil {
+		body := fmt.Sprintf("failed to create schedule, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	logger.AddFields(LogFields{"schedule_id": schedule.Id})
+	logger.Pinfof("user %v scheduled %v of article %v at %v", user.Username, schedule.Action, id, schedule.At.T)
+	return CreateJsonRespData(http.StatusOK, schedule)
+}
+
+func cancelArticleSchedule(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	id := StringFromReq(r, CtxKeyId)
+	user := CmsUserFromReq(r)
+	// same lock as the scheduler so that it isn't canceled while running
+	lock, d := lockArticle(app.ScheduleLock, id, logger)
+	if d != nil {
+		return d
+	}
+	defer lock.Unlock()
+	ctx := context.Background()
+	schedule, err := app.Schedules.GetSchedule(ctx, id)
+	if err == ErrStoreNotFound {
+		return CreateNotFoundRespData(fmt.Sprintf("schedule %v not found!", id))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to get schedule %v, error: %v", id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	if fields, err := articleAuditLogFields("schedule-cancel", user.Username, schedule.ArticleId()); err == nil {
+		fields["schedule_id"] = id
+		logger.AddFields(fields)
+	}
+	if schedule.Status != ScheduleStatusPending {
+		body := fmt.Sprintf("schedule %v is %v already!", id, schedule.Status)
+		return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+	}
+	schedule.Status = ScheduleStatusCanceled
+	schedule.DoneAt = &JSONTime{time.Now().UTC()}
+	if err := app.Schedules.UpdateSchedule(ctx, schedule); err != nil {
+		body := fmt.Sprintf("failed to cancel schedule %v, error: %v", id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	logger.Pinfof("user %v canceled schedule %v", user.Username, id)
+	return CreateJsonRespData(http.StatusOK, schedule)
+}
+
+func getArticleSchedules(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	args := r.URL.Query()
+	guid, d := ParseQueryStringValue(args, "id", false, "")
+	if d != nil {
+		return d
+	}
+	status, d := ParseQueryStringValue(args, "status", false, "")
+	if d != nil {
+		return d
+	}
+	schedules, err := app.Schedules.ListSchedules(context.Background(), &ScheduleQuery{
+		Guid:   guid,
+		Status: ScheduleStatus(status),
+		Size:   1000,
+	})
+	if err != nil {
+		body := fmt.Sprintf("failed to list schedules, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	return CreateJsonRespData(http.StatusOK, &ArticleSchedulesResponseBody{
+		Schedules: schedules,
+	})
+}
+
+func ArticleScheduleCreate() EndpointHandler {
+	h := addArticleAuditLogFields("schedule", createArticleSchedule)
+	h = GetRequiredStringArg("id", CtxKeyId, h)
+	h = RequireOneRole(CmsRoleArticlePublish, h)
+	return RequireAuth(h)
+}
+
+func ArticleScheduleCancel() EndpointHandler {
+	h := GetRequiredStringArg("id", CtxKeyId, cancelArticleSchedule)
+	h = RequireOneRole(CmsRoleArticlePublish, h)
+	return RequireAuth(h)
+}
+
+func ArticleSchedulesGet() EndpointHandler {
+	h := RequireOneRole(CmsRoleArticlePublish, getArticleSchedules)
+	return RequireAuth(h)
+}
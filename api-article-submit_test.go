@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fails the first submit after the draft is saved, like a submit which
+// is retried by the client
+type failingSubmitArticleStore struct {
+	ArticleStore
+	failed bool
+}
+
+func (s *failingSubmitArticleStore) SubmitDraft(ctx context.Context, version *Article) error {
+	if !s.failed {
+		s.failed = true
+		return errors.New("submit failed")
+	}
+	return s.ArticleStore.SubmitDraft(ctx, version)
+}
+
+func testSubmitRequest(user *CmsUser, id, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/api/article/submit?id="+id, strings.NewReader(body))
+	ctx := WithCtxLogger(r.Context(), NewJsonLogger(ioutil.Discard), "test")
+	return r.WithContext(context.WithValue(ctx, CtxKeyCmsUser, user))
+}
+
+func TestSubmitArticleSelfRetry(t *testing.T) {
+	store := &failingSubmitArticleStore{ArticleStore: NewMemoryArticleStore(articleIndexTypes)}
+	app := &AppRuntime{
+		Conf:      &AppConf{ArticleIndexTypes: articleIndexTypes},
+		Articles:  store,
+		Taxonomy:  NewMemoryTaxonomyStore(),
+		Media:     NewMemoryMediaStore(),
+		DraftLock: NewUniqStrMutex(),
+	}
+	ctx := context.Background()
+	user := &CmsUser{Username: "alice", Role: CmsRoleArticleEditSelf}
+	term := &TaxonomyTerm{Id: "elections", Synonyms: []string{"election"}}
+	if err := app.Taxonomy.CreateTerm(ctx, term); err != nil {
+		t.Fatalf("failed to create term, error: %v", err)
+	}
+	draft := &Article{Id: "g", Guid: "g", FromVersion: "0", LockedBy: user.Username}
+	if _, err := app.Articles.CreateDraft(ctx, draft); err != nil {
+		t.Fatalf("failed to create draft, error: %v", err)
+	}
+	submit := GetRequiredStringArg("id", CtxKeyId, submitArticleSelf)
+	// tags and content (format) are normalized on save
+	body := `{"headline":"h","content":"*c*","tag":["Election","Sports"]}`
+
+	if d := submit(app, nil, testSubmitRequest(user, "g", body)); d.Status != http.StatusInternalServerError {
+		t.Fatalf("expecting the first submit failed, but got %v", d.Status)
+	}
+	saved, err := app.Articles.Get(ctx, articleIndexTypes.Draft, "g")
+	if err != nil || strings.Join(saved.Tag, ",") != "elections,sports" {
+		t.Fatalf("expecting the draft saved with normalized tags, but got %+v (error: %v)", saved, err)
+	}
+
+	d := submit(app, nil, testSubmitRequest(user, "g", body))
+	resp, _ := ioutil.ReadAll(d.Body)
+	version := &Article{}
+	if d.Status != http.StatusOK || json.Unmarshal(resp, version) != nil {
+		t.Fatalf("unexpected submit response %v: %s", d.Status, resp)
+	}
+	if version.RevisedAt == nil || !version.RevisedAt.T.Equal(saved.RevisedAt.T) {
+		t.Errorf("expecting the saved draft submitted as is, but got version %+v (draft revised at %v)", version, saved.RevisedAt.T)
+	}
+
+	// the draft is gone after the submit, like a submit whose response got
+	// lost, retried it gets the submitted version
+	d = submit(app, nil, testSubmitRequest(user, "g", body))
+	resp, _ = ioutil.ReadAll(d.Body)
+	retried := &Article{}
+	if d.Status != http.StatusOK || json.Unmarshal(resp, retried) != nil || retried.Id != version.Id {
+		t.Errorf("expecting version %v for the retried submit, but got %v: %s", version.Id, d.Status, resp)
+	}
+	if d = submit(app, nil, testSubmitRequest(user, "g", `{"headline":"other"}`)); d.Status != http.StatusNotFound {
+		t.Errorf("expecting 404 for a submit of other content without a draft, but got %v", d.Status)
+	}
+}
@@ -23,37 +23,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-
-	//elastic "gopkg.in/olivere/elastic.v5"
-	elastic "github.com/yizha/elastic"
-)
-
-const (
-	ESScriptSaveArticle = `
-if (ctx._source.locked_by != params.username) {
-  ctx.op = "none"
-} else {
-  ctx._source.guid = params.guid;
-  ctx._source.headline = params.headline;
-  ctx._source.summary = params.summary;
-  ctx._source.content = params.content;
-  ctx._source.tag = params.tag;
-  ctx._source.note = params.note;
-  ctx._source.revised_at = params.revised_at;
-}`
-
-	ESScriptDiscardArticle = `
-if (ctx._source.locked_by != params.username) {
-  ctx.op = "none"
-} else {
-  ctx.op = "delete"
-}
-`
-)
-
-var (
-	draftLock   = NewUniqStrMutex()
-	publishLock = NewUniqStrMutex()
 )
 
 type JSONTime struct {
@@ -96,6 +65,36 @@ type Article struct {
 	RevisedBy   string    `json:"revised_by"`
 	FromVersion string    `json:"from_version"`
 	LockedBy    string    `json:"locked_by,omitempty"`
+
+	// structured body, when set the content is its plain text
+	// (see ArticleBodyPlainText) and is kept for searching
+	Body []*ArticleBlock `json:"body,omitempty"`
+
+	// format of the content (blank means plain) and its plain text with
+	// the markup stripped, which is what gets searched (see ContentPlainText)
+	ContentFormat ContentFormat `json:"content_format,omitempty"`
+	ContentText   string        `json:"content_text,omitempty"`
+
+	// lead image of the article, an uploaded media asset
+	LeadImage *ArticleMedia `json:"lead_image,omitempty"`
+
+	// when the version was (last) published, only set in publish
+	PublishedAt *JSONTime `json:"published_at,omitempty"`
+
+	// when the draft was locked by LockedBy and when the lock was last
+	// refreshed (by a save or a heartbeat), see draftLockExpired
+	LockedAt      *JSONTime `json:"locked_at,omitempty"`
+	LockHeartbeat *JSONTime `json:"lock_heartbeat,omitempty"`
+
+	// review workflow state of a version, see ArticleState
+	State       ArticleState `json:"state,omitempty"`
+	StateBy     string       `json:"state_by,omitempty"`
+	StateAt     *JSONTime    `json:"state_at,omitempty"`
+	StateReason string       `json:"state_reason,omitempty"`
+
+	// revision of a draft, increased by each save. A save carrying the
+	// revision it was based on fails if the draft has been saved since.
+	Rev int64 `json:"rev,omitempty"`
 }
 
 func (a *Article) NilZeroTimeFields() *Article {
@@ -105,6 +104,15 @@ func (a *Article) NilZeroTimeFields() *Article {
 	if a.RevisedAt != nil && a.RevisedAt.T.IsZero() {
 		a.RevisedAt = nil
 	}
+	if a.LockedAt != nil && a.LockedAt.T.IsZero() {
+		a.LockedAt = nil
+	}
+	if a.LockHeartbeat != nil && a.LockHeartbeat.T.IsZero() {
+		a.LockHeartbeat = nil
+	}
+	if a.StateAt != nil && a.StateAt.T.IsZero() {
+		a.StateAt = nil
+	}
 	return a
 }
 
@@ -117,66 +125,18 @@ func unmarshalArticle(data []byte) (*Article, error) {
 	return (&a).NilZeroTimeFields(), nil
 }
 
-func getFullArticle(
-	client *elastic.Client,
-	ctx context.Context,
-	index, typ, id string,
-	logger *JsonLogger) (*Article, *HttpResponseData) {
-	source := elastic.NewFetchSourceContext(true).Include(
-		"guid",
-		"headline",
-		"summary",
-		"content",
-		"tag",
-		"created_at",
-		"created_by",
-		"revised_at",
-		"revised_by",
-		"version",
-		"from_version",
-		"note",
-		"locked_by",
-	)
-	return getArticle(client, ctx, index, typ, id, source, logger)
-}
-
-func getArticle(
-	client *elastic.Client,
-	ctx context.Context,
-	index, typ, id string,
-	source *elastic.FetchSourceContext,
-	logger *JsonLogger) (*Article, *HttpResponseData) {
-	getService := client.Get()
-	getService.Index(index)
-	getService.Type(typ)
-	getService.Realtime(true)
-	getService.Id(id)
-	getService.FetchSourceContext(source)
-	resp, err := getService.Do(ctx)
-	if err != nil {
-		if elastic.IsNotFound(err) {
-			body := fmt.Sprintf("article %v not found in index %v type %v!", id, index, typ)
-			logger.Perror(body)
-			return nil, CreateNotFoundRespData(body)
-		} else {
-			body := fmt.Sprintf("failed to query elasticsearch, error: %v", err)
-			logger.Perror(body)
-			return nil, CreateInternalServerErrorRespData(body)
-		}
-	} else if !resp.Found {
-		body := fmt.Sprintf("article %v not found in index %v type %v!", id, index, typ)
+func getStoredArticle(app *AppRuntime, typ, id string, logger *JsonLogger) (*Article, *HttpResponseData) {
+	article, err := app.Articles.Get(context.Background(), typ, id)
+	if err == nil {
+		return article, nil
+	} else if err == ErrStoreNotFound {
+		body := fmt.Sprintf("article %v not found in index %v type %v!", id, app.Conf.ArticleIndex.Name, typ)
 		logger.Perror(body)
 		return nil, CreateNotFoundRespData(body)
 	} else {
-		article := &Article{}
-		if err := json.Unmarshal(*resp.Source, article); err != nil {
-			body := fmt.Sprintf("unmarshal article %v error: %v", id, err)
-			logger.Perror(body)
-			return nil, CreateInternalServerErrorRespData(body)
-		} else {
-			article.Id = resp.Id
-			return article, nil
-		}
+		body := fmt.Sprintf("failed to get article %v (%v), error: %v", id, typ, err)
+		logger.Perror(body)
+		return nil, CreateInternalServerErrorRespData(body)
 	}
 }
 
@@ -205,6 +165,25 @@ func parseArticleId(id string) (string, int64, error) {
 	}
 }
 
+// fields logged for every article change, see addArticleAuditLogFields
+func articleAuditLogFields(action, username, id string) (LogFields, error) {
+	fields := make(map[string]interface{})
+	fields["audit"] = "article"
+	fields["action"] = action
+	fields["user"] = username
+	if id != "" {
+		guid, ver, err := parseArticleId(id)
+		if err != nil {
+			return fields, err
+		}
+		fields["article_guid"] = guid
+		if ver > 0 {
+			fields["article_version"] = ver
+		}
+	}
+	return fields, nil
+}
+
 func addArticleAuditLogFields(action string, h EndpointHandler) EndpointHandler {
 	return func(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
 		d := h(app, w, r)
@@ -214,20 +193,9 @@ func addArticleAuditLogFields(action string, h EndpointHandler) EndpointHandler
 				id = a.Id
 			}
 		}
-		fields := make(map[string]interface{})
-		fields["audit"] = "article"
-		fields["action"] = action
-		fields["user"] = CmsUserFromReq(r).Username
-		if id != "" {
-			guid, ver, err := parseArticleId(id)
-			if err == nil {
-				fields["article_guid"] = guid
-				if ver > 0 {
-					fields["article_version"] = ver
-				}
-			} else {
-				CtxLoggerFromReq(r).Perrorf("failed to parse article id %v, error %v", id, err)
-			}
+		fields, err := articleAuditLogFields(action, CmsUserFromReq(r).Username, id)
+		if err != nil {
+			CtxLoggerFromReq(r).Perrorf("failed to parse article id %v, error %v", id, err)
 		}
 		CtxLoggerFromReq(r).AddFields(fields)
 		return d
@@ -250,20 +218,17 @@ func createArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *Htt
 		RevisedAt:   t,
 		LockedBy:    username,
 		FromVersion: "0",
+
+		LockedAt:      t,
+		LockHeartbeat: t,
 	}
-	// don't set Id or OpType in order to have id auto-generated by elasticsearch
-	idxService := app.Elastic.Client.Index()
-	idxService.Index(app.Conf.ArticleIndex.Name)
-	idxService.Type(app.Conf.ArticleIndexTypes.Draft)
-	idxService.BodyJson(article)
-	idxService.Refresh("wait_for")
-	resp, err := idxService.Do(context.Background())
+	// don't set Id in order to have it auto-generated by the store
+	article, err := app.Articles.CreateDraft(context.Background(), article)
 	if err != nil {
 		body := fmt.Sprintf("error creating new article doc: %v", err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
 	} else {
-		article.Id = resp.Id
 		if bytes, err := json.Marshal(article); err == nil {
 			d := CreateRespData(http.StatusOK, ContentTypeValueJSON, bytes)
 			// save article so that we can log auto-generated article-id
@@ -279,66 +244,89 @@ func createArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *Htt
 	}
 }
 
+// Validates the structured body and sets the content to its plain text
+func normalizeArticleBody(app *AppRuntime, article *Article, logger *JsonLogger) *HttpResponseData {
+	if article.Body == nil {
+		return nil
+	}
+	if err := ValidateArticleBody(article.Body, app.Conf.EmbedHosts); err != nil {
+		body := fmt.Sprintf("invalid article body, %v!", err)
+		logger.Perror(body)
+		return CreateBadRequestRespData(body)
+	}
+	article.Content = ArticleBodyPlainText(article.Body)
+	return nil
+}
+
+// Checks the content format and sets the plain text of the content, a
+// structured body comes with plain content only
+func normalizeArticleContent(article *Article, logger *JsonLogger) *HttpResponseData {
+	format, err := ParseContentFormat(string(article.ContentFormat))
+	if err != nil {
+		body := fmt.Sprintf("invalid article content format, %v!", err)
+		logger.Perror(body)
+		return CreateBadRequestRespData(body)
+	}
+	if article.Body != nil && format != ContentFormatPlain {
+		body := fmt.Sprintf("content format %v can't be used with a structured body!", format)
+		logger.Perror(body)
+		return CreateBadRequestRespData(body)
+	}
+	article.ContentFormat = format
+	article.ContentText = ContentPlainText(format, article.Content)
+	return nil
+}
+
+// Normalizes the article the way it is stored as a draft
+func normalizeArticle(app *AppRuntime, article *Article, logger *JsonLogger) *HttpResponseData {
+	if d := normalizeArticleTags(app, article, logger); d != nil {
+		return d
+	}
+	if d := normalizeArticleMedia(app, article, logger); d != nil {
+		return d
+	}
+	if d := normalizeArticleBody(app, article, logger); d != nil {
+		return d
+	}
+	return normalizeArticleContent(article, logger)
+}
+
 func saveArticleDraft(app *AppRuntime, user *CmsUser, article *Article, logger *JsonLogger, waitForRefresh bool) (*Article, *HttpResponseData) {
+	if d := normalizeArticle(app, article, logger); d != nil {
+		return nil, d
+	}
+	return saveNormalizedArticleDraft(app, user, article, logger, waitForRefresh)
+}
+
+func saveNormalizedArticleDraft(app *AppRuntime, user *CmsUser, article *Article, logger *JsonLogger, waitForRefresh bool) (*Article, *HttpResponseData) {
 	username := user.Username
-	script := elastic.NewScript(ESScriptSaveArticle)
-	article.RevisedAt = &JSONTime{time.Now().UTC()}
-	script.Type("inline").Lang("painless").Params(map[string]interface{}{
-		"guid":       article.Guid,
-		"headline":   article.Headline,
-		"summary":    article.Summary,
-		"content":    article.Content,
-		"tag":        article.Tag,
-		"note":       article.Note,
-		"username":   username,
-		"revised_at": article.RevisedAt,
-	})
-	client := app.Elastic.Client
-	index := app.Conf.ArticleIndex.Name
-	typ := app.Conf.ArticleIndexTypes.Draft
-	articleId := article.Id
-	updService := client.Update()
-	updService.Index(index)
-	updService.Type(typ)
-	updService.Id(articleId)
-	updService.Script(script)
-	updService.DetectNoop(true)
-	if waitForRefresh {
-		updService.Refresh("wait_for")
-	}
-	ctx := context.Background()
-	resp, err := updService.Do(ctx)
-	//fmt.Printf("resp: %T, %+v\n", resp, resp)
-	//fmt.Printf("error: %v\n", err)
-	if err != nil {
-		if elastic.IsNotFound(err) {
-			body := fmt.Sprintf("article draft %v not found!", article.Id)
-			logger.Perror(body)
-			return nil, CreateNotFoundRespData(body)
-		} else {
-			body := fmt.Sprintf("failed to update article draft %v, error: %v", article.Id, err)
-			logger.Perror(body)
-			return nil, CreateInternalServerErrorRespData(body)
+	// only milliseconds are kept (see JSONTime), truncate it here so that
+	// the version derived from it is the same before/after being stored
+	article.RevisedAt = &JSONTime{time.Now().UTC().Truncate(time.Millisecond)}
+	saved, err := app.Articles.SaveDraft(context.Background(), username, article, waitForRefresh)
+	if err == nil {
+		logger.Pinfof("user %v saved article draft %v", username, article.Id)
+		return saved, nil
+	} else if err == ErrStoreNotFound {
+		body := fmt.Sprintf("article draft %v not found!", article.Id)
+		logger.Perror(body)
+		return nil, CreateNotFoundRespData(body)
+	} else if err == ErrStoreLocked {
+		body := fmt.Sprintf("Save article draft (%v) locked by another user is not allowed!", article.Id)
+		logger.Perror(body)
+		return nil, CreateForbiddenRespData(body)
+	} else if err == ErrStoreConflict {
+		// stale save, reply with the current draft
+		logger.Perrorf("article draft %v has been saved since rev %v", article.Id, article.Rev)
+		current, d := getStoredArticle(app, app.Conf.ArticleIndexTypes.Draft, article.Id, logger)
+		if d != nil {
+			return nil, d
 		}
+		return nil, CreateJsonRespData(http.StatusConflict, current)
 	} else {
-		if resp.Result == "noop" {
-			body := fmt.Sprintf("Save article draft (%v) locked by another user is not allowed!", article.Id)
-			logger.Perror(body)
-			return nil, CreateForbiddenRespData(body)
-		} else if resp.Result == "updated" {
-			logger.Pinfof("user %v saved article draft %v", username, article.Id)
-			article, d := getFullArticle(client, ctx, index, typ, articleId, logger)
-			fmt.Printf("got full article:\n %+v\n", article)
-			if d != nil {
-				return nil, d
-			} else {
-				return article, nil
-			}
-		} else {
-			body := fmt.Sprintf(`unknown "result" in update response: %v`, resp.Result)
-			logger.Perror(body)
-			return nil, CreateInternalServerErrorRespData(body)
-		}
+		body := fmt.Sprintf("failed to update article draft %v, error: %v", article.Id, err)
+		logger.Perror(body)
+		return nil, CreateInternalServerErrorRespData(body)
 	}
 }
 
@@ -358,51 +346,77 @@ func saveArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpR
 	}
 	article.Id = StringFromReq(r, CtxKeyId)
 	article.Guid = article.Id
+	if d := parseIfMatchRev(r, article); d != nil {
+		return d
+	}
 	user := CmsUserFromReq(r)
 	// lock on the article draft
-	lock := draftLock.Get(article.Id)
-	lock.Lock()
+	lock, d := lockArticle(app.DraftLock, article.Id, logger)
+	if d != nil {
+		return d
+	}
 	defer lock.Unlock()
 	// save article
-	article, d := saveArticleDraft(app, user, article, logger, true)
+	article, d = saveArticleDraft(app, user, article, logger, true)
 	if d != nil {
 		return d
 	} else {
 		logger.Pinfof("user %v saved article draft %v", user.Username, article.Id)
-		return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
+		d = CreateJsonRespData(http.StatusOK, article)
+		d.Header[HeaderETag] = []string{fmt.Sprintf(`"%v"`, article.Rev)}
+		return d
 	}
 }
 
-func submitArticleSelf(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
-	logger := CtxLoggerFromReq(r)
-	// first save the article to draft
-	bytes, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		body := fmt.Sprintf("failed to read request body, error: %v", err)
-		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
+// The draft revision a save is based on can be given either as "rev"
+// in the article json or as an If-Match header (the ETag of a save
+// response), the header wins if both are given.
+func parseIfMatchRev(r *http.Request, article *Article) *HttpResponseData {
+	val := strings.TrimSpace(r.Header.Get(HeaderIfMatch))
+	if val == "" {
+		return nil
 	}
-	article, err := unmarshalArticle(bytes)
-	if err != nil {
-		body := fmt.Sprintf("failed to unmarshal article, error: %v", err)
+	val = strings.Trim(strings.TrimPrefix(val, "W/"), `"`)
+	rev, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || rev <= 0 {
+		return CreateBadRequestRespData(fmt.Sprintf("invalid %v header: %v", HeaderIfMatch, r.Header.Get(HeaderIfMatch)))
+	}
+	article.Rev = rev
+	return nil
+}
+
+// acquires the lock on the given article key
+func lockArticle(locker KeyLocker, key string, logger *JsonLogger) (KeyLock, *HttpResponseData) {
+	lock, err := locker.Lock(key)
+	if err == ErrLockTimeout {
+		body := fmt.Sprintf("article %v is busy, please try again later!", key)
 		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
+		return nil, CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to lock article %v, error: %v", key, err)
+		logger.Perror(body)
+		return nil, CreateInternalServerErrorRespData(body)
 	}
-	article.Id = StringFromReq(r, CtxKeyId)
-	article.Guid = article.Id
-	user := CmsUserFromReq(r)
-	// lock on the article draft
-	lock := draftLock.Get(article.Id)
-	lock.Lock()
-	defer lock.Unlock()
-	// save article draft
-	article, d := saveArticleDraft(app, user, article, logger, false)
-	if d != nil {
+	return lock, nil
+}
+
+// Version of the article submitted from the given draft. It is derived from
+// the draft revised_at so that submitting the same draft again (e.g. retry
+// after a failure) ends up with the same version instead of a new one.
+func draftVersion(draft *Article) int64 {
+	return draft.RevisedAt.T.UnixNano()
+}
+
+// creates a new version from the given (draft) article and then deletes the draft
+func createArticleVersion(app *AppRuntime, user *CmsUser, article *Article, logger *JsonLogger) *HttpResponseData {
+	// drafts created by edit carry the tags of the version edited from,
+	// which may not have been saved (normalized) since
+	if d := normalizeArticleTags(app, article, logger); d != nil {
 		return d
 	}
 	// set article props for the new version
 	jt := article.RevisedAt
-	ver := jt.T.UnixNano()
+	ver := draftVersion(article)
 	verGuid := fmt.Sprintf("%v:%v", article.Guid, ver)
 	article.Id = verGuid
 	article.Version = strconv.FormatInt(ver, 10)
@@ -413,93 +427,143 @@ func submitArticleSelf(app *AppRuntime, w http.ResponseWriter, r *http.Request)
 	article.RevisedAt = jt
 	article.RevisedBy = user.Username
 	article.LockedBy = ""
-	// create the new version
-	ctx := context.Background()
-	idxService := app.Elastic.Client.Index()
-	idxService.Index(app.Conf.ArticleIndex.Name)
-	idxService.Type(app.Conf.ArticleIndexTypes.Version)
-	idxService.OpType(ESIndexOpCreate)
-	idxService.Id(article.Id)
-	idxService.BodyJson(article)
-	idxResp, err := idxService.Do(ctx)
-	if err != nil {
-		body := fmt.Sprintf("failed to create new article version %v, error: %v", verGuid, err)
+	article.LockedAt = nil
+	article.LockHeartbeat = nil
+	article.Rev = 0
+	article.State = ArticleStateSubmitted
+	article.StateBy = user.Username
+	article.StateAt = jt
+	article.StateReason = ""
+	// create the new version and delete the draft
+	// no need to check user here as we have successfully saved it
+	err := app.Articles.SubmitDraft(context.Background(), article)
+	if err == ErrStoreConflict {
+		body := fmt.Sprintf("article version %v already exists with different content!", verGuid)
 		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
-	} else if !idxResp.Created {
-		body := fmt.Sprintf("no reason but article new version %v is not created!", verGuid)
+		return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to submit article version %v, error: %v", verGuid, err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
 	}
-	// delete article from draft
-	// no need to check user here as we have successfully saved it
-	delService := app.Elastic.Client.Delete()
-	delService.Index(app.Conf.ArticleIndex.Name)
-	delService.Type(app.Conf.ArticleIndexTypes.Draft)
-	delService.Id(article.Guid)
-	delService.Refresh("wait_for")
-	_, err = delService.Do(ctx)
-	if err != nil {
-		body := fmt.Sprintf("failed to delete article draft %v, error: %v", article.Guid, err)
+	logger.Pinfof("user %v submited article version %v", user.Username, verGuid)
+	return articleVersionRespData(article, logger)
+}
+
+// Responds a retried submit of the given article, whose draft is gone, with
+// the latest version if the user submitted it with the same content.
+func submittedArticleVersion(app *AppRuntime, user *CmsUser, article *Article, logger *JsonLogger) *HttpResponseData {
+	latest, err := app.Articles.GetLatestVersion(context.Background(), article.Guid)
+	if err != nil && err != ErrStoreNotFound {
+		body := fmt.Sprintf("failed to get latest version of article %v, error: %v", article.Guid, err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
 	}
-	// return article version
+	if err == ErrStoreNotFound || latest.RevisedBy != user.Username || !sameArticleContent(latest, article) {
+		body := fmt.Sprintf("article draft %v not found!", article.Id)
+		logger.Perror(body)
+		return CreateNotFoundRespData(body)
+	}
+	logger.Pinfof("user %v already submited article version %v", user.Username, latest.Id)
+	return articleVersionRespData(latest, logger)
+}
+
+// the submitted article version without its content
+func articleVersionRespData(article *Article, logger *JsonLogger) *HttpResponseData {
 	article.Headline = ""
 	article.Summary = ""
 	article.Content = ""
+	article.Body = nil
+	article.ContentFormat = ""
+	article.ContentText = ""
+	article.LeadImage = nil
 	article.Tag = nil
 	article.Note = ""
 	if bytes, err := json.Marshal(article); err == nil {
-		logger.Pinfof("user %v submited article version %v", user.Username, verGuid)
 		return CreateRespData(http.StatusOK, ContentTypeValueJSON, bytes)
 	} else {
-		body := fmt.Sprintf("error marshaling article %v: %v", verGuid, err)
+		body := fmt.Sprintf("error marshaling article %v: %v", article.Id, err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
 	}
 }
 
+func submitArticleSelf(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	// first save the article to draft
+	bytes, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		body := fmt.Sprintf("failed to read request body, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	article, err := unmarshalArticle(bytes)
+	if err != nil {
+		body := fmt.Sprintf("failed to unmarshal article, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	article.Id = StringFromReq(r, CtxKeyId)
+	article.Guid = article.Id
+	if d := parseIfMatchRev(r, article); d != nil {
+		return d
+	}
+	user := CmsUserFromReq(r)
+	// lock on the article draft
+	lock, d := lockArticle(app.DraftLock, article.Id, logger)
+	if d != nil {
+		return d
+	}
+	defer lock.Unlock()
+	// compare with the draft (or version) as it would be saved
+	if d := normalizeArticle(app, article, logger); d != nil {
+		return d
+	}
+	// skip saving the draft if nothing changed so that it keeps
+	// its revised_at and a retried submit gets the same version
+	draft, err := app.Articles.Get(context.Background(), app.Conf.ArticleIndexTypes.Draft, article.Id)
+	if err == ErrStoreNotFound {
+		// the draft is gone if the submit went through but its response
+		// got lost, the retry gets the version it submitted
+		return submittedArticleVersion(app, user, article, logger)
+	} else if err != nil {
+		body := fmt.Sprintf("failed to get article draft %v, error: %v", article.Id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	if draft.LockedBy == user.Username && draft.RevisedAt != nil && sameArticleContent(draft, article) {
+		draft.Guid = article.Guid
+		return createArticleVersion(app, user, draft, logger)
+	}
+	// save article draft
+	article, d = saveNormalizedArticleDraft(app, user, article, logger, false)
+	if d != nil {
+		return d
+	}
+	return createArticleVersion(app, user, article, logger)
+}
+
 func discardArticleSelf(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
 	logger := CtxLoggerFromReq(r)
 	articleId := StringFromReq(r, CtxKeyId)
 	username := CmsUserFromReq(r).Username
 
-	script := elastic.NewScript(ESScriptDiscardArticle)
-	script.Type("inline").Lang("painless").Params(map[string]interface{}{
-		"username": username,
-	})
-	updService := app.Elastic.Client.Update()
-	updService.Index(app.Conf.ArticleIndex.Name)
-	updService.Type(app.Conf.ArticleIndexTypes.Draft)
-	updService.Id(articleId)
-	updService.Script(script)
-	updService.DetectNoop(true)
-	updService.Refresh("wait_for")
-	resp, err := updService.Do(context.Background())
-	if err != nil {
-		if elastic.IsNotFound(err) {
-			body := fmt.Sprintf("article draft %v not found!", articleId)
-			logger.Perror(body)
-			return CreateNotFoundRespData(body)
-		} else {
-			body := fmt.Sprintf("failed to delete article draft %v, error: %v", articleId, err)
-			logger.Perror(body)
-			return CreateInternalServerErrorRespData(body)
-		}
+	err := app.Articles.DiscardDraft(context.Background(), username, articleId)
+	if err == nil {
+		logger.Pinfof("user %v deleted article draft %v", username, articleId)
+		return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
+	} else if err == ErrStoreNotFound {
+		body := fmt.Sprintf("article draft %v not found!", articleId)
+		logger.Perror(body)
+		return CreateNotFoundRespData(body)
+	} else if err == ErrStoreLocked {
+		body := "delete article locked by another user is not allowed!"
+		logger.Perror(body)
+		return CreateForbiddenRespData(body)
 	} else {
-		if resp.Result == "noop" {
-			body := "delete article locked by another user is not allowed!"
-			logger.Perror(body)
-			return CreateForbiddenRespData(body)
-		} else if resp.Result == "deleted" {
-			logger.Pinfof("user %v deleted article draft %v", username, articleId)
-			return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
-		} else {
-			body := fmt.Sprintf(`unknown "result" in update response: %v`, resp.Result)
-			logger.Perror(body)
-			return CreateInternalServerErrorRespData(body)
-		}
+		body := fmt.Sprintf("failed to delete article draft %v, error: %v", articleId, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
 	}
 }
 
@@ -508,75 +572,18 @@ func submitArticleOther(app *AppRuntime, w http.ResponseWriter, r *http.Request)
 	user := CmsUserFromReq(r)
 	// first get the draft article
 	articleId := StringFromReq(r, CtxKeyId)
-	client := app.Elastic.Client
-	index := app.Conf.ArticleIndex.Name
-	typ := app.Conf.ArticleIndexTypes.Draft
-	ctx := context.Background()
-	article, d := getFullArticle(client, ctx, index, typ, articleId, logger)
+	article, d := getStoredArticle(app, app.Conf.ArticleIndexTypes.Draft, articleId, logger)
 	if d != nil {
 		return d
 	}
 	article.Guid = article.Id // in case it is a newly created article without any "save"
 	// lock on the article draft
-	lock := draftLock.Get(article.Id)
-	lock.Lock()
-	defer lock.Unlock()
-	// set article props for the new version
-	jt := article.RevisedAt
-	ver := jt.T.UnixNano()
-	verGuid := fmt.Sprintf("%v:%v", article.Guid, ver)
-	article.Id = verGuid
-	article.Version = strconv.FormatInt(ver, 10)
-	if article.FromVersion == "0" { // it's the first version
-		article.CreatedAt = jt
-		article.CreatedBy = user.Username
-	}
-	article.RevisedAt = jt
-	article.RevisedBy = user.Username
-	article.LockedBy = ""
-	// create the new version
-	idxService := client.Index()
-	idxService.Index(index)
-	idxService.Type(app.Conf.ArticleIndexTypes.Version)
-	idxService.OpType(ESIndexOpCreate)
-	idxService.Id(article.Id)
-	idxService.BodyJson(article)
-	idxResp, err := idxService.Do(ctx)
-	if err != nil {
-		body := fmt.Sprintf("failed to create new article version %v, error: %v", verGuid, err)
-		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
-	} else if !idxResp.Created {
-		body := fmt.Sprintf("no reason but article new version %v is not created!", verGuid)
-		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
-	}
-	// delete article from draft
-	delService := app.Elastic.Client.Delete()
-	delService.Index(app.Conf.ArticleIndex.Name)
-	delService.Type(app.Conf.ArticleIndexTypes.Draft)
-	delService.Id(article.Guid)
-	delService.Refresh("wait_for")
-	_, err = delService.Do(ctx)
-	if err != nil {
-		body := fmt.Sprintf("failed to delete article draft %v, error: %v", article.Guid, err)
-		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
-	}
-	// return article version
-	article.Headline = ""
-	article.Summary = ""
-	article.Content = ""
-	article.Tag = nil
-	article.Note = ""
-	if bytes, err := json.Marshal(article); err == nil {
-		logger.Pinfof("user %v submited article version %v", user.Username, verGuid)
-		return CreateRespData(http.StatusOK, ContentTypeValueJSON, bytes)
-	} else {
-		body := fmt.Sprintf("error marshaling article %v: %v", verGuid, err)
-		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
+	lock, d := lockArticle(app.DraftLock, article.Id, logger)
+	if d != nil {
+		return d
 	}
+	defer lock.Unlock()
+	return createArticleVersion(app, user, article, logger)
 }
 
 func discardArticleOther(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
@@ -584,12 +591,7 @@ func discardArticleOther(app *AppRuntime, w http.ResponseWriter, r *http.Request
 	articleId := StringFromReq(r, CtxKeyId)
 	username := CmsUserFromReq(r).Username
 
-	delService := app.Elastic.Client.Delete()
-	delService.Index(app.Conf.ArticleIndex.Name)
-	delService.Type(app.Conf.ArticleIndexTypes.Draft)
-	delService.Id(articleId)
-	delService.Refresh("wait_for")
-	_, err := delService.Do(context.Background())
+	err := app.Articles.DeleteDraft(context.Background(), articleId)
 	if err != nil {
 		body := fmt.Sprintf("failed to delete article draft %v, error: %v", articleId, err)
 		logger.Perror(body)
@@ -604,11 +606,7 @@ func editArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpR
 	logger := CtxLoggerFromReq(r)
 	// first get the article from the version type
 	articleId := StringFromReq(r, CtxKeyId)
-	client := app.Elastic.Client
-	index := app.Conf.ArticleIndex.Name
-	typ := app.Conf.ArticleIndexTypes.Version
-	ctx := context.Background()
-	article, d := getFullArticle(client, ctx, index, typ, articleId, logger)
+	article, d := getStoredArticle(app, app.Conf.ArticleIndexTypes.Version, articleId, logger)
 	if d != nil {
 		return d
 	}
@@ -631,27 +629,23 @@ func editArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpR
 	article.RevisedAt = jt
 	article.RevisedBy = username
 	article.LockedBy = username
-	// now try to index (create) it as type draft
-	idxService := client.Index()
-	idxService.Index(index)
-	idxService.Type(app.Conf.ArticleIndexTypes.Draft)
-	idxService.OpType(ESIndexOpCreate)
-	idxService.Id(article.Id)
-	idxService.BodyJson(article)
-	idxService.Refresh("wait_for")
-
-	lock := draftLock.Get(article.Id)
-	lock.Lock()
+	article.LockedAt = jt
+	article.LockHeartbeat = jt
+	article.State = ""
+	article.StateBy = ""
+	article.StateAt = nil
+	article.StateReason = ""
+	// now try to create it as type draft
+	lock, d := lockArticle(app.DraftLock, article.Id, logger)
+	if d != nil {
+		return d
+	}
 	defer lock.Unlock()
-	resp, err := idxService.Do(ctx)
+	_, err := app.Articles.CreateDraft(context.Background(), article)
 	if err != nil {
 		body := fmt.Sprintf("failed to create article draft %v, error: %v", article.Id, err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
-	} else if !resp.Created {
-		body := fmt.Sprintf("no reason but article draft %v is not created!", article.Id)
-		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
 	} else {
 		if bytes, err := json.Marshal(article); err == nil {
 			logger.Pinfof("user %v created article draft %v", username, article.Id)
@@ -664,72 +658,81 @@ func editArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpR
 	}
 }
 
-func publishArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
-	logger := CtxLoggerFromReq(r)
-	// load article from version
-	client := app.Elastic.Client
-	ctx := context.Background()
-	index := app.Conf.ArticleIndex.Name
-	typ := app.Conf.ArticleIndexTypes.Version
-	id := StringFromReq(r, CtxKeyId)
-	article, d := getFullArticle(client, ctx, index, typ, id, logger)
-	if d != nil {
-		return d
+// copies the given article version into publish
+func publishArticleVersion(app *AppRuntime, username string, article *Article, logger *JsonLogger) *HttpResponseData {
+	if app.Conf.RequireApproval && article.State != ArticleStateApproved {
+		body := fmt.Sprintf("article version %v:%v is not approved (state: %v)!", article.Guid, article.Version, article.GetState())
+		logger.Perror(body)
+		return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
 	}
-	// upsert it into publish
 	guid := article.Guid
 	article.Id = guid
 	article.LockedBy = ""
-	updService := client.Update()
-	updService.Index(index)
-	updService.Type(app.Conf.ArticleIndexTypes.Publish)
-	updService.Id(guid)
-	updService.Doc(article)
-	updService.DocAsUpsert(true)
-	updService.Refresh("wait_for")
-
-	lock := publishLock.Get(guid)
-	lock.Lock()
+	article.LockedAt = nil
+	article.LockHeartbeat = nil
+	article.PublishedAt = &JSONTime{time.Now().UTC()}
+
+	lock, d := lockArticle(app.PublishLock, guid, logger)
+	if d != nil {
+		return d
+	}
 	defer lock.Unlock()
-	_, err := updService.Do(ctx)
+	err := app.Articles.UpsertPublish(context.Background(), article)
 	articleVerGuid := fmt.Sprintf("%v:%v", guid, article.Version)
 	if err != nil {
 		body := fmt.Sprintf("failed to publish article version %v, error: %v", articleVerGuid, err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
-	} else {
-		logger.Pinfof("user %v published article version %v", CmsUserFromReq(r).Username, articleVerGuid)
-		return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
 	}
+	logger.Pinfof("user %v published article version %v", username, articleVerGuid)
+	return nil
 }
 
-func unpublishArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+func publishArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
 	logger := CtxLoggerFromReq(r)
-	articleId := StringFromReq(r, CtxKeyId)
-	delService := app.Elastic.Client.Delete()
-	delService.Index(app.Conf.ArticleIndex.Name)
-	delService.Type(app.Conf.ArticleIndexTypes.Publish)
-	delService.Id(articleId)
-	delService.Refresh("wait_for")
-
-	lock := publishLock.Get(articleId)
-	lock.Lock()
+	// load article from version
+	id := StringFromReq(r, CtxKeyId)
+	article, d := getStoredArticle(app, app.Conf.ArticleIndexTypes.Version, id, logger)
+	if d != nil {
+		return d
+	}
+	// upsert it into publish
+	if d = publishArticleVersion(app, CmsUserFromReq(r).Username, article, logger); d != nil {
+		return d
+	}
+	return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
+}
+
+// deletes the published article
+func unpublishArticleGuid(app *AppRuntime, username, guid string, logger *JsonLogger) *HttpResponseData {
+	lock, d := lockArticle(app.PublishLock, guid, logger)
+	if d != nil {
+		return d
+	}
 	defer lock.Unlock()
-	_, err := delService.Do(context.Background())
+	err := app.Articles.DeletePublish(context.Background(), guid)
 	if err != nil {
-		if elastic.IsNotFound(err) {
-			body := fmt.Sprintf("article %v not found!", articleId)
+		if err == ErrStoreNotFound {
+			body := fmt.Sprintf("article %v not found!", guid)
 			logger.Perror(body)
 			return CreateNotFoundRespData(body)
 		} else {
-			body := fmt.Sprintf("failed to unpublish article %v, error: %v", articleId, err)
+			body := fmt.Sprintf("failed to unpublish article %v, error: %v", guid, err)
 			logger.Perror(body)
 			return CreateInternalServerErrorRespData(body)
 		}
-	} else {
-		logger.Pinfof("user %v unpublished article %v", CmsUserFromReq(r).Username, articleId)
-		return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
 	}
+	logger.Pinfof("user %v unpublished article %v", username, guid)
+	return nil
+}
+
+func unpublishArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	articleId := StringFromReq(r, CtxKeyId)
+	if d := unpublishArticleGuid(app, CmsUserFromReq(r).Username, articleId, logger); d != nil {
+		return d
+	}
+	return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
 }
 
 type ArticleVersions []*Article
@@ -746,6 +749,7 @@ type CmsArticle struct {
 	Draft     *Article        `json:"draft,omitempty"`
 	Versions  ArticleVersions `json:"versions,omitempty"`
 	Publish   *Article        `json:"publish,omitempty"`
+	Lock      *ArticleLock    `json:"lock,omitempty"`
 }
 
 type CmsArticles []*CmsArticle
@@ -802,29 +806,23 @@ func getCmsArticles(app *AppRuntime, w http.ResponseWriter, r *http.Request) *Ht
 	if d != nil {
 		return d
 	}
-	search := app.Elastic.Client.Search(app.Conf.ArticleIndex.Name)
-	search.Type(inputTypes...)
-	query := elastic.NewBoolQuery().Filter(
-		elastic.NewExistsQuery("guid"),
-		elastic.NewRangeQuery("created_at").Gte(before),
-	)
-	search.Query(query)
-	search.Size(10000)
-	search.FetchSource(true)
-	search.SortBy(
-		elastic.NewFieldSort("created_at").Desc().UnmappedType("date"),
-		elastic.NewFieldSort("_uid"),
-	)
-	if searchAfter != nil {
-		search.SearchAfter(searchAfter...)
-	}
-	resp, err := search.Do(context.Background())
+	tags, d := resolveQueryTags(app, parseQueryListValue(q, "tag"), logger)
+	if d != nil {
+		return d
+	}
+	docs, err := app.Articles.Search(context.Background(), &ArticleQuery{
+		Types:        inputTypes,
+		CreatedAfter: before,
+		Tag:          tags,
+		SearchAfter:  searchAfter,
+		Size:         10000,
+	})
 	if err != nil {
-		body := fmt.Sprintf("failed to query elasticsearch, error: %v", err)
+		body := fmt.Sprintf("failed to search articles, error: %v", err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
 	}
-	if resp.Hits.TotalHits <= 0 {
+	if len(docs) <= 0 {
 		return CreateJsonRespData(http.StatusOK, &CmsArticlesResponseBody{
 			Articles: make([]*CmsArticle, 0),
 			Before:   &JSONTime{T: before},
@@ -833,13 +831,8 @@ func getCmsArticles(app *AppRuntime, w http.ResponseWriter, r *http.Request) *Ht
 	types := app.Conf.ArticleIndexTypes
 	articleMap := make(map[string]*CmsArticle)
 	var lastSort []interface{}
-	for _, hit := range resp.Hits.Hits {
-		one, err := unmarshalArticle(*hit.Source)
-		if err != nil {
-			logger.Pwarnf("failed to unmarshal article (%v): %v, error: %v", hit.Type, string(*hit.Source), err)
-			continue
-		}
-		one.Id = hit.Id
+	for _, doc := range docs {
+		one := doc.Article
 		var a *CmsArticle
 		var ok bool
 		if a, ok = articleMap[one.Guid]; !ok {
@@ -850,22 +843,23 @@ func getCmsArticles(app *AppRuntime, w http.ResponseWriter, r *http.Request) *Ht
 			}
 			articleMap[a.Guid] = a
 		}
-		if hit.Type == types.Draft {
+		if doc.Type == types.Draft {
 			a.Draft = one
-		} else if hit.Type == types.Version {
+		} else if doc.Type == types.Version {
 			a.Versions = append(a.Versions, one)
-		} else if hit.Type == types.Publish {
+		} else if doc.Type == types.Publish {
 			a.Publish = one
 		} else { // should not happen
-			logger.Pwarnf("unknown type %v in article index.", hit.Type)
+			logger.Pwarnf("unknown type %v in article index.", doc.Type)
 		}
-		lastSort = hit.Sort
+		lastSort = doc.Sort
 	}
 	var articles CmsArticles = make([]*CmsArticle, 0, len(articleMap))
 	for _, a := range articleMap {
 		if len(a.Versions) > 0 {
 			sort.Stable(sort.Reverse(a.Versions))
 		}
+		a.Lock = getArticleLock(app.Conf, a.Draft)
 		articles = append(articles, a)
 	}
 	if len(articles) > 0 {
@@ -898,57 +892,38 @@ func getCmsArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *Htt
 	articleId := StringFromReq(r, CtxKeyId)
 
 	types := app.Conf.ArticleIndexTypes
-	search := app.Elastic.Client.Search(app.Conf.ArticleIndex.Name)
-	search.Type(types.Draft, types.Version, types.Publish)
-	search.Query(elastic.NewConstantScoreQuery(elastic.NewTermQuery("guid", articleId)))
-	search.FetchSource(true)
-	resp, err := search.Do(context.Background())
+	docs, err := app.Articles.GetByGuid(context.Background(), articleId)
 	if err != nil {
-		body := fmt.Sprintf("failed to query elasticsearch, error: %v", err)
+		body := fmt.Sprintf("failed to get article %v, error: %v", articleId, err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
 	}
-	if resp.Hits.TotalHits <= 0 {
+	if len(docs) <= 0 {
 		body := fmt.Sprintf("Article %v is not found!", articleId)
 		return CreateNotFoundRespData(body)
 	}
 	article := &CmsArticle{
 		Versions: make([]*Article, 0),
 	}
-	for _, hit := range resp.Hits.Hits {
-		var a *Article
-		var err error
-		if hit.Type == types.Draft {
-			if a, err = unmarshalArticle(*hit.Source); err == nil {
-				article.Draft = a
-				article.Guid = a.Guid
-				article.CreatedAt = a.CreatedAt
-			} else {
-				logger.Pwarnf("failed to unmarshal article draft: %v, error: %v", string(*hit.Source), err)
-			}
-		} else if hit.Type == types.Version {
-			if a, err = unmarshalArticle(*hit.Source); err == nil {
-				article.Versions = append(article.Versions, a)
-				article.Guid = a.Guid
-				article.CreatedAt = a.CreatedAt
-			} else {
-				logger.Pwarnf("failed to unmarshal article version: %v, error: %v", string(*hit.Source), err)
-			}
-		} else if hit.Type == types.Publish {
-			if a, err = unmarshalArticle(*hit.Source); err == nil {
-				article.Publish = a
-				article.Guid = a.Guid
-				article.CreatedAt = a.CreatedAt
-			} else {
-				logger.Pwarnf("failed to unmarshal article publish: %v, error: %v", string(*hit.Source), err)
-			}
+	for _, doc := range docs {
+		a := doc.Article
+		if doc.Type == types.Draft {
+			article.Draft = a
+		} else if doc.Type == types.Version {
+			article.Versions = append(article.Versions, a)
+		} else if doc.Type == types.Publish {
+			article.Publish = a
 		} else {
-			logger.Pwarnf("unknown type %v in article index.", hit.Type)
+			logger.Pwarnf("unknown type %v in article index.", doc.Type)
+			continue
 		}
+		article.Guid = a.Guid
+		article.CreatedAt = a.CreatedAt
 	}
 	if len(article.Versions) > 0 {
 		sort.Stable(article.Versions)
 	}
+	article.Lock = getArticleLock(app.Conf, article.Draft)
 	return CreateJsonRespData(http.StatusOK, article)
 }
 
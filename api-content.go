@@ -0,0 +1,177 @@
+/*
+   /content/v1/articles         GET  [publish (search)]  no auth, no lock
+   /content/v1/articles/{guid}  GET  [publish (read)]    no auth, no lock
+
+   read-only api of the published articles, e.g.
+   /content/v1/articles?tag=elections,politics&size=20&cursorMark=...
+   pages are sorted by created_at desc, cursor_mark in the response is for
+   the next page (only set when the page is full). Both send an ETag, a
+   single article sends Last-Modified (when it was published) too, lists
+   don't as removed (unpublished) articles wouldn't change it.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	ContentApiPath = "/content/v1/articles"
+
+	contentCacheControl = "public, max-age=60"
+)
+
+// Published article as served by the content api, without the cms-only
+// fields (note, lock, review state, ...). Content is the markdown/plain
+// source, or the sanitized html (never the html as stored), content_html
+// is the content rendered like on the public site.
+type ContentArticle struct {
+	Guid          string          `json:"guid"`
+	Version       string          `json:"version"`
+	Url           string          `json:"url"`
+	Headline      string          `json:"headline"`
+	Summary       string          `json:"summary"`
+	Content       string          `json:"content"`
+	ContentHTML   string          `json:"content_html"`
+	ContentFormat ContentFormat   `json:"content_format"`
+	Body          []*ArticleBlock `json:"body,omitempty"`
+	Tag           []string        `json:"tag"`
+	LeadImage     *ArticleMedia   `json:"lead_image,omitempty"`
+	CreatedAt     *JSONTime       `json:"created_at"`
+	RevisedAt     *JSONTime       `json:"revised_at"`
+	PublishedAt   *JSONTime       `json:"published_at,omitempty"`
+}
+
+type ContentArticlesResponseBody struct {
+	Articles   []*ContentArticle `json:"articles"`
+	CursorMark string            `json:"cursor_mark,omitempty"`
+}
+
+func NewContentArticle(a *Article) *ContentArticle {
+	format := a.ContentFormat
+	if format == "" {
+		format = ContentFormatPlain
+	}
+	tag := a.Tag
+	if tag == nil {
+		tag = make([]string, 0)
+	}
+	contentHTML := string(RenderContentHTML(format, a.Content))
+	content := a.Content
+	if format == ContentFormatHTML {
+		content = contentHTML
+	}
+	return &ContentArticle{
+		Guid:          a.Guid,
+		Version:       a.Version,
+		Url:           fmt.Sprintf("/article?id=%v:%v", a.Guid, a.Version),
+		Headline:      a.Headline,
+		Summary:       a.Summary,
+		Content:       content,
+		ContentHTML:   contentHTML,
+		ContentFormat: format,
+		Body:          a.Body,
+		Tag:           tag,
+		LeadImage:     a.LeadImage,
+		CreatedAt:     a.CreatedAt,
+		RevisedAt:     a.RevisedAt,
+		PublishedAt:   a.PublishedAt,
+	}
+}
+
+// when the published article last changed, articles published before
+// published_at existed fall back to the revised time
+func articlePublishedTime(a *Article) time.Time {
+	if a.PublishedAt != nil {
+		return a.PublishedAt.T
+	}
+	if a.RevisedAt != nil {
+		return a.RevisedAt.T
+	}
+	return time.Time{}
+}
+
+// json response with ETag (and Last-Modified unless zero), or 304 if the
+// client has it already
+func createContentRespData(r *http.Request, val interface{}, lastModified time.Time, logger *JsonLogger) *HttpResponseData {
+	data, err := json.Marshal(val)
+	if err != nil {
+		body := fmt.Sprintf("failed to marshal response, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	return CreateCacheableRespData(r, ContentTypeValueJSON, data, lastModified, contentCacheControl)
+}
+
+func getContentArticles(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	values := r.URL.Query()
+	size, d := ParseQueryIntValue(values, "size", false, 20, 1, 100)
+	if d != nil {
+		return d
+	}
+	searchAfter, d := DecodeCursorMark(values)
+	if d != nil {
+		return d
+	}
+	tags, d := resolveQueryTags(app, parseQueryListValue(values, "tag"), logger)
+	if d != nil {
+		return d
+	}
+	docs, err := app.Articles.Search(context.Background(), &ArticleQuery{
+		Types:       []string{app.Conf.ArticleIndexTypes.Publish},
+		Tag:         tags,
+		SearchAfter: searchAfter,
+		Size:        size,
+	})
+	if err != nil {
+		body := fmt.Sprintf("failed to search published articles, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	resp := &ContentArticlesResponseBody{
+		Articles: make([]*ContentArticle, len(docs)),
+	}
+	for i, doc := range docs {
+		resp.Articles[i] = NewContentArticle(doc.Article)
+	}
+	if len(docs) == size {
+		if resp.CursorMark, err = EncodeCursorMark(docs[len(docs)-1].Sort); err != nil {
+			body := fmt.Sprintf("failed to encode sort %v, error: %v", docs[len(docs)-1].Sort, err)
+			logger.Perror(body)
+			return CreateInternalServerErrorRespData(body)
+		}
+	}
+	return createContentRespData(r, resp, time.Time{}, logger)
+}
+
+func getContentArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	guid := strings.TrimPrefix(r.URL.Path, ContentApiPath+"/")
+	if guid == "" || strings.Contains(guid, "/") {
+		return CreateNotFoundRespData(fmt.Sprintf("article %v not found!", guid))
+	}
+	article, err := app.Articles.Get(context.Background(), app.Conf.ArticleIndexTypes.Publish, guid)
+	if err == ErrStoreNotFound {
+		return CreateNotFoundRespData(fmt.Sprintf("article %v not found!", guid))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to get published article %v, error: %v", guid, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	return createContentRespData(r, NewContentArticle(article), articlePublishedTime(article), logger)
+}
+
+func ContentArticlesGet() EndpointHandler {
+	return getContentArticles
+}
+
+func ContentArticleGet() EndpointHandler {
+	return getContentArticle
+}
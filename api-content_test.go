@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testContentRequest(path string, header ...string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	return r.WithContext(WithCtxLogger(r.Context(), NewJsonLogger(ioutil.Discard), "test"))
+}
+
+func TestNotModified(t *testing.T) {
+	now := time.Now().UTC()
+	for _, c := range []struct {
+		header   []string
+		expected bool
+	}{
+		{nil, false},
+		{[]string{"If-None-Match", `"a", "b"`}, true},
+		{[]string{"If-None-Match", `W/"b"`}, true},
+		{[]string{"If-None-Match", `"c"`}, false},
+		{[]string{"If-Modified-Since", now.Format(http.TimeFormat)}, true},
+		{[]string{"If-Modified-Since", now.Add(-time.Hour).Format(http.TimeFormat)}, false},
+		// If-None-Match wins
+		{[]string{"If-None-Match", `"c"`, "If-Modified-Since", now.Format(http.TimeFormat)}, false},
+	} {
+		if NotModified(testContentRequest("/", c.header...), `"b"`, now) != c.expected {
+			t.Errorf("expecting not modified %v with headers %v", c.expected, c.header)
+		}
+	}
+}
+
+func TestContentArticles(t *testing.T) {
+	app := &AppRuntime{
+		Conf:     &AppConf{ArticleIndexTypes: articleIndexTypes},
+		Articles: NewMemoryArticleStore(articleIndexTypes),
+		Taxonomy: NewMemoryTaxonomyStore(),
+	}
+	ctx := context.Background()
+	if err := app.Taxonomy.CreateTerm(ctx, &TaxonomyTerm{Id: "a", Synonyms: []string{"alpha"}}); err != nil {
+		t.Fatalf("failed to create term, error: %v", err)
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i, tag := range []string{"a", "b", "a"} {
+		guid := string('x' + rune(i))
+		article := &Article{
+			Id:          guid,
+			Guid:        guid,
+			Version:     "1",
+			Headline:    "headline " + guid,
+			Tag:         []string{tag},
+			Note:        "secret note",
+			CreatedAt:   &JSONTime{now.Add(time.Duration(i) * time.Minute)},
+			RevisedAt:   &JSONTime{now},
+			PublishedAt: &JSONTime{now},
+		}
+		if err := app.Articles.UpsertPublish(ctx, article); err != nil {
+			t.Fatalf("failed to publish article, error: %v", err)
+		}
+		// drafts aren't served
+		app.Articles.CreateDraft(ctx, &Article{Id: "draft" + guid, Guid: "draft" + guid, CreatedAt: &JSONTime{now}})
+	}
+
+	d := getContentArticles(app, nil, testContentRequest("/content/v1/articles?size=2"))
+	body, _ := ioutil.ReadAll(d.Body)
+	if d.Status != http.StatusOK || strings.Contains(string(body), "secret") || strings.Contains(string(body), "locked_by") {
+		t.Errorf("unexpected response %v: %s", d.Status, body)
+		return
+	}
+	resp := &ContentArticlesResponseBody{}
+	json.Unmarshal(body, resp)
+	if len(resp.Articles) != 2 || resp.Articles[0].Guid != "z" || resp.Articles[1].Guid != "y" || resp.CursorMark == "" {
+		t.Errorf("unexpected first page %s", body)
+		return
+	}
+	etag := d.Header.Get("ETag")
+	if d = getContentArticles(app, nil, testContentRequest("/content/v1/articles?size=2", "If-None-Match", etag)); d.Status != http.StatusNotModified {
+		t.Errorf("expecting 304, but got %v", d.Status)
+	}
+	d = getContentArticles(app, nil, testContentRequest("/content/v1/articles?size=2&cursorMark="+resp.CursorMark))
+	body, _ = ioutil.ReadAll(d.Body)
+	resp = &ContentArticlesResponseBody{}
+	json.Unmarshal(body, resp)
+	if len(resp.Articles) != 1 || resp.Articles[0].Guid != "x" || resp.CursorMark != "" {
+		t.Errorf("unexpected second page %s", body)
+	}
+	// resolved through the taxonomy, like the cms filters
+	for _, tag := range []string{"A", "Alpha"} {
+		d = getContentArticles(app, nil, testContentRequest("/content/v1/articles?tag="+tag))
+		body, _ = ioutil.ReadAll(d.Body)
+		resp = &ContentArticlesResponseBody{}
+		json.Unmarshal(body, resp)
+		if len(resp.Articles) != 2 || resp.Articles[0].Guid != "z" || resp.Articles[1].Guid != "x" {
+			t.Errorf("unexpected articles tagged %v %s", tag, body)
+		}
+	}
+
+	d = getContentArticle(app, nil, testContentRequest("/content/v1/articles/y"))
+	body, _ = ioutil.ReadAll(d.Body)
+	article := &ContentArticle{}
+	json.Unmarshal(body, article)
+	if d.Status != http.StatusOK || article.Guid != "y" || article.ContentFormat != ContentFormatPlain {
+		t.Errorf("unexpected article %v: %s", d.Status, body)
+	}
+	lastModified := d.Header.Get("Last-Modified")
+	if lastModified != now.Format(http.TimeFormat) {
+		t.Errorf("unexpected Last-Modified %v", lastModified)
+	}
+	if d = getContentArticle(app, nil, testContentRequest("/content/v1/articles/y", "If-Modified-Since", lastModified)); d.Status != http.StatusNotModified {
+		t.Errorf("expecting 304, but got %v", d.Status)
+	}
+	// html content is served sanitized
+	unsafe := &Article{
+		Id:            "h",
+		Guid:          "h",
+		Version:       "1",
+		Content:       `<p onclick="steal()">hi</p><script>steal()</script>`,
+		ContentFormat: ContentFormatHTML,
+		RevisedAt:     &JSONTime{now},
+	}
+	if err := app.Articles.UpsertPublish(ctx, unsafe); err != nil {
+		t.Fatalf("failed to publish article, error: %v", err)
+	}
+	d = getContentArticle(app, nil, testContentRequest("/content/v1/articles/h"))
+	body, _ = ioutil.ReadAll(d.Body)
+	article = &ContentArticle{}
+	json.Unmarshal(body, article)
+	for _, html := range []string{article.Content, article.ContentHTML} {
+		if d.Status != http.StatusOK || !strings.Contains(html, "<p>hi</p>") || strings.Contains(html, "<script") || strings.Contains(html, "onclick") {
+			t.Errorf("expecting sanitized html content, but got %v: %s", d.Status, body)
+		}
+	}
+	for _, path := range []string{"/content/v1/articles/draftx", "/content/v1/articles/", "/content/v1/articles/x/y"} {
+		if d = getContentArticle(app, nil, testContentRequest(path)); d.Status != http.StatusNotFound {
+			t.Errorf("expecting 404 for %v, but got %v", path, d.Status)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+/*
+   /.well-known/jwks.json    GET  [-]  no auth, no lock
+
+   public keys (JWKS, RFC 7517) to verify the jwt access tokens with, so
+   other services don't need to call back. Not found unless issuing jwt,
+   and empty for HS256 as its secrets are never published.
+*/
+
+package main
+
+import (
+	"net/http"
+)
+
+const (
+	JWKSPath = "/.well-known/jwks.json"
+
+	ContentTypeValueJWKS = "application/jwk-set+json"
+
+	// short enough for rotated keys to show up well before they sign
+	jwksCacheControl = "public, max-age=300"
+)
+
+func getJWKS(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	if app.JWTKeys == nil {
+		return CreateNotFoundRespData("jwt is not enabled!")
+	}
+	d := CreateJsonRespData(http.StatusOK, app.JWTKeys.JWKS())
+	if d.Status == http.StatusOK {
+		d.Header.Set(HeaderContentType, ContentTypeValueJWKS)
+		d.Header.Set("Cache-Control", jwksCacheControl)
+	}
+	return d
+}
+
+func JWKSGet() EndpointHandler {
+	return getJWKS
+}
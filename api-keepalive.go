@@ -9,6 +9,13 @@ import (
 
 func Keepalive(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
 	//CtxLoggerFromReq(r).Print("logging from /keepalive handler.")
+	if app.Elastic == nil { // nothing to check for non-elasticsearch storage
+		return &HttpResponseData{
+			Status: http.StatusOK,
+			Header: CreateHeader(HeaderContentType, ContentTypeValueText),
+			Body:   strings.NewReader("I'm all good!"),
+		}
+	}
 	ctx := context.Background()
 	if resp, err := app.Elastic.Client.ClusterHealth().Do(ctx); err == nil {
 		if resp.Status == "red" {
@@ -2,160 +2,381 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"time"
-
-	elastic "github.com/yizha/elastic"
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthToken struct {
 	Token  string `json:"token"`
 	Expire string `json:"expire"`
 	Role   uint32 `json:"role"`
+
+	// "Bearer" for a jwt (sent in the Authorization header), empty for
+	// a securecookie token (sent in the X-Auth-Token header)
+	TokenType string `json:"token_type,omitempty"`
+
+	// only set on login, not on refresh
+	RefreshToken  string `json:"refresh_token,omitempty"`
+	RefreshExpire string `json:"refresh_expire,omitempty"`
+}
+
+// body of POST /api/login and /api/manage/login
+type LoginRequestBody struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// body of POST /api/login/create and /api/login/update, role is a comma
+// separated list of role names, leave it out to keep the role on update
+type LoginManageRequestBody struct {
+	Username string  `json:"username"`
+	Password string  `json:"password"`
+	Role     *string `json:"role"`
+}
+
+// body of POST /api/token/refresh and /api/logout
+type RefreshRequestBody struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
+// max size of a login/refresh/logout request body
+const maxAuthRequestSize = 64 << 10
+
 func getCmsUser(app *AppRuntime, username string) (*CmsUser, *HttpResponseData) {
-	getService := app.Elastic.Client.Get()
-	getService.Index(app.Conf.UserIndex.Name)
-	getService.Type(app.Conf.UserIndexTypes.User)
-	getService.FetchSource(true)
-	getService.Realtime(false)
-	getService.Id(username)
-	resp, err := getService.Do(context.Background())
+	user, err := app.Users.GetUser(context.Background(), username)
+	if err == nil {
+		return user, nil
+	} else if err == ErrStoreNotFound {
+		return nil, CreateForbiddenRespData("username")
+	} else {
+		body := fmt.Sprintf("failed to get cms user, error: %v", err)
+		return nil, CreateInternalServerErrorRespData(body)
+	}
+}
+
+// decodes the json request body into val
+func parseAuthRequestBody(r *http.Request, val interface{}) *HttpResponseData {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxAuthRequestSize+1))
 	if err != nil {
-		if elastic.IsNotFound(err) {
-			return nil, CreateForbiddenRespData("username")
-		} else {
-			body := fmt.Sprintf("failed to query elasticsearch, error: %v", err)
-			return nil, CreateInternalServerErrorRespData(body)
+		return CreateBadRequestRespData(fmt.Sprintf("failed to read request body, error: %v", err))
+	}
+	if len(data) > maxAuthRequestSize {
+		return CreateBadRequestRespData("request body is too large!")
+	}
+	if err := json.Unmarshal(data, val); err != nil {
+		return CreateBadRequestRespData(fmt.Sprintf("failed to parse request body, error: %v", err))
+	}
+	return nil
+}
+
+// username and password from the json body of a POST login, or from the
+// query args of the deprecated GET one (only allowed with -legacy-get-login)
+func parseLoginCredentials(r *http.Request) (string, string, *HttpResponseData) {
+	if r.Method == http.MethodGet {
+		args := r.URL.Query()
+		username, d := ParseQueryStringValue(args, "username", true, "")
+		if d != nil {
+			return "", "", d
 		}
-	} else {
-		var user CmsUser
-		if err = json.Unmarshal(*resp.Source, &user); err == nil {
-			return &user, nil
-		} else {
-			body := fmt.Sprintf("failed to unmarshal cms user, error: %v", err)
-			return nil, CreateInternalServerErrorRespData(body)
+		password, d := ParseQueryStringValue(args, "password", true, "")
+		if d != nil {
+			return "", "", d
 		}
+		CtxLoggerFromReq(r).Pwarnf("user %v logged in with the deprecated GET %v, use POST instead", username, r.URL.Path)
+		return username, password, nil
 	}
+	body := &LoginRequestBody{}
+	if d := parseAuthRequestBody(r, body); d != nil {
+		return "", "", d
+	}
+	if body.Username == "" {
+		return "", "", CreateBadRequestRespData(`missing "username"!`)
+	}
+	if body.Password == "" {
+		return "", "", CreateBadRequestRespData(`missing "password"!`)
+	}
+	return body.Username, body.Password, nil
 }
 
-func manageLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
-	args := r.URL.Query()
-	username, d := ParseQueryStringValue(args, "username", true, "")
-	if d != nil {
-		return d
+// the json body of a POST login create/update, passwords in the query
+// string (which ends up in access logs and browser history) are rejected
+func parseLoginManageRequestBody(r *http.Request) (*LoginManageRequestBody, *HttpResponseData) {
+	if _, ok := r.URL.Query()["password"]; ok {
+		return nil, CreateBadRequestRespData("password is not allowed in the query string, send it in the POST json body!")
 	}
-	password, d := ParseQueryStringValue(args, "password", true, "")
-	if d != nil {
-		return d
+	body := &LoginManageRequestBody{}
+	if d := parseAuthRequestBody(r, body); d != nil {
+		return nil, d
 	}
-	user, d := getCmsUser(app, username)
-	if d != nil {
-		return d
+	if body.Username == "" {
+		return nil, CreateBadRequestRespData(`missing "username"!`)
 	}
-	if (user.Role & CmsRoleLoginManage) != CmsRoleLoginManage {
-		return CreateForbiddenRespData("role")
+	return body, nil
+}
+
+// Verifies the password with the authenticators in turn until one knows
+// the user, external users are provisioned in the user store. An error of
+// an authenticator (like the ldap server being down) is logged and the
+// next one is tried, so local users can still login.
+func authenticateUser(app *AppRuntime, username, password string, logger *JsonLogger) (*CmsUser, *HttpResponseData) {
+	var failure error
+	for _, authenticator := range app.Authenticators {
+		user, err := authenticator.Authenticate(context.Background(), username, password)
+		switch err {
+		case nil:
+			if user.Provider == "" {
+				return user, nil
+			}
+			return provisionUser(app, user, logger)
+		case ErrAuthUnknownUser:
+			continue
+		case ErrAuthBadPassword:
+			logger.Perror(fmt.Sprintf("wrong password of user %v", username))
+			return nil, CreateForbiddenRespData("password")
+		case ErrAuthPasswordDisabled, ErrAuthNoRole:
+			if failure != nil {
+				// an earlier authenticator failed, which may know the user
+				continue
+			}
+			body := fmt.Sprintf("user %v can't login, error: %v", username, err)
+			logger.Perror(body)
+			return nil, CreateForbiddenRespData(body)
+		default:
+			failure = fmt.Errorf("failed to authenticate user %v, error: %v", username, err)
+			logger.Perror(failure.Error())
+		}
+	}
+	if failure != nil {
+		return nil, CreateInternalServerErrorRespData(failure.Error())
+	}
+	return nil, CreateForbiddenRespData("username")
+}
+
+// Gets the external user or creates it with the role on first login, the
+// role of an existing user is updated (and its sessions revoked) if it
+// changed. A user with a provider identity (subject) only logs in with
+// the same identity, the username alone may be reused by the provider.
+func provisionUser(app *AppRuntime, external *CmsUser, logger *JsonLogger) (*CmsUser, *HttpResponseData) {
+	ctx := context.Background()
+	provider, username, role := external.Provider, external.Username, external.Role
+	user, err := app.Users.GetUser(ctx, username)
+	if err == ErrStoreNotFound {
+		user = &CmsUser{
+			Username: username,
+			Role:     role,
+			Provider: provider,
+			Subject:  external.Subject,
+			Issuer:   external.Issuer,
+		}
+		if err = app.Users.CreateUser(ctx, user); err == nil {
+			logger.Pinfof("provisioned %v user %v", provider, user.String())
+			return user, nil
+		} else if err == ErrStoreConflict {
+			// created by a concurrent login
+			user, err = app.Users.GetUser(ctx, username)
+		}
 	}
-	logger := CtxLoggerFromReq(r)
-	//fmt.Println(password)
-	//fmt.Println(user.Password)
-	hashedPassword, err := base64.StdEncoding.DecodeString(user.Password)
 	if err != nil {
-		body := fmt.Sprintf("failed to hex decode user password loaded from elasticsearch, error: %v", err)
+		body := fmt.Sprintf("failed to provision %v user %v, error: %v", provider, username, err)
 		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
+		return nil, CreateInternalServerErrorRespData(body)
 	}
-	if err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password)); err != nil {
-		//fmt.Println(err)
-		logger.Perror(fmt.Sprintf("wrong password: %v", err))
-		return CreateForbiddenRespData("password")
+	if user.Provider != provider {
+		existing := "password"
+		if user.Provider != "" {
+			existing = user.Provider
+		}
+		body := fmt.Sprintf("user %v already exists as a %v user!", username, existing)
+		logger.Perror(body)
+		return nil, CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+	}
+	if user.Subject != "" && (user.Subject != external.Subject || user.Issuer != external.Issuer) {
+		logger.Perrorf("%v user %v is %v of %v, not %v of %v", provider, username, user.Subject, user.Issuer, external.Subject, external.Issuer)
+		return nil, CreateForbiddenRespData(fmt.Sprintf("%v user %v belongs to another identity!", provider, username))
+	}
+	if user.Subject == "" && external.Subject != "" {
+		// provisioned before identities were kept
+		fields := map[string]interface{}{"subject": external.Subject, "issuer": external.Issuer}
+		if err := app.Users.UpdateUser(ctx, username, fields); err != nil {
+			body := fmt.Sprintf("failed to update identity of %v user %v, error: %v", provider, username, err)
+			logger.Perror(body)
+			return nil, CreateInternalServerErrorRespData(body)
+		}
+		user.Subject, user.Issuer = external.Subject, external.Issuer
 	}
-	// clean hashed-password as we don't want it to be in the token
+	if user.Role != role {
+		if err := app.Users.UpdateUser(ctx, username, map[string]interface{}{"role": Role2Names(role)}); err != nil {
+			body := fmt.Sprintf("failed to update role of %v user %v, error: %v", provider, username, err)
+			logger.Perror(body)
+			return nil, CreateInternalServerErrorRespData(body)
+		}
+		logger.Pinfof("updated role of %v user %v from %v to %v", provider, username, Role2Names(user.Role), Role2Names(role))
+		user.Role = role
+		if _, d := revokeUserSessions(app, username, logger); d != nil {
+			return nil, d
+		}
+	}
+	return user, nil
+}
+
+func authExpire(maxAge time.Duration) string {
+	if maxAge <= 0 {
+		return ""
+	}
+	// substract one minute as buffer
+	return time.Now().UTC().Add(maxAge).Add(-1 * time.Minute).Format("2006-01-02T15:04:05.000Z")
+}
+
+// Creates the access token of the user for the session, and a refresh
+// token too if withRefresh is true
+func createAuthToken(app *AppRuntime, user *CmsUser, session *CmsSession, withRefresh bool) (*AuthToken, error) {
+	// clean hashed-password (and the sso identity) as we don't want it
+	// to be in the token
 	user.Password = ""
-	if token, err := app.Conf.SCookie.Encode(TokenCookieName, user); err == nil {
-		logger.Pinfof("user %v login successfully.", user.String())
-		// substract one minute as buffer
-		expire := time.Now().UTC().Add(app.Conf.SCookieMaxAge).Add(-1 * time.Minute)
-		return CreateJsonRespData(http.StatusOK, &AuthToken{
-			Token:  token,
-			Expire: expire.Format("2006-01-02T15:04:05.000Z"),
-			Role:   uint32(user.Role),
-		})
-	} else {
-		body := fmt.Sprintf("failed to encode user, error: %v", err)
+	user.Subject = ""
+	user.Issuer = ""
+	user.Session = session.Id
+	authToken := &AuthToken{
+		Expire: authExpire(app.Conf.SCookieMaxAge),
+		Role:   uint32(user.Role),
+	}
+	var err error
+	if app.Conf.TokenFormat == TokenFormatJWT {
+		claims := NewJWTClaims(user, app.Conf.JWTIssuer, app.Conf.SCookieMaxAge)
+		if authToken.Token, err = app.JWTKeys.Sign(claims); err != nil {
+			return nil, fmt.Errorf("failed to sign jwt, error: %v", err)
+		}
+		authToken.TokenType = AuthSchemeBearer
+	} else if authToken.Token, err = app.Conf.SCookie.Encode(TokenCookieName, user); err != nil {
+		return nil, fmt.Errorf("failed to encode user, error: %v", err)
+	}
+	if withRefresh {
+		refresh := &RefreshToken{Session: session.Id, Username: user.Username}
+		if authToken.RefreshToken, err = app.Conf.SCookieRefresh.Encode(RefreshTokenCookieName, refresh); err != nil {
+			return nil, fmt.Errorf("failed to encode refresh token, error: %v", err)
+		}
+		authToken.RefreshExpire = authExpire(app.Conf.SCookieRefreshMaxAge)
+	}
+	return authToken, nil
+}
+
+// login of users with any of the role, or of all users if it is 0
+func loginWithRole(role CmsRoleValue) EndpointHandler {
+	return func(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+		username, password, d := parseLoginCredentials(r)
+		if d != nil {
+			return d
+		}
+		user, d := authenticateUser(app, username, password, CtxLoggerFromReq(r))
+		if d != nil {
+			return d
+		}
+		if role > 0 && (user.Role&role) == 0 {
+			return CreateForbiddenRespData("role")
+		}
+		return loginResponse(app, r, user)
+	}
+}
+
+// Creates a session for the authenticated user, responds with its access
+// and refresh tokens
+func loginResponse(app *AppRuntime, r *http.Request, user *CmsUser) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	session := NewCmsSession(r, user.Username, app.Conf.SCookieRefreshMaxAge)
+	if err := app.Sessions.CreateSession(context.Background(), session); err != nil {
+		body := fmt.Sprintf("failed to create session, error: %v", err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
 	}
+	token, err := createAuthToken(app, user, session, true)
+	if err != nil {
+		logger.Perror(err.Error())
+		return CreateInternalServerErrorRespData(err.Error())
+	}
+	logger.Pinfof("user %v login successfully (session %v).", user.String(), session.Id)
+	return CreateJsonRespData(http.StatusOK, token)
 }
 
-func login(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
-	args := r.URL.Query()
-	username, d := ParseQueryStringValue(args, "username", true, "")
-	if d != nil {
-		return d
+// decodes the refresh token in the request body and gets its session,
+// 403 if it is invalid or the session is revoked/expired
+func parseRefreshToken(app *AppRuntime, r *http.Request) (*CmsSession, *HttpResponseData) {
+	body := &RefreshRequestBody{}
+	if d := parseAuthRequestBody(r, body); d != nil {
+		return nil, d
+	}
+	if body.RefreshToken == "" {
+		return nil, CreateBadRequestRespData(`missing "refresh_token"!`)
 	}
-	password, d := ParseQueryStringValue(args, "password", true, "")
+	refresh := &RefreshToken{}
+	if err := app.Conf.SCookieRefresh.Decode(RefreshTokenCookieName, body.RefreshToken, refresh); err != nil {
+		return nil, CreateForbiddenRespData(fmt.Sprintf("invalid refresh token, error: %v", err))
+	}
+	return getTokenSession(app, refresh.Session, refresh.Username, CtxLoggerFromReq(r))
+}
+
+// issues a new access token for a refresh token, the user is loaded again
+// so that role changes take effect and deleted users can't refresh
+func refreshToken(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	session, d := parseRefreshToken(app, r)
 	if d != nil {
 		return d
 	}
-	user, d := getCmsUser(app, username)
+	user, d := getCmsUser(app, session.Username)
 	if d != nil {
 		return d
 	}
-	logger := CtxLoggerFromReq(r)
-	//fmt.Println(password)
-	//fmt.Println(user.Password)
-	hashedPassword, err := base64.StdEncoding.DecodeString(user.Password)
-	if err != nil {
-		body := fmt.Sprintf("failed to hex decode user password loaded from elasticsearch, error: %v", err)
+	session.RefreshedAt = &JSONTime{time.Now().UTC()}
+	if err := app.Sessions.UpdateSession(context.Background(), session); err == ErrStoreNotFound {
+		return CreateForbiddenRespData("session is revoked!")
+	} else if err != nil {
+		body := fmt.Sprintf("failed to update session %v, error: %v", session.Id, err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
 	}
-	if err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password)); err != nil {
-		//fmt.Println(err)
-		logger.Perror(fmt.Sprintf("wrong password: %v", err))
-		return CreateForbiddenRespData("password")
-	}
-	// clean hashed-password as we don't want it to be in the token
-	user.Password = ""
-	if token, err := app.Conf.SCookie.Encode(TokenCookieName, user); err == nil {
-		logger.Pinfof("user %v login successfully.", user.String())
-		// substract one minute as buffer
-		expire := time.Now().UTC().Add(app.Conf.SCookieMaxAge).Add(-1 * time.Minute)
-		return CreateJsonRespData(http.StatusOK, &AuthToken{
-			Token:  token,
-			Expire: expire.Format("2006-01-02T15:04:05.000Z"),
-			Role:   uint32(user.Role),
-		})
-	} else {
-		body := fmt.Sprintf("failed to encode user, error: %v", err)
-		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
+	token, err := createAuthToken(app, user, session, false)
+	if err != nil {
+		logger.Perror(err.Error())
+		return CreateInternalServerErrorRespData(err.Error())
 	}
+	return CreateJsonRespData(http.StatusOK, token)
 }
 
-func createLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
-	args := r.URL.Query()
-	username, d := ParseQueryStringValue(args, "username", true, "")
+// deletes the session of the refresh token, which revokes both the
+// refresh token and the access tokens issued with it
+func logout(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	session, d := parseRefreshToken(app, r)
 	if d != nil {
 		return d
 	}
-	password, d := ParseQueryStringValue(args, "password", true, "")
-	if d != nil {
+	if d := deleteSession(app, session.Id, logger); d != nil {
 		return d
 	}
-	roleStr, d := ParseQueryStringValue(args, "role", false, "")
+	logger.Pinfof("user %v logout (session %v).", session.Username, session.Id)
+	return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
+}
+
+func createLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	req, d := parseLoginManageRequestBody(r)
 	if d != nil {
 		return d
 	}
+	if req.Password == "" {
+		return CreateBadRequestRespData(`missing "password"!`)
+	}
+	username := req.Username
+	roleStr := ""
+	if req.Role != nil {
+		roleStr = *req.Role
+	}
 	logger := CtxLoggerFromReq(r)
 	//fmt.Println("starting hashing password ...")
-	password, err := HashPassword(password)
+	password, err := HashPassword(req.Password)
 	if err != nil {
 		body := fmt.Sprintf("failed to hash (bcrypt) password, error: %v", err)
 		logger.Perror(body)
@@ -168,28 +389,17 @@ func createLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpR
 		Password: password,
 		Role:     role,
 	}
-	idxService := app.Elastic.Client.Index()
-	idxService.Index(app.Conf.UserIndex.Name)
-	idxService.Type(app.Conf.UserIndexTypes.User)
-	idxService.OpType(ESIndexOpCreate)
-	idxService.Refresh("wait_for")
-	idxService.Id(user.Username)
-	idxService.BodyJson(user)
-	resp, err := idxService.Do(context.Background())
+	err = app.Users.CreateUser(context.Background(), user)
 	if err != nil {
-		if elasticErr, ok := err.(*elastic.Error); ok {
-			body := elasticErr.Error()
+		if err == ErrStoreConflict {
+			body := fmt.Sprintf("user %v already exists!", user.Username)
 			logger.Perror(body)
-			return CreateRespData(elasticErr.Status, ContentTypeValueText, []byte(body))
+			return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
 		} else {
 			body := fmt.Sprintf("error indexing user doc, error: %v", err)
 			logger.Perror(body)
 			return CreateInternalServerErrorRespData(body)
 		}
-	} else if !resp.Created {
-		body := "unknown error!"
-		logger.Perror(body)
-		return CreateInternalServerErrorRespData(body)
 	} else {
 		logger.Pinfof("user %v create login %v", CmsUserFromReq(r).Username, user.String())
 		return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
@@ -198,21 +408,16 @@ func createLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpR
 
 func updateLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
 	logger := CtxLoggerFromReq(r)
-	args := r.URL.Query()
-	// username
-	username, d := ParseQueryStringValue(args, "username", true, "")
+	req, d := parseLoginManageRequestBody(r)
 	if d != nil {
 		return d
 	}
+	// username
+	username := req.Username
 	// password
 	user := make(map[string]interface{})
-	password, d := ParseQueryStringValue(args, "password", false, "")
-	if d != nil {
-		return d
-	}
-	if len(password) > 0 {
-		var err error
-		password, err = HashPassword(password)
+	if len(req.Password) > 0 {
+		password, err := HashPassword(req.Password)
 		if err != nil {
 			body := fmt.Sprintf("failed to hash (bcrypt) password, error: %v", err)
 			logger.Perror(body)
@@ -224,10 +429,9 @@ func updateLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpR
 	//  1. "role" is not set at all --> don't update user role
 	//  2. "role" is set to blank string --> clear user role
 	//  3. "role" is set to something --> update user role
-	vals, ok := args["role"]
-	if ok && len(vals) > 0 { // this covers case 2 & 3
+	if req.Role != nil { // this covers case 2 & 3
 		// this filters out invalid role names
-		user["role"] = Role2Names(Names2Role(vals[0]))
+		user["role"] = Role2Names(Names2Role(*req.Role))
 	} // else covers case 1
 
 	if len(user) <= 0 {
@@ -238,23 +442,18 @@ func updateLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpR
 
 	logger.Pinfof("updating user %v with %v", username, user)
 
-	updService := app.Elastic.Client.Update()
-	updService.Index(app.Conf.UserIndex.Name)
-	updService.Type(app.Conf.UserIndexTypes.User)
-	updService.Refresh("wait_for")
-	updService.Id(username)
-	updService.Doc(user)
-	updService.DocAsUpsert(false)
-	updService.DetectNoop(false)
-	_, err := updService.Do(context.Background())
+	err := app.Users.UpdateUser(context.Background(), username, user)
 	if err != nil {
 		body := fmt.Sprintf("error indexing user doc, error: %v", err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
-	} else {
-		logger.Pinfof("user %v updated login %v", CmsUserFromReq(r).Username, username)
-		return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
 	}
+	logger.Pinfof("user %v updated login %v", CmsUserFromReq(r).Username, username)
+	// tokens issued before carry the old role, the user has to login again
+	if _, d := revokeUserSessions(app, username, logger); d != nil {
+		return d
+	}
+	return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
 }
 
 func deleteLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
@@ -263,20 +462,17 @@ func deleteLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpR
 	if d != nil {
 		return d
 	}
-	delService := app.Elastic.Client.Delete()
-	delService.Index(app.Conf.UserIndex.Name)
-	delService.Type(app.Conf.UserIndexTypes.User)
-	delService.Refresh("wait_for")
-	delService.Id(username)
-	_, err := delService.Do(context.Background())
+	err := app.Users.DeleteUser(context.Background(), username)
 	if err != nil {
 		body := fmt.Sprintf("failed to delete login %v, error: %v", username, err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
-	} else {
-		logger.Pinfof("user %v deleted login %v", CmsUserFromReq(r).Username, username)
-		return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
 	}
+	logger.Pinfof("user %v deleted login %v", CmsUserFromReq(r).Username, username)
+	if _, d := revokeUserSessions(app, username, logger); d != nil {
+		return d
+	}
+	return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
 }
 
 func addLoginAuditLogFields(action string, h EndpointHandler) EndpointHandler {
@@ -298,30 +494,18 @@ func roles(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpRespons
 
 func users(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
 	logger := CtxLoggerFromReq(r)
-	search := app.Elastic.Client.Search(app.Conf.UserIndex.Name)
-	search.Type(app.Conf.UserIndexTypes.User)
-	search.Query(elastic.NewConstantScoreQuery(elastic.NewMatchAllQuery()))
-	search.FetchSource(true)
-	search.Sort("username", true)
-	resp, err := search.Do(context.Background())
+	all, err := app.Users.ListUsers(context.Background())
 	if err != nil {
-		body := fmt.Sprintf("failed to get users from index %v, error: %v", app.Conf.UserIndex.Name, err)
+		body := fmt.Sprintf("failed to get users, error: %v", err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
 	}
 	loginUser := CmsUserFromReq(r)
-	users := make([]*CmsUser, 0)
-	if resp.Hits != nil && resp.Hits.Hits != nil && len(resp.Hits.Hits) > 0 {
-		for _, h := range resp.Hits.Hits {
-			one := &CmsUser{}
-			if err := json.Unmarshal(*h.Source, one); err != nil {
-				logger.Pwarnf("failed to decode user %v", h.Id)
-			} else {
-				one.Password = ""
-				if one.Username != "void" || loginUser.Username == "void" {
-					users = append(users, one)
-				}
-			}
+	users := make([]*CmsUser, 0, len(all))
+	for _, one := range all {
+		one.Password = ""
+		if one.Username != "void" || loginUser.Username == "void" {
+			users = append(users, one)
 		}
 	}
 	return CreateJsonRespData(http.StatusOK, users)
@@ -338,11 +522,19 @@ func LoginRoles() EndpointHandler {
 }
 
 func LoginManageLogin() EndpointHandler {
-	return manageLogin
+	return loginWithRole(CmsRoleLoginManage)
 }
 
 func Login() EndpointHandler {
-	return login
+	return loginWithRole(0)
+}
+
+func TokenRefresh() EndpointHandler {
+	return refreshToken
+}
+
+func Logout() EndpointHandler {
+	return logout
 }
 
 func LoginCreate() EndpointHandler {
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLoginApp(t *testing.T) *AppRuntime {
+	hashKey := []byte(strings.Repeat("h", 64))
+	blockKey := []byte(strings.Repeat("b", 32))
+	app := &AppRuntime{
+		Conf: &AppConf{
+			SCookie:              newSecureCookie(hashKey, blockKey, 900),
+			SCookieMaxAge:        900 * time.Second,
+			SCookieRefresh:       newSecureCookie(hashKey, blockKey, 86400),
+			SCookieRefreshMaxAge: 86400 * time.Second,
+		},
+		Users:    NewMemoryUserStore(),
+		Sessions: NewMemorySessionStore(),
+	}
+	app.Authenticators = []PasswordAuthenticator{NewLocalAuthenticator(app.Users)}
+	password, err := HashPassword("pw")
+	if err != nil {
+		t.Fatalf("failed to hash password, error: %v", err)
+	}
+	app.Users.CreateUser(context.Background(), &CmsUser{Username: "ed", Password: password, Role: CmsRoleArticleCreate})
+	return app
+}
+
+func testAuthRequest(method, path, body string) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	return r.WithContext(WithCtxLogger(r.Context(), NewJsonLogger(ioutil.Discard), "test"))
+}
+
+func TestLoginAndRefresh(t *testing.T) {
+	app := testLoginApp(t)
+	login := Login()
+	for _, c := range []struct {
+		body   string
+		status int
+	}{
+		{`{"username":"ed"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+		{`{"username":"ed","password":"wrong"}`, http.StatusForbidden},
+		{`{"username":"nobody","password":"pw"}`, http.StatusForbidden},
+	} {
+		if d := login(app, nil, testAuthRequest(http.MethodPost, "/api/login", c.body)); d.Status != c.status {
+			t.Errorf("expecting %v for %v, but got %v", c.status, c.body, d.Status)
+		}
+	}
+	if d := LoginManageLogin()(app, nil, testAuthRequest(http.MethodPost, "/api/manage/login", `{"username":"ed","password":"pw"}`)); d.Status != http.StatusForbidden {
+		t.Errorf("expecting 403 for manage login without the role, but got %v", d.Status)
+	}
+
+	d := login(app, nil, testAuthRequest(http.MethodPost, "/api/login", `{"username":"ed","password":"pw"}`))
+	body, _ := ioutil.ReadAll(d.Body)
+	token := &AuthToken{}
+	json.Unmarshal(body, token)
+	if d.Status != http.StatusOK || token.Token == "" || token.RefreshToken == "" {
+		t.Errorf("unexpected login response %v: %s", d.Status, body)
+		return
+	}
+	user := &CmsUser{}
+	if err := app.Conf.SCookie.Decode(TokenCookieName, token.Token, user); err != nil || user.Username != "ed" || user.Password != "" {
+		t.Errorf("unexpected access token user %+v (error: %v)", user, err)
+	}
+
+	// the access token isn't a refresh token
+	refreshBody := `{"refresh_token":"` + token.Token + `"}`
+	if d = refreshToken(app, nil, testAuthRequest(http.MethodPost, "/api/token/refresh", refreshBody)); d.Status != http.StatusForbidden {
+		t.Errorf("expecting 403 for refreshing with an access token, but got %v", d.Status)
+	}
+
+	// role changes take effect on refresh
+	app.Users.UpdateUser(context.Background(), "ed", map[string]interface{}{"role": []string{CmsRoleArticlePublishName}})
+	refreshBody = `{"refresh_token":"` + token.RefreshToken + `"}`
+	d = refreshToken(app, nil, testAuthRequest(http.MethodPost, "/api/token/refresh", refreshBody))
+	body, _ = ioutil.ReadAll(d.Body)
+	refreshed := &AuthToken{}
+	json.Unmarshal(body, refreshed)
+	if d.Status != http.StatusOK || refreshed.Token == "" || refreshed.RefreshToken != "" || CmsRoleValue(refreshed.Role) != CmsRoleArticlePublish {
+		t.Errorf("unexpected refresh response %v: %s", d.Status, body)
+	}
+
+	// no refresh after logout
+	if d = logout(app, nil, testAuthRequest(http.MethodPost, "/api/logout", refreshBody)); d.Status != http.StatusOK {
+		t.Errorf("expecting logout ok, but got %v", d.Status)
+	}
+	if d = refreshToken(app, nil, testAuthRequest(http.MethodPost, "/api/token/refresh", refreshBody)); d.Status != http.StatusForbidden {
+		t.Errorf("expecting 403 for a revoked refresh token, but got %v", d.Status)
+	}
+}
+
+func TestCreateUpdateLogin(t *testing.T) {
+	app := testLoginApp(t)
+	manager := &CmsUser{Username: "root", Role: CmsRoleLoginManage}
+	request := func(path, body string) *http.Request {
+		r := testAuthRequest(http.MethodPost, path, body)
+		return r.WithContext(context.WithValue(r.Context(), CtxKeyCmsUser, manager))
+	}
+	for _, c := range []struct {
+		path   string
+		body   string
+		status int
+	}{
+		{"/api/login/create?password=pw", `{"username":"kim"}`, http.StatusBadRequest},
+		{"/api/login/create", `{"username":"kim"}`, http.StatusBadRequest},
+		{"/api/login/create", `{"password":"pw"}`, http.StatusBadRequest},
+		{"/api/login/create", `{"username":"kim","password":"pw","role":"article:create"}`, http.StatusOK},
+		{"/api/login/create", `{"username":"kim","password":"pw"}`, http.StatusConflict},
+		{"/api/login/update?password=new", `{"username":"kim"}`, http.StatusBadRequest},
+		{"/api/login/update", `{"username":"kim"}`, http.StatusBadRequest},
+		{"/api/login/update", `{"username":"kim","password":"new","role":""}`, http.StatusOK},
+	} {
+		h := createLogin
+		if strings.HasPrefix(c.path, "/api/login/update") {
+			h = updateLogin
+		}
+		if d := h(app, nil, request(c.path, c.body)); d.Status != c.status {
+			t.Errorf("expecting %v for %v %v, but got %v", c.status, c.path, c.body, d.Status)
+		}
+	}
+	user, err := NewLocalAuthenticator(app.Users).Authenticate(context.Background(), "kim", "new")
+	if err != nil || user.Role != 0 {
+		t.Errorf("unexpected updated user %+v (error: %v)", user, err)
+	}
+}
+
+func testLoginToken(t *testing.T, app *AppRuntime) *AuthToken {
+	d := Login()(app, nil, testAuthRequest(http.MethodPost, "/api/login", `{"username":"ed","password":"pw"}`))
+	body, _ := ioutil.ReadAll(d.Body)
+	token := &AuthToken{}
+	if err := json.Unmarshal(body, token); err != nil || d.Status != http.StatusOK {
+		t.Fatalf("failed to login, %v: %s", d.Status, body)
+	}
+	return token
+}
+
+func TestSessions(t *testing.T) {
+	app := testLoginApp(t)
+	token1 := testLoginToken(t, app)
+	token2 := testLoginToken(t, app)
+	ok := func(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+		return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
+	}
+	authed := func(token *AuthToken, path string, h EndpointHandler) *HttpResponseData {
+		r := testAuthRequest(http.MethodGet, path, "")
+		r.Header.Set(HeaderAuthToken, token.Token)
+		return RequireAuth(h)(app, httptest.NewRecorder(), r)
+	}
+
+	d := authed(token1, "/api/sessions", getSessions)
+	body, _ := ioutil.ReadAll(d.Body)
+	sessions := &SessionsResponseBody{}
+	json.Unmarshal(body, sessions)
+	if d.Status != http.StatusOK || len(sessions.Sessions) != 2 || sessions.Current == "" {
+		t.Fatalf("unexpected sessions response %v: %s", d.Status, body)
+	}
+	current := sessions.Current
+
+	// revoke the current session, its token is rejected from then on
+	if d = authed(token2, "/api/session/revoke?id=nosuchsession", GetRequiredStringArg("id", CtxKeyId, revokeSession)); d.Status != http.StatusNotFound {
+		t.Errorf("expecting 404 for revoking an unknown session, but got %v", d.Status)
+	}
+	if d = authed(token2, "/api/session/revoke?id="+current, GetRequiredStringArg("id", CtxKeyId, revokeSession)); d.Status != http.StatusOK {
+		t.Errorf("expecting revoke ok, but got %v", d.Status)
+	}
+	if d = authed(token1, "/api/sessions", ok); d.Status != http.StatusForbidden {
+		t.Errorf("expecting 403 for a token of a revoked session, but got %v", d.Status)
+	}
+	if d = authed(token2, "/api/sessions", ok); d.Status != http.StatusOK {
+		t.Errorf("expecting the other session still valid, but got %v", d.Status)
+	}
+
+	// revoke all sessions of the user
+	if n, d := revokeUserSessions(app, "ed", NewJsonLogger(ioutil.Discard)); d != nil || n != 1 {
+		t.Errorf("expecting 1 session revoked, but got %v (%v)", n, d)
+	}
+	if d = authed(token2, "/api/sessions", ok); d.Status != http.StatusForbidden {
+		t.Errorf("expecting 403 after the sessions are revoked, but got %v", d.Status)
+	}
+}
+
+func TestRedactRequestURI(t *testing.T) {
+	for uri, expected := range map[string]string{
+		"/api/login?username=ed&password=pw": "/api/login?password=xxxxx&username=ed",
+		"/api/articles?size=10":              "/api/articles?size=10",
+		"/api/login":                         "/api/login",
+	} {
+		if actual := redactRequestURI(httptest.NewRequest(http.MethodGet, uri, nil)); actual != expected {
+			t.Errorf("expecting %v, but got %v", expected, actual)
+		}
+	}
+}
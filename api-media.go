@@ -0,0 +1,267 @@
+/*
+   /media         GET   [asset (read)]               no lock
+   /media/list    GET   [asset (search)]             no lock, latest first
+   /media/upload  POST  [asset (create)]             no lock, dedup by content hash
+   /media/update  GET   [asset (read --> update)]    no lock
+
+   e.g. upload with multipart form fields "file" (the image) and optionally
+   "caption", "credit" and "alt", uploading a file which is already there
+   returns the existing asset (with "existing": true) unchanged.
+   /api/media/update?id=...&caption=...&credit=...&alt=... only changes the
+   given args.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type MediaUploadResponseBody struct {
+	Asset    *MediaAsset `json:"asset"`
+	Existing bool        `json:"existing"`
+}
+
+type MediaListResponseBody struct {
+	Assets []*MediaAsset `json:"assets"`
+}
+
+// Checks the media assets referenced by the article (the lead image and
+// image blocks) exist, fills in blank alt/caption/credit from the assets
+// and sets the src of the image blocks
+func normalizeArticleMedia(app *AppRuntime, article *Article, logger *JsonLogger) *HttpResponseData {
+	ctx := context.Background()
+	assets := make(map[string]*MediaAsset)
+	getAsset := func(id string) (*MediaAsset, *HttpResponseData) {
+		if asset, ok := assets[id]; ok {
+			return asset, nil
+		}
+		asset, err := app.Media.GetMedia(ctx, id)
+		if err == ErrStoreNotFound {
+			body := fmt.Sprintf("media asset %v not found!", id)
+			logger.Perror(body)
+			return nil, CreateBadRequestRespData(body)
+		} else if err != nil {
+			body := fmt.Sprintf("failed to get media asset %v, error: %v", id, err)
+			logger.Perror(body)
+			return nil, CreateInternalServerErrorRespData(body)
+		}
+		assets[id] = asset
+		return asset, nil
+	}
+	if lead := article.LeadImage; lead != nil {
+		if lead.Id = strings.TrimSpace(lead.Id); lead.Id == "" {
+			return CreateBadRequestRespData("lead image id can't be blank!")
+		}
+		asset, d := getAsset(lead.Id)
+		if d != nil {
+			return d
+		}
+		if lead.Alt == "" {
+			lead.Alt = asset.Alt
+		}
+		if lead.Caption == "" {
+			lead.Caption = asset.Caption
+		}
+		if lead.Credit == "" {
+			lead.Credit = asset.Credit
+		}
+	}
+	for _, b := range article.Body {
+		// other blocks with media are rejected by ValidateArticleBody
+		if b == nil || b.Type != ArticleBlockImage || b.Media == "" {
+			continue
+		}
+		asset, d := getAsset(b.Media)
+		if d != nil {
+			return d
+		}
+		b.Src = MediaPath(asset.Id)
+		if b.Alt == "" {
+			b.Alt = asset.Alt
+		}
+		if b.Caption == "" {
+			b.Caption = asset.Caption
+		}
+	}
+	return nil
+}
+
+// sets the asset fields given in the query/form args
+func setMediaAssetFields(asset *MediaAsset, values url.Values) {
+	if _, ok := values["caption"]; ok {
+		asset.Caption = strings.TrimSpace(values.Get("caption"))
+	}
+	if _, ok := values["credit"]; ok {
+		asset.Credit = strings.TrimSpace(values.Get("credit"))
+	}
+	if _, ok := values["alt"]; ok {
+		asset.Alt = strings.TrimSpace(values.Get("alt"))
+	}
+}
+
+func getMediaAsset(app *AppRuntime, id string, logger *JsonLogger) (*MediaAsset, *HttpResponseData) {
+	asset, err := app.Media.GetMedia(context.Background(), id)
+	if err == ErrStoreNotFound {
+		return nil, CreateNotFoundRespData(fmt.Sprintf("media asset %v not found!", id))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to get media asset %v, error: %v", id, err)
+		logger.Perror(body)
+		return nil, CreateInternalServerErrorRespData(body)
+	}
+	return asset, nil
+}
+
+func uploadMedia(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	maxSize := app.Conf.MediaMaxSize
+	tooLarge := CreateRespData(http.StatusRequestEntityTooLarge, ContentTypeValueText,
+		[]byte(fmt.Sprintf("media file is larger than %v bytes!", maxSize)))
+	// leave some room for the rest of the multipart form
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+(1<<20))
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			return tooLarge
+		}
+		return CreateBadRequestRespData(fmt.Sprintf("failed to read the uploaded file, error: %v", err))
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return CreateBadRequestRespData(fmt.Sprintf("failed to read the uploaded file, error: %v", err))
+	} else if int64(len(data)) > maxSize {
+		return tooLarge
+	}
+	asset, err := InspectMedia(data)
+	if err != nil {
+		return CreateBadRequestRespData(fmt.Sprintf("invalid media file, %v!", err))
+	}
+
+	ctx := context.Background()
+	existing, err := app.Media.GetMedia(ctx, asset.Id)
+	if err == nil {
+		return CreateJsonRespData(http.StatusOK, &MediaUploadResponseBody{
+			Asset:    existing,
+			Existing: true,
+		})
+	} else if err != ErrStoreNotFound {
+		body := fmt.Sprintf("failed to get media asset %v, error: %v", asset.Id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+
+	user := CmsUserFromReq(r)
+	jt := &JSONTime{time.Now().UTC()}
+	asset.Filename = filepath.Base(header.Filename)
+	asset.CreatedAt = jt
+	asset.CreatedBy = user.Username
+	asset.RevisedAt = jt
+	asset.RevisedBy = user.Username
+	setMediaAssetFields(asset, r.MultipartForm.Value)
+
+	// content first so that an asset never points to missing content
+	if err := app.MediaStorage.Put(ctx, asset.Id, data); err != nil {
+		body := fmt.Sprintf("failed to store media file %v, error: %v", asset.Id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	err = app.Media.CreateMedia(ctx, asset)
+	if err == ErrStoreConflict {
+		// the same file uploaded concurrently
+		if existing, d := getMediaAsset(app, asset.Id, logger); d == nil {
+			return CreateJsonRespData(http.StatusOK, &MediaUploadResponseBody{
+				Asset:    existing,
+				Existing: true,
+			})
+		} else {
+			return d
+		}
+	} else if err != nil {
+		body := fmt.Sprintf("failed to create media asset %v, error: %v", asset.Id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	logger.Pinfof("user %v uploaded media asset %v (%v, %v bytes)", user.Username, asset.Id, asset.ContentType, asset.Size)
+	return CreateJsonRespData(http.StatusOK, &MediaUploadResponseBody{
+		Asset: asset,
+	})
+}
+
+func updateMedia(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	id := StringFromReq(r, CtxKeyId)
+	asset, d := getMediaAsset(app, id, logger)
+	if d != nil {
+		return d
+	}
+	setMediaAssetFields(asset, r.URL.Query())
+	user := CmsUserFromReq(r)
+	asset.RevisedAt = &JSONTime{time.Now().UTC()}
+	asset.RevisedBy = user.Username
+	err := app.Media.UpdateMedia(context.Background(), asset)
+	if err == ErrStoreNotFound {
+		return CreateNotFoundRespData(fmt.Sprintf("media asset %v not found!", id))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to update media asset %v, error: %v", id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	logger.Pinfof("user %v updated media asset %v", user.Username, id)
+	return CreateJsonRespData(http.StatusOK, asset)
+}
+
+func getMedia(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	asset, d := getMediaAsset(app, StringFromReq(r, CtxKeyId), CtxLoggerFromReq(r))
+	if d != nil {
+		return d
+	}
+	return CreateJsonRespData(http.StatusOK, asset)
+}
+
+func listMedia(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	size, d := ParseQueryIntValue(r.URL.Query(), "size", false, 50, 1, 1000)
+	if d != nil {
+		return d
+	}
+	assets, err := app.Media.ListMedia(context.Background(), size)
+	if err != nil {
+		body := fmt.Sprintf("failed to list media assets, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	return CreateJsonRespData(http.StatusOK, &MediaListResponseBody{
+		Assets: assets,
+	})
+}
+
+const mediaRoles = CmsRoleArticleCreate | CmsRoleArticleEditSelf | CmsRoleArticleEditOther
+
+func MediaGet() EndpointHandler {
+	h := GetRequiredStringArg("id", CtxKeyId, getMedia)
+	return RequireAuth(h)
+}
+
+func MediaList() EndpointHandler {
+	return RequireAuth(listMedia)
+}
+
+func MediaUpload() EndpointHandler {
+	h := RequireOneRole(mediaRoles, uploadMedia)
+	return RequireAuth(h)
+}
+
+func MediaUpdate() EndpointHandler {
+	h := GetRequiredStringArg("id", CtxKeyId, updateMedia)
+	h = RequireOneRole(mediaRoles, h)
+	return RequireAuth(h)
+}
@@ -0,0 +1,204 @@
+/*
+   /api/oidc/login       GET  [user (create/update)]  no auth, no lock
+   /api/oidc/callback    GET  [user (create/update)]  no auth, no lock
+
+   single sign-on with the OpenID Connect provider (-oidc-issuer), both
+   are not found unless it is configured. /api/oidc/login redirects the
+   browser to the provider, which redirects it back to /api/oidc/callback
+   with an authorization code. The state, nonce and PKCE verifier of the
+   flow are kept in a signed cookie (so any api server can handle the
+   callback). The callback responds with the same tokens as /api/login.
+
+   users are provisioned on their first login with the cms roles mapped
+   (-oidc-role-map) from the roles claim of their ID token, and the roles
+   are updated on every login after. Users without any mapped role can't
+   login, neither can sso users login with a password nor can sso take
+   over an existing password user. The sub and iss of the first login are
+   kept with the user, logins of another identity with the same username
+   are rejected.
+*/
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	OIDCLoginPath    = "/api/oidc/login"
+	OIDCCallbackPath = "/api/oidc/callback"
+
+	OIDCStateCookieName = "oidc-state"
+
+	// how long the user has to authenticate with the provider
+	oidcStateMaxAge = 10 * time.Minute
+)
+
+// state of an authorization code flow, kept in a cookie between the
+// login and the callback
+type OIDCState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Expire   int64  `json:"expire"`
+}
+
+func oidcRandom() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func newOIDCState() (*OIDCState, error) {
+	state := &OIDCState{Expire: time.Now().Add(oidcStateMaxAge).Unix()}
+	for _, v := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		s, err := oidcRandom()
+		if err != nil {
+			return nil, err
+		}
+		*v = s
+	}
+	return state, nil
+}
+
+func oidcRedirectUrl(app *AppRuntime, r *http.Request) string {
+	if app.Conf.OIDCRedirectUrl != "" {
+		return app.Conf.OIDCRedirectUrl
+	}
+	return publicBaseUrl(app, r) + OIDCCallbackPath
+}
+
+// the state cookie, value "" to remove it
+func oidcStateCookie(app *AppRuntime, r *http.Request, value string) string {
+	cookie := &http.Cookie{
+		Name:     OIDCStateCookieName,
+		Value:    value,
+		Path:     OIDCCallbackPath,
+		MaxAge:   int(oidcStateMaxAge / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(oidcRedirectUrl(app, r), "https:"),
+		// sent on the (top level) redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	return cookie.String()
+}
+
+// decodes the state cookie and checks it against the state arg
+func parseOIDCState(app *AppRuntime, r *http.Request, arg string) (*OIDCState, *HttpResponseData) {
+	cookie, err := r.Cookie(OIDCStateCookieName)
+	if err != nil {
+		return nil, CreateForbiddenRespData("missing sso state cookie, please login again!")
+	}
+	state := &OIDCState{}
+	if err := app.Conf.SCookieRefresh.Decode(OIDCStateCookieName, cookie.Value, state); err != nil {
+		return nil, CreateForbiddenRespData(fmt.Sprintf("invalid sso state cookie, error: %v", err))
+	}
+	if state.State != arg {
+		return nil, CreateForbiddenRespData("sso state mismatch, please login again!")
+	}
+	if time.Now().Unix() > state.Expire {
+		return nil, CreateForbiddenRespData("sso state is expired, please login again!")
+	}
+	return state, nil
+}
+
+func oidcLogin(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	if app.OIDC == nil {
+		return CreateNotFoundRespData("single sign-on is not enabled!")
+	}
+	logger := CtxLoggerFromReq(r)
+	state, err := newOIDCState()
+	if err != nil {
+		body := fmt.Sprintf("failed to generate sso state, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	cookie, err := app.Conf.SCookieRefresh.Encode(OIDCStateCookieName, state)
+	if err != nil {
+		body := fmt.Sprintf("failed to encode sso state, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	authUrl, err := app.OIDC.AuthCodeURL(r.Context(), oidcRedirectUrl(app, r), state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		logger.Perror(err.Error())
+		return CreateInternalServerErrorRespData(err.Error())
+	}
+	d := CreateRespData(http.StatusFound, ContentTypeValueText, []byte{})
+	d.Header.Set("Location", authUrl)
+	d.Header.Set("Set-Cookie", oidcStateCookie(app, r, cookie))
+	return d
+}
+
+func oidcCallback(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	if app.OIDC == nil {
+		return CreateNotFoundRespData("single sign-on is not enabled!")
+	}
+	logger := CtxLoggerFromReq(r)
+	args := r.URL.Query()
+	if e := args.Get("error"); e != "" {
+		body := fmt.Sprintf("sso is denied by the provider: %v %v", e, args.Get("error_description"))
+		logger.Perror(body)
+		return CreateForbiddenRespData(body)
+	}
+	code, d := ParseQueryStringValue(args, "code", true, "")
+	if d != nil {
+		return d
+	}
+	arg, d := ParseQueryStringValue(args, "state", true, "")
+	if d != nil {
+		return d
+	}
+	state, d := parseOIDCState(app, r, arg)
+	if d != nil {
+		return d
+	}
+	idToken, err := app.OIDC.Exchange(r.Context(), code, oidcRedirectUrl(app, r), state.Verifier)
+	if err != nil {
+		logger.Perror(err.Error())
+		return CreateForbiddenRespData(err.Error())
+	}
+	claims, err := app.OIDC.VerifyIDToken(r.Context(), idToken, state.Nonce, time.Now())
+	if err != nil {
+		body := fmt.Sprintf("invalid id token, error: %v", err)
+		logger.Perror(body)
+		return CreateForbiddenRespData(body)
+	}
+	username, role, err := OIDCUserFromClaims(app.Conf, claims)
+	if err != nil {
+		body := fmt.Sprintf("sso user %v (%v) can't login, error: %v", username, claims.Subject, err)
+		logger.Perror(body)
+		return CreateForbiddenRespData(body)
+	}
+	user, d := provisionUser(app, &CmsUser{
+		Username: username,
+		Role:     role,
+		Provider: OIDCProviderName,
+		Subject:  claims.Subject,
+		Issuer:   claims.Issuer,
+	}, logger)
+	if d != nil {
+		return d
+	}
+	d = loginResponse(app, r, user)
+	d.Header.Set("Set-Cookie", oidcStateCookie(app, r, ""))
+	return d
+}
+
+func OIDCLogin() EndpointHandler {
+	return oidcLogin
+}
+
+func OIDCCallback() EndpointHandler {
+	return oidcCallback
+}
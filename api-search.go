@@ -0,0 +1,135 @@
+/*
+   /search  GET  [draft/version/publish (search)]  no lock
+
+   full-text search on headline and content, sorted by relevance, e.g.
+   /api/search?q=text&type=draft,version&tag=a,b&created_by=ed&revised_by=ed
+       &created_from=2017-07-01T00:00:00Z&created_to=2017-07-31T00:00:00Z
+       &revised_from=...&revised_to=...&size=20&cursorMark=...
+   all args are optional, pass cursor_mark of the response as cursorMark
+   to get the next page.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type ArticleSearchHit struct {
+	Type      string              `json:"type"`
+	Score     float64             `json:"score"`
+	Highlight map[string][]string `json:"highlight,omitempty"`
+	Article   *Article            `json:"article"`
+}
+
+type ArticleSearchResponseBody struct {
+	Total      int64               `json:"total"`
+	Hits       []*ArticleSearchHit `json:"hits"`
+	CursorMark string              `json:"cursor_mark,omitempty"`
+}
+
+// comma separated values of the query arg, nil if not given
+func parseQueryListValue(values url.Values, name string) []string {
+	var list []string
+	for _, v := range strings.Split(values.Get(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func parseQueryTimeValue(values url.Values, name string) (time.Time, *HttpResponseData) {
+	s := values.Get(name)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, CreateBadRequestRespData(fmt.Sprintf("invalid %v=%v, must be a RFC3339 date/time!", name, s))
+	}
+	return t.UTC(), nil
+}
+
+// parses text (q), tag, size and cursorMark of a search request
+func parseArticleTextQuery(values url.Values, defaultSize int) (*ArticleTextQuery, *HttpResponseData) {
+	size, d := ParseQueryIntValue(values, "size", false, defaultSize, 1, 100)
+	if d != nil {
+		return nil, d
+	}
+	searchAfter, d := DecodeCursorMark(values)
+	if d != nil {
+		return nil, d
+	}
+	return &ArticleTextQuery{
+		Text:        strings.TrimSpace(values.Get("q")),
+		Tag:         parseQueryListValue(values, "tag"),
+		SearchAfter: searchAfter,
+		Size:        size,
+	}, nil
+}
+
+func searchArticles(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	values := r.URL.Query()
+	q, d := parseArticleTextQuery(values, 20)
+	if d != nil {
+		return d
+	}
+	if q.Tag, d = resolveQueryTags(app, q.Tag, logger); d != nil {
+		return d
+	}
+	q.Types = getSearchTypesFromQueryString(values, app.Conf.ArticleIndexTypeMap)
+	q.CreatedBy = values.Get("created_by")
+	q.RevisedBy = values.Get("revised_by")
+	for name, t := range map[string]*time.Time{
+		"created_from": &q.CreatedFrom,
+		"created_to":   &q.CreatedTo,
+		"revised_from": &q.RevisedFrom,
+		"revised_to":   &q.RevisedTo,
+	} {
+		if *t, d = parseQueryTimeValue(values, name); d != nil {
+			return d
+		}
+	}
+	body := &ArticleSearchResponseBody{
+		Hits: make([]*ArticleSearchHit, 0),
+	}
+	if len(q.Types) <= 0 {
+		return CreateJsonRespData(http.StatusOK, body)
+	}
+	result, err := app.Articles.TextSearch(context.Background(), q)
+	if err != nil {
+		body := fmt.Sprintf("failed to search articles, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	body.Total = result.Total
+	for _, doc := range result.Docs {
+		body.Hits = append(body.Hits, &ArticleSearchHit{
+			Type:      doc.Type,
+			Score:     doc.Score,
+			Highlight: doc.Highlight,
+			Article:   doc.Article,
+		})
+	}
+	if n := len(result.Docs); n > 0 {
+		cursorMark, err := EncodeCursorMark(result.Docs[n-1].Sort)
+		if err != nil {
+			body := fmt.Sprintf("failed to encode cursor mark, error: %v", err)
+			logger.Perror(body)
+			return CreateInternalServerErrorRespData(body)
+		}
+		body.CursorMark = cursorMark
+	}
+	return CreateJsonRespData(http.StatusOK, body)
+}
+
+func ArticleSearch() EndpointHandler {
+	return RequireAuth(searchArticles)
+}
@@ -0,0 +1,146 @@
+/*
+   /api/sessions                  GET  [session (list)]    auth, no lock
+   /api/session/revoke            GET  [session (delete)]  auth, no lock
+   /api/login/sessions            GET  [session (list)]    auth (login:manage), no lock
+   /api/login/sessions/revoke     GET  [session (delete)]  auth (login:manage), no lock
+
+   a session is created on login, the access and refresh tokens carry its
+   id and RequireAuth rejects tokens of sessions which are gone. Users can
+   list and revoke (by ?id=) their own sessions, login managers can list
+   and revoke all sessions of another user (by ?username=).
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type SessionsResponseBody struct {
+	Sessions []*CmsSession `json:"sessions"`
+
+	// id of the session the request is made with
+	Current string `json:"current,omitempty"`
+}
+
+type SessionsRevokeResponseBody struct {
+	Revoked int `json:"revoked"`
+}
+
+// Gets the session a token refers to, 403 if it is gone (revoked or
+// expired) or belongs to another user
+func getTokenSession(app *AppRuntime, id, username string, logger *JsonLogger) (*CmsSession, *HttpResponseData) {
+	if id == "" {
+		return nil, CreateForbiddenRespData("token without session, please login again!")
+	}
+	session, err := app.Sessions.GetSession(context.Background(), id)
+	if err == ErrStoreNotFound || (err == nil && session.Username != username) {
+		return nil, CreateForbiddenRespData("session is revoked or expired, please login again!")
+	} else if err != nil {
+		body := fmt.Sprintf("failed to get session %v, error: %v", id, err)
+		logger.Perror(body)
+		return nil, CreateInternalServerErrorRespData(body)
+	}
+	return session, nil
+}
+
+func deleteSession(app *AppRuntime, id string, logger *JsonLogger) *HttpResponseData {
+	err := app.Sessions.DeleteSession(context.Background(), id)
+	if err == ErrStoreNotFound {
+		return CreateNotFoundRespData(fmt.Sprintf("session %v not found!", id))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to delete session %v, error: %v", id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	return nil
+}
+
+func revokeUserSessions(app *AppRuntime, username string, logger *JsonLogger) (int, *HttpResponseData) {
+	n, err := app.Sessions.DeleteUserSessions(context.Background(), username)
+	if err != nil {
+		body := fmt.Sprintf("failed to delete sessions of user %v, error: %v", username, err)
+		logger.Perror(body)
+		return 0, CreateInternalServerErrorRespData(body)
+	}
+	logger.Pinfof("revoked %v session(s) of user %v", n, username)
+	return n, nil
+}
+
+func listSessions(app *AppRuntime, username string, logger *JsonLogger) ([]*CmsSession, *HttpResponseData) {
+	sessions, err := app.Sessions.ListSessions(context.Background(), username)
+	if err != nil {
+		body := fmt.Sprintf("failed to list sessions of user %v, error: %v", username, err)
+		logger.Perror(body)
+		return nil, CreateInternalServerErrorRespData(body)
+	}
+	return sessions, nil
+}
+
+func getSessions(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	user := CmsUserFromReq(r)
+	sessions, d := listSessions(app, user.Username, CtxLoggerFromReq(r))
+	if d != nil {
+		return d
+	}
+	return CreateJsonRespData(http.StatusOK, &SessionsResponseBody{
+		Sessions: sessions,
+		Current:  user.Session,
+	})
+}
+
+func revokeSession(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	user := CmsUserFromReq(r)
+	id := StringFromReq(r, CtxKeyId)
+	// others' sessions are not found
+	if _, d := getTokenSession(app, id, user.Username, logger); d != nil {
+		return CreateNotFoundRespData(fmt.Sprintf("session %v not found!", id))
+	}
+	if d := deleteSession(app, id, logger); d != nil {
+		return d
+	}
+	logger.Pinfof("user %v revoked session %v", user.Username, id)
+	return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
+}
+
+func getUserSessions(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	sessions, d := listSessions(app, StringFromReq(r, CtxKeyName), CtxLoggerFromReq(r))
+	if d != nil {
+		return d
+	}
+	return CreateJsonRespData(http.StatusOK, &SessionsResponseBody{Sessions: sessions})
+}
+
+func revokeUserSessionsHandler(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	username := StringFromReq(r, CtxKeyName)
+	n, d := revokeUserSessions(app, username, logger)
+	if d != nil {
+		return d
+	}
+	logger.Pinfof("user %v revoked all sessions of user %v", CmsUserFromReq(r).Username, username)
+	return CreateJsonRespData(http.StatusOK, &SessionsRevokeResponseBody{Revoked: n})
+}
+
+func SessionsGet() EndpointHandler {
+	return RequireAuth(getSessions)
+}
+
+func SessionRevoke() EndpointHandler {
+	return RequireAuth(GetRequiredStringArg("id", CtxKeyId, revokeSession))
+}
+
+func LoginSessionsGet() EndpointHandler {
+	h := GetRequiredStringArg("username", CtxKeyName, getUserSessions)
+	h = RequireOneRole(CmsRoleLoginManage, h)
+	return RequireAuth(h)
+}
+
+func LoginSessionsRevoke() EndpointHandler {
+	h := addLoginAuditLogFields("revoke-sessions", GetRequiredStringArg("username", CtxKeyName, revokeUserSessionsHandler))
+	h = RequireOneRole(CmsRoleLoginManage, h)
+	return RequireAuth(h)
+}
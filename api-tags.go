@@ -0,0 +1,45 @@
+/*
+   /tags  GET  [draft/version/publish (aggregate)]  no lock
+
+   tag counts for each type, e.g. /api/tags?type=draft,publish&size=50
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+type TagsResponseBody struct {
+	Tags map[string][]*TagCount `json:"tags"`
+}
+
+func getTags(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	values := r.URL.Query()
+	size, d := ParseQueryIntValue(values, "size", false, 100, 1, 1000)
+	if d != nil {
+		return d
+	}
+	types := getSearchTypesFromQueryString(values, app.Conf.ArticleIndexTypeMap)
+	if len(types) <= 0 {
+		return CreateJsonRespData(http.StatusOK, &TagsResponseBody{
+			Tags: make(map[string][]*TagCount),
+		})
+	}
+	tags, err := app.Articles.CountTags(context.Background(), types, size)
+	if err != nil {
+		body := fmt.Sprintf("failed to count tags, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	return CreateJsonRespData(http.StatusOK, &TagsResponseBody{
+		Tags: tags,
+	})
+}
+
+func TagsGet() EndpointHandler {
+	return RequireAuth(getTags)
+}
@@ -0,0 +1,284 @@
+/*
+   /taxonomy         GET  [term (search)]           all terms with their ancestors/children
+   /taxonomy/create  GET  [term (create)]           lock on taxonomy
+   /taxonomy/update  GET  [term (read --> update)]  lock on taxonomy
+   /taxonomy/delete  GET  [term (delete)]           lock on taxonomy, only terms without children
+
+   e.g. /api/taxonomy/create?name=Elections&kind=tag&parent=politics&synonyms=election,elections-2026
+   the term id is the normalized name unless given (id=), for update only
+   the given args (name/kind/parent/synonyms) are changed, parent= (blank)
+   makes it a root term.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type TaxonomyTermNode struct {
+	*TaxonomyTerm
+	Ancestors []string `json:"ancestors"`
+	Children  []string `json:"children"`
+}
+
+type TaxonomyResponseBody struct {
+	Terms []*TaxonomyTermNode `json:"terms"`
+}
+
+// Resolves the article tags to taxonomy terms, with -strict-tags
+// unknown tags are rejected, otherwise they are kept normalized
+func normalizeArticleTags(app *AppRuntime, article *Article, logger *JsonLogger) *HttpResponseData {
+	if len(article.Tag) == 0 {
+		return nil
+	}
+	taxonomy, err := loadCachedTaxonomy(context.Background(), app.Taxonomy)
+	if err != nil {
+		body := fmt.Sprintf("failed to load taxonomy, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	tags, unknown := taxonomy.NormalizeTags(article.Tag)
+	if app.Conf.StrictTags && len(unknown) > 0 {
+		body := fmt.Sprintf("unknown tag(s) %v, add them to the taxonomy first!", strings.Join(unknown, ", "))
+		logger.Perror(body)
+		return CreateBadRequestRespData(body)
+	}
+	article.Tag = tags
+	return nil
+}
+
+// Resolves the tags of a query (filter) to term ids the same way the tags
+// of articles are normalized on save, unknown tags are kept normalized.
+// Returns the tags to match articles on, see Taxonomy.MatchTags.
+func resolveQueryTags(app *AppRuntime, tags []string, logger *JsonLogger) ([]string, *HttpResponseData) {
+	if len(tags) == 0 {
+		return tags, nil
+	}
+	taxonomy, err := loadCachedTaxonomy(context.Background(), app.Taxonomy)
+	if err != nil {
+		body := fmt.Sprintf("failed to load taxonomy, error: %v", err)
+		logger.Perror(body)
+		return nil, CreateInternalServerErrorRespData(body)
+	}
+	return taxonomy.MatchTags(tags), nil
+}
+
+// resolveQueryTags for a single tag, returns the term id and the tags to
+// match articles on
+func resolveQueryTag(app *AppRuntime, tag string, logger *JsonLogger) (string, []string, *HttpResponseData) {
+	if tag == "" {
+		return "", nil, nil
+	}
+	tags, d := resolveQueryTags(app, []string{tag}, logger)
+	if d != nil || len(tags) == 0 {
+		return "", nil, d
+	}
+	return tags[0], tags, nil
+}
+
+func parseTaxonomyKind(s string) (TaxonomyKind, *HttpResponseData) {
+	switch k := TaxonomyKind(strings.ToLower(strings.TrimSpace(s))); k {
+	case TaxonomyKindCategory, TaxonomyKindTag:
+		return k, nil
+	default:
+		return "", CreateBadRequestRespData(fmt.Sprintf("invalid kind %v, must be %v or %v!", s, TaxonomyKindCategory, TaxonomyKindTag))
+	}
+}
+
+// sets the term fields given in the query args
+func setTaxonomyTermFields(term *TaxonomyTerm, values url.Values) *HttpResponseData {
+	if _, ok := values["name"]; ok {
+		if term.Name = strings.TrimSpace(values.Get("name")); term.Name == "" {
+			return CreateBadRequestRespData("name can't be blank!")
+		}
+	}
+	if _, ok := values["kind"]; ok {
+		kind, d := parseTaxonomyKind(values.Get("kind"))
+		if d != nil {
+			return d
+		}
+		term.Kind = kind
+	}
+	if _, ok := values["parent"]; ok {
+		term.Parent = NormalizeTag(values.Get("parent"))
+	}
+	if _, ok := values["synonyms"]; ok {
+		term.Synonyms = normalizeTags(parseQueryListValue(values, "synonyms"))
+	}
+	return nil
+}
+
+// saves the term after checking it against the taxonomy, with the
+// taxonomy locked so that concurrent changes don't break the hierarchy
+// or end up with the same synonym twice
+func saveTaxonomyTerm(app *AppRuntime, term *TaxonomyTerm, create bool, logger *JsonLogger) *HttpResponseData {
+	lock, d := lockArticle(app.TaxonomyLock, "taxonomy", logger)
+	if d != nil {
+		return d
+	}
+	defer lock.Unlock()
+	ctx := context.Background()
+	taxonomy, err := loadTaxonomy(ctx, app.Taxonomy)
+	if err != nil {
+		body := fmt.Sprintf("failed to load taxonomy, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	if err := taxonomy.CheckTerm(term); err != nil {
+		return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(err.Error()))
+	}
+	if create {
+		err = app.Taxonomy.CreateTerm(ctx, term)
+	} else {
+		err = app.Taxonomy.UpdateTerm(ctx, term)
+	}
+	if err == ErrStoreConflict {
+		body := fmt.Sprintf("taxonomy term %v already exists!", term.Id)
+		return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+	} else if err == ErrStoreNotFound {
+		return CreateNotFoundRespData(fmt.Sprintf("taxonomy term %v not found!", term.Id))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to save taxonomy term %v, error: %v", term.Id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	return nil
+}
+
+func createTaxonomyTerm(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	values := r.URL.Query()
+	name, d := ParseQueryStringValue(values, "name", true, "")
+	if d != nil {
+		return d
+	}
+	user := CmsUserFromReq(r)
+	jt := &JSONTime{time.Now().UTC()}
+	term := &TaxonomyTerm{
+		Id:        NormalizeTag(values.Get("id")),
+		Kind:      TaxonomyKindTag,
+		Synonyms:  make([]string, 0),
+		CreatedAt: jt,
+		CreatedBy: user.Username,
+		RevisedAt: jt,
+		RevisedBy: user.Username,
+	}
+	if term.Id == "" {
+		term.Id = NormalizeTag(name)
+	}
+	if term.Id == "" {
+		return CreateBadRequestRespData(fmt.Sprintf("invalid term name %v!", name))
+	}
+	if d := setTaxonomyTermFields(term, values); d != nil {
+		return d
+	}
+	if d := saveTaxonomyTerm(app, term, true, logger); d != nil {
+		return d
+	}
+	logger.Pinfof("user %v created taxonomy term %v", user.Username, term.Id)
+	return CreateJsonRespData(http.StatusOK, term)
+}
+
+func updateTaxonomyTerm(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	id := StringFromReq(r, CtxKeyId)
+	term, err := app.Taxonomy.GetTerm(context.Background(), id)
+	if err == ErrStoreNotFound {
+		return CreateNotFoundRespData(fmt.Sprintf("taxonomy term %v not found!", id))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to get taxonomy term %v, error: %v", id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	if d := setTaxonomyTermFields(term, r.URL.Query()); d != nil {
+		return d
+	}
+	user := CmsUserFromReq(r)
+	term.RevisedAt = &JSONTime{time.Now().UTC()}
+	term.RevisedBy = user.Username
+	if d := saveTaxonomyTerm(app, term, false, logger); d != nil {
+		return d
+	}
+	logger.Pinfof("user %v updated taxonomy term %v", user.Username, term.Id)
+	return CreateJsonRespData(http.StatusOK, term)
+}
+
+func deleteTaxonomyTerm(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	id := StringFromReq(r, CtxKeyId)
+	lock, d := lockArticle(app.TaxonomyLock, "taxonomy", logger)
+	if d != nil {
+		return d
+	}
+	defer lock.Unlock()
+	ctx := context.Background()
+	taxonomy, err := loadTaxonomy(ctx, app.Taxonomy)
+	if err != nil {
+		body := fmt.Sprintf("failed to load taxonomy, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	if children := taxonomy.Children(id); len(children) > 0 {
+		body := fmt.Sprintf("taxonomy term %v has children %v, move or delete them first!", id, strings.Join(children, ", "))
+		return CreateRespData(http.StatusConflict, ContentTypeValueText, []byte(body))
+	}
+	err = app.Taxonomy.DeleteTerm(ctx, id)
+	if err == ErrStoreNotFound {
+		return CreateNotFoundRespData(fmt.Sprintf("taxonomy term %v not found!", id))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to delete taxonomy term %v, error: %v", id, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	logger.Pinfof("user %v deleted taxonomy term %v", CmsUserFromReq(r).Username, id)
+	return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
+}
+
+func getTaxonomy(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	terms, err := app.Taxonomy.ListTerms(context.Background())
+	if err != nil {
+		body := fmt.Sprintf("failed to list taxonomy terms, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	taxonomy := NewTaxonomy(terms)
+	nodes := make([]*TaxonomyTermNode, len(terms))
+	for i, term := range terms {
+		nodes[i] = &TaxonomyTermNode{
+			TaxonomyTerm: term,
+			Ancestors:    taxonomy.Ancestors(term.Id),
+			Children:     taxonomy.Children(term.Id),
+		}
+	}
+	return CreateJsonRespData(http.StatusOK, &TaxonomyResponseBody{
+		Terms: nodes,
+	})
+}
+
+func TaxonomyGet() EndpointHandler {
+	return RequireAuth(getTaxonomy)
+}
+
+func TaxonomyCreate() EndpointHandler {
+	h := RequireOneRole(CmsRoleTaxonomyManage, createTaxonomyTerm)
+	return RequireAuth(h)
+}
+
+func TaxonomyUpdate() EndpointHandler {
+	h := GetRequiredStringArg("id", CtxKeyId, updateTaxonomyTerm)
+	h = RequireOneRole(CmsRoleTaxonomyManage, h)
+	return RequireAuth(h)
+}
+
+func TaxonomyDelete() EndpointHandler {
+	h := GetRequiredStringArg("id", CtxKeyId, deleteTaxonomyTerm)
+	h = RequireOneRole(CmsRoleTaxonomyManage, h)
+	return RequireAuth(h)
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -11,17 +12,23 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
 	HeaderRequestId   string = "X-Request-Id"
 	HeaderAuthToken   string = "X-Auth-Token"
+	HeaderAuthz       string = "Authorization"
 	HeaderContentType string = "Content-Type"
+	HeaderETag        string = "ETag"
+	HeaderIfMatch     string = "If-Match"
 
 	ContentTypeValueJSON string = "application/json; charset=utf-8"
 	ContentTypeValueText string = "text/plain; charset=utf-8"
 
 	ESIndexOpCreate string = "create"
+
+	AuthSchemeBearer string = "Bearer"
 )
 
 func GetRequiredStringArg(argName string, ctxKey CtxKey, h EndpointHandler) EndpointHandler {
@@ -36,13 +43,41 @@ func GetRequiredStringArg(argName string, ctxKey CtxKey, h EndpointHandler) Endp
 	}
 }
 
+// Auth token from the "Authorization: Bearer" header or the X-Auth-Token
+// header
+func authTokenFromReq(r *http.Request) string {
+	if authz := r.Header.Get(HeaderAuthz); len(authz) > len(AuthSchemeBearer) &&
+		strings.EqualFold(authz[0:len(AuthSchemeBearer)+1], AuthSchemeBearer+" ") {
+		return strings.TrimSpace(authz[len(AuthSchemeBearer)+1:])
+	}
+	return r.Header.Get(HeaderAuthToken)
+}
+
+// Decodes a jwt (if jwt is enabled) or a securecookie token
+func decodeAuthToken(app *AppRuntime, token string) (*CmsUser, error) {
+	if app.JWTKeys != nil && isJWT(token) {
+		claims, err := app.JWTKeys.Parse(token, app.Conf.JWTIssuer, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return claims.User(), nil
+	}
+	var user CmsUser
+	if err := app.Conf.SCookie.Decode(TokenCookieName, token, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
 func RequireAuth(h EndpointHandler) EndpointHandler {
 	return func(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
 		msg := ""
-		if token := r.Header.Get(HeaderAuthToken); len(token) > 0 {
-			var user CmsUser
-			if err := app.Conf.SCookie.Decode(TokenCookieName, token, &user); err == nil {
-				r = r.WithContext(context.WithValue(r.Context(), CtxKeyCmsUser, &user))
+		if token := authTokenFromReq(r); len(token) > 0 {
+			if user, err := decodeAuthToken(app, token); err == nil {
+				if _, d := getTokenSession(app, user.Session, user.Username, CtxLoggerFromReq(r)); d != nil {
+					return d
+				}
+				r = r.WithContext(context.WithValue(r.Context(), CtxKeyCmsUser, user))
 				return h(app, w, r)
 			} else {
 				msg = fmt.Sprintf(`You are not authorized to access this resource! Reason: %v`, err)
@@ -275,3 +310,66 @@ func EncodeCursorMark(sorts []interface{}) (string, error) {
 	}
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
+
+// Returns true if the request has an If-None-Match matching the etag
+func etagMatched(r *http.Request, etag string) bool {
+	for _, tag := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == etag || tag == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the conditional GET headers of the request against the etag and
+// the last modified time (either can be blank/zero), If-None-Match takes
+// precedence over If-Modified-Since as in RFC 7232
+func NotModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if r.Header.Get("If-None-Match") != "" {
+		return etag != "" && etagMatched(r, etag)
+	}
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// http dates have no sub-second part
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// quoted (strong) etag of the data, first 128 bits of its sha256
+func ContentETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf(`"%x"`, sum[0:16])
+}
+
+func CreateNotModifiedRespData(header http.Header) *HttpResponseData {
+	return &HttpResponseData{
+		Status: http.StatusNotModified,
+		Header: header,
+		Body:   bytes.NewReader(nil),
+	}
+}
+
+// 200 response of the data with its ETag, Last-Modified (unless zero) and
+// Cache-Control, or 304 if the client has it already
+func CreateCacheableRespData(r *http.Request, contentType string, data []byte, lastModified time.Time, cacheControl string) *HttpResponseData {
+	etag := ContentETag(data)
+	header := make(http.Header)
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", cacheControl)
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if NotModified(r, etag, lastModified) {
+		return CreateNotModifiedRespData(header)
+	}
+	d := CreateRespData(http.StatusOK, contentType, data)
+	for k, v := range header {
+		d.Header[k] = v
+	}
+	return d
+}
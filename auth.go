@@ -40,6 +40,15 @@ const (
 	// publish/unpublish article
 	CmsRoleArticlePublish CmsRoleValue = 1 << 4
 
+	// release/take over draft article locked by others
+	CmsRoleArticleUnlock CmsRoleValue = 1 << 5
+
+	// review (approve/reject) submitted article versions
+	CmsRoleArticleReview CmsRoleValue = 1 << 6
+
+	// create/update/delete taxonomy terms (categories and tags)
+	CmsRoleTaxonomyManage CmsRoleValue = 1 << 7
+
 	// create/update/delete login
 	CmsRoleLoginManage CmsRoleValue = 1 << 20
 
@@ -48,6 +57,9 @@ const (
 	CmsRoleArticleEditOtherName = "article:edit_other"
 	CmsRoleArticleSubmitName    = "article:submit"
 	CmsRoleArticlePublishName   = "article:publish"
+	CmsRoleArticleUnlockName    = "article:unlock"
+	CmsRoleArticleReviewName    = "article:review"
+	CmsRoleTaxonomyManageName   = "taxonomy:manage"
 	CmsRoleLoginManageName      = "login:manage"
 )
 
@@ -64,6 +76,9 @@ func init() {
 		CmsRoleArticleEditOther: CmsRoleArticleEditOtherName,
 		CmsRoleArticleSubmit:    CmsRoleArticleSubmitName,
 		CmsRoleArticlePublish:   CmsRoleArticlePublishName,
+		CmsRoleArticleUnlock:    CmsRoleArticleUnlockName,
+		CmsRoleArticleReview:    CmsRoleArticleReviewName,
+		CmsRoleTaxonomyManage:   CmsRoleTaxonomyManageName,
 		CmsRoleLoginManage:      CmsRoleLoginManageName,
 	}
 	CmsRoles = make([]*CmsRole, len(CmsRoleValue2Name))
@@ -114,6 +129,18 @@ type CmsUser struct {
 	Username string       `json:"username,omitempty"`
 	Password string       `json:"password,omitempty"`
 	Role     CmsRoleValue `json:"role"`
+
+	// who authenticates the user, "" for password (bcrypt) login or
+	// "oidc" for users provisioned by single sign-on
+	Provider string `json:"provider,omitempty"`
+
+	// identity of a sso user at the provider (the sub and iss claims),
+	// later logins with the username must have the same
+	Subject string `json:"subject,omitempty"`
+	Issuer  string `json:"issuer,omitempty"`
+
+	// id of the login session, only set in the auth token
+	Session string `json:"session,omitempty"`
 }
 
 func (u *CmsUser) String() string {
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type AuthBackend string
+
+const (
+	AuthBackendLocal AuthBackend = "local"
+	AuthBackendLDAP  AuthBackend = "ldap"
+
+	LDAPProviderName = "ldap"
+)
+
+var (
+	// the user isn't known to the authenticator, the next one is tried
+	ErrAuthUnknownUser = errors.New("unknown user")
+
+	ErrAuthBadPassword      = errors.New("wrong password")
+	ErrAuthPasswordDisabled = errors.New("password login is disabled for the user")
+
+	// the user is authenticated but none of its groups/roles is mapped to
+	// a cms role
+	ErrAuthNoRole = errors.New("no cms role for the user")
+)
+
+func parseAuthBackend(s string) (AuthBackend, error) {
+	switch t := AuthBackend(strings.ToLower(strings.TrimSpace(s))); t {
+	case AuthBackendLocal, AuthBackendLDAP:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unsupported auth backend %v, must be one of %v, %v!", s, AuthBackendLocal, AuthBackendLDAP)
+	}
+}
+
+// Verifies the password of a user for login. The user returned has the
+// role to login with, its Provider is set if it is an external user which
+// is provisioned (created/updated) in the user store on login.
+type PasswordAuthenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*CmsUser, error)
+}
+
+// Local users with bcrypt hashed passwords in the user store
+type LocalAuthenticator struct {
+	users UserStore
+}
+
+func NewLocalAuthenticator(users UserStore) *LocalAuthenticator {
+	return &LocalAuthenticator{users: users}
+}
+
+func (a *LocalAuthenticator) Authenticate(ctx context.Context, username, password string) (*CmsUser, error) {
+	user, err := a.users.GetUser(ctx, username)
+	if err == ErrStoreNotFound {
+		return nil, ErrAuthUnknownUser
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get cms user, error: %v", err)
+	}
+	// provisioned users have no password
+	if user.Provider != "" {
+		return nil, ErrAuthPasswordDisabled
+	}
+	hashedPassword, err := base64.StdEncoding.DecodeString(user.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode password of user %v, error: %v", username, err)
+	}
+	if err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password)); err != nil {
+		return nil, ErrAuthBadPassword
+	}
+	return user, nil
+}
+
+// Authenticators of the auth backend, the ldap one falls back to local
+// users (like the first user) who are not in the directory
+func NewPasswordAuthenticators(conf *AppConf, users UserStore) ([]PasswordAuthenticator, error) {
+	local := NewLocalAuthenticator(users)
+	if conf.AuthBackend != AuthBackendLDAP {
+		return []PasswordAuthenticator{local}, nil
+	}
+	ldap, err := NewLDAPAuthenticator(conf)
+	if err != nil {
+		return nil, err
+	}
+	return []PasswordAuthenticator{ldap, local}, nil
+}
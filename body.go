@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type ArticleBlockType string
+
+const (
+	ArticleBlockParagraph ArticleBlockType = "paragraph"
+	ArticleBlockHeading   ArticleBlockType = "heading"
+	ArticleBlockQuote     ArticleBlockType = "quote"
+	ArticleBlockList      ArticleBlockType = "list"
+	ArticleBlockImage     ArticleBlockType = "image"
+	ArticleBlockEmbed     ArticleBlockType = "embed"
+
+	maxArticleBlocks = 1000
+)
+
+// A block of the structured article body, which fields are used depends
+// on the type:
+//
+//	paragraph  text
+//	heading    text, level (1~6)
+//	quote      text, cite (optional)
+//	list       items, ordered
+//	image      src (or media), alt, caption (optional)
+//	embed      src (https), caption (optional)
+type ArticleBlock struct {
+	Type    ArticleBlockType `json:"type"`
+	Text    string           `json:"text,omitempty"`
+	Level   int              `json:"level,omitempty"`
+	Cite    string           `json:"cite,omitempty"`
+	Items   []string         `json:"items,omitempty"`
+	Ordered bool             `json:"ordered,omitempty"`
+	Src     string           `json:"src,omitempty"`
+	Alt     string           `json:"alt,omitempty"`
+	Caption string           `json:"caption,omitempty"`
+
+	// id of the media asset an image block shows, src is set from it
+	Media string `json:"media,omitempty"`
+}
+
+func (b *ArticleBlock) equal(o *ArticleBlock) bool {
+	if b.Type != o.Type || b.Text != o.Text || b.Level != o.Level ||
+		b.Cite != o.Cite || b.Ordered != o.Ordered || b.Src != o.Src ||
+		b.Alt != o.Alt || b.Caption != o.Caption || b.Media != o.Media ||
+		len(b.Items) != len(o.Items) {
+		return false
+	}
+	for i := 0; i < len(b.Items); i++ {
+		if b.Items[i] != o.Items[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameArticleBody(a, b []*ArticleBlock) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if !a[i].equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func copyArticleBody(body []*ArticleBlock) []*ArticleBlock {
+	if body == nil {
+		return nil
+	}
+	c := make([]*ArticleBlock, len(body))
+	for i, b := range body {
+		one := *b
+		if b.Items != nil {
+			one.Items = make([]string, len(b.Items))
+			copy(one.Items, b.Items)
+		}
+		c[i] = &one
+	}
+	return c
+}
+
+// image src is either a http(s) url or an absolute path on this server,
+// embed src has to be a https url
+func checkBlockSrc(src string, httpsOnly bool) error {
+	u, err := url.Parse(src)
+	if err != nil || src == "" {
+		return fmt.Errorf("invalid src %q", src)
+	}
+	switch {
+	case u.Scheme == "https" && u.Host != "":
+		return nil
+	case u.Scheme == "http" && u.Host != "" && !httpsOnly:
+		return nil
+	case u.Scheme == "" && u.Host == "" && strings.HasPrefix(u.Path, "/") && !httpsOnly:
+		return nil
+	}
+	if httpsOnly {
+		return fmt.Errorf("src %q must be a https url", src)
+	}
+	return fmt.Errorf("src %q must be a http(s) url or an absolute path", src)
+}
+
+// embeds are only allowed from the given hosts
+func checkEmbedHost(src string, embedHosts []string) error {
+	u, err := url.Parse(src)
+	if err != nil {
+		return fmt.Errorf("invalid src %q", src)
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range embedHosts {
+		if host == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("embed host %v is not allowed", host)
+}
+
+func validateArticleBlock(b *ArticleBlock, embedHosts []string) error {
+	if b.Media != "" && b.Type != ArticleBlockImage {
+		return fmt.Errorf("%v can't have media", b.Type)
+	}
+	switch b.Type {
+	case ArticleBlockParagraph, ArticleBlockQuote:
+		if strings.TrimSpace(b.Text) == "" {
+			return fmt.Errorf("%v text is blank", b.Type)
+		}
+	case ArticleBlockHeading:
+		if strings.TrimSpace(b.Text) == "" {
+			return fmt.Errorf("%v text is blank", b.Type)
+		}
+		if b.Level < 1 || b.Level > 6 {
+			return fmt.Errorf("heading level %v is not within 1~6", b.Level)
+		}
+	case ArticleBlockList:
+		if len(b.Items) == 0 {
+			return fmt.Errorf("list has no items")
+		}
+		for _, item := range b.Items {
+			if strings.TrimSpace(item) == "" {
+				return fmt.Errorf("list item is blank")
+			}
+		}
+	case ArticleBlockImage:
+		return checkBlockSrc(b.Src, false)
+	case ArticleBlockEmbed:
+		if err := checkBlockSrc(b.Src, true); err != nil {
+			return err
+		}
+		return checkEmbedHost(b.Src, embedHosts)
+	default:
+		return fmt.Errorf("unknown block type %q", b.Type)
+	}
+	return nil
+}
+
+// Validates the blocks of a structured body, embeds are only allowed from
+// the given hosts
+func ValidateArticleBody(body []*ArticleBlock, embedHosts []string) error {
+	if len(body) > maxArticleBlocks {
+		return fmt.Errorf("too many blocks (%v), at most %v are allowed", len(body), maxArticleBlocks)
+	}
+	for i, b := range body {
+		if b == nil {
+			return fmt.Errorf("block %v is null", i)
+		}
+		if err := validateArticleBlock(b, embedHosts); err != nil {
+			return fmt.Errorf("block %v: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Plain text of the body, one block per paragraph (separated by a blank
+// line). It is stored as the article content so that the body is
+// searched/diffed through it.
+func ArticleBodyPlainText(body []*ArticleBlock) string {
+	parts := make([]string, 0, len(body))
+	for _, b := range body {
+		var text string
+		switch b.Type {
+		case ArticleBlockQuote:
+			text = b.Text
+			if b.Cite != "" {
+				text = fmt.Sprintf("%v\n-- %v", text, b.Cite)
+			}
+		case ArticleBlockList:
+			items := make([]string, len(b.Items))
+			for i, item := range b.Items {
+				if b.Ordered {
+					items[i] = fmt.Sprintf("%v. %v", i+1, item)
+				} else {
+					items[i] = "- " + item
+				}
+			}
+			text = strings.Join(items, "\n")
+		case ArticleBlockImage:
+			text = strings.TrimSpace(b.Alt + "\n" + b.Caption)
+		case ArticleBlockEmbed:
+			text = b.Caption
+		default:
+			text = b.Text
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// html/template of the body blocks, used by the article page templates
+// as {{ template "body" .Body }}
+const ARTICLE_BODY_TPL = `{{ define "body" }}{{ range . }}
+{{- if eq .Type "paragraph" }}<p>{{.Text}}</p>
+{{ else if eq .Type "heading" }}{{ if eq .Level 1 }}<h1>{{.Text}}</h1>{{ else if eq .Level 2 }}<h2>{{.Text}}</h2>{{ else if eq .Level 3 }}<h3>{{.Text}}</h3>{{ else if eq .Level 4 }}<h4>{{.Text}}</h4>{{ else if eq .Level 5 }}<h5>{{.Text}}</h5>{{ else }}<h6>{{.Text}}</h6>{{ end }}
+{{ else if eq .Type "quote" }}<blockquote><p>{{.Text}}</p>{{ if .Cite }}<footer>{{.Cite}}</footer>{{ end }}</blockquote>
+{{ else if eq .Type "list" }}{{ if .Ordered }}<ol>{{ else }}<ul>{{ end }}{{ range .Items }}<li>{{.}}</li>{{ end }}{{ if .Ordered }}</ol>{{ else }}</ul>{{ end }}
+{{ else if eq .Type "image" }}<figure><img src="{{.Src}}" alt="{{.Alt}}"/>{{ if .Caption }}<figcaption>{{.Caption}}</figcaption>{{ end }}</figure>
+{{ else if eq .Type "embed" }}<figure><iframe src="{{.Src}}" sandbox="allow-scripts allow-same-origin allow-popups" referrerpolicy="strict-origin-when-cross-origin" frameborder="0" allowfullscreen></iframe>{{ if .Caption }}<figcaption>{{.Caption}}</figcaption>{{ end }}</figure>
+{{ end }}{{ end }}{{ end }}`
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testArticleBody() []*ArticleBlock {
+	return []*ArticleBlock{
+		&ArticleBlock{Type: ArticleBlockHeading, Text: "Title", Level: 2},
+		&ArticleBlock{Type: ArticleBlockParagraph, Text: "Hello <world>"},
+		&ArticleBlock{Type: ArticleBlockQuote, Text: "To be", Cite: "Hamlet"},
+		&ArticleBlock{Type: ArticleBlockList, Items: []string{"one", "two"}, Ordered: true},
+		&ArticleBlock{Type: ArticleBlockImage, Src: "/static/a.png", Alt: "an image"},
+		&ArticleBlock{Type: ArticleBlockEmbed, Src: "https://example.com/v/1", Caption: "a video"},
+	}
+}
+
+var testEmbedHosts = []string{"example.com"}
+
+func TestValidateArticleBody(t *testing.T) {
+	if err := ValidateArticleBody(testArticleBody(), testEmbedHosts); err != nil {
+		t.Errorf("expecting valid body, but got %v", err)
+	}
+	for _, b := range []*ArticleBlock{
+		&ArticleBlock{Type: "table"},
+		&ArticleBlock{Type: ArticleBlockParagraph, Text: "  "},
+		&ArticleBlock{Type: ArticleBlockHeading, Text: "h", Level: 7},
+		&ArticleBlock{Type: ArticleBlockList, Items: []string{"a", ""}},
+		&ArticleBlock{Type: ArticleBlockImage, Src: "javascript:alert(1)"},
+		&ArticleBlock{Type: ArticleBlockImage, Src: "a.png"},
+		&ArticleBlock{Type: ArticleBlockEmbed, Src: "http://example.com/v/1"},
+		&ArticleBlock{Type: ArticleBlockEmbed, Src: "https://evil.example.org/v/1"},
+		&ArticleBlock{Type: ArticleBlockEmbed, Src: "https://sub.example.com/v/1"},
+		nil,
+	} {
+		if err := ValidateArticleBody([]*ArticleBlock{b}, testEmbedHosts); err == nil {
+			t.Errorf("expecting block %+v rejected, but it isn't", b)
+		}
+	}
+}
+
+func TestArticleBodyPlainText(t *testing.T) {
+	expected := "Title\n\nHello <world>\n\nTo be\n-- Hamlet\n\n1. one\n2. two\n\nan image\n\na video"
+	if text := ArticleBodyPlainText(testArticleBody()); text != expected {
+		t.Errorf("expecting plain text %q, but got %q", expected, text)
+	}
+}
+
+func TestArticleBodyHTML(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := articleTpl.Execute(buf, &Article{Headline: "h", Body: testArticleBody()}); err != nil {
+		t.Errorf("failed to render article, error: %v", err)
+		return
+	}
+	page := buf.String()
+	for _, s := range []string{
+		"<h2>Title</h2>",
+		"<p>Hello &lt;world&gt;</p>",
+		"<blockquote><p>To be</p><footer>Hamlet</footer></blockquote>",
+		"<ol><li>one</li><li>two</li></ol>",
+		`<img src="/static/a.png" alt="an image"/>`,
+		`<iframe src="https://example.com/v/1" sandbox="allow-scripts allow-same-origin allow-popups" referrerpolicy="strict-origin-when-cross-origin"`,
+	} {
+		if !strings.Contains(page, s) {
+			t.Errorf("expecting %q in the page, but got %v", s, page)
+		}
+	}
+	if strings.Contains(page, "Content:") {
+		t.Errorf("expecting no plain content with body, but got %v", page)
+	}
+}
@@ -5,12 +5,16 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/go-ldap/ldap/v3"
 	"github.com/gorilla/securecookie"
 )
 
@@ -24,6 +28,26 @@ type UserIndexTypes struct {
 	User string
 }
 
+type LockIndexTypes struct {
+	Lock string
+}
+
+type ScheduleIndexTypes struct {
+	Schedule string
+}
+
+type TaxonomyIndexTypes struct {
+	Term string
+}
+
+type MediaIndexTypes struct {
+	Asset string
+}
+
+type SessionIndexTypes struct {
+	Session string
+}
+
 var (
 	articleIndexDef string
 
@@ -45,7 +69,10 @@ var (
       "properties":{
         "username":        {"type": "keyword"},
         "password":        {"type": "binary", "doc_values": false},
-        "role":            {"type": "keyword"}
+        "role":            {"type": "keyword"},
+        "provider":        {"type": "keyword"},
+        "subject":         {"type": "keyword"},
+        "issuer":          {"type": "keyword"}
       }
     }
   }
@@ -54,24 +81,172 @@ var (
 	userIndexTypes = &UserIndexTypes{
 		User: "user",
 	}
+
+	lockIndexDef = `
+{
+  "settings" : {
+    "number_of_shards" :   1,
+    "number_of_replicas" : 1,
+	"index.mapper.dynamic": false
+  },
+  "mappings":{
+    "lock":{
+      "properties":{
+        "owner":           {"type": "keyword"},
+        "expire_at":       {"type": "date"}
+      }
+    }
+  }
+}`
+
+	lockIndexTypes = &LockIndexTypes{
+		Lock: "lock",
+	}
+
+	scheduleIndexDef = `
+{
+  "settings" : {
+    "number_of_shards" :   1,
+    "number_of_replicas" : 1,
+	"index.mapper.dynamic": false
+  },
+  "mappings":{
+    "schedule":{
+      "properties":{
+        "id":              {"type": "keyword"},
+        "guid":            {"type": "keyword"},
+        "version":         {"type": "keyword"},
+        "action":          {"type": "keyword"},
+        "at":              {"type": "date"},
+        "status":          {"type": "keyword"},
+        "created_at":      {"type": "date"},
+        "created_by":      {"type": "keyword"},
+        "done_at":         {"type": "date"},
+        "error":           {"type": "text", "index": "false"}
+      }
+    }
+  }
+}`
+
+	scheduleIndexTypes = &ScheduleIndexTypes{
+		Schedule: "schedule",
+	}
+
+	taxonomyIndexDef = `
+{
+  "settings" : {
+    "number_of_shards" :   1,
+    "number_of_replicas" : 1,
+	"index.mapper.dynamic": false
+  },
+  "mappings":{
+    "term":{
+      "properties":{
+        "id":              {"type": "keyword"},
+        "name":            {"type": "keyword"},
+        "kind":            {"type": "keyword"},
+        "parent":          {"type": "keyword"},
+        "synonyms":        {"type": "keyword"},
+        "created_at":      {"type": "date"},
+        "created_by":      {"type": "keyword"},
+        "revised_at":      {"type": "date"},
+        "revised_by":      {"type": "keyword"}
+      }
+    }
+  }
+}`
+
+	taxonomyIndexTypes = &TaxonomyIndexTypes{
+		Term: "term",
+	}
+
+	mediaIndexDef = `
+{
+  "settings" : {
+    "number_of_shards" :   1,
+    "number_of_replicas" : 1,
+	"index.mapper.dynamic": false
+  },
+  "mappings":{
+    "asset":{
+      "properties":{
+        "id":              {"type": "keyword"},
+        "filename":        {"type": "keyword"},
+        "content_type":    {"type": "keyword"},
+        "size":            {"type": "long"},
+        "width":           {"type": "integer"},
+        "height":          {"type": "integer"},
+        "caption":         {"type": "text"},
+        "credit":          {"type": "text"},
+        "alt":             {"type": "text"},
+        "created_at":      {"type": "date"},
+        "created_by":      {"type": "keyword"},
+        "revised_at":      {"type": "date"},
+        "revised_by":      {"type": "keyword"}
+      }
+    }
+  }
+}`
+
+	mediaIndexTypes = &MediaIndexTypes{
+		Asset: "asset",
+	}
+
+	sessionIndexDef = `
+{
+  "settings" : {
+    "number_of_shards" :   1,
+    "number_of_replicas" : 1,
+	"index.mapper.dynamic": false
+  },
+  "mappings":{
+    "session":{
+      "properties":{
+        "id":              {"type": "keyword"},
+        "username":        {"type": "keyword"},
+        "created_at":      {"type": "date"},
+        "refreshed_at":    {"type": "date"},
+        "expire_at":       {"type": "date"},
+        "remote_ip":       {"type": "keyword"},
+        "user_agent":      {"type": "keyword", "index": false}
+      }
+    }
+  }
+}`
+
+	sessionIndexTypes = &SessionIndexTypes{
+		Session: "session",
+	}
 )
 
 func init() {
 	articleMappingProps := map[string]map[string]map[string]interface{}{
 		"properties": map[string]map[string]interface{}{
-			"guid":         map[string]interface{}{"type": "keyword"},
-			"headline":     map[string]interface{}{"type": "text"},
-			"summary":      map[string]interface{}{"type": "text", "index": "false"},
-			"content":      map[string]interface{}{"type": "text"},
-			"tag":          map[string]interface{}{"type": "keyword"},
-			"created_at":   map[string]interface{}{"type": "date"},
-			"created_by":   map[string]interface{}{"type": "keyword"},
-			"revised_at":   map[string]interface{}{"type": "date"},
-			"revised_by":   map[string]interface{}{"type": "keyword"},
-			"version":      map[string]interface{}{"type": "keyword"},
-			"from_version": map[string]interface{}{"type": "keyword"},
-			"note":         map[string]interface{}{"type": "text", "index": "false"},
-			"locked_by":    map[string]interface{}{"type": "keyword"},
+			"guid":           map[string]interface{}{"type": "keyword"},
+			"headline":       map[string]interface{}{"type": "text"},
+			"summary":        map[string]interface{}{"type": "text", "index": "false"},
+			"content":        map[string]interface{}{"type": "text"},
+			"body":           map[string]interface{}{"type": "object", "enabled": false},
+			"lead_image":     map[string]interface{}{"type": "object", "enabled": false},
+			"content_format": map[string]interface{}{"type": "keyword"},
+			"content_text":   map[string]interface{}{"type": "text"},
+			"tag":            map[string]interface{}{"type": "keyword"},
+			"created_at":     map[string]interface{}{"type": "date"},
+			"created_by":     map[string]interface{}{"type": "keyword"},
+			"revised_at":     map[string]interface{}{"type": "date"},
+			"revised_by":     map[string]interface{}{"type": "keyword"},
+			"published_at":   map[string]interface{}{"type": "date"},
+			"version":        map[string]interface{}{"type": "keyword"},
+			"from_version":   map[string]interface{}{"type": "keyword"},
+			"note":           map[string]interface{}{"type": "text", "index": "false"},
+			"locked_by":      map[string]interface{}{"type": "keyword"},
+			"locked_at":      map[string]interface{}{"type": "date"},
+			"lock_heartbeat": map[string]interface{}{"type": "date"},
+			"rev":            map[string]interface{}{"type": "long", "index": "false"},
+			"state":          map[string]interface{}{"type": "keyword"},
+			"state_by":       map[string]interface{}{"type": "keyword"},
+			"state_at":       map[string]interface{}{"type": "date"},
+			"state_reason":   map[string]interface{}{"type": "text", "index": "false"},
 		},
 	}
 
@@ -101,6 +276,55 @@ const (
 	LoggingTargetFile   LoggingTarget = "file"
 )
 
+type StoreType string
+
+const (
+	StoreTypeElastic StoreType = "elasticsearch"
+	StoreTypeBolt    StoreType = "bolt"
+	StoreTypeMemory  StoreType = "memory"
+)
+
+func parseStoreType(s string) (StoreType, error) {
+	switch t := StoreType(strings.ToLower(strings.TrimSpace(s))); t {
+	case StoreTypeElastic, StoreTypeBolt, StoreTypeMemory:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unsupported store %v, must be one of %v, %v, %v!", s, StoreTypeElastic, StoreTypeBolt, StoreTypeMemory)
+	}
+}
+
+type LockType string
+
+const (
+	LockTypeLocal   LockType = "local"
+	LockTypeElastic LockType = "elasticsearch"
+)
+
+func parseLockType(s string) (LockType, error) {
+	switch t := LockType(strings.ToLower(strings.TrimSpace(s))); t {
+	case LockTypeLocal, LockTypeElastic:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unsupported lock %v, must be one of %v, %v!", s, LockTypeLocal, LockTypeElastic)
+	}
+}
+
+type TokenFormat string
+
+const (
+	TokenFormatSecureCookie TokenFormat = "securecookie"
+	TokenFormatJWT          TokenFormat = "jwt"
+)
+
+func parseTokenFormat(s string) (TokenFormat, error) {
+	switch t := TokenFormat(strings.ToLower(strings.TrimSpace(s))); t {
+	case TokenFormatSecureCookie, TokenFormatJWT:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unsupported token format %v, must be one of %v, %v!", s, TokenFormatSecureCookie, TokenFormatJWT)
+	}
+}
+
 type LoggingSpec struct {
 	Target     LoggingTarget
 	Filepath   string
@@ -187,9 +411,78 @@ type AppConf struct {
 	// server write timeout
 	ServerWriteTimeout time.Duration
 
+	// Where articles and users are stored
+	// "elasticsearch" (default), "bolt" (single file) or "memory" (nothing is persisted)
+	Store StoreType
+
+	// Path to the bolt db file when Store is "bolt"
+	BoltPath string
+
 	// Elasticsearch Hosts
 	ESHosts []string
 
+	// How article drafts/publishes are locked
+	// "local" (default, within this process only) or "elasticsearch"
+	// (across all api servers sharing the same elasticsearch cluster)
+	Lock LockType
+
+	// Lease of an elasticsearch lock, it is renewed while the lock is
+	// held and can be taken over by others once expired
+	LockTTL time.Duration
+
+	// How long to wait for an elasticsearch lock
+	LockTimeout time.Duration
+
+	// How long a draft lock is kept without a save or heartbeat from its
+	// holder, after that it can be taken over by other editors, 0 to never
+	// expire (only users with article:unlock can take it over)
+	DraftLockExpiry time.Duration
+
+	// Only approved article versions can be published
+	RequireApproval bool
+
+	// Reject article tags which are not in the taxonomy (as a term or a
+	// synonym), otherwise they are only normalized
+	StrictTags bool
+
+	// Hosts (lower case) the embeds of article bodies are allowed from
+	EmbedHosts []string
+
+	// Directory to store uploaded media files in
+	MediaDir string
+
+	// Max size (in bytes) of an uploaded media file
+	MediaMaxSize int64
+
+	// Directory to cache resized media variants in and the max size (in
+	// bytes) of them, least recently used ones are removed beyond it
+	MediaCacheDir  string
+	MediaCacheSize int64
+
+	// Sizes (ascending) the width/height of requested media variants are
+	// snapped to, empty to serve only the originals
+	MediaVariantSizes []int
+
+	// Max number of media variants resized at the same time
+	MediaResizeConcurrency int
+
+	// Base url (scheme and host, e.g. https://news.example.com) of the
+	// frontend pages for absolute links in feeds, "" to take it from
+	// the request
+	PublicUrl string
+
+	// Publication name and language (ISO 639, e.g. "en") in the google
+	// news sitemap, the name defaults to the host of the public url
+	NewsName     string
+	NewsLanguage string
+
+	// How often to check for due scheduled publish/unpublish
+	ScheduleInterval time.Duration
+
+	// How often to clean up drafts left behind by failed submits,
+	// 0 to do it only at startup
+	DraftReconcileInterval time.Duration
+
 	// Used to sign/encrypt/decrypt auth data with gorilla/securecookie
 	// This is hacky as the securecookie is meant for cookie but here
 	// we set the result string as an auth-token in header
@@ -198,6 +491,78 @@ type AppConf struct {
 	// max age for SCookie
 	SCookieMaxAge time.Duration
 
+	// Used to sign/encrypt/decrypt refresh tokens (which live much longer
+	// than the access tokens from SCookie) and their max age
+	SCookieRefresh       *securecookie.SecureCookie
+	SCookieRefreshMaxAge time.Duration
+
+	// Deprecated: also accept username/password as GET query args on
+	// the login endpoints, they end up in access and proxy logs
+	LegacyGetLogin bool
+
+	// Format of the issued access tokens, "securecookie" (default) or
+	// "jwt" (signed with keys in JWTKeyDir), securecookie tokens in the
+	// X-Auth-Token header are accepted either way
+	TokenFormat TokenFormat
+
+	// Signing algorithm of the JWTs ("HS256", "RS256" or "EdDSA"), the
+	// directory of the keys (one per file, named after its kid) and how
+	// often it is reloaded to pick up rotated keys
+	JWTAlg       JWTAlg
+	JWTKeyDir    string
+	JWTKeyReload time.Duration
+
+	// "iss" claim of the issued JWTs and the one accepted
+	JWTIssuer string
+
+	// How passwords are verified on login, "local" (default, bcrypt
+	// hashed passwords in the user store) or "ldap" (bind to the ldap
+	// server, falling back to local users not in the directory)
+	AuthBackend AuthBackend
+
+	// ldap:// or ldaps:// url of the ldap server, whether to StartTLS on
+	// ldap://, the CA certificate(s) of the server ("" for the system ones)
+	// and the timeout of an operation
+	LDAPUrl      string
+	LDAPStartTLS bool
+	LDAPCAFile   string
+	LDAPTimeout  time.Duration
+
+	// Service account to search with, "" to search anonymously
+	LDAPBindDN       string
+	LDAPBindPassword string
+
+	// Where and how (%s is the username) to search the user entry, the
+	// cms username is the value of the username attribute of the entry
+	LDAPBaseDN       string
+	LDAPUserFilter   string
+	LDAPUsernameAttr string
+
+	// Groups of the user are the values (dn) of the attribute of its entry,
+	// or the ones found by the filter (%s is the user dn) under the group
+	// base dn if it is set
+	LDAPGroupAttr   string
+	LDAPGroupBaseDN string
+	LDAPGroupFilter string
+
+	// cms roles of the groups by lower-cased group name (cn)
+	LDAPRoleMap map[string]CmsRoleValue
+
+	// OpenID Connect provider (issuer url, "" to disable sso) and the
+	// client registered with it. The redirect url defaults to
+	// /api/oidc/callback on the public url (or the request host).
+	OIDCIssuer       string
+	OIDCClientId     string
+	OIDCClientSecret string
+	OIDCRedirectUrl  string
+	OIDCScopes       []string
+
+	// ID token claims of the username and of the groups/roles, and the
+	// cms roles of each of their values
+	OIDCUsernameClaim string
+	OIDCRolesClaim    string
+	OIDCRoleMap       map[string]CmsRoleValue
+
 	// logging spec
 	// Only support logging to stdout or file
 	// When it is file we use https://github.com/natefinch/lumberjack
@@ -217,6 +582,36 @@ type AppConf struct {
 
 	// user type
 	UserIndexTypes *UserIndexTypes
+
+	// lock index
+	LockIndex *ESIndex
+
+	// lock type
+	LockIndexTypes *LockIndexTypes
+
+	// schedule index
+	ScheduleIndex *ESIndex
+
+	// schedule type
+	ScheduleIndexTypes *ScheduleIndexTypes
+
+	// taxonomy index
+	TaxonomyIndex *ESIndex
+
+	// taxonomy type
+	TaxonomyIndexTypes *TaxonomyIndexTypes
+
+	// media index
+	MediaIndex *ESIndex
+
+	// media type
+	MediaIndexTypes *MediaIndexTypes
+
+	// session index
+	SessionIndex *ESIndex
+
+	// session type
+	SessionIndexTypes *SessionIndexTypes
 }
 
 func (c *AppConf) String() string {
@@ -225,14 +620,66 @@ func (c *AppConf) String() string {
 		ServerIP         string   `json:"server-ip"`
 		ServerPort       int      `json:"server-port"`
 		ServerRoot       string   `json:"server-root"`
+		Store            string   `json:"store"`
+		BoltPath         string   `json:"bolt-path,omitempty"`
 		ESHosts          []string `json:"es-hosts"`
+		Lock             string   `json:"lock"`
+		RequireApproval  bool     `json:"require-approval"`
+		StrictTags       bool     `json:"strict-tags"`
+		EmbedHosts       []string `json:"embed-hosts"`
+		LegacyGetLogin   bool     `json:"legacy-get-login"`
+		TokenFormat      string   `json:"token-format"`
+		JWTAlg           string   `json:"jwt-alg,omitempty"`
+		JWTKeyDir        string   `json:"jwt-key-dir,omitempty"`
+		JWTIssuer        string   `json:"jwt-issuer,omitempty"`
+		AuthBackend      string   `json:"auth-backend"`
+		LDAPUrl          string   `json:"ldap-url,omitempty"`
+		LDAPBaseDN       string   `json:"ldap-base-dn,omitempty"`
+		OIDCIssuer       string   `json:"oidc-issuer,omitempty"`
+		OIDCClientId     string   `json:"oidc-client-id,omitempty"`
+		OIDCRedirectUrl  string   `json:"oidc-redirect-url,omitempty"`
+		MediaDir         string   `json:"media-dir"`
+		MediaMaxSize     int64    `json:"media-max-size"`
+		MediaCacheDir    string   `json:"media-cache-dir"`
+		MediaCacheSize   int64    `json:"media-cache-size"`
+		MediaVariants    []int    `json:"media-variant-sizes"`
+		MediaResizes     int      `json:"media-resize-concurrency"`
+		PublicUrl        string   `json:"public-url,omitempty"`
+		NewsName         string   `json:"news-name,omitempty"`
+		NewsLanguage     string   `json:"news-language"`
 		ArticleIndexName string   `json:"article-index"`
 		LoggingSpec      string   `json:"logging-spec"`
 	}{
 		ServerIP:         c.ServerIP,
 		ServerPort:       c.ServerPort,
 		ServerRoot:       c.ServerRoot,
+		Store:            string(c.Store),
+		BoltPath:         c.BoltPath,
 		ESHosts:          c.ESHosts,
+		Lock:             string(c.Lock),
+		RequireApproval:  c.RequireApproval,
+		StrictTags:       c.StrictTags,
+		EmbedHosts:       c.EmbedHosts,
+		LegacyGetLogin:   c.LegacyGetLogin,
+		TokenFormat:      string(c.TokenFormat),
+		JWTAlg:           string(c.JWTAlg),
+		JWTKeyDir:        c.JWTKeyDir,
+		JWTIssuer:        c.JWTIssuer,
+		AuthBackend:      string(c.AuthBackend),
+		LDAPUrl:          c.LDAPUrl,
+		LDAPBaseDN:       c.LDAPBaseDN,
+		OIDCIssuer:       c.OIDCIssuer,
+		OIDCClientId:     c.OIDCClientId,
+		OIDCRedirectUrl:  c.OIDCRedirectUrl,
+		MediaDir:         c.MediaDir,
+		MediaMaxSize:     c.MediaMaxSize,
+		MediaCacheDir:    c.MediaCacheDir,
+		MediaCacheSize:   c.MediaCacheSize,
+		MediaVariants:    c.MediaVariantSizes,
+		MediaResizes:     c.MediaResizeConcurrency,
+		PublicUrl:        c.PublicUrl,
+		NewsName:         c.NewsName,
+		NewsLanguage:     c.NewsLanguage,
 		ArticleIndexName: c.ArticleIndex.Name,
 		LoggingSpec:      c.LoggingSpec.String(),
 	}
@@ -287,6 +734,130 @@ func checkServerRoot(root string) error {
 	}
 }
 
+func newSecureCookie(hashKey, blockKey []byte, maxAge int) *securecookie.SecureCookie {
+	scookie := securecookie.New(hashKey, blockKey)
+	scookie.SetSerializer(securecookie.JSONEncoder{})
+	scookie.MinAge(0)    // no restriction
+	scookie.MaxLength(0) // no restriction
+	scookie.MaxAge(maxAge)
+	return scookie
+}
+
+// Parses "value=role,role;value=role" into the cms roles of the values
+// (of the oidc roles claim or the ldap groups)
+func parseRoleMap(s string) (map[string]CmsRoleValue, error) {
+	roles := make(map[string]CmsRoleValue)
+	for _, entry := range strings.Split(s, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		value := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || value == "" {
+			return nil, fmt.Errorf("invalid role map entry %v, it should be like value=role,role", entry)
+		}
+		for _, name := range strings.Split(parts[1], ",") {
+			role, ok := CmsRoleName2Value[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown cms role %v in role map entry %v", name, entry)
+			}
+			roles[value] |= role
+		}
+	}
+	return roles, nil
+}
+
+func parseMediaVariantSizes(s string) ([]int, error) {
+	sizes := make([]int, 0)
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		size, err := strconv.Atoi(part)
+		if err != nil || size < 1 || size > maxMediaVariantSide {
+			return nil, fmt.Errorf("invalid media variant size %v, it should be within [1, %v]", part, maxMediaVariantSide)
+		}
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+	return sizes, nil
+}
+
+func parseEmbedHosts(s string) []string {
+	hosts := make([]string, 0)
+	for _, host := range strings.Split(s, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+func parseOIDCScopes(s string) []string {
+	scopes := []string{"openid"}
+	for _, scope := range strings.Split(s, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" && scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+func checkOIDCConf(issuer, clientId, redirectUrl string, roles map[string]CmsRoleValue) error {
+	if u, err := url.Parse(issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("invalid oidc issuer %v, it should be like https://login.example.com", issuer)
+	}
+	if clientId == "" {
+		return fmt.Errorf("missing oidc client id (-oidc-client-id)!")
+	}
+	if redirectUrl != "" {
+		if u, err := url.Parse(redirectUrl); err != nil || !u.IsAbs() {
+			return fmt.Errorf("invalid oidc redirect url %v, it should be an absolute url", redirectUrl)
+		}
+	}
+	if len(roles) == 0 {
+		return fmt.Errorf("missing oidc role map (-oidc-role-map), sso users would have no role!")
+	}
+	return nil
+}
+
+func checkLDAPConf(ldapUrl, baseDN, userFilter, groupFilter string, roles map[string]CmsRoleValue) error {
+	if u, err := url.Parse(ldapUrl); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		return fmt.Errorf("invalid ldap url %v, it should be like ldaps://ldap.example.com", ldapUrl)
+	}
+	if strings.TrimSpace(baseDN) == "" {
+		return fmt.Errorf("missing ldap base dn (-ldap-base-dn)!")
+	}
+	for _, filter := range []string{userFilter, groupFilter} {
+		if !strings.Contains(filter, "%s") {
+			return fmt.Errorf("ldap filter %v has no %%s", filter)
+		}
+		if _, err := ldap.CompileFilter(strings.Replace(filter, "%s", "x", -1)); err != nil {
+			return fmt.Errorf("invalid ldap filter %v, error: %v", filter, err)
+		}
+	}
+	if len(roles) == 0 {
+		return fmt.Errorf("missing ldap role map (-ldap-role-map), ldap users would have no role!")
+	}
+	return nil
+}
+
+var newsLanguageRegexp = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z]{2,4})?$`)
+
+func checkPublicUrl(s string) error {
+	if s == "" {
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid public url %v, error: %v", s, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" {
+		return fmt.Errorf("invalid public url %v, it should be like https://news.example.com", s)
+	}
+	return nil
+}
+
 func ParseArgs(args []string) *AppConf {
 
 	// parse command line args
@@ -297,10 +868,59 @@ func ParseArgs(args []string) *AppConf {
 	var serverRoot = cli.String("server-root", "", "Path to the server root directory.")
 	var serverWriteTimeout = cli.Int("server-write-timeout", 15, "http server write timeout in seconds.")
 	var serverReadTimeout = cli.Int("server-read-timeout", 15, "http server read timeout in seconds.")
+	var storeStr = cli.String("store", string(StoreTypeElastic), `Where to store articles and users, "elasticsearch", "bolt" or "memory".`)
+	var boltPath = cli.String("bolt-path", "", `Path to the bolt db file for "-store bolt", default to article-api.db inside server root.`)
 	var esHostStr = cli.String("es-hosts", "127.0.0.1:9200", "Elasticsearch server hosts (comma separated).")
+	var lockStr = cli.String("lock", string(LockTypeLocal), `How to lock articles, "local" (single api server) or "elasticsearch" (multiple api servers, requires "-store elasticsearch").`)
+	var lockTTL = cli.Int("lock-ttl", 30, "Lease in seconds of an elasticsearch lock, it is renewed while held and can be taken over once expired.")
+	var lockTimeout = cli.Int("lock-timeout", 10, "How long in seconds to wait for an elasticsearch lock.")
 	var hashKey = cli.String("hash-key", "", "Secret hash key used to sign data")
 	var blockKey = cli.String("block-key", "", "Secret block key used to encrypt/decrypt data")
-	var authExp = cli.Int("auth-expiration", 3600, "auth token expiration in seconds, set to 0 to not expire.")
+	var authExp = cli.Int("auth-expiration", 900, "auth (access) token expiration in seconds, set to 0 to not expire.")
+	var refreshExp = cli.Int("refresh-expiration", 2592000, "refresh token expiration in seconds, set to 0 to not expire.")
+	var legacyGetLogin = cli.Bool("legacy-get-login", false, "Deprecated: also accept username/password as GET query args on /api/login and /api/manage/login.")
+	var tokenFormatStr = cli.String("token-format", string(TokenFormatSecureCookie), `Format of the issued access tokens, "securecookie" or "jwt" (sent as "Authorization: Bearer").`)
+	var jwtAlgStr = cli.String("jwt-alg", string(JWTAlgHS256), `JWT signing algorithm, "HS256", "RS256" or "EdDSA".`)
+	var jwtKeyDir = cli.String("jwt-key-dir", "", `Directory of the JWT signing keys (one per file, named after its kid), required for "-token-format jwt".`)
+	var jwtKeyReload = cli.Int("jwt-key-reload", 300, "How often (in seconds) to reload the JWT key directory to pick up rotated keys.")
+	var jwtIssuer = cli.String("jwt-issuer", "article-api", `"iss" claim of the issued JWTs.`)
+	var authBackendStr = cli.String("auth-backend", string(AuthBackendLocal), `How passwords are verified on login, "local" (user store) or "ldap" (ldap bind, falling back to local users).`)
+	var ldapUrl = cli.String("ldap-url", "", `ldap:// or ldaps:// url of the ldap server, required for "-auth-backend ldap".`)
+	var ldapStartTLS = cli.Bool("ldap-starttls", false, "StartTLS on a ldap:// connection.")
+	var ldapCAFile = cli.String("ldap-ca-file", "", "PEM file of the CA certificate(s) of the ldap server, default to the system ones.")
+	var ldapTimeout = cli.Int("ldap-timeout", 10, "Timeout (in seconds) of a ldap operation.")
+	var ldapBindDN = cli.String("ldap-bind-dn", "", "DN of the service account to search users with, default to search anonymously.")
+	var ldapBindPassword = cli.String("ldap-bind-password", "", "Password of the ldap service account.")
+	var ldapBaseDN = cli.String("ldap-base-dn", "", "Base DN to search users under.")
+	var ldapUserFilter = cli.String("ldap-user-filter", "(uid=%s)", "Filter to search the user entry with, %s is the (escaped) username.")
+	var ldapUsernameAttr = cli.String("ldap-username-attr", "uid", "Attribute of the user entry with the cms username, the directory may match the typed in one case-insensitively.")
+	var ldapGroupAttr = cli.String("ldap-group-attr", "memberOf", "Attribute of the user entry with the DNs of its groups.")
+	var ldapGroupBaseDN = cli.String("ldap-group-base-dn", "", "Base DN to search groups under instead of reading the group attribute.")
+	var ldapGroupFilter = cli.String("ldap-group-filter", "(member=%s)", "Filter to search the groups of a user with, %s is the (escaped) user DN.")
+	var ldapRoleMap = cli.String("ldap-role-map", "", `Cms roles of the ldap groups (cn), like "editors=article:create,article:edit_self;admins=login:manage".`)
+	var oidcIssuer = cli.String("oidc-issuer", "", "OpenID Connect provider (issuer url) for single sign-on, default to none.")
+	var oidcClientId = cli.String("oidc-client-id", "", "Client id registered with the OpenID Connect provider.")
+	var oidcClientSecret = cli.String("oidc-client-secret", "", "Client secret registered with the OpenID Connect provider.")
+	var oidcRedirectUrl = cli.String("oidc-redirect-url", "", "Redirect url registered with the OpenID Connect provider, default to /api/oidc/callback on the public url.")
+	var oidcScopes = cli.String("oidc-scopes", "openid,profile,email", "Scopes (comma separated) to request from the OpenID Connect provider.")
+	var oidcUsernameClaim = cli.String("oidc-username-claim", "preferred_username", "ID token claim of the cms username.")
+	var oidcRolesClaim = cli.String("oidc-roles-claim", "groups", "ID token claim of the groups/roles mapped to cms roles.")
+	var oidcRoleMap = cli.String("oidc-role-map", "", `Cms roles of the values of the roles claim, like "editors=article:create,article:edit_self;admins=login:manage".`)
+	var draftLockExpiry = cli.Int("draft-lock-expiry", 7200, "How long (in seconds) a draft stays locked without a save or heartbeat from the editor, set to 0 to never expire.")
+	var requireApproval = cli.Bool("require-approval", false, "Only allow publishing article versions approved by a reviewer.")
+	var strictTags = cli.Bool("strict-tags", false, "Reject article tags which are not in the taxonomy.")
+	var embedHosts = cli.String("embed-hosts", "www.youtube.com,www.youtube-nocookie.com,player.vimeo.com", "Hosts (comma separated) the embeds of article bodies are allowed from.")
+	var mediaDir = cli.String("media-dir", "", "Directory to store uploaded media files in, default to media inside server root.")
+	var mediaMaxSize = cli.Int("media-max-size", 10, "Max size (in MB) of an uploaded media file.")
+	var mediaCacheDir = cli.String("media-cache-dir", "", "Directory to cache resized media in, default to media-cache inside server root.")
+	var mediaCacheSize = cli.Int("media-cache-size", 256, "Max size (in MB) of the resized media cache, least recently used ones are removed beyond it.")
+	var mediaVariantSizes = cli.String("media-variant-sizes", "160,320,640,960,1280,1920", "Sizes (comma separated, in pixels) the width/height of resized media are snapped to, empty to serve only the originals.")
+	var mediaResizeConcurrency = cli.Int("media-resize-concurrency", 2, "Max number of media resized at the same time.")
+	var publicUrl = cli.String("public-url", "", "Base url (e.g. https://news.example.com) of the frontend pages for absolute links in feeds, default to the one of the request.")
+	var newsName = cli.String("news-name", "", "Publication name in the google news sitemap, default to the host of the public url.")
+	var newsLanguage = cli.String("news-language", "en", "Publication language (ISO 639, e.g. en or zh-cn) in the google news sitemap.")
+	var scheduleInterval = cli.Int("schedule-interval", 30, "How often (in seconds) to check for due scheduled publish/unpublish.")
+	var reconcileInterval = cli.Int("draft-reconcile-interval", 600, "How often (in seconds) to clean up drafts left behind by failed submits, set to 0 to do it only at startup.")
 	var loggingSpec = &LoggingSpec{Target: LoggingTargetStdout}
 	cli.Var(loggingSpec, "logging", `set logging to stdout ("stdout") or a file ("file:[path],[max-size],[max-backups],[max-age]")`)
 
@@ -330,11 +950,54 @@ func ParseArgs(args []string) *AppConf {
 	if *authExp < 0 || *authExp > 86400 {
 		panic(fmt.Sprintf("auth expiration %v (seconds) in not in allowed range [0, 86400]", *authExp))
 	}
-	scookie := securecookie.New(hashKeyBytes, blockKeyBytes)
-	scookie.SetSerializer(securecookie.JSONEncoder{})
-	scookie.MinAge(0)    // no restriction
-	scookie.MaxLength(0) // no restriction
-	scookie.MaxAge(*authExp)
+	if *refreshExp < 0 || *refreshExp > 31536000 {
+		panic(fmt.Sprintf("refresh expiration %v (seconds) in not in allowed range [0, 31536000]", *refreshExp))
+	}
+	authBackend, err := parseAuthBackend(*authBackendStr)
+	if err != nil {
+		panic(err.Error())
+	}
+	ldapRoles, err := parseRoleMap(strings.ToLower(*ldapRoleMap))
+	if err != nil {
+		panic(err.Error())
+	}
+	if authBackend == AuthBackendLDAP {
+		if err := checkLDAPConf(*ldapUrl, *ldapBaseDN, *ldapUserFilter, *ldapGroupFilter, ldapRoles); err != nil {
+			panic(err.Error())
+		}
+		if *ldapTimeout < 1 || *ldapTimeout > 300 {
+			panic(fmt.Sprintf("ldap timeout (%v seconds) is not in allowed range [1, 300].", *ldapTimeout))
+		}
+	}
+	oidcRoles, err := parseRoleMap(*oidcRoleMap)
+	if err != nil {
+		panic(err.Error())
+	}
+	if *oidcIssuer != "" {
+		if err := checkOIDCConf(*oidcIssuer, *oidcClientId, *oidcRedirectUrl, oidcRoles); err != nil {
+			panic(err.Error())
+		}
+	}
+	tokenFormat, err := parseTokenFormat(*tokenFormatStr)
+	if err != nil {
+		panic(err.Error())
+	}
+	jwtAlg, err := parseJWTAlg(*jwtAlgStr)
+	if err != nil {
+		panic(err.Error())
+	}
+	if tokenFormat == TokenFormatJWT {
+		if strings.TrimSpace(*jwtKeyDir) == "" {
+			panic(fmt.Sprintf("missing jwt key directory (-jwt-key-dir) for token format %v!", TokenFormatJWT))
+		}
+		if *jwtKeyReload < 0 || *jwtKeyReload > 86400 {
+			panic(fmt.Sprintf("jwt key reload interval (%v seconds) is not in allowed range [0, 86400].", *jwtKeyReload))
+		}
+	} else {
+		*jwtKeyDir = ""
+	}
+	scookie := newSecureCookie(hashKeyBytes, blockKeyBytes, *authExp)
+	scookieRefresh := newSecureCookie(hashKeyBytes, blockKeyBytes, *refreshExp)
 
 	// validate given args
 	if err := checkServerRoot(*serverRoot); err != nil {
@@ -343,6 +1006,28 @@ func ParseArgs(args []string) *AppConf {
 	if err := checkIPAndPort(*serverIP, *serverPort); err != nil {
 		panic(err.Error())
 	}
+	store, err := parseStoreType(*storeStr)
+	if err != nil {
+		panic(err.Error())
+	}
+	if store != StoreTypeBolt {
+		*boltPath = ""
+	} else if strings.TrimSpace(*boltPath) == "" {
+		*boltPath = filepath.Join(*serverRoot, "article-api.db")
+	}
+	lock, err := parseLockType(*lockStr)
+	if err != nil {
+		panic(err.Error())
+	}
+	if lock == LockTypeElastic && store != StoreTypeElastic {
+		panic(fmt.Sprintf(`lock %v requires store %v!`, LockTypeElastic, StoreTypeElastic))
+	}
+	if *lockTTL < 3 || *lockTTL > 3600 {
+		panic(fmt.Sprintf("lock ttl (%v seconds) is not in allowed range [3, 3600].", *lockTTL))
+	}
+	if *lockTimeout < 0 || *lockTimeout > 300 {
+		panic(fmt.Sprintf("lock timeout (%v seconds) is not in allowed range [0, 300].", *lockTimeout))
+	}
 	esHosts, err := parseESHosts(*esHostStr)
 	if err != nil {
 		panic(err.Error())
@@ -353,6 +1038,40 @@ func ParseArgs(args []string) *AppConf {
 	if *serverWriteTimeout < 5 || *serverWriteTimeout > 300 {
 		panic(fmt.Sprintf("server read timeout (%v seconds) is not in allowed range [5, 300].", *serverWriteTimeout))
 	}
+	if *draftLockExpiry < 0 || *draftLockExpiry > 2592000 {
+		panic(fmt.Sprintf("draft lock expiry (%v seconds) is not in allowed range [0, 2592000].", *draftLockExpiry))
+	}
+	if strings.TrimSpace(*mediaDir) == "" {
+		*mediaDir = filepath.Join(*serverRoot, "media")
+	}
+	if *mediaMaxSize < 1 || *mediaMaxSize > 1024 {
+		panic(fmt.Sprintf("media max size (%v MB) is not in allowed range [1, 1024].", *mediaMaxSize))
+	}
+	if strings.TrimSpace(*mediaCacheDir) == "" {
+		*mediaCacheDir = filepath.Join(*serverRoot, "media-cache")
+	}
+	if *mediaCacheSize < 0 || *mediaCacheSize > 1048576 {
+		panic(fmt.Sprintf("media cache size (%v MB) is not in allowed range [0, 1048576].", *mediaCacheSize))
+	}
+	variantSizes, err := parseMediaVariantSizes(*mediaVariantSizes)
+	if err != nil {
+		panic(err.Error())
+	}
+	if *mediaResizeConcurrency < 1 || *mediaResizeConcurrency > 64 {
+		panic(fmt.Sprintf("media resize concurrency (%v) is not in allowed range [1, 64].", *mediaResizeConcurrency))
+	}
+	if err := checkPublicUrl(*publicUrl); err != nil {
+		panic(err.Error())
+	}
+	if !newsLanguageRegexp.MatchString(*newsLanguage) {
+		panic(fmt.Sprintf("invalid news language %v, it should be an ISO 639 code like en or zh-cn.", *newsLanguage))
+	}
+	if *scheduleInterval < 1 || *scheduleInterval > 3600 {
+		panic(fmt.Sprintf("schedule interval (%v seconds) is not in allowed range [1, 3600].", *scheduleInterval))
+	}
+	if *reconcileInterval < 0 || *reconcileInterval > 86400 {
+		panic(fmt.Sprintf("draft reconcile interval (%v seconds) is not in allowed range [0, 86400].", *reconcileInterval))
+	}
 
 	articleIndexTypeMap := map[string]bool{
 		articleIndexTypes.Draft:   true,
@@ -366,11 +1085,65 @@ func ParseArgs(args []string) *AppConf {
 		ServerRoot:         *serverRoot,
 		ServerReadTimeout:  time.Duration(*serverReadTimeout) * time.Second,
 		ServerWriteTimeout: time.Duration(*serverWriteTimeout) * time.Second,
+		Store:              store,
+		BoltPath:           *boltPath,
 		ESHosts:            esHosts,
 		LoggingSpec:        loggingSpec,
 
-		SCookie:       scookie,
-		SCookieMaxAge: time.Duration(*authExp) * time.Second,
+		Lock:        lock,
+		LockTTL:     time.Duration(*lockTTL) * time.Second,
+		LockTimeout: time.Duration(*lockTimeout) * time.Second,
+
+		RequireApproval:        *requireApproval,
+		StrictTags:             *strictTags,
+		EmbedHosts:             parseEmbedHosts(*embedHosts),
+		MediaDir:               *mediaDir,
+		MediaMaxSize:           int64(*mediaMaxSize) << 20,
+		MediaCacheDir:          *mediaCacheDir,
+		MediaCacheSize:         int64(*mediaCacheSize) << 20,
+		MediaVariantSizes:      variantSizes,
+		MediaResizeConcurrency: *mediaResizeConcurrency,
+		PublicUrl:              strings.TrimRight(*publicUrl, "/"),
+		NewsName:               strings.TrimSpace(*newsName),
+		NewsLanguage:           strings.ToLower(*newsLanguage),
+		DraftLockExpiry:        time.Duration(*draftLockExpiry) * time.Second,
+		ScheduleInterval:       time.Duration(*scheduleInterval) * time.Second,
+		DraftReconcileInterval: time.Duration(*reconcileInterval) * time.Second,
+
+		SCookie:              scookie,
+		SCookieMaxAge:        time.Duration(*authExp) * time.Second,
+		SCookieRefresh:       scookieRefresh,
+		SCookieRefreshMaxAge: time.Duration(*refreshExp) * time.Second,
+		LegacyGetLogin:       *legacyGetLogin,
+		TokenFormat:          tokenFormat,
+		JWTAlg:               jwtAlg,
+		JWTKeyDir:            *jwtKeyDir,
+		JWTKeyReload:         time.Duration(*jwtKeyReload) * time.Second,
+		JWTIssuer:            strings.TrimSpace(*jwtIssuer),
+
+		AuthBackend:      authBackend,
+		LDAPUrl:          *ldapUrl,
+		LDAPStartTLS:     *ldapStartTLS,
+		LDAPCAFile:       *ldapCAFile,
+		LDAPTimeout:      time.Duration(*ldapTimeout) * time.Second,
+		LDAPBindDN:       *ldapBindDN,
+		LDAPBindPassword: *ldapBindPassword,
+		LDAPBaseDN:       *ldapBaseDN,
+		LDAPUserFilter:   *ldapUserFilter,
+		LDAPUsernameAttr: *ldapUsernameAttr,
+		LDAPGroupAttr:    *ldapGroupAttr,
+		LDAPGroupBaseDN:  *ldapGroupBaseDN,
+		LDAPGroupFilter:  *ldapGroupFilter,
+		LDAPRoleMap:      ldapRoles,
+
+		OIDCIssuer:        strings.TrimRight(*oidcIssuer, "/"),
+		OIDCClientId:      *oidcClientId,
+		OIDCClientSecret:  *oidcClientSecret,
+		OIDCRedirectUrl:   *oidcRedirectUrl,
+		OIDCScopes:        parseOIDCScopes(*oidcScopes),
+		OIDCUsernameClaim: *oidcUsernameClaim,
+		OIDCRolesClaim:    *oidcRolesClaim,
+		OIDCRoleMap:       oidcRoles,
 
 		ArticleIndex:        &ESIndex{"article", articleIndexDef},
 		ArticleIndexTypes:   articleIndexTypes,
@@ -378,5 +1151,20 @@ func ParseArgs(args []string) *AppConf {
 
 		UserIndex:      &ESIndex{"user", userIndexDef},
 		UserIndexTypes: userIndexTypes,
+
+		LockIndex:      &ESIndex{"lock", lockIndexDef},
+		LockIndexTypes: lockIndexTypes,
+
+		ScheduleIndex:      &ESIndex{"schedule", scheduleIndexDef},
+		ScheduleIndexTypes: scheduleIndexTypes,
+
+		TaxonomyIndex:      &ESIndex{"taxonomy", taxonomyIndexDef},
+		TaxonomyIndexTypes: taxonomyIndexTypes,
+
+		MediaIndex:      &ESIndex{"media", mediaIndexDef},
+		MediaIndexTypes: mediaIndexTypes,
+
+		SessionIndex:      &ESIndex{"session", sessionIndexDef},
+		SessionIndexTypes: sessionIndexTypes,
 	}
 }
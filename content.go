@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/russross/blackfriday"
+)
+
+type ContentFormat string
+
+const (
+	ContentFormatPlain    ContentFormat = "plain"
+	ContentFormatMarkdown ContentFormat = "markdown"
+	ContentFormatHTML     ContentFormat = "html"
+)
+
+var (
+	// elements/attributes allowed in the rendered content, everything
+	// else (scripts, styles, event handlers, iframes ...) is dropped
+	contentPolicy = newContentPolicy()
+
+	// drops all markup, for the plain text of the content
+	contentStripPolicy = bluemonday.StrictPolicy().AddSpaceWhenStrippingTag(true)
+
+	blankLinesRegexp = regexp.MustCompile(`\n\s*\n\s*`)
+)
+
+func newContentPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements(
+		"p", "br", "hr", "h1", "h2", "h3", "h4", "h5", "h6",
+		"b", "strong", "i", "em", "u", "s", "del", "sub", "sup", "small",
+		"blockquote", "pre", "code", "ul", "ol", "li", "dl", "dt", "dd",
+		"table", "thead", "tbody", "tfoot", "tr", "th", "td",
+		"figure", "figcaption", "a", "img",
+	)
+	p.AllowAttrs("href", "title").OnElements("a")
+	p.AllowAttrs("src", "alt", "title", "width", "height").OnElements("img")
+	p.AllowAttrs("cite").OnElements("blockquote")
+	p.AllowStandardURLs()
+	p.RequireNoFollowOnLinks(true)
+	return p
+}
+
+// Checks the content format, blank means plain
+func ParseContentFormat(s string) (ContentFormat, error) {
+	switch f := ContentFormat(strings.ToLower(strings.TrimSpace(s))); f {
+	case "":
+		return ContentFormatPlain, nil
+	case ContentFormatPlain, ContentFormatMarkdown, ContentFormatHTML:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown content format %q, must be %v, %v or %v", s, ContentFormatPlain, ContentFormatMarkdown, ContentFormatHTML)
+	}
+}
+
+// Renders markdown content to html and sanitizes html content against
+// the allow-list, plain content is just escaped
+func RenderContentHTML(format ContentFormat, content string) template.HTML {
+	switch format {
+	case ContentFormatMarkdown:
+		return template.HTML(contentPolicy.SanitizeBytes(blackfriday.MarkdownCommon([]byte(content))))
+	case ContentFormatHTML:
+		return template.HTML(contentPolicy.Sanitize(content))
+	default:
+		return template.HTML(template.HTMLEscapeString(content))
+	}
+}
+
+// Plain text of the content with the markup stripped, it is what gets
+// indexed so that searching doesn't match on markup
+func ContentPlainText(format ContentFormat, content string) string {
+	switch format {
+	case ContentFormatMarkdown:
+		content = string(blackfriday.MarkdownCommon([]byte(content)))
+	case ContentFormatHTML:
+	default:
+		return content
+	}
+	text := html.UnescapeString(contentStripPolicy.Sanitize(content))
+	lines := strings.Split(blankLinesRegexp.ReplaceAllString(text, "\n\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// text of the article to search in, the stripped content if there is
+// one (articles saved before content_format existed don't have it)
+func articleContentText(a *Article) string {
+	if a.ContentText != "" {
+		return a.ContentText
+	}
+	return a.Content
+}
+
+// funcs of the article page template, {{ contentHTML . }} renders the
+// article content according to its format
+var articleTplFuncs = template.FuncMap{
+	"contentHTML": func(a *Article) template.HTML {
+		return RenderContentHTML(a.ContentFormat, a.Content)
+	},
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseContentFormat(t *testing.T) {
+	for s, expected := range map[string]ContentFormat{
+		"":          ContentFormatPlain,
+		"plain":     ContentFormatPlain,
+		" Markdown": ContentFormatMarkdown,
+		"HTML":      ContentFormatHTML,
+	} {
+		if f, err := ParseContentFormat(s); err != nil || f != expected {
+			t.Errorf("expecting %q parsed to %v, but got %v (error: %v)", s, expected, f, err)
+		}
+	}
+	if _, err := ParseContentFormat("rtf"); err == nil {
+		t.Errorf("expecting unknown format rejected, but it isn't")
+	}
+}
+
+func TestRenderContentHTML(t *testing.T) {
+	page := string(RenderContentHTML(ContentFormatHTML, `<p>hello<script>alert(1)</script></p><iframe src="x"></iframe>`))
+	if !strings.Contains(page, "<p>hello") || strings.Contains(page, "<script") || strings.Contains(page, "<iframe") {
+		t.Errorf("expecting html sanitized, but got %v", page)
+	}
+	page = string(RenderContentHTML(ContentFormatMarkdown, "# Title\n\nsome **bold** text\n\n<script>alert(1)</script>"))
+	for _, s := range []string{"<h1>Title</h1>", "<strong>bold</strong>"} {
+		if !strings.Contains(page, s) {
+			t.Errorf("expecting %q in the rendered markdown, but got %v", s, page)
+		}
+	}
+	if strings.Contains(page, "<script") {
+		t.Errorf("expecting rendered markdown sanitized, but got %v", page)
+	}
+	if page = string(RenderContentHTML(ContentFormatPlain, "a <b> c")); page != "a &lt;b&gt; c" {
+		t.Errorf("expecting plain content escaped, but got %v", page)
+	}
+}
+
+func TestContentPlainText(t *testing.T) {
+	if text := ContentPlainText(ContentFormatHTML, "<p>fish &amp; <em>chips</em></p>"); text != "fish & chips" {
+		t.Errorf("unexpected plain text %q", text)
+	}
+	text := ContentPlainText(ContentFormatMarkdown, "# Title\n\nsome **bold** text")
+	if !strings.Contains(text, "Title") || !strings.Contains(text, "some bold text") || strings.ContainsAny(text, "#*<>") {
+		t.Errorf("unexpected plain text %q", text)
+	}
+	if text := ContentPlainText(ContentFormatPlain, "a <b> c"); text != "a <b> c" {
+		t.Errorf("expecting plain content kept, but got %q", text)
+	}
+}
+
+func TestNormalizeArticleContent(t *testing.T) {
+	logger := NewJsonLogger(ioutil.Discard)
+	article := &Article{Content: "<p>hello <strong>world</strong></p>", ContentFormat: "HTML"}
+	if d := normalizeArticleContent(article, logger); d != nil {
+		t.Errorf("expecting content accepted, but got %v", d.Status)
+		return
+	}
+	if article.ContentFormat != ContentFormatHTML || article.ContentText != "hello world" {
+		t.Errorf("unexpected format %v and text %q", article.ContentFormat, article.ContentText)
+	}
+	for _, a := range []*Article{
+		&Article{ContentFormat: "rtf"},
+		&Article{ContentFormat: ContentFormatMarkdown, Body: testArticleBody()},
+	} {
+		if d := normalizeArticleContent(a, logger); d == nil {
+			t.Errorf("expecting article %+v rejected, but it isn't", a)
+		}
+	}
+
+	// markup isn't searched
+	q := &ArticleTextQuery{}
+	now := &JSONTime{time.Now().UTC()}
+	article = &Article{Guid: "g", CreatedAt: now, RevisedAt: now, Content: `<p class="strong">hello</p>`, ContentFormat: ContentFormatHTML}
+	normalizeArticleContent(article, logger)
+	if doc := matchArticleTextQuery(q, searchTerms("strong"), "publish", article); doc != nil {
+		t.Errorf("expecting markup not matched, but got %+v", doc)
+	}
+	if doc := matchArticleTextQuery(q, searchTerms("hello"), "publish", article); doc == nil {
+		t.Errorf("expecting content text matched, but it isn't")
+	}
+}
+
+func TestArticleContentPage(t *testing.T) {
+	buf := &bytes.Buffer{}
+	article := &Article{Headline: "h", Content: "some **bold** text", ContentFormat: ContentFormatMarkdown}
+	if err := articleTpl.Execute(buf, article); err != nil {
+		t.Errorf("failed to render article, error: %v", err)
+		return
+	}
+	if page := buf.String(); !strings.Contains(page, "<strong>bold</strong>") {
+		t.Errorf("expecting markdown rendered, but got %v", page)
+	}
+}
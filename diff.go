@@ -0,0 +1,246 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+type DiffOpType string
+
+const (
+	DiffOpEqual  DiffOpType = "equal"
+	DiffOpInsert DiffOpType = "insert"
+	DiffOpDelete DiffOpType = "delete"
+)
+
+// One chunk of a diff, concatenating Text of all equal/delete ops gives
+// the old text while equal/insert ops give the new one.
+type DiffOp struct {
+	Op   DiffOpType `json:"op"`
+	Text string     `json:"text"`
+}
+
+// max edit distance (of half the edit script) searched for a middle
+// snake, texts which differ more are diff'ed as a delete plus an insert
+// which keeps the diff of long rewritten texts cheap
+const diffMaxHalfEdits = 1000
+
+var wordTokenRegexp = regexp.MustCompile(`\s+|[^\s]+`)
+
+// splits text into lines, each keeping its trailing "\n"
+func splitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// splits text into words and the whitespaces between them
+func splitWords(s string) []string {
+	return wordTokenRegexp.FindAllString(s, -1)
+}
+
+func DiffLines(a, b string) []*DiffOp {
+	return diffTokens(splitLines(a), splitLines(b))
+}
+
+func DiffWords(a, b string) []*DiffOp {
+	return diffTokens(splitWords(a), splitWords(b))
+}
+
+// Word diff of texts which are already line diff'ed, only the changed
+// lines are word diff'ed which keeps it cheap for long texts.
+func DiffWordsInLines(lineOps []*DiffOp) []*DiffOp {
+	ops := make([]*DiffOp, 0)
+	add := func(op *DiffOp) {
+		if last := len(ops) - 1; last >= 0 && ops[last].Op == op.Op {
+			ops[last].Text += op.Text
+		} else if op.Text != "" {
+			ops = append(ops, &DiffOp{op.Op, op.Text})
+		}
+	}
+	deleted, inserted := "", ""
+	flush := func() {
+		for _, op := range DiffWords(deleted, inserted) {
+			add(op)
+		}
+		deleted, inserted = "", ""
+	}
+	for _, op := range lineOps {
+		switch op.Op {
+		case DiffOpDelete:
+			deleted += op.Text
+		case DiffOpInsert:
+			inserted += op.Text
+		default:
+			flush()
+			add(op)
+		}
+	}
+	flush()
+	return ops
+}
+
+// Myers' O((N+M)D) diff in linear space, see "An O(ND) Difference
+// Algorithm and Its Variations" (4b). The middle snake of the shortest
+// edit script splits the texts into two halves which are diff'ed
+// recursively, so only two vectors of N+M+2 ints are kept.
+// Consecutive tokens of the same op are merged.
+func diffTokens(a, b []string) []*DiffOp {
+	size := len(a) + len(b) + 2
+	d := &tokenDiff{a: a, b: b, vf: make([]int, 2*size+1), vb: make([]int, 2*size+1)}
+	d.diff(0, len(a), 0, len(b))
+	d.shiftEdits()
+	ops := make([]*DiffOp, len(d.ops))
+	for i, op := range d.ops {
+		ops[i] = &DiffOp{op, strings.Join(d.texts[i], "")}
+	}
+	return ops
+}
+
+type tokenDiff struct {
+	a, b   []string
+	vf, vb []int // furthest x of the forward/backward paths by diagonal
+
+	ops   []DiffOpType
+	texts [][]string
+}
+
+func (d *tokenDiff) add(op DiffOpType, tokens []string) {
+	if len(tokens) == 0 {
+		return
+	}
+	if last := len(d.ops) - 1; last >= 0 && d.ops[last] == op {
+		d.texts[last] = append(d.texts[last], tokens...)
+	} else {
+		d.ops = append(d.ops, op)
+		d.texts = append(d.texts, append([]string{}, tokens...))
+	}
+}
+
+// Slides each insert/delete between two equal ops to the right as far as
+// it goes, like the greedy forward search does: "the lazy dog" -> "the
+// dog" deletes "lazy " rather than " lazy".
+func (d *tokenDiff) shiftEdits() {
+	for i := 0; i < len(d.ops); i++ {
+		if d.ops[i] == DiffOpEqual || i+1 >= len(d.ops) || d.ops[i+1] != DiffOpEqual || (i > 0 && d.ops[i-1] != DiffOpEqual) {
+			continue
+		}
+		run, next := d.texts[i], d.texts[i+1]
+		moved := make([]string, 0)
+		for len(next) > 0 && run[0] == next[0] {
+			moved = append(moved, run[0])
+			run = append(run[1:], next[0])
+			next = next[1:]
+		}
+		if len(moved) == 0 {
+			continue
+		}
+		if i == 0 {
+			d.ops = append([]DiffOpType{DiffOpEqual}, d.ops...)
+			d.texts = append([][]string{moved}, d.texts...)
+			i++
+		} else {
+			d.texts[i-1] = append(d.texts[i-1], moved...)
+		}
+		d.texts[i] = run
+		if len(next) > 0 {
+			d.texts[i+1] = next
+			continue
+		}
+		// the equal op is used up, the run may merge with the next one
+		// and slide further
+		d.ops = append(d.ops[:i+1], d.ops[i+2:]...)
+		d.texts = append(d.texts[:i+1], d.texts[i+2:]...)
+		if i+1 < len(d.ops) && d.ops[i+1] == d.ops[i] {
+			d.texts[i] = append(d.texts[i], d.texts[i+1]...)
+			d.ops = append(d.ops[:i+1], d.ops[i+2:]...)
+			d.texts = append(d.texts[:i+1], d.texts[i+2:]...)
+		}
+		i--
+	}
+}
+
+// diffs a[x0:x1] against b[y0:y1]
+func (d *tokenDiff) diff(x0, x1, y0, y1 int) {
+	prefix := x0
+	for prefix < x1 && prefix-x0 < y1-y0 && d.a[prefix] == d.b[y0+prefix-x0] {
+		prefix++
+	}
+	d.add(DiffOpEqual, d.a[x0:prefix])
+	y0, x0 = y0+prefix-x0, prefix
+	suffix := 0
+	for x1-suffix > x0 && y1-suffix > y0 && d.a[x1-suffix-1] == d.b[y1-suffix-1] {
+		suffix++
+	}
+	x1, y1 = x1-suffix, y1-suffix
+	if x0 == x1 {
+		d.add(DiffOpInsert, d.b[y0:y1])
+	} else if y0 == y1 {
+		d.add(DiffOpDelete, d.a[x0:x1])
+	} else if x, y, u, v, ok := d.middleSnake(x0, x1, y0, y1); !ok {
+		d.add(DiffOpDelete, d.a[x0:x1])
+		d.add(DiffOpInsert, d.b[y0:y1])
+	} else {
+		d.diff(x0, x, y0, y)
+		d.add(DiffOpEqual, d.a[x:u])
+		d.diff(u, x1, v, y1)
+	}
+	d.add(DiffOpEqual, d.a[x1:x1+suffix])
+}
+
+// Finds the middle snake (x, y) -> (u, v) of a[x0:x1] and b[y0:y1], which
+// have neither a common prefix nor a common suffix. Returns false if it
+// is beyond diffMaxHalfEdits.
+func (d *tokenDiff) middleSnake(x0, x1, y0, y1 int) (int, int, int, int, bool) {
+	n, m := x1-x0, y1-y0
+	delta := n - m
+	odd := delta&1 != 0
+	offset := len(d.vf) / 2
+	vf, vb := d.vf, d.vb
+	vf[offset+1], vb[offset+1] = 0, 0
+	for D := 0; D <= (n+m+1)/2 && D <= diffMaxHalfEdits; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1] // down, insert
+			} else {
+				x = vf[offset+k-1] + 1 // right, delete
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[x0+x] == d.b[y0+y] {
+				x, y = x+1, y+1
+			}
+			vf[offset+k] = x
+			// overlaps the backward path of D-1 on the same diagonal
+			if kb := delta - k; odd && kb >= -(D-1) && kb <= D-1 && x+vb[offset+kb] >= n {
+				return x0 + sx, y0 + sy, x0 + x, y0 + y, true
+			}
+		}
+		// backward paths are from the end of both, on the diagonals
+		// of the reversed texts
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && d.a[x1-x-1] == d.b[y1-y-1] {
+				x, y = x+1, y+1
+			}
+			vb[offset+k] = x
+			if kf := delta - k; !odd && kf >= -D && kf <= D && x+vf[offset+kf] >= n {
+				return x1 - x, y1 - y, x1 - sx, y1 - sy, true
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func joinDiffOps(ops []*DiffOp, skip DiffOpType) string {
+	s := ""
+	for _, op := range ops {
+		if op.Op != skip {
+			s += op.Text
+		}
+	}
+	return s
+}
+
+func TestDiffWords(t *testing.T) {
+	a := "the quick brown fox jumps over the lazy dog"
+	b := "the quick red fox jumps over the dog today"
+	ops := DiffWords(a, b)
+	if s := joinDiffOps(ops, DiffOpInsert); s != a {
+		t.Errorf("expecting old text %q from diff, but got %q", a, s)
+		return
+	}
+	if s := joinDiffOps(ops, DiffOpDelete); s != b {
+		t.Errorf("expecting new text %q from diff, but got %q", b, s)
+		return
+	}
+	deleted, inserted := "", ""
+	for _, op := range ops {
+		switch op.Op {
+		case DiffOpDelete:
+			deleted += op.Text + "|"
+		case DiffOpInsert:
+			inserted += op.Text + "|"
+		}
+	}
+	if deleted != "brown|lazy |" || inserted != "red| today|" {
+		t.Errorf("unexpected diff, deleted: %q, inserted: %q", deleted, inserted)
+		return
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	cases := [][2]string{
+		{"", ""},
+		{"", "a\nb"},
+		{"a\nb\n", ""},
+		{"a\nb\nc\n", "a\nc\nd\n"},
+		{"same\n", "same\n"},
+	}
+	for _, c := range cases {
+		ops := DiffLines(c[0], c[1])
+		if s := joinDiffOps(ops, DiffOpInsert); s != c[0] {
+			t.Errorf("expecting old text %q from diff, but got %q", c[0], s)
+		}
+		if s := joinDiffOps(ops, DiffOpDelete); s != c[1] {
+			t.Errorf("expecting new text %q from diff, but got %q", c[1], s)
+		}
+	}
+	if ops := DiffLines("same\n", "same\n"); len(ops) != 1 || ops[0].Op != DiffOpEqual {
+		t.Errorf("expecting a single equal op, but got %v op(s)", len(ops))
+	}
+}
+
+func TestDiffWordsInLines(t *testing.T) {
+	a := "first line\nsecond line here\nthird\n"
+	b := "first line\nsecond row here\nthird\nfourth\n"
+	ops := DiffWordsInLines(DiffLines(a, b))
+	if s := joinDiffOps(ops, DiffOpInsert); s != a {
+		t.Errorf("expecting old text %q from diff, but got %q", a, s)
+		return
+	}
+	if s := joinDiffOps(ops, DiffOpDelete); s != b {
+		t.Errorf("expecting new text %q from diff, but got %q", b, s)
+		return
+	}
+	changed := make([]string, 0)
+	for _, op := range ops {
+		if op.Op != DiffOpEqual {
+			changed = append(changed, string(op.Op)+":"+op.Text)
+		}
+	}
+	if len(changed) != 3 || changed[0] != "delete:line" || changed[1] != "insert:row" || changed[2] != "insert:fourth\n" {
+		t.Errorf("unexpected changes %q", changed)
+		return
+	}
+}
+
+func TestDiffWordsLongRewrite(t *testing.T) {
+	oldWords, newWords := make([]string, 20000), make([]string, 20000)
+	for i := range oldWords {
+		oldWords[i] = fmt.Sprintf("old%d", i)
+		newWords[i] = fmt.Sprintf("new%d", i)
+	}
+	// a few edits in a long text are still diff'ed word by word
+	a := strings.Join(oldWords, " ")
+	b := strings.Replace(strings.Replace(a, "old7 ", "", 1), "old19000", "new19000", 1)
+	ops := DiffWords(a, b)
+	if s := joinDiffOps(ops, DiffOpInsert); s != a {
+		t.Errorf("unexpected old text from diff")
+		return
+	}
+	if s := joinDiffOps(ops, DiffOpDelete); s != b {
+		t.Errorf("unexpected new text from diff")
+		return
+	}
+	if len(ops) != 6 || ops[1].Text != "old7 " || ops[3].Text != "old19000" || ops[4].Text != "new19000" {
+		t.Errorf("expecting 6 ops, but got %v", len(ops))
+		return
+	}
+	// a full rewrite is a delete plus an insert
+	b = strings.Join(newWords, " ")
+	ops = DiffWords(a, b)
+	if len(ops) != 2 || ops[0].Op != DiffOpDelete || ops[0].Text != a || ops[1].Op != DiffOpInsert || ops[1].Text != b {
+		t.Errorf("expecting a delete and an insert, but got %v op(s)", len(ops))
+		return
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	FeedTypeRSS  = "rss"
+	FeedTypeAtom = "atom"
+
+	ContentTypeValueRSS  = "application/rss+xml; charset=utf-8"
+	ContentTypeValueAtom = "application/atom+xml; charset=utf-8"
+)
+
+// html of an article in a feed entry, the body blocks if there are,
+// otherwise the content according to its format
+const FEED_CONTENT_TPL = `{{ with .LeadImage }}<figure><img src="/media/{{.Id}}" alt="{{.Alt}}"/>{{ if or .Caption .Credit }}<figcaption>{{.Caption}}{{ if .Credit }} ({{.Credit}}){{ end }}</figcaption>{{ end }}</figure>
+{{ end }}{{ if .Body }}{{ template "body" .Body }}{{ else if or (eq .ContentFormat "markdown") (eq .ContentFormat "html") }}{{ contentHTML . }}{{ else }}<p>{{.Content}}</p>{{ end }}`
+
+var feedContentTpl = template.Must(template.New("feed").Funcs(articleTplFuncs).Parse(FEED_CONTENT_TPL + ARTICLE_BODY_TPL))
+
+// A feed of the latest published articles, either of all articles or
+// only those with the tag
+type ArticleFeed struct {
+	// base url of the frontend pages, links are made absolute with it
+	BaseUrl  string
+	SelfPath string
+	Tag      string
+	Articles []*Article
+}
+
+// public base url (scheme and host) of the frontend pages, -public-url
+// if set otherwise the one the request is sent to
+func publicBaseUrl(app *AppRuntime, r *http.Request) string {
+	if app.Conf.PublicUrl != "" {
+		return app.Conf.PublicUrl
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%v://%v", scheme, r.Host)
+}
+
+func (f *ArticleFeed) title() string {
+	host := f.BaseUrl
+	if u, err := url.Parse(f.BaseUrl); err == nil {
+		host = u.Host
+	}
+	if f.Tag != "" {
+		return fmt.Sprintf("%v - %v", host, f.Tag)
+	}
+	return host
+}
+
+func (f *ArticleFeed) pageUrl() string {
+	if f.Tag != "" {
+		return fmt.Sprintf("%v/tag?name=%v", f.BaseUrl, url.QueryEscape(f.Tag))
+	}
+	return f.BaseUrl + "/articles"
+}
+
+// the same for all pages/sizes of the feed
+func (f *ArticleFeed) atomId() string {
+	if f.Tag != "" {
+		return fmt.Sprintf("%v/feed.atom?tag=%v", f.BaseUrl, url.QueryEscape(f.Tag))
+	}
+	return f.BaseUrl + "/feed.atom"
+}
+
+func (f *ArticleFeed) articleUrl(a *Article) string {
+	return fmt.Sprintf("%v/article?id=%v:%v", f.BaseUrl, a.Guid, a.Version)
+}
+
+// Globally unique and permanent id of an article (across its versions) as
+// a tag uri (RFC 4151), e.g. tag:news.example.com,2017-10-27:b5og7p3ljrlb0gbdkm0g
+func (f *ArticleFeed) articleId(a *Article) string {
+	host := f.BaseUrl
+	if u, err := url.Parse(f.BaseUrl); err == nil {
+		host = u.Hostname()
+	}
+	date := articleCreatedTime(a).UTC().Format("2006-01-02")
+	return fmt.Sprintf("tag:%v,%v:%v", host, date, a.Guid)
+}
+
+// When the feed last changed, i.e. the latest time one of its articles
+// was published. Articles unpublished since don't change it but they are
+// gone from the feed anyway (the ETag changes though).
+func (f *ArticleFeed) Updated() time.Time {
+	var updated time.Time
+	for _, a := range f.Articles {
+		if t := articlePublishedTime(a); t.After(updated) {
+			updated = t
+		}
+	}
+	return updated
+}
+
+func articleFeedHTML(a *Article) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := feedContentTpl.Execute(buf, a); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func articleRevisedTime(a *Article) time.Time {
+	if a.RevisedAt != nil {
+		return a.RevisedAt.T
+	}
+	return articlePublishedTime(a)
+}
+
+func articleCreatedTime(a *Article) time.Time {
+	if a.CreatedAt != nil {
+		return a.CreatedAt.T
+	}
+	return articleRevisedTime(a)
+}
+
+/* rss 2.0 */
+
+type rssFeed struct {
+	XMLName   xml.Name    `xml:"rss"`
+	Version   string      `xml:"version,attr"`
+	ContentNS string      `xml:"xmlns:content,attr"`
+	AtomNS    string      `xml:"xmlns:atom,attr"`
+	Channel   *rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	Self          *atomLink  `xml:"atom:link"`
+	LastBuildDate string     `xml:"lastBuildDate,omitempty"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        *rssGuid `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Category    []string `xml:"category"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"content:encoded"`
+}
+
+func (f *ArticleFeed) RSS() ([]byte, error) {
+	channel := &rssChannel{
+		Title:       f.title(),
+		Link:        f.pageUrl(),
+		Description: fmt.Sprintf("Latest articles of %v", f.title()),
+		Self:        &atomLink{Href: f.BaseUrl + f.SelfPath, Rel: "self", Type: "application/rss+xml"},
+		Items:       make([]*rssItem, 0, len(f.Articles)),
+	}
+	if updated := f.Updated(); !updated.IsZero() {
+		channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, a := range f.Articles {
+		content, err := articleFeedHTML(a)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render article %v, error: %v", a.Id, err)
+		}
+		channel.Items = append(channel.Items, &rssItem{
+			Title:       a.Headline,
+			Link:        f.articleUrl(a),
+			Guid:        &rssGuid{Value: f.articleId(a)},
+			PubDate:     articleRevisedTime(a).UTC().Format(time.RFC1123Z),
+			Category:    a.Tag,
+			Description: a.Summary,
+			Content:     content,
+		})
+	}
+	return marshalXMLDoc(&rssFeed{
+		Version:   "2.0",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		Channel:   channel,
+	})
+}
+
+/* atom (RFC 4287) */
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"http://www.w3.org/2005/Atom feed"`
+	Base    string       `xml:"xml:base,attr"`
+	Id      string       `xml:"id"`
+	Title   string       `xml:"title"`
+	Updated string       `xml:"updated"`
+	Author  *atomAuthor  `xml:"author"`
+	Link    []*atomLink  `xml:"link"`
+	Entry   []*atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	Id        string          `xml:"id"`
+	Title     string          `xml:"title"`
+	Link      *atomLink       `xml:"link"`
+	Published string          `xml:"published"`
+	Updated   string          `xml:"updated"`
+	Category  []*atomCategory `xml:"category"`
+	Summary   *atomText       `xml:"summary,omitempty"`
+	Content   *atomText       `xml:"content"`
+}
+
+func (f *ArticleFeed) Atom() ([]byte, error) {
+	feed := &atomFeed{
+		Base:    f.BaseUrl + "/",
+		Id:      f.atomId(),
+		Title:   f.title(),
+		Updated: f.Updated().UTC().Format(time.RFC3339),
+		Author:  &atomAuthor{Name: f.title()},
+		Link: []*atomLink{
+			&atomLink{Href: f.BaseUrl + f.SelfPath, Rel: "self", Type: "application/atom+xml"},
+			&atomLink{Href: f.pageUrl(), Rel: "alternate", Type: "text/html"},
+		},
+		Entry: make([]*atomEntry, 0, len(f.Articles)),
+	}
+	for _, a := range f.Articles {
+		content, err := articleFeedHTML(a)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render article %v, error: %v", a.Id, err)
+		}
+		entry := &atomEntry{
+			Id:        f.articleId(a),
+			Title:     a.Headline,
+			Link:      &atomLink{Href: f.articleUrl(a), Rel: "alternate", Type: "text/html"},
+			Published: articleCreatedTime(a).UTC().Format(time.RFC3339),
+			Updated:   articleRevisedTime(a).UTC().Format(time.RFC3339),
+			Content:   &atomText{Type: "html", Value: content},
+		}
+		if a.Summary != "" {
+			entry.Summary = &atomText{Type: "text", Value: a.Summary}
+		}
+		for _, tag := range a.Tag {
+			entry.Category = append(entry.Category, &atomCategory{Term: tag})
+		}
+		feed.Entry = append(feed.Entry, entry)
+	}
+	return marshalXMLDoc(feed)
+}
+
+func marshalXMLDoc(feed interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArticleFeeds(t *testing.T) {
+	app := &AppRuntime{
+		Conf:     &AppConf{ArticleIndexTypes: articleIndexTypes, PublicUrl: "https://news.example.com"},
+		Articles: NewMemoryArticleStore(articleIndexTypes),
+		Taxonomy: NewMemoryTaxonomyStore(),
+	}
+	app.Taxonomy.CreateTerm(context.Background(), &TaxonomyTerm{Id: "a", Synonyms: []string{"alpha"}})
+	now := time.Date(2017, 10, 27, 8, 0, 0, 0, time.UTC)
+	for i, tag := range []string{"a", "b"} {
+		guid := string('x' + rune(i))
+		app.Articles.UpsertPublish(context.Background(), &Article{
+			Id:            guid,
+			Guid:          guid,
+			Version:       "1",
+			Headline:      "headline <" + guid + ">",
+			Summary:       "summary " + guid,
+			Content:       "*content*",
+			ContentFormat: ContentFormatMarkdown,
+			Tag:           []string{tag},
+			CreatedAt:     &JSONTime{now},
+			RevisedAt:     &JSONTime{now.Add(time.Duration(i) * time.Hour)},
+			PublishedAt:   &JSONTime{now.Add(time.Duration(i) * time.Hour)},
+		})
+	}
+
+	d := getFEFeed(app, testContentRequest("/feed.rss"), FeedTypeRSS, "")
+	body, _ := ioutil.ReadAll(d.Body)
+	rss := &rssFeed{}
+	if err := xml.Unmarshal(body, rss); err != nil || d.Status != http.StatusOK || d.Header.Get(HeaderContentType) != ContentTypeValueRSS {
+		t.Errorf("unexpected rss feed %v (error: %v): %s", d.Status, err, body)
+		return
+	}
+	items := rss.Channel.Items
+	if len(items) != 2 || items[0].Title != "headline <y>" || items[1].Link != "https://news.example.com/article?id=x:1" {
+		t.Errorf("unexpected rss items %s", body)
+	}
+	if g := items[0].Guid; g.IsPermaLink || g.Value != "tag:news.example.com,2017-10-27:y" {
+		t.Errorf("unexpected rss guid %+v", g)
+	}
+	if !strings.Contains(string(body), "<content:encoded>&lt;p&gt;") {
+		t.Errorf("expecting rendered content in the rss feed, but got %s", body)
+	}
+	if lastModified := d.Header.Get("Last-Modified"); lastModified != "" {
+		t.Errorf("expecting no Last-Modified, but got %v", lastModified)
+	}
+	if d = getFEFeed(app, testContentRequest("/feed.rss", "If-None-Match", d.Header.Get("ETag")), FeedTypeRSS, ""); d.Status != http.StatusNotModified {
+		t.Errorf("expecting 304, but got %v", d.Status)
+	}
+
+	// synonyms resolve to the term
+	d = getFEFeed(app, testContentRequest("/feed.atom?tag=Alpha"), FeedTypeAtom, "Alpha")
+	body, _ = ioutil.ReadAll(d.Body)
+	atom := &atomFeed{}
+	if err := xml.Unmarshal(body, atom); err != nil || d.Status != http.StatusOK {
+		t.Errorf("unexpected atom feed %v (error: %v): %s", d.Status, err, body)
+		return
+	}
+	if len(atom.Entry) != 1 || atom.Entry[0].Id != "tag:news.example.com,2017-10-27:x" || atom.Updated != "2017-10-27T08:00:00Z" {
+		t.Errorf("unexpected atom feed %s", body)
+	}
+	if d = getFEFeed(app, testContentRequest("/feed.atom?tag=Alpha", "If-None-Match", d.Header.Get("ETag")), FeedTypeAtom, "Alpha"); d.Status != http.StatusNotModified {
+		t.Errorf("expecting 304, but got %v", d.Status)
+	}
+}
@@ -8,12 +8,10 @@ import (
 	"html/template"
 	//	"io/ioutil"
 	"net/http"
-	//	"net/url"
-	//	"strconv"
+	"net/url"
+	"strconv"
 	//	"strings"
 	//	"time"
-	//elastic "gopkg.in/olivere/elastic.v5"
-	elastic "github.com/yizha/elastic"
 )
 
 const (
@@ -23,10 +21,19 @@ const (
 <h1>Headline: {{.Headline}}</h1>
 <div><p>Created at/by: {{.CreatedAt}}/{{.CreatedBy}}</p></div>
 <div><p>Revised at/by: {{.RevisedAt}}/{{.RevisedBy}}</p></div>
+<div><p>Tag: {{ range .Tag }}<a href="/tag?name={{.}}">{{.}}</a> {{ end }}</p></div>
 <br/>
 <div><p>Summary: {{.Summary}}</p></div>
 <br/>
+{{ with .LeadImage }}<figure><img src="/media/{{.Id}}" alt="{{.Alt}}"/>{{ if or .Caption .Credit }}<figcaption>{{.Caption}}{{ if .Credit }} ({{.Credit}}){{ end }}</figcaption>{{ end }}</figure>
+<br/>
+{{ end }}{{ if .Body }}
+<div>{{ template "body" .Body }}</div>
+{{ else if or (eq .ContentFormat "markdown") (eq .ContentFormat "html") }}
+<div>{{ contentHTML . }}</div>
+{{ else }}
 <div><p>Content: {{.Content}}</p></div>
+{{ end }}
 </body>
 </html>
 `
@@ -34,17 +41,50 @@ const (
 	ARTICLE_LIST_TPL = `<html>
 <head></head>
 <body>
+{{ if .Tag }}
+<h1>Tag: {{.Tag}}</h1>
+{{ end }}
 <div>
 <ul>
 {{ range $idx,$cluster := .Articles }}
-<li><a href="/article?id={{.Guid}}:{{.Version}}" target="_blank">{{.Headline}}</a></li>
+<li>{{ with .LeadImage }}<img src="/media/{{.Id}}?w=160&amp;h=90&amp;fit=cover" alt="{{.Alt}}"/> {{ end }}<a href="/article?id={{.Guid}}:{{.Version}}" target="_blank">{{.Headline}}</a></li>
 {{ end }}
 </ul>
 </div>
 {{ if gt .MoreSize 0 }}
 <br/>
 <div>
-<a href="/articles?size={{.MoreSize}}">More</a>
+<a href="{{.MoreUrl}}">More</a>
+</div>
+{{ end }}
+</body>
+</html>
+`
+
+	SEARCH_TPL = `<html>
+<head></head>
+<body>
+<div>
+<form action="/search" method="get">
+<input type="text" name="q" value="{{.Text}}"/>
+<input type="submit" value="Search"/>
+</form>
+</div>
+<div><p>{{.Total}} article(s) found</p></div>
+<div>
+<ul>
+{{ range $idx,$hit := .Hits }}
+<li>
+<a href="/article?id={{.Article.Guid}}:{{.Article.Version}}" target="_blank">{{.Headline}}</a>
+{{ range .Fragments }}<p>... {{.}} ...</p>{{ end }}
+</li>
+{{ end }}
+</ul>
+</div>
+{{ if .MoreUrl }}
+<br/>
+<div>
+<a href="{{.MoreUrl}}">More</a>
 </div>
 {{ end }}
 </body>
@@ -53,21 +93,16 @@ const (
 )
 
 var (
-	articleTpl     = template.Must(template.New("article").Parse(ARTICLE_TPL))
+	articleTpl     = template.Must(template.New("article").Funcs(articleTplFuncs).Parse(ARTICLE_TPL + ARTICLE_BODY_TPL))
 	articleListTpl = template.Must(template.New("article").Parse(ARTICLE_LIST_TPL))
+	searchTpl      = template.Must(template.New("search").Parse(SEARCH_TPL))
 )
 
 func getFEArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
 	logger := CtxLoggerFromReq(r)
 	articleId := StringFromReq(r, CtxKeyId)
 
-	article, d := getFullArticle(
-		app.Elastic.Client,
-		context.Background(),
-		app.Conf.ArticleIndex.Name,
-		app.Conf.ArticleIndexTypes.Version,
-		articleId,
-		logger)
+	article, d := getStoredArticle(app, app.Conf.ArticleIndexTypes.Version, articleId, logger)
 	if d != nil {
 		return d
 	}
@@ -80,49 +115,46 @@ func getFEArticle(app *AppRuntime, w http.ResponseWriter, r *http.Request) *Http
 }
 
 type FEArticlesResponse struct {
+	Tag      string
 	Articles []*Article
 	MoreSize int
+	MoreUrl  string
 }
 
-func getFEArticles(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+// renders the latest published articles, only those with the tag
+// unless it is ""
+func listFEArticles(app *AppRuntime, r *http.Request, tag string) *HttpResponseData {
 	logger := CtxLoggerFromReq(r)
-	size, d := ParseQueryIntValue(r.URL.Query(), "size", false, 10, 1, 100)
+	values := r.URL.Query()
+	size, d := ParseQueryIntValue(values, "size", false, 10, 1, 100)
+	if d != nil {
+		return d
+	}
+	tag, tags, d := resolveQueryTag(app, tag, logger)
 	if d != nil {
 		return d
 	}
 
-	search := app.Elastic.Client.Search(app.Conf.ArticleIndex.Name)
-	search.Type(app.Conf.ArticleIndexTypes.Publish)
-	search.Query(elastic.NewMatchAllQuery())
-	search.Size(size)
-	search.FetchSource(true)
-	search.Sort("revised_at", false)
-	resp, err := search.Do(context.Background())
+	articles, err := app.Articles.ListPublished(context.Background(), size, tags)
 	if err != nil {
-		body := fmt.Sprintf("failed to query elasticsearch, error: %v", err)
+		body := fmt.Sprintf("failed to get published articles, error: %v", err)
 		logger.Perror(body)
 		return CreateInternalServerErrorRespData(body)
 	}
-	articles := make([]*Article, 0)
-	for _, hit := range resp.Hits.Hits {
-		one, err := unmarshalArticle(*hit.Source)
-		if err != nil {
-			logger.Pwarnf("failed to unmarshal article (%v): %v, error: %v", hit.Type, string(*hit.Source), err)
-			continue
-		}
-		one.Id = hit.Id
-		articles = append(articles, one)
-	}
 	moreSize := size
-	if len(resp.Hits.Hits) == size {
+	if len(articles) == size {
 		moreSize += size
 	} else {
 		moreSize = 0
 	}
 
+	// same page with the bigger size
+	values.Set("size", strconv.Itoa(moreSize))
 	articlesResp := &FEArticlesResponse{
+		Tag:      tag,
 		Articles: articles,
 		MoreSize: moreSize,
+		MoreUrl:  r.URL.Path + "?" + values.Encode(),
 	}
 
 	buf := &bytes.Buffer{}
@@ -134,6 +166,88 @@ func getFEArticles(app *AppRuntime, w http.ResponseWriter, r *http.Request) *Htt
 
 }
 
+func getFEArticles(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	return listFEArticles(app, r, r.URL.Query().Get("tag"))
+}
+
+func getFETagArticles(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	return listFEArticles(app, r, StringFromReq(r, CtxKeyName))
+}
+
+type FESearchHit struct {
+	Article *Article
+
+	// highlighted (html-escaped) headline and content fragments
+	Headline  template.HTML
+	Fragments []template.HTML
+}
+
+type FESearchResponse struct {
+	Text    string
+	Total   int64
+	Hits    []*FESearchHit
+	MoreUrl string
+}
+
+func getFESearch(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	values := r.URL.Query()
+	q, d := parseArticleTextQuery(values, 10)
+	if d != nil {
+		return d
+	}
+	if q.Tag, d = resolveQueryTags(app, q.Tag, logger); d != nil {
+		return d
+	}
+	q.Types = []string{app.Conf.ArticleIndexTypes.Publish}
+	result, err := app.Articles.TextSearch(context.Background(), q)
+	if err != nil {
+		body := fmt.Sprintf("failed to search published articles, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+
+	searchResp := &FESearchResponse{
+		Text:  q.Text,
+		Total: result.Total,
+		Hits:  make([]*FESearchHit, 0, len(result.Docs)),
+	}
+	for _, doc := range result.Docs {
+		hit := &FESearchHit{
+			Article:  doc.Article,
+			Headline: template.HTML(template.HTMLEscapeString(doc.Article.Headline)),
+		}
+		// highlight fragments are html-escaped by the store
+		if h := doc.Highlight["headline"]; len(h) > 0 {
+			hit.Headline = template.HTML(h[0])
+		}
+		for _, f := range doc.Highlight["content"] {
+			hit.Fragments = append(hit.Fragments, template.HTML(f))
+		}
+		searchResp.Hits = append(searchResp.Hits, hit)
+	}
+	if n := len(result.Docs); n == q.Size {
+		cursorMark, err := EncodeCursorMark(result.Docs[n-1].Sort)
+		if err != nil {
+			body := fmt.Sprintf("failed to encode cursor mark, error: %v", err)
+			return CreateInternalServerErrorRespData(body)
+		}
+		more := url.Values{}
+		for k, v := range values {
+			more[k] = v
+		}
+		more.Set("cursorMark", cursorMark)
+		searchResp.MoreUrl = "/search?" + more.Encode()
+	}
+
+	buf := &bytes.Buffer{}
+	if err := searchTpl.Execute(buf, searchResp); err != nil {
+		body := fmt.Sprintf("failed to generate search page, error: %v", err)
+		return CreateInternalServerErrorRespData(body)
+	}
+	return CreateRespData(http.StatusOK, "text/html; charset=UTF-8", buf.Bytes())
+}
+
 func FEArticlePage() EndpointHandler {
 	return GetRequiredStringArg("id", CtxKeyId, getFEArticle)
 }
@@ -141,3 +255,11 @@ func FEArticlePage() EndpointHandler {
 func FEArticlesPage() EndpointHandler {
 	return getFEArticles
 }
+
+func FETagPage() EndpointHandler {
+	return GetRequiredStringArg("name", CtxKeyName, getFETagArticles)
+}
+
+func FESearchPage() EndpointHandler {
+	return getFESearch
+}
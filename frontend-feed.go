@@ -0,0 +1,75 @@
+/*
+   /feed.rss         GET  [publish (list)]  no auth, no lock
+   /feed.atom        GET  [publish (list)]  no auth, no lock
+   /tag/feed.rss     GET  [publish (list)]  no auth, no lock
+   /tag/feed.atom    GET  [publish (list)]  no auth, no lock
+
+   feeds of the latest published articles sorted by revised_at desc (the
+   same as /articles), only those with the tag given with ?tag= (or ?name=
+   for the /tag ones), at most ?size= (default 20) of them.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const feedCacheControl = "public, max-age=300"
+
+func getFEFeed(app *AppRuntime, r *http.Request, feedType, tag string) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	size, d := ParseQueryIntValue(r.URL.Query(), "size", false, 20, 1, 100)
+	if d != nil {
+		return d
+	}
+	tag, tags, d := resolveQueryTag(app, tag, logger)
+	if d != nil {
+		return d
+	}
+	articles, err := app.Articles.ListPublished(context.Background(), size, tags)
+	if err != nil {
+		body := fmt.Sprintf("failed to get published articles, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+
+	feed := &ArticleFeed{
+		BaseUrl:  publicBaseUrl(app, r),
+		SelfPath: r.URL.RequestURI(),
+		Tag:      tag,
+		Articles: articles,
+	}
+	var data []byte
+	var contentType string
+	if feedType == FeedTypeAtom {
+		data, err = feed.Atom()
+		contentType = ContentTypeValueAtom
+	} else {
+		data, err = feed.RSS()
+		contentType = ContentTypeValueRSS
+	}
+	if err != nil {
+		body := fmt.Sprintf("failed to generate %v feed, error: %v", feedType, err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	// no Last-Modified, an article unpublished or dropped off the list
+	// doesn't move the latest revised time, the ETag catches it
+	return CreateCacheableRespData(r, contentType, data, time.Time{}, feedCacheControl)
+}
+
+func FEFeed(feedType string) EndpointHandler {
+	return func(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+		return getFEFeed(app, r, feedType, r.URL.Query().Get("tag"))
+	}
+}
+
+func FETagFeed(feedType string) EndpointHandler {
+	return GetRequiredStringArg("name", CtxKeyName, func(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+		return getFEFeed(app, r, feedType, StringFromReq(r, CtxKeyName))
+	})
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// both the assets and their variants never change (the id is the hash
+// of the content)
+const mediaCacheControl = "public, max-age=31536000, immutable"
+
+func mediaRespData(status int, contentType string, size int64, etag string) *HttpResponseData {
+	header := make(http.Header)
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", mediaCacheControl)
+	if status == http.StatusNotModified {
+		return CreateNotModifiedRespData(header)
+	}
+	header.Set(HeaderContentType, contentType)
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	return &HttpResponseData{
+		Status: status,
+		Header: header,
+	}
+}
+
+func openMediaContent(app *AppRuntime, id string, logger *JsonLogger) (io.ReadCloser, *HttpResponseData) {
+	f, err := app.MediaStorage.Open(context.Background(), id)
+	if err == ErrStoreNotFound {
+		logger.Perrorf("content of media asset %v not found!", id)
+		return nil, CreateNotFoundRespData(fmt.Sprintf("media asset %v not found!", id))
+	} else if err != nil {
+		body := fmt.Sprintf("failed to open media asset %v, error: %v", id, err)
+		logger.Perror(body)
+		return nil, CreateInternalServerErrorRespData(body)
+	}
+	return f, nil
+}
+
+// resizes the media asset and caches the variant, run by the resizer
+func resizeMediaVariant(app *AppRuntime, id, key, contentType string, width, height int, fit MediaFit, logger *JsonLogger) ([]byte, string, error) {
+	// cached by the resize just done for the same key
+	if data, ok := app.MediaCache.Get(key); ok {
+		return data, contentType, nil
+	}
+	f, err := app.MediaStorage.Open(context.Background(), id)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	data, contentType, err = ResizeMedia(data, width, height, fit)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := app.MediaCache.Put(key, data); err != nil {
+		logger.Pwarnf("failed to cache media variant %v, error: %v", key, err)
+	}
+	return data, contentType, nil
+}
+
+// serves the content of a media asset at /media/{id}, or a resized
+// variant of it with /media/{id}?w=&h=&fit= (see mediaVariantGeometry),
+// w and h are snapped to the configured variant sizes
+func getFEMedia(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	id := strings.TrimPrefix(r.URL.Path, MediaPathPrefix)
+	if !isMediaId(id) {
+		return CreateNotFoundRespData(fmt.Sprintf("media asset %v not found!", id))
+	}
+	values := r.URL.Query()
+	width, d := ParseQueryIntValue(values, "w", false, 0, 0, maxMediaVariantSide)
+	if d != nil {
+		return d
+	}
+	height, d := ParseQueryIntValue(values, "h", false, 0, 0, maxMediaVariantSide)
+	if d != nil {
+		return d
+	}
+	fit, err := parseMediaFit(values.Get("fit"))
+	if err != nil {
+		return CreateBadRequestRespData(err.Error())
+	}
+	if (width > 0 || height > 0) && len(app.Conf.MediaVariantSizes) == 0 {
+		return CreateBadRequestRespData("resized media variants are disabled!")
+	}
+	width = snapMediaVariantSide(width, app.Conf.MediaVariantSizes)
+	height = snapMediaVariantSide(height, app.Conf.MediaVariantSizes)
+	asset, d := getMediaAsset(app, id, logger)
+	if d != nil {
+		return d
+	}
+
+	// original
+	if width == 0 && height == 0 {
+		etag := fmt.Sprintf(`"%v"`, id)
+		if NotModified(r, etag, time.Time{}) {
+			return mediaRespData(http.StatusNotModified, "", 0, etag)
+		}
+		f, d := openMediaContent(app, id, logger)
+		if d != nil {
+			return d
+		}
+		resp := mediaRespData(http.StatusOK, asset.ContentType, asset.Size, etag)
+		resp.Body = f
+		return resp
+	}
+
+	// variant
+	key := fmt.Sprintf("%v-%vx%v-%v", id, width, height, fit)
+	etag := fmt.Sprintf(`"%v"`, key)
+	if NotModified(r, etag, time.Time{}) {
+		return mediaRespData(http.StatusNotModified, "", 0, etag)
+	}
+	contentType := asset.ContentType
+	if contentType != "image/jpeg" {
+		contentType = "image/png"
+	}
+	data, ok := app.MediaCache.Get(key)
+	if !ok {
+		data, contentType, err = app.MediaResizer.Do(key, func() ([]byte, string, error) {
+			return resizeMediaVariant(app, id, key, contentType, width, height, fit, logger)
+		})
+		if err == ErrStoreNotFound {
+			logger.Perrorf("content of media asset %v not found!", id)
+			return CreateNotFoundRespData(fmt.Sprintf("media asset %v not found!", id))
+		} else if err == ErrMediaResizeBusy {
+			body := fmt.Sprintf("failed to resize media asset %v, error: %v", id, err)
+			logger.Perror(body)
+			return CreateRespData(http.StatusServiceUnavailable, ContentTypeValueText, []byte(body))
+		} else if err != nil {
+			body := fmt.Sprintf("failed to resize media asset %v, error: %v", id, err)
+			logger.Perror(body)
+			return CreateInternalServerErrorRespData(body)
+		}
+	}
+	resp := mediaRespData(http.StatusOK, contentType, int64(len(data)), etag)
+	resp.Body = bytes.NewReader(data)
+	return resp
+}
+
+func FEMedia() EndpointHandler {
+	return getFEMedia
+}
@@ -0,0 +1,105 @@
+/*
+   /sitemap.xml          GET  [publish (scroll)]  no auth, no lock
+   /sitemap.xml?page=N   GET  [publish (scroll)]  no auth, no lock
+   /sitemap-news.xml     GET  [publish (list)]    no auth, no lock
+
+   /sitemap.xml lists all published articles with lastmod from revised_at,
+   or it is a sitemap index of /sitemap.xml?page=1..N if there are more
+   than SitemapMaxUrls of them (sorted by created_at). /sitemap-news.xml
+   is the google news sitemap of the articles published within the last
+   NewsSitemapMaxAge. The articles are cached for sitemapCacheTTL.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	SitemapPath     = "/sitemap.xml"
+	NewsSitemapPath = "/sitemap-news.xml"
+
+	ContentTypeValueXML = "application/xml; charset=utf-8"
+
+	sitemapCacheControl = "public, max-age=600"
+)
+
+func getFESitemap(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	page, d := ParseQueryIntValue(r.URL.Query(), "page", false, 0, 1, 1000000)
+	if d != nil {
+		return d
+	}
+	articles, err := app.Sitemaps.Get(SitemapPath, func() ([]*SitemapArticle, error) {
+		return loadSitemapArticles(context.Background(), app)
+	})
+	if err != nil {
+		body := fmt.Sprintf("failed to scroll published articles, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+
+	baseUrl := publicBaseUrl(app, r)
+	var data []byte
+	switch {
+	case page == 0 && sitemapPages(len(articles)) > 1:
+		data, err = BuildSitemapIndex(baseUrl, SitemapPath, articles)
+	case page == 0:
+		data, err = BuildSitemap(baseUrl, articles)
+	default:
+		pageArticles := sitemapPage(articles, page)
+		if pageArticles == nil {
+			return CreateNotFoundRespData(fmt.Sprintf("sitemap page %v not found!", page))
+		}
+		data, err = BuildSitemap(baseUrl, pageArticles)
+	}
+	if err != nil {
+		body := fmt.Sprintf("failed to generate sitemap, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	// no Last-Modified, an unpublished article doesn't move the latest
+	// revised time, the ETag catches it
+	return CreateCacheableRespData(r, ContentTypeValueXML, data, time.Time{}, sitemapCacheControl)
+}
+
+func getFENewsSitemap(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+	logger := CtxLoggerFromReq(r)
+	articles, err := app.Sitemaps.Get(NewsSitemapPath, func() ([]*SitemapArticle, error) {
+		since := time.Now().UTC().Add(-NewsSitemapMaxAge)
+		return loadNewsSitemapArticles(context.Background(), app, since)
+	})
+	if err != nil {
+		body := fmt.Sprintf("failed to list recently published articles, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	baseUrl := publicBaseUrl(app, r)
+	name := app.Conf.NewsName
+	if name == "" {
+		if u, err := url.Parse(baseUrl); err == nil {
+			name = u.Hostname()
+		}
+	}
+	since := time.Now().UTC().Add(-NewsSitemapMaxAge)
+	data, err := BuildNewsSitemap(baseUrl, name, app.Conf.NewsLanguage, articles, since)
+	if err != nil {
+		body := fmt.Sprintf("failed to generate news sitemap, error: %v", err)
+		logger.Perror(body)
+		return CreateInternalServerErrorRespData(body)
+	}
+	return CreateCacheableRespData(r, ContentTypeValueXML, data, time.Time{}, sitemapCacheControl)
+}
+
+func FESitemap() EndpointHandler {
+	return getFESitemap
+}
+
+func FENewsSitemap() EndpointHandler {
+	return getFENewsSitemap
+}
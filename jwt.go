@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWT access tokens (RFC 7519) signed with keys from a directory.
+//
+// Every (non-hidden) file in the key directory is a key of the configured
+// algorithm, its name without extension is the key id ("kid" in the token
+// header and in the JWKS):
+//
+//   HS256 - the raw secret, at least 32 bytes
+//   RS256 - PEM encoded RSA key, private (PKCS#1 or PKCS#8) or public (PKIX)
+//   EdDSA - PEM encoded Ed25519 key, private (PKCS#8) or public (PKIX)
+//
+// Tokens are signed with the most recently modified private key and all
+// keys verify. To rotate, add a new key file, then remove the old one once
+// the tokens signed with it have expired.
+
+type JWTAlg string
+
+const (
+	JWTAlgHS256 JWTAlg = "HS256"
+	JWTAlgRS256 JWTAlg = "RS256"
+	JWTAlgEdDSA JWTAlg = "EdDSA"
+
+	// min size of a HS256 secret and a RSA key
+	jwtMinSecretSize = 32
+	jwtMinRSABits    = 2048
+
+	// allowed clock skew between servers when checking "exp" and "iat"
+	jwtLeeway = time.Minute
+)
+
+var (
+	ErrJWTMalformed     = errors.New("malformed jwt")
+	ErrJWTUnknownKey    = errors.New("jwt signed with an unknown key")
+	ErrJWTBadSignature  = errors.New("jwt signature is invalid")
+	ErrJWTExpired       = errors.New("jwt is expired")
+	ErrJWTInvalidClaims = errors.New("jwt claims are invalid")
+)
+
+func parseJWTAlg(s string) (JWTAlg, error) {
+	for _, alg := range []JWTAlg{JWTAlgHS256, JWTAlgRS256, JWTAlgEdDSA} {
+		if strings.EqualFold(strings.TrimSpace(s), string(alg)) {
+			return alg, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported jwt algorithm %v, must be one of %v, %v, %v!", s, JWTAlgHS256, JWTAlgRS256, JWTAlgEdDSA)
+}
+
+type JWTHeader struct {
+	Alg JWTAlg `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+type JWTClaims struct {
+	Issuer    string       `json:"iss,omitempty"`
+	Subject   string       `json:"sub"`
+	IssuedAt  int64        `json:"iat"`
+	ExpiresAt int64        `json:"exp,omitempty"`
+	Role      CmsRoleValue `json:"role"`
+	Session   string       `json:"sid"`
+}
+
+func NewJWTClaims(user *CmsUser, issuer string, maxAge time.Duration) *JWTClaims {
+	now := time.Now()
+	claims := &JWTClaims{
+		Issuer:   issuer,
+		Subject:  user.Username,
+		IssuedAt: now.Unix(),
+		Role:     user.Role,
+		Session:  user.Session,
+	}
+	if maxAge > 0 {
+		claims.ExpiresAt = now.Add(maxAge).Unix()
+	}
+	return claims
+}
+
+func (c *JWTClaims) User() *CmsUser {
+	return &CmsUser{
+		Username: c.Subject,
+		Role:     c.Role,
+		Session:  c.Session,
+	}
+}
+
+type JWTKey struct {
+	Kid     string
+	Alg     JWTAlg
+	ModTime time.Time
+
+	// HS256 secret, or the private (nil for public only keys) and public
+	// key of RS256/EdDSA
+	secret  []byte
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+func (k *JWTKey) CanSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+func (k *JWTKey) sign(data []byte) ([]byte, error) {
+	switch k.Alg {
+	case JWTAlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case JWTAlgRS256:
+		hash := sha256.Sum256(data)
+		return rsa.SignPKCS1v15(nil, k.private.(*rsa.PrivateKey), crypto.SHA256, hash[:])
+	default:
+		return ed25519.Sign(k.private.(ed25519.PrivateKey), data), nil
+	}
+}
+
+func (k *JWTKey) verify(data, sig []byte) bool {
+	switch k.Alg {
+	case JWTAlgHS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case JWTAlgRS256:
+		hash := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k.public.(*rsa.PublicKey), crypto.SHA256, hash[:], sig) == nil
+	default:
+		return ed25519.Verify(k.public.(ed25519.PublicKey), data, sig)
+	}
+}
+
+// Loads the key of the algorithm from the file
+func LoadJWTKey(path string, alg JWTAlg) (*JWTKey, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := &JWTKey{
+		Kid:     strings.TrimSuffix(fi.Name(), filepath.Ext(fi.Name())),
+		Alg:     alg,
+		ModTime: fi.ModTime(),
+	}
+	if alg == JWTAlgHS256 {
+		key.secret = bytes.TrimRight(data, "\r\n")
+		if len(key.secret) < jwtMinSecretSize {
+			return nil, fmt.Errorf("jwt secret %v is shorter than %v bytes", path, jwtMinSecretSize)
+		}
+		return key, nil
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no pem encoded key in %v", path)
+	}
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported pem block type %v", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key in %v, error: %v", path, err)
+	}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.private, key.public = k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.public = k
+	case ed25519.PrivateKey:
+		key.private, key.public = k, k.Public()
+	case ed25519.PublicKey:
+		key.public = k
+	}
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		if alg != JWTAlgRS256 {
+			return nil, fmt.Errorf("key in %v is a RSA key, not for %v", path, alg)
+		}
+		if pub.N.BitLen() < jwtMinRSABits {
+			return nil, fmt.Errorf("RSA key in %v is shorter than %v bits", path, jwtMinRSABits)
+		}
+	case ed25519.PublicKey:
+		if alg != JWTAlgEdDSA {
+			return nil, fmt.Errorf("key in %v is an Ed25519 key, not for %v", path, alg)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T in %v", parsed, path)
+	}
+	return key, nil
+}
+
+// JWT keys of an algorithm loaded from a directory
+type JWTKeySet struct {
+	Dir string
+	Alg JWTAlg
+
+	mu     sync.RWMutex
+	keys   map[string]*JWTKey
+	signer *JWTKey
+}
+
+func NewJWTKeySet(dir string, alg JWTAlg) (*JWTKeySet, error) {
+	ks := &JWTKeySet{Dir: dir, Alg: alg}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// (Re)loads all keys in the directory, the current keys are kept if any
+// of them fails to load or there is no private key to sign with
+func (ks *JWTKeySet) Reload() error {
+	fis, err := ioutil.ReadDir(ks.Dir)
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]*JWTKey)
+	var signer *JWTKey
+	for _, fi := range fis {
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			continue
+		}
+		key, err := LoadJWTKey(filepath.Join(ks.Dir, fi.Name()), ks.Alg)
+		if err != nil {
+			return err
+		}
+		if _, ok := keys[key.Kid]; ok {
+			return fmt.Errorf("duplicate jwt key id %v in %v", key.Kid, ks.Dir)
+		}
+		keys[key.Kid] = key
+		if key.CanSign() && (signer == nil || key.ModTime.After(signer.ModTime) ||
+			(key.ModTime.Equal(signer.ModTime) && key.Kid > signer.Kid)) {
+			signer = key
+		}
+	}
+	if signer == nil {
+		return fmt.Errorf("no %v private key in %v to sign jwt with", ks.Alg, ks.Dir)
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys = keys
+	ks.signer = signer
+	return nil
+}
+
+// Id of the key tokens are signed with
+func (ks *JWTKeySet) SignerKid() string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.signer.Kid
+}
+
+func (ks *JWTKeySet) Sign(claims *JWTClaims) (string, error) {
+	ks.mu.RLock()
+	signer := ks.signer
+	ks.mu.RUnlock()
+	header, err := json.Marshal(&JWTHeader{Alg: signer.Alg, Typ: "JWT", Kid: signer.Kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	data := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, err := signer.sign([]byte(data))
+	if err != nil {
+		return "", err
+	}
+	return data + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return ErrJWTMalformed
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return ErrJWTMalformed
+	}
+	return nil
+}
+
+// Verifies the signature of the token with the key returned by keyFn
+// for its header and decodes its payload into claims
+func parseSignedJWT(token string, keyFn func(*JWTHeader) (*JWTKey, error), claims interface{}) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ErrJWTMalformed
+	}
+	header := &JWTHeader{}
+	if err := decodeJWTPart(parts[0], header); err != nil {
+		return err
+	}
+	key, err := keyFn(header)
+	if err != nil {
+		return err
+	}
+	if header.Alg != key.Alg {
+		return fmt.Errorf("unexpected jwt algorithm %v", header.Alg)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return ErrJWTMalformed
+	}
+	if !key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+		return ErrJWTBadSignature
+	}
+	return decodeJWTPart(parts[1], claims)
+}
+
+// Verifies the token and returns its claims. Tokens of other algorithms
+// (including "none") are rejected, so are the ones from another issuer
+// if the issuer is given.
+func (ks *JWTKeySet) Parse(token, issuer string, now time.Time) (*JWTClaims, error) {
+	claims := &JWTClaims{}
+	err := parseSignedJWT(token, func(header *JWTHeader) (*JWTKey, error) {
+		ks.mu.RLock()
+		defer ks.mu.RUnlock()
+		if key, ok := ks.keys[header.Kid]; ok {
+			return key, nil
+		}
+		return nil, ErrJWTUnknownKey
+	}, claims)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" || (issuer != "" && claims.Issuer != issuer) {
+		return nil, ErrJWTInvalidClaims
+	}
+	if err := checkJWTTimes(claims.IssuedAt, claims.ExpiresAt, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// checks "iat" and "exp" (0 for no expiry) with some leeway
+func checkJWTTimes(issuedAt, expiresAt int64, now time.Time) error {
+	if issuedAt > now.Add(jwtLeeway).Unix() {
+		return ErrJWTInvalidClaims
+	}
+	if expiresAt > 0 && now.Add(-jwtLeeway).Unix() >= expiresAt {
+		return ErrJWTExpired
+	}
+	return nil
+}
+
+// a JWT has three dot separated parts while a securecookie token (base64
+// url encoded) has no dot at all
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// JSON Web Key (RFC 7517) of a public key
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Ed25519 (OKP)
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// Parses the public key of a JWK, keys not for signing or of unsupported
+// types and algorithms are skipped (nil without error)
+func ParseJWK(jwk *JWK) (*JWTKey, error) {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, nil
+	}
+	key := &JWTKey{Kid: jwk.Kid, Alg: JWTAlg(jwk.Alg)}
+	switch {
+	case jwk.Kty == "RSA" && (jwk.Alg == "" || jwk.Alg == string(JWTAlgRS256)):
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus of jwk %v, error: %v", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid exponent of jwk %v", jwk.Kid)
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < jwtMinRSABits {
+			return nil, fmt.Errorf("RSA jwk %v is shorter than %v bits", jwk.Kid, jwtMinRSABits)
+		}
+		key.Alg, key.public = JWTAlgRS256, pub
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519" && (jwk.Alg == "" || jwk.Alg == string(JWTAlgEdDSA)):
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 jwk %v", jwk.Kid)
+		}
+		key.Alg, key.public = JWTAlgEdDSA, ed25519.PublicKey(x)
+	default:
+		return nil, nil
+	}
+	return key, nil
+}
+
+// Public keys of the set, sorted by kid. HS256 secrets are never
+// published so the set is empty for it.
+func (ks *JWTKeySet) JWKS() *JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	jwks := &JWKS{Keys: make([]*JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		jwk := &JWK{Use: "sig", Alg: string(key.Alg), Kid: key.Kid}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+	return jwks
+}
+
+// Reloads the jwt keys periodically to pick up rotated ones
+func StartJWTKeyReloader(app *AppRuntime) {
+	interval := app.Conf.JWTKeyReload
+	if app.JWTKeys == nil || interval <= 0 {
+		return
+	}
+	logger := app.Logger.CloneWithFields(LogFields{
+		"log_group": "jwt",
+	})
+	go func() {
+		for range time.Tick(interval) {
+			kid := app.JWTKeys.SignerKid()
+			if err := app.JWTKeys.Reload(); err != nil {
+				logger.Perrorf("failed to reload jwt keys from %v, keep the current ones, error: %v", app.JWTKeys.Dir, err)
+			} else if newKid := app.JWTKeys.SignerKid(); newKid != kid {
+				logger.Pinfof("signing jwt with key %v (was %v)", newKid, kid)
+			}
+		}
+	}()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeTestJWTKey(t *testing.T, dir, kid string, alg JWTAlg, modTime time.Time) {
+	var data []byte
+	switch alg {
+	case JWTAlgHS256:
+		data = []byte(strings.Repeat(kid, 32) + "\n")
+	default:
+		var key interface{}
+		var err error
+		if alg == JWTAlgRS256 {
+			key, err = rsa.GenerateKey(rand.Reader, 2048)
+		} else {
+			_, key, err = ed25519.GenerateKey(rand.Reader)
+		}
+		if err != nil {
+			t.Fatalf("failed to generate %v key, error: %v", alg, err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatalf("failed to marshal %v key, error: %v", alg, err)
+		}
+		data = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+	path := filepath.Join(dir, kid+".key")
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("failed to write key %v, error: %v", path, err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatalf("failed to touch key %v, error: %v", path, err)
+	}
+}
+
+func testJWTKeySet(t *testing.T, alg JWTAlg) *JWTKeySet {
+	dir, err := ioutil.TempDir("", "jwt-keys")
+	if err != nil {
+		t.Fatalf("failed to create key dir, error: %v", err)
+	}
+	writeTestJWTKey(t, dir, "k1", alg, time.Now().Add(-time.Hour))
+	ks, err := NewJWTKeySet(dir, alg)
+	if err != nil {
+		t.Fatalf("failed to load %v keys, error: %v", alg, err)
+	}
+	return ks
+}
+
+func TestJWTSignAndParse(t *testing.T) {
+	user := &CmsUser{Username: "ed", Role: CmsRoleArticleCreate | CmsRoleArticlePublish, Session: "s1"}
+	now := time.Now()
+	for _, alg := range []JWTAlg{JWTAlgHS256, JWTAlgRS256, JWTAlgEdDSA} {
+		ks := testJWTKeySet(t, alg)
+		defer os.RemoveAll(ks.Dir)
+
+		token, err := ks.Sign(NewJWTClaims(user, "article-api", time.Minute*15))
+		if err != nil {
+			t.Fatalf("failed to sign %v jwt, error: %v", alg, err)
+		}
+		claims, err := ks.Parse(token, "article-api", now)
+		if err != nil {
+			t.Errorf("failed to parse %v jwt, error: %v", alg, err)
+		} else if u := claims.User(); u.Username != user.Username || u.Role != user.Role || u.Session != user.Session {
+			t.Errorf("expecting user %+v from %v jwt, but got %+v", user, alg, u)
+		}
+
+		parts := strings.Split(token, ".")
+		for name, c := range map[string]struct {
+			token  string
+			issuer string
+			now    time.Time
+		}{
+			"tampered":     {parts[0] + "." + parts[1] + "x." + parts[2], "article-api", now},
+			"bad-sig":      {parts[0] + "." + parts[1] + "." + parts[2][2:], "article-api", now},
+			"alg-none":     {"eyJhbGciOiJub25lIiwia2lkIjoiazEifQ." + parts[1] + ".", "article-api", now},
+			"other-issuer": {token, "other", now},
+			"expired":      {token, "article-api", now.Add(time.Hour)},
+			"malformed":    {parts[0] + "." + parts[1], "article-api", now},
+		} {
+			if _, err := ks.Parse(c.token, c.issuer, c.now); err == nil {
+				t.Errorf("expecting %v %v jwt to be rejected", name, alg)
+			}
+		}
+	}
+
+	// a jwt isn't accepted by a key set of another algorithm, even with
+	// the same kid
+	hs, ed := testJWTKeySet(t, JWTAlgHS256), testJWTKeySet(t, JWTAlgEdDSA)
+	defer os.RemoveAll(hs.Dir)
+	defer os.RemoveAll(ed.Dir)
+	token, _ := hs.Sign(NewJWTClaims(user, "", 0))
+	if _, err := ed.Parse(token, "", now); err == nil {
+		t.Errorf("expecting HS256 jwt to be rejected by EdDSA keys")
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	ks := testJWTKeySet(t, JWTAlgEdDSA)
+	defer os.RemoveAll(ks.Dir)
+	user := &CmsUser{Username: "ed", Session: "s1"}
+	old, _ := ks.Sign(NewJWTClaims(user, "", 0))
+
+	// a new key signs from the next reload on, the old one still verifies
+	writeTestJWTKey(t, ks.Dir, "k2", JWTAlgEdDSA, time.Now())
+	if err := ks.Reload(); err != nil {
+		t.Fatalf("failed to reload keys, error: %v", err)
+	}
+	if kid := ks.SignerKid(); kid != "k2" {
+		t.Errorf("expecting signing with k2, but got %v", kid)
+	}
+	if _, err := ks.Parse(old, "", time.Now()); err != nil {
+		t.Errorf("expecting jwt of the old key still valid, but got %v", err)
+	}
+	if jwks := ks.JWKS(); len(jwks.Keys) != 2 || jwks.Keys[0].Kid != "k1" || jwks.Keys[1].Kid != "k2" {
+		t.Errorf("expecting k1 and k2 in jwks, but got %+v", jwks.Keys)
+	}
+
+	// a broken key file keeps the current keys
+	ioutil.WriteFile(filepath.Join(ks.Dir, "k3.key"), []byte("not a key"), 0600)
+	if err := ks.Reload(); err == nil {
+		t.Errorf("expecting reload to fail with a broken key file")
+	}
+	os.Remove(filepath.Join(ks.Dir, "k3.key"))
+
+	// the old key is gone, so are its tokens
+	os.Remove(filepath.Join(ks.Dir, "k1.key"))
+	if err := ks.Reload(); err != nil {
+		t.Fatalf("failed to reload keys, error: %v", err)
+	}
+	if _, err := ks.Parse(old, "", time.Now()); err != ErrJWTUnknownKey {
+		t.Errorf("expecting %v for jwt of a removed key, but got %v", ErrJWTUnknownKey, err)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	for alg, kty := range map[JWTAlg]string{JWTAlgHS256: "", JWTAlgRS256: "RSA", JWTAlgEdDSA: "OKP"} {
+		ks := testJWTKeySet(t, alg)
+		defer os.RemoveAll(ks.Dir)
+		d := getJWKS(&AppRuntime{JWTKeys: ks}, nil, httptest.NewRequest(http.MethodGet, JWKSPath, nil))
+		body, _ := ioutil.ReadAll(d.Body)
+		if d.Status != http.StatusOK || d.Header.Get(HeaderContentType) != ContentTypeValueJWKS {
+			t.Errorf("unexpected %v jwks response %v: %s", alg, d.Status, body)
+			continue
+		}
+		// secrets are never published
+		if kty == "" {
+			if string(body) != `{"keys":[]}` {
+				t.Errorf("expecting no HS256 key in jwks, but got %s", body)
+			}
+			continue
+		}
+		jwks := &JWKS{}
+		json.Unmarshal(body, jwks)
+		if len(jwks.Keys) != 1 || jwks.Keys[0].Kty != kty || jwks.Keys[0].Kid != "k1" || jwks.Keys[0].Alg != string(alg) {
+			t.Errorf("unexpected %v jwks %s", alg, body)
+		}
+		if strings.Contains(string(body), `"d"`) {
+			t.Errorf("expecting no private key in %v jwks %s", alg, body)
+		}
+	}
+	if d := getJWKS(&AppRuntime{}, nil, httptest.NewRequest(http.MethodGet, JWKSPath, nil)); d.Status != http.StatusNotFound {
+		t.Errorf("expecting 404 for jwks without jwt, but got %v", d.Status)
+	}
+}
+
+func TestRequireAuthJWT(t *testing.T) {
+	app := testLoginApp(t)
+	app.JWTKeys = testJWTKeySet(t, JWTAlgRS256)
+	defer os.RemoveAll(app.JWTKeys.Dir)
+	app.Conf.JWTIssuer = "article-api"
+
+	// securecookie token issued before switching to jwt
+	cookie := testLoginToken(t, app)
+	app.Conf.TokenFormat = TokenFormatJWT
+	jwt := testLoginToken(t, app)
+	if jwt.TokenType != AuthSchemeBearer || !isJWT(jwt.Token) {
+		t.Fatalf("expecting a bearer jwt, but got %+v", jwt)
+	}
+
+	var authed *CmsUser
+	h := RequireAuth(func(app *AppRuntime, w http.ResponseWriter, r *http.Request) *HttpResponseData {
+		authed = CmsUserFromReq(r)
+		return CreateRespData(http.StatusOK, ContentTypeValueText, []byte{})
+	})
+	for _, c := range []struct {
+		header string
+		value  string
+		status int
+	}{
+		{HeaderAuthz, "Bearer " + jwt.Token, http.StatusOK},
+		{HeaderAuthz, "bearer " + jwt.Token, http.StatusOK},
+		{HeaderAuthToken, jwt.Token, http.StatusOK},
+		{HeaderAuthToken, cookie.Token, http.StatusOK},
+		{HeaderAuthz, "Bearer " + cookie.Token, http.StatusOK},
+		{HeaderAuthz, "Basic " + jwt.Token, http.StatusForbidden},
+		{HeaderAuthz, "Bearer " + jwt.Token + "x", http.StatusForbidden},
+	} {
+		authed = nil
+		r := testAuthRequest(http.MethodGet, "/api/articles", "")
+		r.Header.Set(c.header, c.value)
+		if d := h(app, httptest.NewRecorder(), r); d.Status != c.status {
+			t.Errorf("expecting %v for %v: %v, but got %v", c.status, c.header, c.value, d.Status)
+		} else if c.status == http.StatusOK && (authed == nil || authed.Username != "ed" || authed.Session == "") {
+			t.Errorf("unexpected user %+v for %v: %v", authed, c.header, c.value)
+		}
+	}
+
+	// the session of a jwt can be revoked like the one of a securecookie
+	// token
+	claims, _ := app.JWTKeys.Parse(jwt.Token, "article-api", time.Now())
+	app.Sessions.DeleteSession(context.Background(), claims.Session)
+	r := testAuthRequest(http.MethodGet, "/api/articles", "")
+	r.Header.Set(HeaderAuthz, "Bearer "+jwt.Token)
+	if d := h(app, httptest.NewRecorder(), r); d.Status != http.StatusForbidden {
+		t.Errorf("expecting 403 for jwt of a revoked session, but got %v", d.Status)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Users in a ldap directory. The user entry is searched (as the service
+// account or anonymously) and its password verified by binding as it,
+// its groups are from an attribute of the entry (like memberOf) or from
+// a search of the groups with it as a member.
+type LDAPAuthenticator struct {
+	conf    *AppConf
+	tlsConf *tls.Config
+}
+
+func NewLDAPAuthenticator(conf *AppConf) (*LDAPAuthenticator, error) {
+	u, err := url.Parse(conf.LDAPUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ldap url %v, error: %v", conf.LDAPUrl, err)
+	}
+	// StartTLS doesn't take the server name from the url
+	tlsConf := &tls.Config{ServerName: u.Hostname()}
+	if conf.LDAPCAFile != "" {
+		pem, err := ioutil.ReadFile(conf.LDAPCAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate in %v", conf.LDAPCAFile)
+		}
+	}
+	return &LDAPAuthenticator{conf: conf, tlsConf: tlsConf}, nil
+}
+
+func (a *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(a.conf.LDAPUrl,
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.conf.LDAPTimeout}),
+		ldap.DialWithTLSConfig(a.tlsConf))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.conf.LDAPTimeout)
+	if a.conf.LDAPStartTLS && strings.HasPrefix(a.conf.LDAPUrl, "ldap://") {
+		if err := conn.StartTLS(a.tlsConf); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to StartTLS, error: %v", err)
+		}
+	}
+	if a.conf.LDAPBindDN != "" {
+		if err := conn.Bind(a.conf.LDAPBindDN, a.conf.LDAPBindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to bind as %v, error: %v", a.conf.LDAPBindDN, err)
+		}
+	}
+	return conn, nil
+}
+
+func (a *LDAPAuthenticator) search(conn *ldap.Conn, baseDN, filter string, attrs []string, sizeLimit int) ([]*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		sizeLimit, 0, false, filter, attrs, nil))
+	if err != nil {
+		return nil, err
+	}
+	return result.Entries, nil
+}
+
+// Name of the group of the dn, the value of its first RDN, e.g. editors
+// of cn=editors,ou=groups,dc=example,dc=com
+func ldapGroupName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return dn
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+// names (cn) of the groups of the user entry
+func (a *LDAPAuthenticator) groups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	names := make([]string, 0)
+	if a.conf.LDAPGroupBaseDN == "" {
+		for _, dn := range entry.GetEqualFoldAttributeValues(a.conf.LDAPGroupAttr) {
+			names = append(names, ldapGroupName(dn))
+		}
+		return names, nil
+	}
+	filter := strings.Replace(a.conf.LDAPGroupFilter, "%s", ldap.EscapeFilter(entry.DN), -1)
+	groups, err := a.search(conn, a.conf.LDAPGroupBaseDN, filter, []string{"cn"}, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search groups of %v, error: %v", entry.DN, err)
+	}
+	for _, group := range groups {
+		if cn := group.GetEqualFoldAttributeValue("cn"); cn != "" {
+			names = append(names, cn)
+		} else {
+			names = append(names, ldapGroupName(group.DN))
+		}
+	}
+	return names, nil
+}
+
+// Authenticates the user against the directory, the username of the user
+// is the one of the entry (-ldap-username-attr) rather than what was typed
+// in, which the directory may match case-insensitively.
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, username, password string) (*CmsUser, error) {
+	// a simple bind with an empty password is an anonymous bind which
+	// always succeeds
+	if password == "" {
+		return nil, ErrAuthBadPassword
+	}
+	conn, err := a.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	filter := strings.Replace(a.conf.LDAPUserFilter, "%s", ldap.EscapeFilter(username), -1)
+	attrs := []string{a.conf.LDAPUsernameAttr, a.conf.LDAPGroupAttr}
+	entries, err := a.search(conn, a.conf.LDAPBaseDN, filter, attrs, 2)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) || len(entries) > 1 {
+		return nil, fmt.Errorf("more than one ldap entry of user %v", username)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to search user %v, error: %v", username, err)
+	}
+	if len(entries) == 0 {
+		return nil, ErrAuthUnknownUser
+	}
+	entry := entries[0]
+	uid := entry.GetEqualFoldAttributeValue(a.conf.LDAPUsernameAttr)
+	if uid == "" {
+		return nil, fmt.Errorf("ldap entry %v of user %v has no %v", entry.DN, username, a.conf.LDAPUsernameAttr)
+	}
+	if err := conn.Bind(entry.DN, password); ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrAuthBadPassword
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to bind as %v, error: %v", entry.DN, err)
+	}
+	// search groups as the service account again
+	if a.conf.LDAPGroupBaseDN != "" && a.conf.LDAPBindDN != "" {
+		if err := conn.Bind(a.conf.LDAPBindDN, a.conf.LDAPBindPassword); err != nil {
+			return nil, fmt.Errorf("failed to bind as %v, error: %v", a.conf.LDAPBindDN, err)
+		}
+	}
+	groups, err := a.groups(conn, entry)
+	if err != nil {
+		return nil, err
+	}
+	user := &CmsUser{Username: uid, Provider: LDAPProviderName}
+	for _, group := range groups {
+		user.Role |= a.conf.LDAPRoleMap[strings.ToLower(group)]
+	}
+	if user.Role == 0 {
+		return nil, ErrAuthNoRole
+	}
+	return user, nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+type stubLDAPEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// Stub ldap server handling simple binds and searches with equality (and
+// "&" of equality) filters
+type stubLDAPServer struct {
+	ln net.Listener
+
+	mu      sync.Mutex
+	entries []*stubLDAPEntry
+}
+
+func newStubLDAPServer(t *testing.T, entries ...*stubLDAPEntry) *stubLDAPServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen, error: %v", err)
+	}
+	s := &stubLDAPServer{ln: ln, entries: entries}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *stubLDAPServer) URL() string {
+	return "ldap://" + s.ln.Addr().String()
+}
+
+func (s *stubLDAPServer) setEntries(entries []*stubLDAPEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = entries
+}
+
+func (s *stubLDAPServer) Close() {
+	s.ln.Close()
+}
+
+func stubLDAPResult(tag ber.Tag, code int64) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "result")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "code"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matched dn"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "message"))
+	return p
+}
+
+func (s *stubLDAPServer) match(entry *stubLDAPEntry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !s.match(entry, child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterEqualityMatch:
+		attr, value := strings.ToLower(filter.Children[0].Data.String()), filter.Children[1].Data.String()
+		for _, v := range entry.attrs[attr] {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *stubLDAPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if err != nil || len(msg.Children) < 2 {
+			return
+		}
+		id, _ := msg.Children[0].Value.(int64)
+		op := msg.Children[1]
+		reply := func(op *ber.Packet) {
+			p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "message")
+			p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "id"))
+			p.AppendChild(op)
+			conn.Write(p.Bytes())
+		}
+		s.mu.Lock()
+		entries := s.entries
+		s.mu.Unlock()
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+			code := int64(ldap.LDAPResultInvalidCredentials)
+			for _, entry := range entries {
+				if entry.dn == dn && entry.password != "" && entry.password == password {
+					code = ldap.LDAPResultSuccess
+				}
+			}
+			reply(stubLDAPResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			base := strings.ToLower(op.Children[0].Data.String())
+			for _, entry := range entries {
+				if !strings.HasSuffix(strings.ToLower(entry.dn), base) || !s.match(entry, op.Children[6]) {
+					continue
+				}
+				result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "entry")
+				result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "dn"))
+				attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+				for _, name := range op.Children[7].Children {
+					attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+					attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name.Data.String(), "type"))
+					values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "values")
+					for _, v := range entry.attrs[strings.ToLower(name.Data.String())] {
+						values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+					}
+					attr.AppendChild(values)
+					attrs.AppendChild(attr)
+				}
+				result.AppendChild(attrs)
+				reply(result)
+			}
+			reply(stubLDAPResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		default:
+			return
+		}
+	}
+}
+
+func testLDAPServer(t *testing.T) *stubLDAPServer {
+	return newStubLDAPServer(t,
+		&stubLDAPEntry{dn: "cn=cms,ou=apps,dc=example,dc=com", password: "svc"},
+		&stubLDAPEntry{dn: "uid=amy,ou=people,dc=example,dc=com", password: "amy-pw", attrs: map[string][]string{
+			"uid":      {"amy"},
+			"memberof": {"cn=Editors,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+		}},
+		&stubLDAPEntry{dn: "uid=bob,ou=people,dc=example,dc=com", password: "bob-pw", attrs: map[string][]string{
+			"uid":      {"bob"},
+			"memberof": {"cn=staff,ou=groups,dc=example,dc=com"},
+		}},
+		&stubLDAPEntry{dn: "uid=ed,ou=people,dc=example,dc=com", password: "ed-pw", attrs: map[string][]string{
+			"uid":      {"ed"},
+			"memberof": {"cn=editors,ou=groups,dc=example,dc=com"},
+		}},
+		&stubLDAPEntry{dn: "cn=publishers,ou=groups,dc=example,dc=com", attrs: map[string][]string{
+			"cn":     {"publishers"},
+			"member": {"uid=bob,ou=people,dc=example,dc=com"},
+		}},
+	)
+}
+
+func testLDAPConf(url string) *AppConf {
+	return &AppConf{
+		AuthBackend:      AuthBackendLDAP,
+		LDAPUrl:          url,
+		LDAPTimeout:      5 * time.Second,
+		LDAPBindDN:       "cn=cms,ou=apps,dc=example,dc=com",
+		LDAPBindPassword: "svc",
+		LDAPBaseDN:       "ou=people,dc=example,dc=com",
+		LDAPUserFilter:   "(&(uid=%s))",
+		LDAPUsernameAttr: "uid",
+		LDAPGroupAttr:    "memberOf",
+		LDAPGroupFilter:  "(member=%s)",
+		LDAPRoleMap: map[string]CmsRoleValue{
+			"editors":    CmsRoleArticleCreate | CmsRoleArticleEditSelf,
+			"publishers": CmsRoleArticlePublish,
+		},
+	}
+}
+
+func TestLDAPGroupName(t *testing.T) {
+	for dn, expected := range map[string]string{
+		"cn=editors,ou=groups,dc=example,dc=com":       "editors",
+		`cn=Editors\, Inc,ou=groups,dc=example,dc=com`: "Editors, Inc",
+		"not a dn": "not a dn",
+	} {
+		if name := ldapGroupName(dn); name != expected {
+			t.Errorf("expecting group name %v of %v, but got %v", expected, dn, name)
+		}
+	}
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	server := testLDAPServer(t)
+	defer server.Close()
+	conf := testLDAPConf(server.URL())
+	a, err := NewLDAPAuthenticator(conf)
+	if err != nil {
+		t.Fatalf("failed to create ldap authenticator, error: %v", err)
+	}
+	ctx := context.Background()
+	// the username is the one of the entry
+	for _, username := range []string{"amy", "AMY"} {
+		user, err := a.Authenticate(ctx, username, "amy-pw")
+		if err != nil || user.Username != "amy" || user.Provider != LDAPProviderName || user.Role != CmsRoleArticleCreate|CmsRoleArticleEditSelf {
+			t.Errorf("unexpected user %+v of %v (error: %v)", user, username, err)
+		}
+	}
+	for _, c := range []struct {
+		username string
+		password string
+		err      error
+	}{
+		{"amy", "wrong", ErrAuthBadPassword},
+		{"amy", "", ErrAuthBadPassword},
+		{"nobody", "pw", ErrAuthUnknownUser},
+		{"amy)(uid=*", "amy-pw", ErrAuthUnknownUser},
+		{"bob", "bob-pw", ErrAuthNoRole},
+	} {
+		if _, err := a.Authenticate(ctx, c.username, c.password); err != c.err {
+			t.Errorf("expecting %v for %v, but got %v", c.err, c.username, err)
+		}
+	}
+
+	// groups from a search instead of the memberOf attribute
+	conf.LDAPGroupBaseDN = "ou=groups,dc=example,dc=com"
+	user, err := a.Authenticate(ctx, "bob", "bob-pw")
+	if err != nil || user.Role != CmsRoleArticlePublish {
+		t.Errorf("unexpected user %+v (error: %v)", user, err)
+	}
+}
+
+// the login test app with the ldap backend
+func testLDAPLoginApp(t *testing.T, server *stubLDAPServer) *AppRuntime {
+	app := testLoginApp(t)
+	conf := testLDAPConf(server.URL())
+	conf.SCookie, conf.SCookieMaxAge = app.Conf.SCookie, app.Conf.SCookieMaxAge
+	conf.SCookieRefresh, conf.SCookieRefreshMaxAge = app.Conf.SCookieRefresh, app.Conf.SCookieRefreshMaxAge
+	app.Conf = conf
+	var err error
+	if app.Authenticators, err = NewPasswordAuthenticators(app.Conf, app.Users); err != nil {
+		t.Fatalf("failed to create authenticators, error: %v", err)
+	}
+	return app
+}
+
+func TestLDAPLogin(t *testing.T) {
+	server := testLDAPServer(t)
+	defer server.Close()
+	app := testLDAPLoginApp(t, server)
+	login := func(body string) *HttpResponseData {
+		return Login()(app, nil, testAuthRequest(http.MethodPost, "/api/login", body))
+	}
+
+	d := login(`{"username":"amy","password":"amy-pw"}`)
+	body, _ := ioutil.ReadAll(d.Body)
+	token := &AuthToken{}
+	json.Unmarshal(body, token)
+	if d.Status != http.StatusOK || CmsRoleValue(token.Role) != CmsRoleArticleCreate|CmsRoleArticleEditSelf {
+		t.Errorf("unexpected ldap login response %v: %s", d.Status, body)
+	}
+	user, err := app.Users.GetUser(context.Background(), "amy")
+	if err != nil || user.Provider != LDAPProviderName || user.Password != "" {
+		t.Errorf("unexpected provisioned user %+v (error: %v)", user, err)
+	}
+
+	for _, c := range []struct {
+		body   string
+		status int
+	}{
+		{`{"username":"amy","password":"wrong"}`, http.StatusForbidden},
+		{`{"username":"bob","password":"bob-pw"}`, http.StatusForbidden},
+		// ldap can't take over the local user ed
+		{`{"username":"ed","password":"ed-pw"}`, http.StatusConflict},
+		{`{"username":"nobody","password":"pw"}`, http.StatusForbidden},
+	} {
+		if d := login(c.body); d.Status != c.status {
+			t.Errorf("expecting %v for %v, but got %v", c.status, c.body, d.Status)
+		}
+	}
+
+	// local users not in the directory still login with their password
+	server.setEntries(server.entries[0:2])
+	if d := login(`{"username":"ed","password":"pw"}`); d.Status != http.StatusOK {
+		t.Errorf("expecting local user login ok, but got %v", d.Status)
+	}
+
+	// the provisioned user can't login with a local password once it is
+	// gone from the directory
+	server.setEntries(server.entries[0:1])
+	if d := login(`{"username":"amy","password":"amy-pw"}`); d.Status != http.StatusForbidden || !bytes.Contains(readRespBody(d), []byte("disabled")) {
+		t.Errorf("expecting 403 for a provisioned user not in the directory, but got %v", d.Status)
+	}
+}
+
+func TestLDAPLoginServerDown(t *testing.T) {
+	server := testLDAPServer(t)
+	server.Close()
+	app := testLDAPLoginApp(t, server)
+	app.Users.CreateUser(context.Background(), &CmsUser{Username: "amy", Role: CmsRoleArticleCreate, Provider: LDAPProviderName})
+	for _, c := range []struct {
+		body   string
+		status int
+	}{
+		// local users can still login
+		{`{"username":"ed","password":"pw"}`, http.StatusOK},
+		{`{"username":"amy","password":"amy-pw"}`, http.StatusInternalServerError},
+		{`{"username":"nobody","password":"pw"}`, http.StatusInternalServerError},
+	} {
+		if d := Login()(app, nil, testAuthRequest(http.MethodPost, "/api/login", c.body)); d.Status != c.status {
+			t.Errorf("expecting %v for %v, but got %v", c.status, c.body, d.Status)
+		}
+	}
+}
+
+func readRespBody(d *HttpResponseData) []byte {
+	body, _ := ioutil.ReadAll(d.Body)
+	return body
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	//elastic "gopkg.in/olivere/elastic.v5"
+	"github.com/rs/xid"
+	elastic "github.com/yizha/elastic"
+)
+
+// Lock document stored in elasticsearch, one per locked key.
+type ESLockDoc struct {
+	Owner    string    `json:"owner"`
+	ExpireAt *JSONTime `json:"expire_at"`
+}
+
+// KeyLocker backed by elasticsearch documents so that it works across
+// api server instances.
+//
+// A lock is a document created with op_type=create, it is then only
+// updated/deleted with the document version it was last seen with
+// (optimistic concurrency control) so two instances never both think
+// they hold it. Each lock has a lease which is renewed while held, a
+// lock whose lease expired (e.g. the instance died) can be taken over.
+type ElasticLocker struct {
+	docs lockDocStore
+
+	// prefix of the lock doc id, e.g. "draft" or "publish"
+	name string
+
+	// lease ttl, the lease is renewed every ttl/3 while held
+	ttl time.Duration
+
+	// how long to wait for the lock before giving up
+	timeout time.Duration
+
+	// how long to wait before retrying to acquire the lock
+	retryInterval time.Duration
+
+	logger *JsonLogger
+}
+
+type elasticLock struct {
+	locker  *ElasticLocker
+	id      string
+	owner   string
+	l       *sync.Mutex
+	version int64
+
+	// when the lease (last put) expires
+	expireAt time.Time
+
+	done chan struct{}
+	lost chan struct{}
+}
+
+// Versioned storage of the lock docs, a put/delete with a version only
+// succeeds if the doc is still at that version.
+type lockDocStore interface {
+	// creates the doc (version 0) or replaces it, returns its new version,
+	// ErrStoreConflict if it exists (create) or is at another version
+	Put(ctx context.Context, id string, doc *ESLockDoc, version int64) (int64, error)
+
+	// returns the doc and its version, ErrStoreNotFound if there is none
+	Get(ctx context.Context, id string) (*ESLockDoc, int64, error)
+
+	// deletes the doc at the version
+	Delete(ctx context.Context, id string, version int64) error
+}
+
+// lockDocStore of the lock index in elasticsearch
+type esLockDocStore struct {
+	client *elastic.Client
+	index  string
+	typ    string
+}
+
+var esLockOwnerPrefix = func() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%v/%v", host, os.Getpid())
+}()
+
+func (l *ElasticLocker) doc(owner string) *ESLockDoc {
+	return &ESLockDoc{
+		Owner:    owner,
+		ExpireAt: &JSONTime{time.Now().UTC().Add(l.ttl)},
+	}
+}
+
+func (s *esLockDocStore) Put(ctx context.Context, id string, doc *ESLockDoc, version int64) (int64, error) {
+	idxService := s.client.Index()
+	idxService.Index(s.index)
+	idxService.Type(s.typ)
+	idxService.Id(id)
+	if version > 0 {
+		idxService.Version(version)
+	} else {
+		idxService.OpType(ESIndexOpCreate)
+	}
+	idxService.BodyJson(doc)
+	resp, err := idxService.Do(ctx)
+	if err != nil {
+		if elastic.IsConflict(err) {
+			return 0, ErrStoreConflict
+		}
+		return 0, err
+	}
+	return resp.Version, nil
+}
+
+func (s *esLockDocStore) Get(ctx context.Context, id string) (*ESLockDoc, int64, error) {
+	getService := s.client.Get()
+	getService.Index(s.index)
+	getService.Type(s.typ)
+	getService.Realtime(true)
+	getService.Id(id)
+	resp, err := getService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, 0, ErrStoreNotFound
+		}
+		return nil, 0, err
+	} else if !resp.Found || resp.Version == nil {
+		return nil, 0, ErrStoreNotFound
+	}
+	doc := &ESLockDoc{}
+	if err := json.Unmarshal(*resp.Source, doc); err != nil {
+		return nil, 0, err
+	}
+	return doc, *resp.Version, nil
+}
+
+func (s *esLockDocStore) Delete(ctx context.Context, id string, version int64) error {
+	delService := s.client.Delete()
+	delService.Index(s.index)
+	delService.Type(s.typ)
+	delService.Id(id)
+	delService.Version(version)
+	_, err := delService.Do(ctx)
+	return err
+}
+
+// one attempt to acquire the lock, returns the lock doc or nil if the
+// lock is held by someone else, and its version
+func (l *ElasticLocker) tryLock(ctx context.Context, id, owner string) (*ESLockDoc, int64, error) {
+	lockDoc := l.doc(owner)
+	version, err := l.docs.Put(ctx, id, lockDoc, 0)
+	if err == nil {
+		return lockDoc, version, nil
+	} else if err != ErrStoreConflict {
+		return nil, 0, err
+	}
+	// lock doc exists, take it over if its lease expired
+	doc, version, err := l.docs.Get(ctx, id)
+	if err == ErrStoreNotFound { // just unlocked
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+	if doc.ExpireAt != nil && doc.ExpireAt.T.After(time.Now().UTC()) {
+		return nil, 0, nil
+	}
+	version, err = l.docs.Put(ctx, id, lockDoc, version)
+	if err == nil {
+		l.logger.Pwarnf("took over lock %v from %v, lease expired at %v", id, doc.Owner, doc.ExpireAt.T)
+		return lockDoc, version, nil
+	} else if err == ErrStoreConflict { // someone else took it over first
+		return nil, 0, nil
+	}
+	return nil, 0, err
+}
+
+func (l *ElasticLocker) Lock(key string) (KeyLock, error) {
+	id := fmt.Sprintf("%v:%v", l.name, key)
+	owner := fmt.Sprintf("%v/%v", esLockOwnerPrefix, xid.New().String())
+	ctx := context.Background()
+	deadline := time.Now().Add(l.timeout)
+	for {
+		doc, version, err := l.tryLock(ctx, id, owner)
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			lock := &elasticLock{
+				locker:   l,
+				id:       id,
+				owner:    owner,
+				l:        &sync.Mutex{},
+				version:  version,
+				expireAt: doc.ExpireAt.T,
+				done:     make(chan struct{}),
+				lost:     make(chan struct{}),
+			}
+			go lock.renew()
+			return lock, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, ErrLockTimeout
+		}
+		time.Sleep(l.retryInterval)
+	}
+}
+
+// Renews the lease until unlocked. The lock is lost if someone else took
+// it over (conflict) or the lease expired before it could be renewed.
+func (lock *elasticLock) renew() {
+	l := lock.locker
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lock.done:
+			return
+		case <-ticker.C:
+			lock.l.Lock()
+			doc := l.doc(lock.owner)
+			version, err := l.docs.Put(context.Background(), lock.id, doc, lock.version)
+			if err == nil {
+				lock.version = version
+				lock.expireAt = doc.ExpireAt.T
+			} else {
+				l.logger.Perrorf("failed to renew lock %v, error: %v", lock.id, err)
+			}
+			lost := err == ErrStoreConflict || (err != nil && !time.Now().UTC().Before(lock.expireAt))
+			if lost {
+				l.logger.Perrorf("lost lock %v, its lease was renewed until %v", lock.id, lock.expireAt)
+				close(lock.lost)
+			}
+			lock.l.Unlock()
+			if lost {
+				return
+			}
+		}
+	}
+}
+
+func (lock *elasticLock) Unlock() {
+	close(lock.done)
+	lock.l.Lock()
+	defer lock.l.Unlock()
+	l := lock.locker
+	if LockLost(lock) { // the lock doc (if any) is someone else's now
+		return
+	}
+	if err := l.docs.Delete(context.Background(), lock.id, lock.version); err != nil {
+		l.logger.Perrorf("failed to unlock %v, error: %v", lock.id, err)
+	}
+}
+
+func (lock *elasticLock) Lost() <-chan struct{} {
+	return lock.lost
+}
+
+func NewElasticLocker(es *Elastic, conf *AppConf, name string, logger *JsonLogger) *ElasticLocker {
+	return &ElasticLocker{
+		docs: &esLockDocStore{
+			client: es.Client,
+			index:  conf.LockIndex.Name,
+			typ:    conf.LockIndexTypes.Lock,
+		},
+		name:          name,
+		ttl:           conf.LockTTL,
+		timeout:       conf.LockTimeout,
+		retryInterval: 100 * time.Millisecond,
+		logger:        logger,
+	}
+}
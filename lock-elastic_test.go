@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memLockDoc struct {
+	doc     *ESLockDoc
+	version int64
+}
+
+// in-memory lockDocStore with elasticsearch versioning, puts fail while
+// down is set like when elasticsearch can't be reached
+type memLockDocStore struct {
+	l    *sync.Mutex
+	docs map[string]*memLockDoc
+	down bool
+}
+
+func newMemLockDocStore() *memLockDocStore {
+	return &memLockDocStore{l: &sync.Mutex{}, docs: make(map[string]*memLockDoc)}
+}
+
+func (s *memLockDocStore) Put(ctx context.Context, id string, doc *ESLockDoc, version int64) (int64, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.down {
+		return 0, errors.New("elasticsearch is down")
+	}
+	d, ok := s.docs[id]
+	if (version == 0 && ok) || (version > 0 && (!ok || d.version != version)) {
+		return 0, ErrStoreConflict
+	}
+	s.docs[id] = &memLockDoc{doc: doc, version: version + 1}
+	return version + 1, nil
+}
+
+func (s *memLockDocStore) Get(ctx context.Context, id string) (*ESLockDoc, int64, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	d, ok := s.docs[id]
+	if !ok {
+		return nil, 0, ErrStoreNotFound
+	}
+	return d.doc, d.version, nil
+}
+
+func (s *memLockDocStore) Delete(ctx context.Context, id string, version int64) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if d, ok := s.docs[id]; !ok || d.version != version {
+		return ErrStoreConflict
+	}
+	delete(s.docs, id)
+	return nil
+}
+
+func (s *memLockDocStore) setDown(down bool) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.down = down
+}
+
+func testElasticLocker(docs lockDocStore, ttl time.Duration) *ElasticLocker {
+	return &ElasticLocker{
+		docs:          docs,
+		name:          "test",
+		ttl:           ttl,
+		timeout:       50 * time.Millisecond,
+		retryInterval: 5 * time.Millisecond,
+		logger:        NewJsonLogger(ioutil.Discard),
+	}
+}
+
+func TestElasticLockerLock(t *testing.T) {
+	docs := newMemLockDocStore()
+	locker := testElasticLocker(docs, time.Minute)
+	lock, err := locker.Lock("a")
+	if err != nil {
+		t.Fatalf("failed to lock a, error: %v", err)
+	}
+	if _, err := locker.Lock("a"); err != ErrLockTimeout {
+		t.Errorf("expecting a locked, but got error %v", err)
+	}
+	lockB, err := locker.Lock("b")
+	if err != nil {
+		t.Fatalf("failed to lock b while a is locked, error: %v", err)
+	}
+	lockB.Unlock()
+	lock.Unlock()
+	if _, _, err := docs.Get(context.Background(), "test:a"); err != ErrStoreNotFound {
+		t.Errorf("expecting lock doc deleted on unlock, but got error %v", err)
+	}
+	lock, err = locker.Lock("a")
+	if err != nil {
+		t.Fatalf("failed to lock a again, error: %v", err)
+	}
+	lock.Unlock()
+	if LockLost(lock) {
+		t.Errorf("expecting the unlocked lock not lost")
+	}
+}
+
+func TestElasticLockerRenew(t *testing.T) {
+	docs := newMemLockDocStore()
+	locker := testElasticLocker(docs, 60*time.Millisecond)
+	lock, err := locker.Lock("a")
+	if err != nil {
+		t.Fatalf("failed to lock a, error: %v", err)
+	}
+	defer lock.Unlock()
+	// held (and renewed) for a few ttl
+	time.Sleep(200 * time.Millisecond)
+	doc, version, err := docs.Get(context.Background(), "test:a")
+	if err != nil || version < 3 || !doc.ExpireAt.T.After(time.Now().UTC()) {
+		t.Errorf("expecting the lease renewed, but got %+v at version %v (error: %v)", doc, version, err)
+	}
+	if _, err := locker.Lock("a"); err != ErrLockTimeout {
+		t.Errorf("expecting renewed lock not taken over, but got error %v", err)
+	}
+	if LockLost(lock) {
+		t.Errorf("expecting the renewed lock not lost")
+	}
+}
+
+func TestElasticLockerExpired(t *testing.T) {
+	docs := newMemLockDocStore()
+	expired := &ESLockDoc{Owner: "dead", ExpireAt: &JSONTime{time.Now().UTC().Add(-time.Second)}}
+	if _, err := docs.Put(context.Background(), "test:a", expired, 0); err != nil {
+		t.Fatalf("failed to put lock doc, error: %v", err)
+	}
+	lock, err := testElasticLocker(docs, time.Minute).Lock("a")
+	if err != nil {
+		t.Fatalf("expecting the expired lock taken over, but got error %v", err)
+	}
+	defer lock.Unlock()
+	if doc, _, _ := docs.Get(context.Background(), "test:a"); doc == nil || doc.Owner == "dead" {
+		t.Errorf("expecting the lock doc owned by the locker, but got %+v", doc)
+	}
+}
+
+func TestElasticLockerLost(t *testing.T) {
+	ctx := context.Background()
+	waitLost := func(lock KeyLock) bool {
+		select {
+		case <-lock.Lost():
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+	// taken over by someone else
+	docs := newMemLockDocStore()
+	locker := testElasticLocker(docs, 60*time.Millisecond)
+	lock, err := locker.Lock("a")
+	if err != nil {
+		t.Fatalf("failed to lock a, error: %v", err)
+	}
+	_, version, _ := docs.Get(ctx, "test:a")
+	other := &ESLockDoc{Owner: "other", ExpireAt: &JSONTime{time.Now().UTC().Add(time.Minute)}}
+	if _, err := docs.Put(ctx, "test:a", other, version); err != nil {
+		t.Fatalf("failed to take over lock doc, error: %v", err)
+	}
+	if !waitLost(lock) {
+		t.Errorf("expecting the lock taken over lost")
+	}
+	lock.Unlock()
+	if doc, _, _ := docs.Get(ctx, "test:a"); doc == nil || doc.Owner != "other" {
+		t.Errorf("expecting the lock doc of the new owner kept on unlock, but got %+v", doc)
+	}
+
+	// lease expired as it could not be renewed
+	lock, err = locker.Lock("b")
+	if err != nil {
+		t.Fatalf("failed to lock b, error: %v", err)
+	}
+	docs.setDown(true)
+	start := time.Now()
+	if !waitLost(lock) {
+		t.Errorf("expecting the lock not renewed lost")
+	} else if time.Since(start) < 20*time.Millisecond {
+		t.Errorf("expecting the lock lost once its lease expired, but lost after %v", time.Since(start))
+	}
+	docs.setDown(false)
+	lock.Unlock()
+}
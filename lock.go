@@ -1,9 +1,39 @@
 package main
 
 import (
+	"errors"
 	"sync"
 )
 
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+// Lock acquired from a KeyLocker
+type KeyLock interface {
+	Unlock()
+
+	// closed once the lock is lost while held (e.g. its lease could not be
+	// renewed in time and may be taken over), nil if it can't be lost
+	Lost() <-chan struct{}
+}
+
+// returns true if the lock was lost, the holder must not go on with
+// changes it guards
+func LockLost(lock KeyLock) bool {
+	select {
+	case <-lock.Lost():
+		return true
+	default:
+		return false
+	}
+}
+
+// Locks on string keys (e.g. article guid). UniqStrMutex is the
+// single-node implementation, ElasticLocker works across instances.
+type KeyLocker interface {
+	// blocks until the lock on the key is acquired, or fails
+	Lock(key string) (KeyLock, error)
+}
+
 type StrMutex struct {
 	parent *UniqStrMutex
 	value  string
@@ -20,6 +50,11 @@ func (sm *StrMutex) Unlock() {
 	sm.parent.return_(sm)
 }
 
+// a local lock is never lost
+func (sm *StrMutex) Lost() <-chan struct{} {
+	return nil
+}
+
 type UniqStrMutex struct {
 	l     *sync.Mutex
 	locks map[string]*StrMutex
@@ -49,6 +84,12 @@ func (l *UniqStrMutex) return_(sm *StrMutex) {
 	}
 }
 
+func (l *UniqStrMutex) Lock(val string) (KeyLock, error) {
+	sm := l.Get(val)
+	sm.Lock()
+	return sm, nil
+}
+
 func NewUniqStrMutex() *UniqStrMutex {
 	return &UniqStrMutex{
 		l:     &sync.Mutex{},
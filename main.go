@@ -8,15 +8,30 @@ import (
 	"os"
 	"path"
 	//"time"
-
-	elastic "github.com/yizha/elastic"
 )
 
 type AppRuntime struct {
-	Logger        *JsonLogger
-	Conf          *AppConf
-	Elastic       *Elastic
-	StaticMapping map[string]string
+	Logger         *JsonLogger
+	Conf           *AppConf
+	Elastic        *Elastic // nil unless storing in elasticsearch
+	Articles       ArticleStore
+	Users          UserStore
+	Schedules      ScheduleStore
+	Taxonomy       TaxonomyStore
+	Media          MediaStore
+	MediaStorage   MediaStorage
+	MediaCache     *MediaCache
+	MediaResizer   *MediaResizer
+	Sitemaps       *SitemapCache
+	DraftLock      KeyLocker
+	PublishLock    KeyLocker
+	ScheduleLock   KeyLocker
+	TaxonomyLock   KeyLocker
+	Sessions       SessionStore
+	Authenticators []PasswordAuthenticator
+	JWTKeys        *JWTKeySet    // nil unless issuing jwt
+	OIDC           *OIDCProvider // nil unless sso is configured
+	StaticMapping  map[string]string
 }
 
 func createFirstUser(app *AppRuntime) {
@@ -32,24 +47,12 @@ func createFirstUser(app *AppRuntime) {
 		Role:     CmsRoleLoginManage,
 	}
 	ctx := context.Background()
-	getService := app.Elastic.Client.Get()
-	getService.Index(app.Conf.UserIndex.Name)
-	getService.Index(app.Conf.UserIndexTypes.User)
-	getService.Id(username)
-	_, err = getService.Do(ctx)
-	if err != nil {
-		if elastic.IsNotFound(err) {
-			idxService := app.Elastic.Client.Index()
-			idxService.Index(app.Conf.UserIndex.Name)
-			idxService.Type(app.Conf.UserIndexTypes.User)
-			idxService.Id(username)
-			idxService.BodyJson(user)
-			_, err := idxService.Do(ctx)
-			if err != nil {
-				panic(err)
-			}
-			app.Logger.Pinfof("created user: %v, password: %v, role: %v", username, password, CmsRoleLoginManageName)
+	_, err = app.Users.GetUser(ctx, username)
+	if err == ErrStoreNotFound {
+		if err := app.Users.CreateUser(ctx, user); err != nil {
+			panic(err)
 		}
+		app.Logger.Pinfof("created user: %v, password: %v, role: %v", username, password, CmsRoleLoginManageName)
 	}
 }
 
@@ -66,6 +69,33 @@ func createIndices(app *AppRuntime) {
 	} else {
 		panic(fmt.Sprintf("failed to create index %v, error: %v", conf.UserIndex.Name, msg))
 	}
+	if ok, msg := app.Elastic.CreateIndex(conf.ScheduleIndex); ok {
+		logger.Pinfo(msg)
+	} else {
+		panic(fmt.Sprintf("failed to create index %v, error: %v", conf.ScheduleIndex.Name, msg))
+	}
+	if ok, msg := app.Elastic.CreateIndex(conf.TaxonomyIndex); ok {
+		logger.Pinfo(msg)
+	} else {
+		panic(fmt.Sprintf("failed to create index %v, error: %v", conf.TaxonomyIndex.Name, msg))
+	}
+	if ok, msg := app.Elastic.CreateIndex(conf.MediaIndex); ok {
+		logger.Pinfo(msg)
+	} else {
+		panic(fmt.Sprintf("failed to create index %v, error: %v", conf.MediaIndex.Name, msg))
+	}
+	if ok, msg := app.Elastic.CreateIndex(conf.SessionIndex); ok {
+		logger.Pinfo(msg)
+	} else {
+		panic(fmt.Sprintf("failed to create index %v, error: %v", conf.SessionIndex.Name, msg))
+	}
+	if conf.Lock == LockTypeElastic {
+		if ok, msg := app.Elastic.CreateIndex(conf.LockIndex); ok {
+			logger.Pinfo(msg)
+		} else {
+			panic(fmt.Sprintf("failed to create index %v, error: %v", conf.LockIndex.Name, msg))
+		}
+	}
 }
 
 func loadStaticMapping(app *AppRuntime) {
@@ -87,7 +117,9 @@ func loadStaticMapping(app *AppRuntime) {
 }
 
 func bootstrap(app *AppRuntime) {
-	createIndices(app)
+	if app.Elastic != nil {
+		createIndices(app)
+	}
 	createFirstUser(app)
 	loadStaticMapping(app)
 }
@@ -106,20 +138,110 @@ func main() {
 	})
 	logger.Pinfof("starting with conf: %v", conf.String())
 
-	// init elasticsearch client
-	elastic, err := NewElastic(conf.ESHosts)
-	if err != nil {
-		panic(err)
-	}
-
 	app := &AppRuntime{
 		Logger:        logger,
 		Conf:          conf,
-		Elastic:       elastic,
 		StaticMapping: make(map[string]string),
 	}
 
+	// init storage
+	switch conf.Store {
+	case StoreTypeMemory:
+		app.Articles = NewMemoryArticleStore(conf.ArticleIndexTypes)
+		app.Users = NewMemoryUserStore()
+		app.Schedules = NewMemoryScheduleStore()
+		app.Taxonomy = NewMemoryTaxonomyStore()
+		app.Media = NewMemoryMediaStore()
+		app.Sessions = NewMemorySessionStore()
+	case StoreTypeBolt:
+		db, err := OpenBoltDB(conf.BoltPath)
+		if err != nil {
+			panic(fmt.Sprintf("failed to open bolt db %v, error: %v", conf.BoltPath, err))
+		}
+		if app.Articles, err = NewBoltArticleStore(db, conf.ArticleIndexTypes); err != nil {
+			panic(err)
+		}
+		if app.Users, err = NewBoltUserStore(db); err != nil {
+			panic(err)
+		}
+		if app.Schedules, err = NewBoltScheduleStore(db); err != nil {
+			panic(err)
+		}
+		if app.Taxonomy, err = NewBoltTaxonomyStore(db); err != nil {
+			panic(err)
+		}
+		if app.Media, err = NewBoltMediaStore(db); err != nil {
+			panic(err)
+		}
+		if app.Sessions, err = NewBoltSessionStore(db); err != nil {
+			panic(err)
+		}
+	default:
+		// init elasticsearch client
+		elastic, err := NewElastic(conf.ESHosts)
+		if err != nil {
+			panic(err)
+		}
+		app.Elastic = elastic
+		app.Articles = NewElasticArticleStore(elastic, conf, logger)
+		app.Users = NewElasticUserStore(elastic, conf, logger)
+		app.Schedules = NewElasticScheduleStore(elastic, conf, logger)
+		app.Taxonomy = NewElasticTaxonomyStore(elastic, conf, logger)
+		app.Media = NewElasticMediaStore(elastic, conf, logger)
+		app.Sessions = NewElasticSessionStore(elastic, conf, logger)
+	}
+
+	// the taxonomy is cached for resolving tags
+	app.Taxonomy = NewCachedTaxonomyStore(app.Taxonomy, taxonomyCacheTTL)
+
+	// init media storage
+	if mediaStorage, err := NewLocalMediaStorage(conf.MediaDir); err == nil {
+		app.MediaStorage = mediaStorage
+	} else {
+		panic(fmt.Sprintf("failed to init media storage in %v, error: %v", conf.MediaDir, err))
+	}
+	if app.MediaCache, err = NewMediaCache(conf.MediaCacheDir, conf.MediaCacheSize); err != nil {
+		panic(fmt.Sprintf("failed to init media cache in %v, error: %v", conf.MediaCacheDir, err))
+	}
+	app.MediaResizer = NewMediaResizer(conf.MediaResizeConcurrency)
+
+	// init sitemap cache
+	app.Sitemaps = NewSitemapCache(sitemapCacheTTL)
+
+	// init article locks
+	if conf.Lock == LockTypeElastic {
+		app.DraftLock = NewElasticLocker(app.Elastic, conf, "draft", logger)
+		app.PublishLock = NewElasticLocker(app.Elastic, conf, "publish", logger)
+		app.ScheduleLock = NewElasticLocker(app.Elastic, conf, "schedule", logger)
+		app.TaxonomyLock = NewElasticLocker(app.Elastic, conf, "taxonomy", logger)
+	} else {
+		app.DraftLock = NewUniqStrMutex()
+		app.PublishLock = NewUniqStrMutex()
+		app.ScheduleLock = NewUniqStrMutex()
+		app.TaxonomyLock = NewUniqStrMutex()
+	}
+
+	// load jwt signing keys
+	if conf.TokenFormat == TokenFormatJWT {
+		if app.JWTKeys, err = NewJWTKeySet(conf.JWTKeyDir, conf.JWTAlg); err != nil {
+			panic(fmt.Sprintf("failed to load jwt keys from %v, error: %v", conf.JWTKeyDir, err))
+		}
+		logger.Pinfof("signing jwt with key %v", app.JWTKeys.SignerKid())
+	}
+
+	if app.Authenticators, err = NewPasswordAuthenticators(conf, app.Users); err != nil {
+		panic(fmt.Sprintf("failed to create %v authenticator, error: %v", conf.AuthBackend, err))
+	}
+
+	if conf.OIDCIssuer != "" {
+		app.OIDC = NewOIDCProvider(conf, nil)
+	}
+
 	bootstrap(app)
 
+	StartJWTKeyReloader(app)
+
+	StartDraftReconciler(app)
+
 	StartAPIServer(app)
 }
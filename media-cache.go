@@ -0,0 +1,139 @@
+package main
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type mediaCacheEntry struct {
+	key  string
+	size int64
+}
+
+// Bounded on-disk cache of media variants (resized images). Once the
+// files take more than maxSize bytes the least recently used ones are
+// removed.
+type MediaCache struct {
+	dir     string
+	maxSize int64
+
+	l       *sync.Mutex
+	size    int64
+	lru     *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+func (c *MediaCache) path(key string) string {
+	return filepath.Join(c.dir, key)
+}
+
+// must be called with the lock held
+func (c *MediaCache) remove(e *list.Element) {
+	entry := e.Value.(*mediaCacheEntry)
+	c.lru.Remove(e)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	os.Remove(c.path(entry.key))
+}
+
+// must be called with the lock held
+func (c *MediaCache) add(key string, size int64) {
+	if e, ok := c.entries[key]; ok {
+		c.size -= e.Value.(*mediaCacheEntry).size
+		c.lru.Remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(&mediaCacheEntry{key: key, size: size})
+	c.size += size
+	for c.size > c.maxSize && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *MediaCache) Get(key string) ([]byte, bool) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return data, true
+}
+
+// Caches the variant unless it alone is larger than the cache
+func (c *MediaCache) Put(key string, data []byte) error {
+	size := int64(len(data))
+	if size > c.maxSize {
+		return nil
+	}
+	f, err := ioutil.TempFile(c.dir, ".variant-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	c.l.Lock()
+	defer c.l.Unlock()
+	if err == nil {
+		err = os.Rename(f.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	c.add(key, size)
+	return nil
+}
+
+// Total size of the cached variants
+func (c *MediaCache) Size() int64 {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.size
+}
+
+// Creates the cache in the directory, variants already in it are kept
+// (the most recently modified ones as the most recently used) as long as
+// they fit in maxSize.
+func NewMediaCache(dir string, maxSize int64) (*MediaCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &MediaCache{
+		dir:     dir,
+		maxSize: maxSize,
+		l:       &sync.Mutex{},
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, fi := range files {
+		if fi.IsDir() {
+			continue
+		}
+		if strings.HasPrefix(fi.Name(), ".") { // left behind by a failed put
+			os.Remove(c.path(fi.Name()))
+			continue
+		}
+		c.add(fi.Name(), fi.Size())
+	}
+	return c, nil
+}
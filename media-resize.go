@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"sync"
+	"time"
+)
+
+type MediaFit string
+
+const (
+	// scale down to fit within the box, keeping the aspect ratio
+	MediaFitContain MediaFit = "contain"
+
+	// scale down to cover the box and crop the overflow (centered)
+	MediaFitCover MediaFit = "cover"
+
+	// max width/height of a variant
+	maxMediaVariantSide = 4096
+
+	// images with more pixels than this are rejected on upload (and not
+	// decoded for resizing), decoded it takes 4 bytes per pixel
+	maxMediaResizePixels = 25000000
+
+	mediaVariantJpegQuality = 85
+
+	// how long a resize waits for a free slot
+	mediaResizeWait = 10 * time.Second
+)
+
+var ErrMediaResizeBusy = errors.New("too many media resizes in progress")
+
+func parseMediaFit(s string) (MediaFit, error) {
+	switch f := MediaFit(s); f {
+	case "":
+		return MediaFitContain, nil
+	case MediaFitContain, MediaFitCover:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid fit %v, must be %v or %v", s, MediaFitContain, MediaFitCover)
+	}
+}
+
+// Snaps the requested width/height of a variant up to the nearest of the
+// (sorted) sizes, or down to the largest one, so that only a bounded
+// number of variants can be requested. 0 (follow the aspect ratio) is
+// kept.
+func snapMediaVariantSide(n int, sizes []int) int {
+	if n <= 0 || len(sizes) == 0 {
+		return n
+	}
+	for _, size := range sizes {
+		if size >= n {
+			return size
+		}
+	}
+	return sizes[len(sizes)-1]
+}
+
+// Computes the part of the (sw x sh) source image to use and the size
+// (dw x dh) to scale it to for a w x h variant, either of w and h can be
+// 0 to follow the aspect ratio. Images are never scaled up.
+func mediaVariantGeometry(sw, sh, w, h int, fit MediaFit) (src image.Rectangle, dw, dh int) {
+	src = image.Rect(0, 0, sw, sh)
+	if w <= 0 && h <= 0 {
+		return src, sw, sh
+	}
+	fsw, fsh := float64(sw), float64(sh)
+	if w <= 0 {
+		w = int(math.Max(1, math.Floor(fsw*float64(h)/fsh+0.5)))
+	} else if h <= 0 {
+		h = int(math.Max(1, math.Floor(fsh*float64(w)/fsw+0.5)))
+	}
+	fw, fh := float64(w), float64(h)
+	if fit == MediaFitCover {
+		scale := math.Max(fw/fsw, fh/fsh)
+		if scale > 1 {
+			// shrink the box instead of scaling up
+			fw, fh, scale = fw/scale, fh/scale, 1
+		}
+		cw := int(math.Min(fsw, math.Floor(fw/scale+0.5)))
+		ch := int(math.Min(fsh, math.Floor(fh/scale+0.5)))
+		x0, y0 := (sw-cw)/2, (sh-ch)/2
+		src = image.Rect(x0, y0, x0+cw, y0+ch)
+		dw = int(math.Max(1, math.Floor(fw+0.5)))
+		dh = int(math.Max(1, math.Floor(fh+0.5)))
+		return src, dw, dh
+	}
+	scale := math.Min(1, math.Min(fw/fsw, fh/fsh))
+	dw = int(math.Max(1, math.Floor(fsw*scale+0.5)))
+	dh = int(math.Max(1, math.Floor(fsh*scale+0.5)))
+	return src, dw, dh
+}
+
+// Scales the src part of the image down to w x h, each destination pixel
+// is the average of the source pixels it covers (box filter)
+func resampleImage(img *image.RGBA, src image.Rectangle, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := src.Dx(), src.Dy()
+	for y := 0; y < h; y++ {
+		y0 := src.Min.Y + y*sh/h
+		y1 := src.Min.Y + ((y+1)*sh+h-1)/h
+		for x := 0; x < w; x++ {
+			x0 := src.Min.X + x*sw/w
+			x1 := src.Min.X + ((x+1)*sw+w-1)/w
+			var r, g, b, a uint64
+			for sy := y0; sy < y1; sy++ {
+				i := img.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(img.Pix[i])
+					g += uint64(img.Pix[i+1])
+					b += uint64(img.Pix[i+2])
+					a += uint64(img.Pix[i+3])
+					i += 4
+				}
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+			j := dst.PixOffset(x, y)
+			dst.Pix[j] = uint8((r + n/2) / n)
+			dst.Pix[j+1] = uint8((g + n/2) / n)
+			dst.Pix[j+2] = uint8((b + n/2) / n)
+			dst.Pix[j+3] = uint8((a + n/2) / n)
+		}
+	}
+	return dst
+}
+
+// Generates a w x h variant of the image, returns its content and content
+// type. JPEG images stay JPEG, others (PNG/GIF) become PNG.
+func ResizeMedia(data []byte, w, h int, fit MediaFit) ([]byte, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if cfg.Width*cfg.Height > maxMediaResizePixels {
+		return nil, "", fmt.Errorf("image (%vx%v) is too large to resize", cfg.Width, cfg.Height)
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	src, dw, dh := mediaVariantGeometry(bounds.Dx(), bounds.Dy(), w, h, fit)
+	variant := rgba
+	if dw != src.Dx() || dh != src.Dy() {
+		variant = resampleImage(rgba, src, dw, dh)
+	} else if src != rgba.Bounds() {
+		variant = rgba.SubImage(src).(*image.RGBA)
+	}
+	buf := &bytes.Buffer{}
+	if format == "jpeg" {
+		err = jpeg.Encode(buf, variant, &jpeg.Options{Quality: mediaVariantJpegQuality})
+		return buf.Bytes(), "image/jpeg", err
+	}
+	err = png.Encode(buf, variant)
+	return buf.Bytes(), "image/png", err
+}
+
+type mediaResizeCall struct {
+	done        chan struct{}
+	data        []byte
+	contentType string
+	err         error
+}
+
+// Runs the resizes of media variants, identical concurrent resizes (of
+// the same variant key) are done once with the result shared, and at most
+// concurrency of them are running at the same time.
+type MediaResizer struct {
+	slots chan struct{}
+	wait  time.Duration
+
+	l     *sync.Mutex
+	calls map[string]*mediaResizeCall
+}
+
+func NewMediaResizer(concurrency int) *MediaResizer {
+	return &MediaResizer{
+		slots: make(chan struct{}, concurrency),
+		wait:  mediaResizeWait,
+		l:     &sync.Mutex{},
+		calls: make(map[string]*mediaResizeCall),
+	}
+}
+
+// Calls resize for the key, or waits for the one in progress for the same
+// key and returns its result. ErrMediaResizeBusy is returned if no slot
+// frees up in time. If resize panics the slot is released and the waiters
+// get an error before the panic goes on.
+func (r *MediaResizer) Do(key string, resize func() ([]byte, string, error)) ([]byte, string, error) {
+	r.l.Lock()
+	if call, ok := r.calls[key]; ok {
+		r.l.Unlock()
+		<-call.done
+		return call.data, call.contentType, call.err
+	}
+	call := &mediaResizeCall{done: make(chan struct{})}
+	r.calls[key] = call
+	r.l.Unlock()
+	defer func() {
+		r.l.Lock()
+		delete(r.calls, key)
+		r.l.Unlock()
+		close(call.done)
+	}()
+
+	timer := time.NewTimer(r.wait)
+	select {
+	case r.slots <- struct{}{}:
+		timer.Stop()
+		defer func() { <-r.slots }()
+		// what the waiters get if resize panics
+		call.err = fmt.Errorf("failed to resize %v", key)
+		call.data, call.contentType, call.err = resize()
+	case <-timer.C:
+		call.err = ErrMediaResizeBusy
+	}
+	return call.data, call.contentType, call.err
+}
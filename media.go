@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// path the frontend serves media assets under
+const MediaPathPrefix = "/media/"
+
+// content types of the media assets that can be uploaded
+var mediaContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// Metadata of an uploaded media asset. The id is the (hex) sha256 of the
+// content so the same file uploaded twice ends up as the same asset.
+type MediaAsset struct {
+	Id          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"width"`
+	Height      int       `json:"height"`
+	Caption     string    `json:"caption"`
+	Credit      string    `json:"credit"`
+	Alt         string    `json:"alt"`
+	CreatedAt   *JSONTime `json:"created_at"`
+	CreatedBy   string    `json:"created_by"`
+	RevisedAt   *JSONTime `json:"revised_at"`
+	RevisedBy   string    `json:"revised_by"`
+}
+
+// Reference to a media asset from an article, blank alt/caption/credit
+// are filled in from the asset when the article is saved
+type ArticleMedia struct {
+	Id      string `json:"id"`
+	Alt     string `json:"alt,omitempty"`
+	Caption string `json:"caption,omitempty"`
+	Credit  string `json:"credit,omitempty"`
+}
+
+func sameArticleMedia(a, b *ArticleMedia) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func MediaPath(id string) string {
+	return MediaPathPrefix + id
+}
+
+func MediaHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Checks the uploaded content is a supported image and returns the asset
+// (id, content type, size and dimensions set)
+func InspectMedia(data []byte) (*MediaAsset, error) {
+	contentType := http.DetectContentType(data)
+	if !mediaContentTypes[contentType] {
+		return nil, fmt.Errorf("unsupported content type %v", contentType)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %v image, error: %v", contentType, err)
+	}
+	if cfg.Width*cfg.Height > maxMediaResizePixels {
+		return nil, fmt.Errorf("image (%vx%v) is too large, at most %v pixels are allowed", cfg.Width, cfg.Height, maxMediaResizePixels)
+	}
+	return &MediaAsset{
+		Id:          MediaHash(data),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+	}, nil
+}
+
+func isMediaId(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+func sortMediaAssets(assets []*MediaAsset, size int) []*MediaAsset {
+	sort.Slice(assets, func(i, j int) bool {
+		x, y := assets[i].CreatedAt, assets[j].CreatedAt
+		if x != nil && y != nil && !x.T.Equal(y.T) {
+			return x.T.After(y.T)
+		}
+		return assets[i].Id < assets[j].Id
+	})
+	if size > 0 && len(assets) > size {
+		assets = assets[0:size]
+	}
+	return assets
+}
+
+// MediaStorage keeps the content of media assets (the metadata is in
+// MediaStore). Only the local filesystem is supported for now, object
+// storage can be plugged in by implementing it.
+type MediaStorage interface {
+
+	// Store the content under the key, storing the same key again
+	// replaces the content
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Open the content, returns ErrStoreNotFound if there isn't any
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// Stores media content in files under a directory, sharded by the first
+// 2 characters of the key
+type LocalMediaStorage struct {
+	dir string
+}
+
+func (s *LocalMediaStorage) path(key string) string {
+	if len(key) < 3 {
+		return filepath.Join(s.dir, key)
+	}
+	return filepath.Join(s.dir, key[0:2], key)
+}
+
+// writes to a temp file and renames it, so that a file is never seen
+// half-written
+func (s *LocalMediaStorage) Put(ctx context.Context, key string, data []byte) error {
+	p := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (s *LocalMediaStorage) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrStoreNotFound
+	}
+	return f, err
+}
+
+func NewLocalMediaStorage(dir string) (*LocalMediaStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalMediaStorage{dir: dir}, nil
+}
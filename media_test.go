@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testPNG(t *testing.T, w, h int) []byte {
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatalf("failed to encode png, error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestInspectMedia(t *testing.T) {
+	data := testPNG(t, 4, 3)
+	asset, err := InspectMedia(data)
+	if err != nil {
+		t.Errorf("failed to inspect png, error: %v", err)
+		return
+	}
+	if asset.ContentType != "image/png" || asset.Width != 4 || asset.Height != 3 || asset.Size != int64(len(data)) {
+		t.Errorf("unexpected asset %+v", asset)
+	}
+	if !isMediaId(asset.Id) || asset.Id != MediaHash(data) {
+		t.Errorf("unexpected asset id %v", asset.Id)
+	}
+	if _, err := InspectMedia([]byte("<html><script>alert(1)</script></html>")); err == nil {
+		t.Errorf("expecting non-image rejected, but it isn't")
+	}
+	if _, err := InspectMedia(data[0:20]); err == nil {
+		t.Errorf("expecting broken image rejected, but it isn't")
+	}
+	// only the header is decoded, set a huge size in it
+	huge := append([]byte{}, data...)
+	binary.BigEndian.PutUint32(huge[16:], 6000)
+	binary.BigEndian.PutUint32(huge[20:], 5000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+	if _, err := InspectMedia(huge); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("expecting too large image rejected, but got %v", err)
+	}
+}
+
+func TestLocalMediaStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "media")
+	if err != nil {
+		t.Fatalf("failed to create temp dir, error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	storage, err := NewLocalMediaStorage(dir)
+	if err != nil {
+		t.Fatalf("failed to create storage, error: %v", err)
+	}
+	ctx := context.Background()
+	data := testPNG(t, 1, 1)
+	id := MediaHash(data)
+	if _, err := storage.Open(ctx, id); err != ErrStoreNotFound {
+		t.Errorf("expecting ErrStoreNotFound, but got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := storage.Put(ctx, id, data); err != nil {
+			t.Errorf("failed to put media, error: %v", err)
+			return
+		}
+	}
+	f, err := storage.Open(ctx, id)
+	if err != nil {
+		t.Errorf("failed to open media, error: %v", err)
+		return
+	}
+	defer f.Close()
+	if stored, _ := ioutil.ReadAll(f); !bytes.Equal(stored, data) {
+		t.Errorf("stored content differs from the uploaded one")
+	}
+}
+
+func TestMemoryMediaStore(t *testing.T) {
+	store := NewMemoryMediaStore()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for i, id := range []string{"a", "b", "c"} {
+		asset := &MediaAsset{Id: id, CreatedAt: &JSONTime{now.Add(time.Duration(i) * time.Second)}}
+		if err := store.CreateMedia(ctx, asset); err != nil {
+			t.Errorf("failed to create asset, error: %v", err)
+			return
+		}
+	}
+	if err := store.CreateMedia(ctx, &MediaAsset{Id: "a"}); err != ErrStoreConflict {
+		t.Errorf("expecting ErrStoreConflict, but got %v", err)
+	}
+	assets, err := store.ListMedia(ctx, 2)
+	if err != nil || len(assets) != 2 || assets[0].Id != "c" || assets[1].Id != "b" {
+		t.Errorf("unexpected assets %v (error: %v)", assets, err)
+	}
+}
+
+func TestNormalizeArticleMedia(t *testing.T) {
+	app := &AppRuntime{
+		Conf:  &AppConf{},
+		Media: NewMemoryMediaStore(),
+	}
+	logger := NewJsonLogger(ioutil.Discard)
+	asset := &MediaAsset{Id: MediaHash([]byte("x")), Alt: "a cat", Caption: "the cat", Credit: "me"}
+	app.Media.CreateMedia(context.Background(), asset)
+
+	article := &Article{
+		LeadImage: &ArticleMedia{Id: asset.Id, Caption: "my cat"},
+		Body: []*ArticleBlock{
+			&ArticleBlock{Type: ArticleBlockImage, Media: asset.Id},
+		},
+	}
+	if d := normalizeArticleMedia(app, article, logger); d != nil {
+		t.Errorf("expecting media accepted, but got %v", d.Status)
+		return
+	}
+	expected := ArticleMedia{Id: asset.Id, Alt: "a cat", Caption: "my cat", Credit: "me"}
+	if *article.LeadImage != expected {
+		t.Errorf("unexpected lead image %+v", article.LeadImage)
+	}
+	if b := article.Body[0]; b.Src != MediaPath(asset.Id) || b.Alt != "a cat" || b.Caption != "the cat" {
+		t.Errorf("unexpected image block %+v", b)
+	}
+	if err := ValidateArticleBody(article.Body, nil); err != nil {
+		t.Errorf("expecting valid body, but got %v", err)
+	}
+
+	for _, a := range []*Article{
+		&Article{LeadImage: &ArticleMedia{Id: "unknown"}},
+		&Article{LeadImage: &ArticleMedia{}},
+		&Article{Body: []*ArticleBlock{&ArticleBlock{Type: ArticleBlockImage, Media: "unknown"}}},
+	} {
+		if d := normalizeArticleMedia(app, a, logger); d == nil {
+			t.Errorf("expecting article %+v rejected, but it isn't", a)
+		}
+	}
+	if err := ValidateArticleBody([]*ArticleBlock{&ArticleBlock{Type: ArticleBlockParagraph, Text: "t", Media: asset.Id}}, nil); err == nil {
+		t.Errorf("expecting media on a paragraph rejected, but it isn't")
+	}
+}
+
+func TestMediaVariantGeometry(t *testing.T) {
+	for _, c := range []struct {
+		sw, sh, w, h int
+		fit          MediaFit
+		src          image.Rectangle
+		dw, dh       int
+	}{
+		{400, 200, 0, 0, MediaFitContain, image.Rect(0, 0, 400, 200), 400, 200},
+		{400, 200, 100, 0, MediaFitContain, image.Rect(0, 0, 400, 200), 100, 50},
+		{400, 200, 0, 100, MediaFitContain, image.Rect(0, 0, 400, 200), 200, 100},
+		{400, 200, 100, 100, MediaFitContain, image.Rect(0, 0, 400, 200), 100, 50},
+		{400, 200, 100, 100, MediaFitCover, image.Rect(100, 0, 300, 200), 100, 100},
+		{400, 200, 800, 800, MediaFitContain, image.Rect(0, 0, 400, 200), 400, 200},
+		{400, 200, 800, 800, MediaFitCover, image.Rect(100, 0, 300, 200), 200, 200},
+	} {
+		src, dw, dh := mediaVariantGeometry(c.sw, c.sh, c.w, c.h, c.fit)
+		if src != c.src || dw != c.dw || dh != c.dh {
+			t.Errorf("%+v: unexpected geometry %v, %vx%v", c, src, dw, dh)
+		}
+	}
+}
+
+func TestResizeMedia(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	// left half black, right half white
+	for y := 0; y < 20; y++ {
+		for x := 20; x < 40; x++ {
+			i := img.PixOffset(x, y)
+			img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = 255, 255, 255, 255
+		}
+	}
+	buf := &bytes.Buffer{}
+	png.Encode(buf, img)
+	data, contentType, err := ResizeMedia(buf.Bytes(), 4, 0, MediaFitContain)
+	if err != nil || contentType != "image/png" {
+		t.Errorf("failed to resize, content type: %v, error: %v", contentType, err)
+		return
+	}
+	variant, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Errorf("failed to decode variant, error: %v", err)
+		return
+	}
+	if b := variant.Bounds(); b.Dx() != 4 || b.Dy() != 2 {
+		t.Errorf("unexpected variant size %v", b)
+	}
+	if r, _, _, _ := variant.At(0, 0).RGBA(); r != 0 {
+		t.Errorf("expecting black at the left, but got %v", r)
+	}
+	if r, _, _, _ := variant.At(3, 1).RGBA(); r != 0xffff {
+		t.Errorf("expecting white at the right, but got %v", r)
+	}
+}
+
+func TestSnapMediaVariantSide(t *testing.T) {
+	sizes := []int{160, 320, 640}
+	for n, expected := range map[int]int{0: 0, 1: 160, 160: 160, 161: 320, 500: 640, 4096: 640} {
+		if snapped := snapMediaVariantSide(n, sizes); snapped != expected {
+			t.Errorf("expecting %v snapped to %v, but got %v", n, expected, snapped)
+		}
+	}
+}
+
+func TestMediaResizer(t *testing.T) {
+	resizer := NewMediaResizer(1)
+	resizer.wait = 10 * time.Millisecond
+	started := make(chan struct{})
+	release := make(chan struct{})
+	calls := 0
+	resize := func() ([]byte, string, error) {
+		calls += 1
+		if calls == 1 {
+			close(started)
+		}
+		<-release
+		return []byte("variant"), "image/png", nil
+	}
+
+	// the same key resized once
+	wg := &sync.WaitGroup{}
+	results := make([]string, 5)
+	do := func(i int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, _, _ := resizer.Do("a", resize)
+			results[i] = string(data)
+		}()
+	}
+	do(0)
+	<-started
+	for i := 1; i < len(results); i++ {
+		do(i)
+	}
+	// no free slot for other keys
+	if _, _, err := resizer.Do("b", resize); err != ErrMediaResizeBusy {
+		t.Errorf("expecting ErrMediaResizeBusy, but got %v", err)
+	}
+	// let the others join the resize in progress
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expecting resized once, but got %v", calls)
+	}
+	for _, result := range results {
+		if result != "variant" {
+			t.Errorf("expecting the variant shared, but got %q", result)
+		}
+	}
+	if data, _, err := resizer.Do("b", resize); err != nil || string(data) != "variant" {
+		t.Errorf("expecting b resized, but got %q (error: %v)", data, err)
+	}
+}
+
+func TestMediaResizerPanic(t *testing.T) {
+	resizer := NewMediaResizer(1)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	panicked := make(chan interface{})
+	go func() {
+		defer func() {
+			panicked <- recover()
+		}()
+		resizer.Do("a", func() ([]byte, string, error) {
+			close(started)
+			<-release
+			panic("broken image")
+		})
+	}()
+	<-started
+	waiter := make(chan error)
+	go func() {
+		_, _, err := resizer.Do("a", nil) // joins the call in progress
+		waiter <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if p := <-panicked; p == nil {
+		t.Errorf("expecting the panic of resize to go on")
+	}
+	if err := <-waiter; err == nil {
+		t.Errorf("expecting an error for the waiter of the panicked resize")
+	}
+	if len(resizer.slots) != 0 || len(resizer.calls) != 0 {
+		t.Errorf("expecting the slot and call released, but got %v slot(s) and %v call(s)", len(resizer.slots), len(resizer.calls))
+	}
+}
+
+func TestMediaCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "media-cache")
+	if err != nil {
+		t.Fatalf("failed to create temp dir, error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := NewMediaCache(dir, 10)
+	if err != nil {
+		t.Fatalf("failed to create cache, error: %v", err)
+	}
+	cache.Put("a", []byte("aaaa"))
+	cache.Put("b", []byte("bbbb"))
+	cache.Get("a") // b is the least recently used now
+	cache.Put("c", []byte("cccc"))
+	cache.Put("d", []byte("this is too large"))
+	for key, cached := range map[string]bool{"a": true, "b": false, "c": true, "d": false} {
+		if _, ok := cache.Get(key); ok != cached {
+			t.Errorf("expecting %v cached: %v, but got %v", key, cached, ok)
+		}
+	}
+	if cache.Size() != 8 {
+		t.Errorf("unexpected cache size %v", cache.Size())
+	}
+
+	// kept after restart
+	cache, err = NewMediaCache(dir, 10)
+	if err != nil {
+		t.Fatalf("failed to create cache, error: %v", err)
+	}
+	if data, ok := cache.Get("c"); !ok || string(data) != "cccc" {
+		t.Errorf("expecting c kept after restart, but got %q", data)
+	}
+}
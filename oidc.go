@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenID Connect (authorization code flow with PKCE) against a provider
+// found by discovery. ID tokens are verified with the keys of the
+// provider's JWKS, only RS256 and EdDSA ones are supported.
+
+const (
+	OIDCProviderName = "oidc"
+
+	// max size of a response from the provider
+	oidcMaxResponseSize = 1 << 20
+
+	// min interval between JWKS fetches for an unknown kid, so tokens
+	// with made-up kids can't make us hammer the provider
+	oidcJWKSMinRefetch = time.Minute
+)
+
+type OIDCProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSUri               string `json:"jwks_uri"`
+}
+
+type OIDCTokenResponse struct {
+	IdToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type OIDCProvider struct {
+	conf   *AppConf
+	client *http.Client
+
+	mu            sync.Mutex
+	meta          *OIDCProviderMetadata
+	keys          map[string]*JWTKey
+	keysFetchedAt time.Time
+}
+
+func NewOIDCProvider(conf *AppConf, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{conf: conf, client: client}
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %v returned %v", u, resp.Status)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Provider metadata, discovered on first use (so that the api server
+// starts while the provider is down) and cached afterwards
+func (p *OIDCProvider) Metadata(ctx context.Context) (*OIDCProviderMetadata, error) {
+	p.mu.Lock()
+	meta := p.meta
+	p.mu.Unlock()
+	if meta != nil {
+		return meta, nil
+	}
+	meta = &OIDCProviderMetadata{}
+	if err := p.getJSON(ctx, p.conf.OIDCIssuer+"/.well-known/openid-configuration", meta); err != nil {
+		return nil, fmt.Errorf("failed to discover oidc provider %v, error: %v", p.conf.OIDCIssuer, err)
+	}
+	if strings.TrimRight(meta.Issuer, "/") != p.conf.OIDCIssuer {
+		return nil, fmt.Errorf("oidc provider issuer %v doesn't match %v", meta.Issuer, p.conf.OIDCIssuer)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSUri == "" {
+		return nil, fmt.Errorf("incomplete metadata of oidc provider %v", p.conf.OIDCIssuer)
+	}
+	p.mu.Lock()
+	p.meta = meta
+	p.mu.Unlock()
+	return meta, nil
+}
+
+// Gets the key of the kid from the provider's JWKS, which is fetched
+// again (at most once per oidcJWKSMinRefetch) for an unknown kid as the
+// provider may have rotated its keys. The JWKS is fetched without the
+// lock held so a slow provider doesn't hold up the known keys, keys the
+// cms can't use (like a too short RSA one) are skipped.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (*JWTKey, error) {
+	meta, err := p.Metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	fetchedAt := p.keysFetchedAt
+	if !ok && time.Since(fetchedAt) >= oidcJWKSMinRefetch {
+		// taken by this fetch, concurrent ones for the kid don't fetch again
+		p.keysFetchedAt = time.Now()
+	}
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if time.Since(fetchedAt) < oidcJWKSMinRefetch {
+		return nil, ErrJWTUnknownKey
+	}
+	jwks := &JWKS{}
+	if err := p.getJSON(ctx, meta.JWKSUri, jwks); err != nil {
+		// let the next one try again
+		p.mu.Lock()
+		p.keysFetchedAt = fetchedAt
+		p.mu.Unlock()
+		return nil, fmt.Errorf("failed to get jwks of oidc provider, error: %v", err)
+	}
+	keys := make(map[string]*JWTKey)
+	for _, jwk := range jwks.Keys {
+		if key, err := ParseJWK(jwk); err == nil && key != nil {
+			keys[key.Kid] = key
+		}
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrJWTUnknownKey
+}
+
+// PKCE (RFC 7636) S256 challenge of the verifier
+func oidcCodeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// Url of the provider to send the user to for authentication
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, redirectUrl, state, nonce, verifier string) (string, error) {
+	meta, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid oidc authorization endpoint %v, error: %v", meta.AuthorizationEndpoint, err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.conf.OIDCClientId)
+	q.Set("redirect_uri", redirectUrl)
+	q.Set("scope", strings.Join(p.conf.OIDCScopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", oidcCodeChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchanges the authorization code for the ID token
+func (p *OIDCProvider) Exchange(ctx context.Context, code, redirectUrl, verifier string) (string, error) {
+	meta, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectUrl},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(HeaderContentType, "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client_secret_basic, both are form encoded first (RFC 6749 2.3.1)
+	req.SetBasicAuth(url.QueryEscape(p.conf.OIDCClientId), url.QueryEscape(p.conf.OIDCClientSecret))
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange oidc code, error: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseSize))
+	if err != nil {
+		return "", fmt.Errorf("failed to read oidc token response, error: %v", err)
+	}
+	token := &OIDCTokenResponse{}
+	if err := json.Unmarshal(data, token); err != nil {
+		return "", fmt.Errorf("failed to parse oidc token response (%v), error: %v", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return "", fmt.Errorf("failed to exchange oidc code (%v), error: %v %v", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IdToken == "" {
+		return "", fmt.Errorf("no id_token in oidc token response")
+	}
+	return token.IdToken, nil
+}
+
+// ID token claims, the standard ones checked by VerifyIDToken and all of
+// them for the username and roles
+type OIDCClaims struct {
+	Issuer    string      `json:"iss"`
+	Subject   string      `json:"sub"`
+	Audience  interface{} `json:"aud"`
+	IssuedAt  int64       `json:"iat"`
+	ExpiresAt int64       `json:"exp"`
+	Nonce     string      `json:"nonce"`
+
+	All map[string]interface{} `json:"-"`
+}
+
+func (c *OIDCClaims) hasAudience(aud string) bool {
+	switch v := c.Audience.(type) {
+	case string:
+		return v == aud
+	case []interface{}:
+		for _, a := range v {
+			if a == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Verifies the signature, issuer, audience, expiry and nonce of the ID
+// token
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, token, nonce string, now time.Time) (*OIDCClaims, error) {
+	var raw json.RawMessage
+	err := parseSignedJWT(token, func(header *JWTHeader) (*JWTKey, error) {
+		return p.key(ctx, header.Kid)
+	}, &raw)
+	if err != nil {
+		return nil, err
+	}
+	claims := &OIDCClaims{}
+	if err := json.Unmarshal(raw, claims); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if err := json.Unmarshal(raw, &claims.All); err != nil {
+		return nil, ErrJWTMalformed
+	}
+	if strings.TrimRight(claims.Issuer, "/") != p.conf.OIDCIssuer || !claims.hasAudience(p.conf.OIDCClientId) ||
+		claims.Subject == "" || claims.ExpiresAt == 0 || claims.Nonce != nonce {
+		return nil, ErrJWTInvalidClaims
+	}
+	if err := checkJWTTimes(claims.IssuedAt, claims.ExpiresAt, now); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// Username (from the username claim) and cms role (from the values of the
+// roles claim, a list or a space separated string) of the claims
+func OIDCUserFromClaims(conf *AppConf, claims *OIDCClaims) (string, CmsRoleValue, error) {
+	username, _ := claims.All[conf.OIDCUsernameClaim].(string)
+	if username = strings.TrimSpace(username); username == "" {
+		return "", 0, fmt.Errorf("no username (%v) in the id token", conf.OIDCUsernameClaim)
+	}
+	var values []string
+	switch v := claims.All[conf.OIDCRolesClaim].(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	var role CmsRoleValue
+	for _, value := range values {
+		role |= conf.OIDCRoleMap[value]
+	}
+	if role == 0 {
+		return username, 0, ErrAuthNoRole
+	}
+	return username, role, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// authorization of a code by the stub identity provider
+type stubOIDCAuthz struct {
+	nonce       string
+	challenge   string
+	redirectUrl string
+	claims      map[string]interface{}
+}
+
+// Stub OpenID Connect provider signing ID tokens with an Ed25519 key
+type stubOIDCProvider struct {
+	*httptest.Server
+	key      *JWTKey
+	clientId string
+	secret   string
+
+	mu    sync.Mutex
+	codes map[string]*stubOIDCAuthz
+	next  int
+}
+
+func newStubOIDCProvider(t *testing.T) *stubOIDCProvider {
+	pub, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key, error: %v", err)
+	}
+	p := &stubOIDCProvider{
+		key:      &JWTKey{Kid: "idp1", Alg: JWTAlgEdDSA, private: private, public: pub},
+		clientId: "cms",
+		secret:   "s3cret",
+		codes:    make(map[string]*stubOIDCAuthz),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&OIDCProviderMetadata{
+			Issuer:                p.URL,
+			AuthorizationEndpoint: p.URL + "/authorize",
+			TokenEndpoint:         p.URL + "/token",
+			JWKSUri:               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		// a key the cms can't use doesn't spoil the others
+		json.NewEncoder(w).Encode(&JWKS{Keys: []*JWK{{
+			Kty: "RSA", Use: "sig", Kid: "short", N: "AQAB", E: "AQAB",
+		}, {
+			Kty: "OKP", Use: "sig", Alg: string(JWTAlgEdDSA), Kid: p.key.Kid, Crv: "Ed25519",
+			X: base64.RawURLEncoding.EncodeToString(pub),
+		}}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+// authorizes the (parsed) authorization url with the claims, returns the
+// code
+func (p *stubOIDCProvider) authorize(q url.Values, claims map[string]interface{}) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.next++
+	code := fmt.Sprintf("code%v", p.next)
+	p.codes[code] = &stubOIDCAuthz{
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		redirectUrl: q.Get("redirect_uri"),
+		claims:      claims,
+	}
+	return code
+}
+
+func (p *stubOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(e string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(&OIDCTokenResponse{Error: e})
+	}
+	if clientId, secret, ok := r.BasicAuth(); !ok || clientId != p.clientId || secret != p.secret {
+		tokenError("invalid_client")
+		return
+	}
+	r.ParseForm()
+	p.mu.Lock()
+	authz, ok := p.codes[r.Form.Get("code")]
+	delete(p.codes, r.Form.Get("code"))
+	p.mu.Unlock()
+	if !ok || r.Form.Get("grant_type") != "authorization_code" || r.Form.Get("redirect_uri") != authz.redirectUrl ||
+		oidcCodeChallenge(r.Form.Get("code_verifier")) != authz.challenge {
+		tokenError("invalid_grant")
+		return
+	}
+	now := time.Now().Unix()
+	claims := map[string]interface{}{
+		"iss": p.URL, "sub": "id-" + fmt.Sprint(authz.claims["preferred_username"]), "aud": p.clientId,
+		"iat": now, "exp": now + 300, "nonce": authz.nonce,
+	}
+	for k, v := range authz.claims {
+		claims[k] = v
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(claims)})
+}
+
+func (p *stubOIDCProvider) sign(claims interface{}) string {
+	header, _ := json.Marshal(&JWTHeader{Alg: p.key.Alg, Typ: "JWT", Kid: p.key.Kid})
+	payload, _ := json.Marshal(claims)
+	data := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig, _ := p.key.sign([]byte(data))
+	return data + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func testOIDCApp(t *testing.T, idp *stubOIDCProvider) *AppRuntime {
+	app := testLoginApp(t)
+	app.Conf.PublicUrl = "https://cms.example.com"
+	app.Conf.OIDCIssuer = idp.URL
+	app.Conf.OIDCClientId = idp.clientId
+	app.Conf.OIDCClientSecret = idp.secret
+	app.Conf.OIDCScopes = parseOIDCScopes("profile,email")
+	app.Conf.OIDCUsernameClaim = "preferred_username"
+	app.Conf.OIDCRolesClaim = "groups"
+	roles, err := parseRoleMap("editors=article:create,article:edit_self; admins=login:manage")
+	if err != nil {
+		t.Fatalf("failed to parse role map, error: %v", err)
+	}
+	app.Conf.OIDCRoleMap = roles
+	app.OIDC = NewOIDCProvider(app.Conf, idp.Client())
+	return app
+}
+
+// goes through the sso flow, the user authenticates with the claims
+func testOIDCLogin(t *testing.T, app *AppRuntime, idp *stubOIDCProvider, claims map[string]interface{}) *HttpResponseData {
+	d := oidcLogin(app, nil, testAuthRequest(http.MethodGet, OIDCLoginPath, ""))
+	if d.Status != http.StatusFound {
+		body, _ := ioutil.ReadAll(d.Body)
+		t.Fatalf("expecting a redirect to the provider, but got %v: %s", d.Status, body)
+	}
+	u, _ := url.Parse(d.Header.Get("Location"))
+	q := u.Query()
+	if !strings.HasPrefix(u.String(), idp.URL+"/authorize?") || q.Get("client_id") != idp.clientId ||
+		q.Get("scope") != "openid profile email" || q.Get("code_challenge_method") != "S256" ||
+		q.Get("redirect_uri") != "https://cms.example.com"+OIDCCallbackPath {
+		t.Fatalf("unexpected authorization url %v", u)
+	}
+	cookies := (&http.Response{Header: d.Header}).Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || !cookies[0].Secure {
+		t.Fatalf("unexpected state cookie %v", d.Header.Get("Set-Cookie"))
+	}
+	code := idp.authorize(q, claims)
+	r := testAuthRequest(http.MethodGet, OIDCCallbackPath+"?code="+code+"&state="+q.Get("state"), "")
+	r.AddCookie(cookies[0])
+	return oidcCallback(app, nil, r)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newStubOIDCProvider(t)
+	defer idp.Close()
+	app := testOIDCApp(t, idp)
+	ctx := context.Background()
+	editor := map[string]interface{}{"preferred_username": "amy", "groups": []string{"editors", "staff"}}
+
+	// first login provisions the user
+	d := testOIDCLogin(t, app, idp, editor)
+	body, _ := ioutil.ReadAll(d.Body)
+	token := &AuthToken{}
+	json.Unmarshal(body, token)
+	if d.Status != http.StatusOK || token.Token == "" || token.RefreshToken == "" {
+		t.Fatalf("unexpected sso login response %v: %s", d.Status, body)
+	}
+	if cookie := d.Header.Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=0") {
+		t.Errorf("expecting the state cookie removed, but got %v", cookie)
+	}
+	user, err := app.Users.GetUser(ctx, "amy")
+	if err != nil || user.Provider != OIDCProviderName || user.Role != CmsRoleArticleCreate|CmsRoleArticleEditSelf || user.Password != "" ||
+		user.Subject != "id-amy" || user.Issuer != idp.URL {
+		t.Errorf("unexpected provisioned user %+v (error: %v)", user, err)
+	}
+	if d := Login()(app, nil, testAuthRequest(http.MethodPost, "/api/login", `{"username":"amy","password":"pw"}`)); d.Status != http.StatusForbidden {
+		t.Errorf("expecting 403 for password login of a sso user, but got %v", d.Status)
+	}
+
+	// roles follow the claims, the sessions with the old roles are revoked
+	admin := map[string]interface{}{"preferred_username": "amy", "groups": "editors admins"}
+	if d := testOIDCLogin(t, app, idp, admin); d.Status != http.StatusOK {
+		t.Errorf("expecting sso login ok, but got %v", d.Status)
+	}
+	if user, _ = app.Users.GetUser(ctx, "amy"); user.Role != CmsRoleArticleCreate|CmsRoleArticleEditSelf|CmsRoleLoginManage {
+		t.Errorf("expecting role updated, but got %v", Role2Names(user.Role))
+	}
+	r := testAuthRequest(http.MethodGet, "/api/sessions", "")
+	r.Header.Set(HeaderAuthToken, token.Token)
+	if d := SessionsGet()(app, nil, r); d.Status != http.StatusForbidden {
+		t.Errorf("expecting 403 for a token with the old role, but got %v", d.Status)
+	}
+
+	for name, c := range map[string]struct {
+		claims map[string]interface{}
+		status int
+	}{
+		"no role":        {map[string]interface{}{"preferred_username": "bob", "groups": []string{"staff"}}, http.StatusForbidden},
+		"no username":    {map[string]interface{}{"groups": []string{"editors"}}, http.StatusForbidden},
+		"password user":  {map[string]interface{}{"preferred_username": "ed", "groups": []string{"editors"}}, http.StatusConflict},
+		"other identity": {map[string]interface{}{"preferred_username": "amy", "sub": "id-other", "groups": []string{"editors"}}, http.StatusForbidden},
+		"bad nonce":      {map[string]interface{}{"preferred_username": "amy", "groups": []string{"editors"}, "nonce": "x"}, http.StatusForbidden},
+		"other client":   {map[string]interface{}{"preferred_username": "amy", "groups": []string{"editors"}, "aud": "other"}, http.StatusForbidden},
+		"expired":        {map[string]interface{}{"preferred_username": "amy", "groups": []string{"editors"}, "exp": time.Now().Unix() - 3600}, http.StatusForbidden},
+	} {
+		if d := testOIDCLogin(t, app, idp, c.claims); d.Status != c.status {
+			t.Errorf("expecting %v for %v, but got %v", c.status, name, d.Status)
+		}
+	}
+	if _, err := app.Users.GetUser(ctx, "bob"); err != ErrStoreNotFound {
+		t.Errorf("expecting user without role not provisioned, but got %v", err)
+	}
+	if user, _ := app.Users.GetUser(ctx, "ed"); user.Provider != "" {
+		t.Errorf("expecting password user untouched, but got %+v", user)
+	}
+
+	// users provisioned without an identity get the one of their next login
+	app.Users.CreateUser(ctx, &CmsUser{Username: "kim", Role: CmsRoleArticleCreate, Provider: OIDCProviderName})
+	kim := map[string]interface{}{"preferred_username": "kim", "groups": []string{"editors"}}
+	if d := testOIDCLogin(t, app, idp, kim); d.Status != http.StatusOK {
+		t.Errorf("expecting sso login ok, but got %v", d.Status)
+	}
+	if user, _ := app.Users.GetUser(ctx, "kim"); user.Subject != "id-kim" || user.Issuer != idp.URL {
+		t.Errorf("expecting identity of kim kept, but got %+v", user)
+	}
+}
+
+func TestOIDCCallbackRejects(t *testing.T) {
+	idp := newStubOIDCProvider(t)
+	defer idp.Close()
+	app := testOIDCApp(t, idp)
+
+	d := oidcLogin(app, nil, testAuthRequest(http.MethodGet, OIDCLoginPath, ""))
+	u, _ := url.Parse(d.Header.Get("Location"))
+	q := u.Query()
+	cookie := (&http.Response{Header: d.Header}).Cookies()[0]
+	code := idp.authorize(q, map[string]interface{}{"preferred_username": "amy", "groups": []string{"editors"}})
+	for name, c := range map[string]struct {
+		query  string
+		cookie bool
+		status int
+	}{
+		"provider error": {"error=access_denied", true, http.StatusForbidden},
+		"no code":        {"state=" + q.Get("state"), true, http.StatusBadRequest},
+		"no cookie":      {"code=" + code + "&state=" + q.Get("state"), false, http.StatusForbidden},
+		"other state":    {"code=" + code + "&state=other", true, http.StatusForbidden},
+		"unknown code":   {"code=nosuchcode&state=" + q.Get("state"), true, http.StatusForbidden},
+	} {
+		r := testAuthRequest(http.MethodGet, OIDCCallbackPath+"?"+c.query, "")
+		if c.cookie {
+			r.AddCookie(cookie)
+		}
+		if d := oidcCallback(app, nil, r); d.Status != c.status {
+			t.Errorf("expecting %v for %v, but got %v", c.status, name, d.Status)
+		}
+	}
+
+	// not found unless configured
+	app.OIDC = nil
+	if d := oidcLogin(app, nil, testAuthRequest(http.MethodGet, OIDCLoginPath, "")); d.Status != http.StatusNotFound {
+		t.Errorf("expecting 404 without sso, but got %v", d.Status)
+	}
+}
+
+func TestParseRoleMap(t *testing.T) {
+	roles, err := parseRoleMap("editors=article:create,article:edit_self;editors=article:submit; admins = login:manage ;")
+	if err != nil {
+		t.Fatalf("failed to parse role map, error: %v", err)
+	}
+	if len(roles) != 2 || roles["editors"] != CmsRoleArticleCreate|CmsRoleArticleEditSelf|CmsRoleArticleSubmit || roles["admins"] != CmsRoleLoginManage {
+		t.Errorf("unexpected roles %v", roles)
+	}
+	for _, s := range []string{"editors", "=article:create", "editors=article:nosuchrole"} {
+		if _, err := parseRoleMap(s); err == nil {
+			t.Errorf("expecting role map %v to be rejected", s)
+		}
+	}
+}
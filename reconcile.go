@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Cleans up drafts left behind by a submit which created the
+// version but failed (or died) before deleting the draft.
+//
+// A draft is considered submitted if the version derived from it
+// (see draftVersion) exists and has the same content.
+func reconcileDrafts(app *AppRuntime, logger *JsonLogger) (int, error) {
+	ctx := context.Background()
+	types := app.Conf.ArticleIndexTypes
+	q := &ArticleQuery{
+		Types:        []string{types.Draft},
+		CreatedAfter: time.Unix(0, 0).UTC(),
+		Size:         1000,
+	}
+	repaired := 0
+	for {
+		docs, err := app.Articles.Search(ctx, q)
+		if err != nil {
+			return repaired, err
+		}
+		if len(docs) <= 0 {
+			return repaired, nil
+		}
+		for _, doc := range docs {
+			if reconcileDraft(app, doc.Article, logger) {
+				repaired += 1
+			}
+		}
+		q.SearchAfter = docs[len(docs)-1].Sort
+	}
+}
+
+func reconcileDraft(app *AppRuntime, draft *Article, logger *JsonLogger) bool {
+	if draft.RevisedAt == nil {
+		return false
+	}
+	guid := draft.Id
+	ver := strconv.FormatInt(draftVersion(draft), 10)
+	verGuid := fmt.Sprintf("%v:%v", guid, ver)
+	ctx := context.Background()
+
+	lock, err := app.DraftLock.Lock(guid)
+	if err != nil {
+		logger.Perrorf("failed to lock article draft %v, error: %v", guid, err)
+		return false
+	}
+	defer lock.Unlock()
+	version, err := app.Articles.Get(ctx, app.Conf.ArticleIndexTypes.Version, verGuid)
+	if err == ErrStoreNotFound {
+		return false
+	} else if err != nil {
+		logger.Perrorf("failed to get article version %v, error: %v", verGuid, err)
+		return false
+	}
+	if !sameArticleContent(draft, version) {
+		return false
+	}
+	logger = logger.CloneWithFields(LogFields{
+		"action":          "delete-draft",
+		"article_guid":    guid,
+		"article_version": ver,
+	})
+	if LockLost(lock) {
+		logger.Perrorf("lost lock on article draft %v, not deleting it", guid)
+		return false
+	}
+	if err := app.Articles.DeleteDraft(ctx, guid); err != nil && err != ErrStoreNotFound {
+		logger.Perrorf("failed to delete article draft %v already submitted as version %v, error: %v", guid, verGuid, err)
+		return false
+	}
+	logger.Pinfof("deleted article draft %v already submitted as version %v", guid, verGuid)
+	return true
+}
+
+// Reconciles drafts once and then every interval (if it is > 0)
+func StartDraftReconciler(app *AppRuntime) {
+	logger := app.Logger.CloneWithFields(LogFields{
+		"log_group": "reconciler",
+	})
+	run := func() {
+		if n, err := reconcileDrafts(app, logger); err != nil {
+			logger.Perrorf("failed to reconcile article drafts, error: %v", err)
+		} else if n > 0 {
+			logger.Pinfof("reconciled %v article draft(s)", n)
+		}
+	}
+	run()
+	interval := app.Conf.DraftReconcileInterval
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(interval) {
+			run()
+		}
+	}()
+}
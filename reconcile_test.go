@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestReconcileDrafts(t *testing.T) {
+	ctx := context.Background()
+	types := articleIndexTypes
+	app := &AppRuntime{
+		Conf:      &AppConf{ArticleIndexTypes: types},
+		Articles:  NewMemoryArticleStore(types),
+		DraftLock: NewUniqStrMutex(),
+	}
+	logger := NewJsonLogger(ioutil.Discard)
+
+	jt := &JSONTime{time.Now().UTC().Truncate(time.Millisecond)}
+	newDraft := func(headline string) *Article {
+		draft, err := app.Articles.CreateDraft(ctx, &Article{
+			Id:        headline,
+			Guid:      headline,
+			Headline:  headline,
+			CreatedAt: jt,
+			RevisedAt: jt,
+			LockedBy:  "alice",
+		})
+		if err != nil {
+			t.Fatalf("failed to create draft, error: %v", err)
+		}
+		return draft
+	}
+	newVersion := func(draft *Article, headline string) {
+		version := *draft
+		version.Id = fmt.Sprintf("%v:%v", draft.Guid, draftVersion(draft))
+		version.Headline = headline
+		if err := app.Articles.CreateVersion(ctx, &version); err != nil {
+			t.Fatalf("failed to create version, error: %v", err)
+		}
+	}
+
+	// submitted but the draft was not deleted
+	submitted := newDraft("submitted")
+	newVersion(submitted, "submitted")
+	// version with the same id but different content
+	changed := newDraft("changed")
+	newVersion(changed, "something else")
+	// never submitted
+	pending := newDraft("pending")
+
+	n, err := reconcileDrafts(app, logger)
+	if err != nil {
+		t.Errorf("failed to reconcile drafts, error: %v", err)
+		return
+	}
+	if n != 1 {
+		t.Errorf("expecting 1 reconciled draft, but got %v", n)
+		return
+	}
+	if _, err := app.Articles.Get(ctx, types.Draft, submitted.Id); err != ErrStoreNotFound {
+		t.Errorf("expecting draft %v deleted, but got %v", submitted.Id, err)
+	}
+	for _, id := range []string{changed.Id, pending.Id} {
+		if _, err := app.Articles.Get(ctx, types.Draft, id); err != nil {
+			t.Errorf("expecting draft %v kept, but got %v", id, err)
+		}
+	}
+}
+
+func TestSubmitDraftRetry(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryArticleStore(articleIndexTypes)
+
+	draft, err := s.CreateDraft(ctx, &Article{Headline: "x", LockedBy: "alice"})
+	if err != nil {
+		t.Errorf("failed to create draft, error: %v", err)
+		return
+	}
+	version := *draft
+	version.Guid = draft.Id
+	version.Id = draft.Id + ":1"
+	if err := s.SubmitDraft(ctx, &version); err != nil {
+		t.Errorf("failed to submit draft, error: %v", err)
+		return
+	}
+	// retry is fine
+	if err := s.SubmitDraft(ctx, &version); err != nil {
+		t.Errorf("expecting retried submit to succeed, but got %v", err)
+		return
+	}
+	// different content under the same version is not
+	version.Headline = "y"
+	if err := s.SubmitDraft(ctx, &version); err != ErrStoreConflict {
+		t.Errorf("expecting %v, but got %v", ErrStoreConflict, err)
+		return
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+type ScheduleAction string
+
+const (
+	ScheduleActionPublish   ScheduleAction = "publish"
+	ScheduleActionUnpublish ScheduleAction = "unpublish"
+)
+
+type ScheduleStatus string
+
+const (
+	ScheduleStatusPending  ScheduleStatus = "pending"
+	ScheduleStatusDone     ScheduleStatus = "done"
+	ScheduleStatusFailed   ScheduleStatus = "failed"
+	ScheduleStatusCanceled ScheduleStatus = "canceled"
+)
+
+// Publish of an article version (embargo) or unpublish of an
+// article (expiry) at the given time
+type ArticleSchedule struct {
+	Id        string         `json:"id,omitempty"`
+	Guid      string         `json:"guid"`
+	Version   string         `json:"version,omitempty"` // only for publish
+	Action    ScheduleAction `json:"action"`
+	At        *JSONTime      `json:"at"`
+	Status    ScheduleStatus `json:"status"`
+	CreatedAt *JSONTime      `json:"created_at"`
+	CreatedBy string         `json:"created_by"`
+	DoneAt    *JSONTime      `json:"done_at,omitempty"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// id of the article version for publish, guid for unpublish
+func (s *ArticleSchedule) ArticleId() string {
+	if s.Action == ScheduleActionPublish {
+		return fmt.Sprintf("%v:%v", s.Guid, s.Version)
+	}
+	return s.Guid
+}
+
+// Publishes/unpublishes the article the same way as the publish/unpublish
+// endpoints do, on behalf of the user who created the schedule.
+func executeSchedule(app *AppRuntime, schedule *ArticleSchedule, logger *JsonLogger) *HttpResponseData {
+	switch schedule.Action {
+	case ScheduleActionPublish:
+		article, d := getStoredArticle(app, app.Conf.ArticleIndexTypes.Version, schedule.ArticleId(), logger)
+		if d != nil {
+			return d
+		}
+		return publishArticleVersion(app, schedule.CreatedBy, article, logger)
+	case ScheduleActionUnpublish:
+		return unpublishArticleGuid(app, schedule.CreatedBy, schedule.Guid, logger)
+	default:
+		return CreateBadRequestRespData(fmt.Sprintf("unknown schedule action %v!", schedule.Action))
+	}
+}
+
+// Runs the schedule if it is still pending, returns true if it was run.
+func runSchedule(app *AppRuntime, id string, logger *JsonLogger) bool {
+	ctx := context.Background()
+	// lock on the schedule so that it's run only once even with
+	// multiple api servers (when using elasticsearch locks)
+	lock, err := app.ScheduleLock.Lock(id)
+	if err != nil {
+		logger.Perrorf("failed to lock schedule %v, error: %v", id, err)
+		return false
+	}
+	defer lock.Unlock()
+	schedule, err := app.Schedules.GetSchedule(ctx, id)
+	if err != nil {
+		logger.Perrorf("failed to get schedule %v, error: %v", id, err)
+		return false
+	} else if schedule.Status != ScheduleStatusPending {
+		return false
+	}
+
+	fields, err := articleAuditLogFields(string(schedule.Action), schedule.CreatedBy, schedule.ArticleId())
+	if err != nil {
+		logger.Perrorf("failed to parse article id %v, error %v", schedule.ArticleId(), err)
+	}
+	fields["schedule_id"] = schedule.Id
+	auditLogger := logger.CloneWithFields(fields)
+	if LockLost(lock) {
+		// may be run by another api server which took over the lock
+		logger.Perrorf("lost lock on schedule %v, not running it", id)
+		return false
+	}
+	if d := executeSchedule(app, schedule, auditLogger); d != nil {
+		body, _ := ioutil.ReadAll(d.Body)
+		schedule.Status = ScheduleStatusFailed
+		schedule.Error = fmt.Sprintf("%v: %v", d.Status, string(body))
+	} else {
+		schedule.Status = ScheduleStatusDone
+	}
+	schedule.DoneAt = &JSONTime{time.Now().UTC()}
+	if err := app.Schedules.UpdateSchedule(ctx, schedule); err != nil {
+		logger.Perrorf("failed to update schedule %v to %v, error: %v", id, schedule.Status, err)
+	}
+	return true
+}
+
+// runs all pending schedules which are due now
+func runDueSchedules(app *AppRuntime, logger *JsonLogger) (int, error) {
+	schedules, err := app.Schedules.ListSchedules(context.Background(), &ScheduleQuery{
+		Status:    ScheduleStatusPending,
+		DueBefore: time.Now().UTC(),
+		Size:      100,
+	})
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, schedule := range schedules {
+		if runSchedule(app, schedule.Id, logger) {
+			n += 1
+		}
+	}
+	return n, nil
+}
+
+// Checks for due schedules every ScheduleInterval
+func StartScheduler(app *AppRuntime) {
+	logger := app.Logger.CloneWithFields(LogFields{
+		"log_group": "scheduler",
+	})
+	go func() {
+		for range time.Tick(app.Conf.ScheduleInterval) {
+			if n, err := runDueSchedules(app, logger); err != nil {
+				logger.Perrorf("failed to run due schedules, error: %v", err)
+			} else if n > 0 {
+				logger.Pinfof("ran %v scheduled publish/unpublish", n)
+			}
+		}
+	}()
+}
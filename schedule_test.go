@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRunDueSchedules(t *testing.T) {
+	ctx := context.Background()
+	types := articleIndexTypes
+	app := &AppRuntime{
+		Conf:         &AppConf{ArticleIndexTypes: types},
+		Articles:     NewMemoryArticleStore(types),
+		Schedules:    NewMemoryScheduleStore(),
+		PublishLock:  NewUniqStrMutex(),
+		ScheduleLock: NewUniqStrMutex(),
+	}
+	logger := NewJsonLogger(ioutil.Discard)
+
+	jt := &JSONTime{time.Now().UTC()}
+	version := &Article{Id: "a:1", Guid: "a", Version: "1", Headline: "a", CreatedAt: jt, RevisedAt: jt}
+	if err := app.Articles.CreateVersion(ctx, version); err != nil {
+		t.Errorf("failed to create version, error: %v", err)
+		return
+	}
+	newSchedule := func(action ScheduleAction, ver string, at time.Time) *ArticleSchedule {
+		s, err := app.Schedules.CreateSchedule(ctx, &ArticleSchedule{
+			Guid:      "a",
+			Version:   ver,
+			Action:    action,
+			At:        &JSONTime{at},
+			Status:    ScheduleStatusPending,
+			CreatedAt: jt,
+			CreatedBy: "alice",
+		})
+		if err != nil {
+			t.Fatalf("failed to create schedule, error: %v", err)
+		}
+		return s
+	}
+	publish := newSchedule(ScheduleActionPublish, "1", jt.T.Add(-time.Minute))
+	unpublish := newSchedule(ScheduleActionUnpublish, "", jt.T.Add(time.Hour))
+
+	n, err := runDueSchedules(app, logger)
+	if err != nil {
+		t.Errorf("failed to run due schedules, error: %v", err)
+		return
+	}
+	if n != 1 {
+		t.Errorf("expecting 1 schedule run, but got %v", n)
+		return
+	}
+	if a, err := app.Articles.Get(ctx, types.Publish, "a"); err != nil || a.Version != "1" {
+		t.Errorf("expecting version 1 published, but got %+v (error: %v)", a, err)
+		return
+	}
+	if s, _ := app.Schedules.GetSchedule(ctx, publish.Id); s.Status != ScheduleStatusDone || s.DoneAt == nil {
+		t.Errorf("expecting publish schedule done, but got %+v", s)
+		return
+	}
+	if s, _ := app.Schedules.GetSchedule(ctx, unpublish.Id); s.Status != ScheduleStatusPending {
+		t.Errorf("expecting unpublish schedule pending, but got %+v", s)
+		return
+	}
+	// nothing left to run
+	if n, _ = runDueSchedules(app, logger); n != 0 {
+		t.Errorf("expecting no schedule run, but got %v", n)
+		return
+	}
+}
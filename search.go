@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// tags wrapped around matched terms in highlight fragments
+	HighlightPreTag  = "<em>"
+	HighlightPostTag = "</em>"
+
+	// headline matches weigh more than content matches
+	searchHeadlineBoost = 3.0
+
+	// number of tokens around a match in a content fragment
+	highlightFragmentTokens = 12
+	highlightMaxFragments   = 3
+)
+
+type textToken struct {
+	term       string
+	start, end int
+}
+
+// splits text into lower-cased words (letters and digits) along with
+// their byte offsets in the text
+func tokenizeText(text string) []*textToken {
+	tokens := make([]*textToken, 0)
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, &textToken{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, &textToken{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// distinct terms of the query text
+func searchTerms(text string) map[string]bool {
+	terms := make(map[string]bool)
+	for _, t := range tokenizeText(text) {
+		terms[t.term] = true
+	}
+	return terms
+}
+
+func countTermMatches(tokens []*textToken, terms map[string]bool) int {
+	n := 0
+	for _, t := range tokens {
+		if terms[t.term] {
+			n += 1
+		}
+	}
+	return n
+}
+
+// Scores an article against the query terms, any term matching in the
+// headline or the content makes a hit (like the default "or" operator
+// of elasticsearch). Returns 0 if nothing matches.
+func scoreArticleText(a *Article, terms map[string]bool) float64 {
+	headline := countTermMatches(tokenizeText(a.Headline), terms)
+	content := countTermMatches(tokenizeText(articleContentText(a)), terms)
+	return searchHeadlineBoost*float64(headline) + float64(content)
+}
+
+// html-escapes text[start:end] and wraps matched tokens in it with the
+// highlight tags
+func highlightTokens(text string, tokens []*textToken, terms map[string]bool, start, end int) string {
+	buf := &bytes.Buffer{}
+	pos := start
+	for _, t := range tokens {
+		if t.start < start || t.end > end || !terms[t.term] {
+			continue
+		}
+		buf.WriteString(html.EscapeString(text[pos:t.start]))
+		buf.WriteString(HighlightPreTag)
+		buf.WriteString(html.EscapeString(text[t.start:t.end]))
+		buf.WriteString(HighlightPostTag)
+		pos = t.end
+	}
+	buf.WriteString(html.EscapeString(text[pos:end]))
+	return buf.String()
+}
+
+// Returns up to maxFragments html-escaped fragments of text around the
+// matched terms, nil if no term matches. With maxFragments 0 the whole
+// text is returned as one fragment.
+func highlightText(text string, terms map[string]bool, maxFragments int) []string {
+	tokens := tokenizeText(text)
+	if countTermMatches(tokens, terms) == 0 {
+		return nil
+	}
+	if maxFragments <= 0 {
+		return []string{highlightTokens(text, tokens, terms, 0, len(text))}
+	}
+	fragments := make([]string, 0, maxFragments)
+	for i := 0; i < len(tokens) && len(fragments) < maxFragments; i++ {
+		if !terms[tokens[i].term] {
+			continue
+		}
+		first := i - highlightFragmentTokens/2
+		if first < 0 {
+			first = 0
+		}
+		last := first + highlightFragmentTokens
+		if last >= len(tokens) {
+			last = len(tokens) - 1
+		}
+		fragments = append(fragments, highlightTokens(text, tokens, terms, tokens[first].start, tokens[last].end))
+		i = last
+	}
+	return fragments
+}
+
+// Returns a doc (without the article set) with its score, highlight and
+// sort values if the article matches the query, nil otherwise. Used by
+// stores which have to scan through all articles, see pageArticleSearchDocs.
+func matchArticleTextQuery(q *ArticleTextQuery, terms map[string]bool, typ string, a *Article) *ArticleDoc {
+	if a.Guid == "" || a.CreatedAt == nil {
+		return nil
+	}
+	if q.CreatedBy != "" && a.CreatedBy != q.CreatedBy {
+		return nil
+	}
+	if q.RevisedBy != "" && a.RevisedBy != q.RevisedBy {
+		return nil
+	}
+	if !q.CreatedFrom.IsZero() && a.CreatedAt.T.Before(q.CreatedFrom) {
+		return nil
+	}
+	if !q.CreatedTo.IsZero() && a.CreatedAt.T.After(q.CreatedTo) {
+		return nil
+	}
+	if !q.RevisedFrom.IsZero() && (a.RevisedAt == nil || a.RevisedAt.T.Before(q.RevisedFrom)) {
+		return nil
+	}
+	if !q.RevisedTo.IsZero() && (a.RevisedAt == nil || a.RevisedAt.T.After(q.RevisedTo)) {
+		return nil
+	}
+	if len(q.Tag) > 0 && !hasAnyTag(a, q.Tag) {
+		return nil
+	}
+	score := 1.0
+	var highlight map[string][]string
+	if len(terms) > 0 {
+		if score = scoreArticleText(a, terms); score <= 0 {
+			return nil
+		}
+		highlight = make(map[string][]string)
+		if h := highlightText(a.Headline, terms, 0); h != nil {
+			highlight["headline"] = h
+		}
+		if h := highlightText(articleContentText(a), terms, highlightMaxFragments); h != nil {
+			highlight["content"] = h
+		}
+	}
+	return &ArticleDoc{
+		Type:      typ,
+		Sort:      append([]interface{}{score}, articleDocSort(typ, a)...),
+		Score:     score,
+		Highlight: highlight,
+	}
+}
+
+// Returns true if the article has any of the tags, its tags are compared
+// normalized too as articles saved before the taxonomy keep them as they
+// were entered.
+func hasAnyTag(a *Article, tags []string) bool {
+	for _, t := range a.Tag {
+		normalized := NormalizeTag(t)
+		for _, one := range tags {
+			if t == one || normalized == one {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// returns true if search sort values x (score desc, created_at desc,
+// _uid asc) come before y
+func articleSearchSortLess(x, y []interface{}) bool {
+	xs, _ := x[0].(float64)
+	ys, _ := y[0].(float64)
+	if xs != ys {
+		return xs > ys
+	}
+	return articleDocSortLess(x[1:], y[1:])
+}
+
+// Sorts all matched docs and returns the page after q.SearchAfter,
+// the total is the number of all matched docs like elasticsearch does
+func pageArticleSearchDocs(q *ArticleTextQuery, docs []*ArticleDoc) *ArticleSearchResult {
+	sort.Slice(docs, func(i, j int) bool {
+		return articleSearchSortLess(docs[i].Sort, docs[j].Sort)
+	})
+	result := &ArticleSearchResult{
+		Total: int64(len(docs)),
+		Docs:  make([]*ArticleDoc, 0),
+	}
+	for _, doc := range docs {
+		if q.Size > 0 && len(result.Docs) >= q.Size {
+			break
+		}
+		if len(q.SearchAfter) == 3 && !articleSearchSortLess(q.SearchAfter, doc.Sort) {
+			continue
+		}
+		result.Docs = append(result.Docs, doc)
+	}
+	return result
+}
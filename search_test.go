@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHighlightText(t *testing.T) {
+	terms := searchTerms("Go")
+	h := highlightText("Let's go <now>!", terms, 0)
+	if len(h) != 1 || h[0] != "Let&#39;s <em>go</em> &lt;now&gt;!" {
+		t.Errorf("unexpected highlight %q", h)
+	}
+	if h := highlightText("nothing here", terms, 0); h != nil {
+		t.Errorf("expecting no highlight, but got %q", h)
+	}
+	// fragments around matches, at most the given number
+	text := "go a b c d e f g h i j k l m n o p q r s t u v w x y z go go"
+	if h := highlightText(text, terms, 1); len(h) != 1 || h[0] != "<em>go</em> a b c d e f g h i j k l" {
+		t.Errorf("unexpected fragments %q", h)
+	}
+	if h := highlightText(text, terms, 3); len(h) != 2 {
+		t.Errorf("expecting 2 fragments, but got %q", h)
+	}
+}
+
+func TestMemoryArticleStoreTextSearch(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryArticleStore(articleIndexTypes)
+
+	now := time.Now().UTC()
+	articles := []*Article{
+		&Article{Guid: "a", Headline: "golang release", Content: "nothing", Tag: []string{"tech"}, CreatedBy: "alice"},
+		&Article{Guid: "b", Headline: "weather", Content: "golang and more golang", Tag: []string{"tech"}, CreatedBy: "bob"},
+		&Article{Guid: "c", Headline: "sports", Content: "golang once", Tag: []string{"sports"}, CreatedBy: "alice"},
+		&Article{Guid: "d", Headline: "cooking", Content: "no match", CreatedBy: "alice"},
+	}
+	for i, a := range articles {
+		a.Id = a.Guid
+		a.CreatedAt = &JSONTime{now.Add(time.Duration(-i) * time.Minute)}
+		a.RevisedAt = a.CreatedAt
+		if err := s.UpsertPublish(ctx, a); err != nil {
+			t.Errorf("failed to publish article, error: %v", err)
+			return
+		}
+	}
+
+	q := &ArticleTextQuery{
+		Text:  "Golang",
+		Types: []string{articleIndexTypes.Publish},
+		Size:  2,
+	}
+	guids := ""
+	for {
+		result, err := s.TextSearch(ctx, q)
+		if err != nil {
+			t.Errorf("failed to search, error: %v", err)
+			return
+		}
+		if result.Total != 3 {
+			t.Errorf("expecting total 3, but got %v", result.Total)
+			return
+		}
+		if len(result.Docs) == 0 {
+			break
+		}
+		for _, doc := range result.Docs {
+			guids += doc.Article.Guid
+		}
+		// cursor mark goes through json just like over http
+		bytes, _ := json.Marshal(result.Docs[len(result.Docs)-1].Sort)
+		q.SearchAfter = nil
+		json.Unmarshal(bytes, &q.SearchAfter)
+	}
+	// headline match scores higher than two content matches
+	if guids != "abc" {
+		t.Errorf("expecting articles sorted by relevance abc, but got %v", guids)
+	}
+
+	q = &ArticleTextQuery{
+		Text:      "golang",
+		Types:     []string{articleIndexTypes.Publish},
+		Tag:       []string{"tech"},
+		CreatedBy: "bob",
+	}
+	result, err := s.TextSearch(ctx, q)
+	if err != nil {
+		t.Errorf("failed to search, error: %v", err)
+		return
+	}
+	if len(result.Docs) != 1 || result.Docs[0].Article.Guid != "b" {
+		t.Errorf("expecting article b, but got %+v", result.Docs)
+		return
+	}
+	if h := result.Docs[0].Highlight["content"]; len(h) != 1 || h[0] != "<em>golang</em> and more <em>golang</em>" {
+		t.Errorf("unexpected content highlight %q", h)
+	}
+
+	// no text matches all articles within the time range
+	q = &ArticleTextQuery{
+		Types:       []string{articleIndexTypes.Publish},
+		CreatedFrom: now.Add(-150 * time.Second),
+	}
+	if result, err = s.TextSearch(ctx, q); err != nil || result.Total != 3 {
+		t.Errorf("expecting 3 articles, but got %v (error: %v)", result, err)
+	}
+}
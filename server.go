@@ -21,6 +21,7 @@ const (
 	CtxKeyId             = "id"
 	CtxKeyVer            = "ver"
 	CtxKeySize           = "size"
+	CtxKeyName           = "name"
 )
 
 func WithCtxStringValue(ctx context.Context, key CtxKey, val string) context.Context {
@@ -106,6 +107,9 @@ func (data *HttpResponseData) Write(w http.ResponseWriter) error {
 	// write header with status code
 	w.WriteHeader(data.Status)
 	// write body
+	if c, ok := data.Body.(io.Closer); ok {
+		defer c.Close()
+	}
 	_, err := io.Copy(w, data.Body)
 	return err
 }
@@ -153,6 +157,20 @@ func IPFromRequestRemoteAddr(addr string) string {
 	return ""
 }
 
+// request uri with the password query arg (of the deprecated GET login
+// and of the login create/update endpoints) masked
+func redactRequestURI(r *http.Request) string {
+	if r.URL.RawQuery == "" || !strings.Contains(r.URL.RawQuery, "password") {
+		return r.RequestURI
+	}
+	values := r.URL.Query()
+	if _, ok := values["password"]; !ok {
+		return r.RequestURI
+	}
+	values.Set("password", "xxxxx")
+	return r.URL.Path + "?" + values.Encode()
+}
+
 func logRequest(w *ResponseWriter, r *http.Request) {
 	processDuration := time.Now().UTC().Sub(w.requestTime)
 	/*
@@ -177,7 +195,7 @@ func logRequest(w *ResponseWriter, r *http.Request) {
 		"req_remote_ip":    clientIp,
 		"req_time":         w.requestTime.Format("2006-01-02T15:04:05.000Z"),
 		"req_method":       r.Method,
-		"req_uri":          r.RequestURI,
+		"req_uri":          redactRequestURI(r),
 		"req_protocol":     r.Proto,
 		"req_process_time": processDuration.Nanoseconds(),
 		"resp_status":      w.status,
@@ -190,13 +208,23 @@ func logRequest(w *ResponseWriter, r *http.Request) {
 type EndpointHandler func(*AppRuntime, http.ResponseWriter, *http.Request) *HttpResponseData
 
 func handler(app *AppRuntime, method string, h EndpointHandler) http.Handler {
+	return methodsHandler(app, []string{method}, h)
+}
+
+// the same as handler but allows any of the methods
+func methodsHandler(app *AppRuntime, methods []string, h EndpointHandler) http.Handler {
+	allowed := make(map[string]bool)
+	for _, method := range methods {
+		allowed[method] = true
+	}
+	allow := strings.Join(methods, ", ")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ww, wr := wrapRequestAndResponse(w, r, app)
 		var d *HttpResponseData
-		if method != r.Method {
+		if !allowed[r.Method] {
 			d = &HttpResponseData{
 				Status: http.StatusMethodNotAllowed,
-				Header: CreateHeader(HeaderContentType, ContentTypeValueText, "Allow", method),
+				Header: http.Header{HeaderContentType: {ContentTypeValueText}, "Allow": {allow}},
 				Body:   strings.NewReader(fmt.Sprintf("Method %v not allowed for resource %v", r.Method, r.URL.Path)),
 			}
 		} else {
@@ -218,13 +246,26 @@ func registerHandlers(app *AppRuntime) *http.ServeMux {
 	mux.Handle("/keepalive", handler(app, http.MethodGet, Keepalive))
 
 	// login
-	mux.Handle("/api/manage/login", handler(app, http.MethodGet, LoginManageLogin()))
-	mux.Handle("/api/login", handler(app, http.MethodGet, Login()))
-	mux.Handle("/api/login/create", handler(app, http.MethodGet, LoginCreate()))
-	mux.Handle("/api/login/update", handler(app, http.MethodGet, LoginUpdate()))
+	loginMethods := []string{http.MethodPost}
+	if app.Conf.LegacyGetLogin {
+		loginMethods = append(loginMethods, http.MethodGet)
+	}
+	mux.Handle("/api/manage/login", methodsHandler(app, loginMethods, LoginManageLogin()))
+	mux.Handle("/api/login", methodsHandler(app, loginMethods, Login()))
+	mux.Handle("/api/token/refresh", handler(app, http.MethodPost, TokenRefresh()))
+	mux.Handle("/api/logout", handler(app, http.MethodPost, Logout()))
+	mux.Handle(JWKSPath, handler(app, http.MethodGet, JWKSGet()))
+	mux.Handle(OIDCLoginPath, handler(app, http.MethodGet, OIDCLogin()))
+	mux.Handle(OIDCCallbackPath, handler(app, http.MethodGet, OIDCCallback()))
+	mux.Handle("/api/sessions", handler(app, http.MethodGet, SessionsGet()))
+	mux.Handle("/api/session/revoke", handler(app, http.MethodGet, SessionRevoke()))
+	mux.Handle("/api/login/create", handler(app, http.MethodPost, LoginCreate()))
+	mux.Handle("/api/login/update", handler(app, http.MethodPost, LoginUpdate()))
 	mux.Handle("/api/login/delete", handler(app, http.MethodGet, LoginDelete()))
 	mux.Handle("/api/login/roles", handler(app, http.MethodGet, LoginRoles()))
 	mux.Handle("/api/login/users", handler(app, http.MethodGet, LoginUsers()))
+	mux.Handle("/api/login/sessions", handler(app, http.MethodGet, LoginSessionsGet()))
+	mux.Handle("/api/login/sessions/revoke", handler(app, http.MethodGet, LoginSessionsRevoke()))
 
 	// article update endpoints
 	mux.Handle("/api/article/create", handler(app, http.MethodGet, ArticleCreate()))
@@ -236,14 +277,48 @@ func registerHandlers(app *AppRuntime) *http.ServeMux {
 	mux.Handle("/api/article/discard-other", handler(app, http.MethodGet, ArticleDiscardOther()))
 	mux.Handle("/api/article/publish", handler(app, http.MethodGet, ArticlePublish()))
 	mux.Handle("/api/article/unpublish", handler(app, http.MethodGet, ArticleUnpublish()))
+	mux.Handle("/api/article/revert", handler(app, http.MethodGet, ArticleRevert()))
+	mux.Handle("/api/article/review/start", handler(app, http.MethodGet, ArticleReviewStart()))
+	mux.Handle("/api/article/review/approve", handler(app, http.MethodGet, ArticleReviewApprove()))
+	mux.Handle("/api/article/review/reject", handler(app, http.MethodGet, ArticleReviewReject()))
+	mux.Handle("/api/article/schedule", handler(app, http.MethodGet, ArticleSchedulesGet()))
+	mux.Handle("/api/article/schedule/create", handler(app, http.MethodGet, ArticleScheduleCreate()))
+	mux.Handle("/api/article/schedule/cancel", handler(app, http.MethodGet, ArticleScheduleCancel()))
+	mux.Handle("/api/article/lock/heartbeat", handler(app, http.MethodGet, ArticleLockHeartbeat()))
+	mux.Handle("/api/article/lock/release", handler(app, http.MethodGet, ArticleLockRelease()))
+	mux.Handle("/api/article/lock/steal", handler(app, http.MethodGet, ArticleLockSteal()))
 
 	// article(s) get endpoints
 	mux.Handle("/api/article", handler(app, http.MethodGet, ArticleGet()))
 	mux.Handle("/api/articles", handler(app, http.MethodGet, ArticlesGet()))
+	mux.Handle("/api/article/diff", handler(app, http.MethodGet, ArticleDiffGet()))
+	mux.Handle("/api/search", handler(app, http.MethodGet, ArticleSearch()))
+	mux.Handle("/api/tags", handler(app, http.MethodGet, TagsGet()))
+	mux.Handle("/api/taxonomy", handler(app, http.MethodGet, TaxonomyGet()))
+	mux.Handle("/api/taxonomy/create", handler(app, http.MethodGet, TaxonomyCreate()))
+	mux.Handle("/api/taxonomy/update", handler(app, http.MethodGet, TaxonomyUpdate()))
+	mux.Handle("/api/taxonomy/delete", handler(app, http.MethodGet, TaxonomyDelete()))
+	mux.Handle("/api/media", handler(app, http.MethodGet, MediaGet()))
+	mux.Handle("/api/media/list", handler(app, http.MethodGet, MediaList()))
+	mux.Handle("/api/media/upload", handler(app, http.MethodPost, MediaUpload()))
+	mux.Handle("/api/media/update", handler(app, http.MethodGet, MediaUpdate()))
 
 	// frontend article(s) endpoints
 	mux.Handle("/article", handler(app, http.MethodGet, FEArticlePage()))
 	mux.Handle("/articles", handler(app, http.MethodGet, FEArticlesPage()))
+	mux.Handle("/tag", handler(app, http.MethodGet, FETagPage()))
+	mux.Handle("/search", handler(app, http.MethodGet, FESearchPage()))
+	mux.Handle(MediaPathPrefix, handler(app, http.MethodGet, FEMedia()))
+	mux.Handle("/feed.rss", handler(app, http.MethodGet, FEFeed(FeedTypeRSS)))
+	mux.Handle("/feed.atom", handler(app, http.MethodGet, FEFeed(FeedTypeAtom)))
+	mux.Handle("/tag/feed.rss", handler(app, http.MethodGet, FETagFeed(FeedTypeRSS)))
+	mux.Handle("/tag/feed.atom", handler(app, http.MethodGet, FETagFeed(FeedTypeAtom)))
+	mux.Handle(SitemapPath, handler(app, http.MethodGet, FESitemap()))
+	mux.Handle(NewsSitemapPath, handler(app, http.MethodGet, FENewsSitemap()))
+
+	// public content api
+	mux.Handle(ContentApiPath, handler(app, http.MethodGet, ContentArticlesGet()))
+	mux.Handle(ContentApiPath+"/", handler(app, http.MethodGet, ContentArticleGet()))
 
 	// cms endpoints
 	mux.Handle("/cms/user", handler(app, http.MethodGet, CmsPage("cms/user")))
@@ -267,6 +342,9 @@ func StartAPIServer(app *AppRuntime) {
 		WriteTimeout: app.Conf.ServerWriteTimeout,
 	}
 
+	// start scheduled publish/unpublish
+	StartScheduler(app)
+
 	// start server
 	logger.Pinfof("starting api server on %v", addr)
 	if err := srv.ListenAndServe(); err != nil {
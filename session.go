@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// A login session, created on login and referenced (by id) from both the
+// access and the refresh tokens issued for it. Deleting it revokes them.
+type CmsSession struct {
+	Id          string    `json:"id"`
+	Username    string    `json:"username"`
+	CreatedAt   *JSONTime `json:"created_at"`
+	RefreshedAt *JSONTime `json:"refreshed_at,omitempty"`
+
+	// when the refresh token expires, nil for never
+	ExpireAt *JSONTime `json:"expire_at,omitempty"`
+
+	RemoteIP  string `json:"remote_ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+func NewCmsSession(r *http.Request, username string, maxAge time.Duration) *CmsSession {
+	now := time.Now().UTC()
+	session := &CmsSession{
+		Id:        xid.New().String(),
+		Username:  username,
+		CreatedAt: &JSONTime{now},
+		RemoteIP:  IPFromRequestRemoteAddr(r.RemoteAddr),
+		UserAgent: r.UserAgent(),
+	}
+	if ip := r.Header.Get("X-Forwarded-For"); ip != "" {
+		session.RemoteIP = ip
+	}
+	if maxAge > 0 {
+		session.ExpireAt = &JSONTime{now.Add(maxAge)}
+	}
+	return session
+}
+
+func (s *CmsSession) Expired(now time.Time) bool {
+	return s.ExpireAt != nil && !s.ExpireAt.T.After(now)
+}
+
+// Unexpired sessions of the user sorted by created_at desc, used by
+// stores which have to scan through all sessions
+func filterSessions(sessions []*CmsSession, username string) []*CmsSession {
+	now := time.Now().UTC()
+	result := make([]*CmsSession, 0)
+	for _, s := range sessions {
+		if s.Username == username && !s.Expired(now) {
+			result = append(result, s)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.T.After(result[j].CreatedAt.T)
+	})
+	return result
+}
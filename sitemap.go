@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// max number of urls in a sitemap (as in sitemaps.org), beyond it
+	// the sitemap is split into pages listed in a sitemap index
+	SitemapMaxUrls = 50000
+
+	// google news sitemaps have articles published within the last two
+	// days, at most 1000 of them
+	NewsSitemapMaxAge  = 48 * time.Hour
+	NewsSitemapMaxUrls = 1000
+
+	// how long the articles loaded for the sitemaps are reused
+	sitemapCacheTTL = 5 * time.Minute
+
+	sitemapNS     = "http://www.sitemaps.org/schemas/sitemap/0.9"
+	newsSitemapNS = "http://www.google.com/schemas/sitemap-news/0.9"
+)
+
+// What a sitemap needs of a published article
+type SitemapArticle struct {
+	Guid        string
+	Version     string
+	Headline    string
+	CreatedAt   time.Time
+	RevisedAt   time.Time
+	PublishedAt time.Time
+}
+
+func newSitemapArticle(a *Article) *SitemapArticle {
+	return &SitemapArticle{
+		Guid:        a.Guid,
+		Version:     a.Version,
+		Headline:    a.Headline,
+		CreatedAt:   articleCreatedTime(a),
+		RevisedAt:   articleRevisedTime(a),
+		PublishedAt: articlePublishedTime(a),
+	}
+}
+
+// Scrolls through all published articles (sorted by created_at desc)
+// in pages of the given size
+func scrollPublishedArticles(ctx context.Context, app *AppRuntime, size int, fn func(*Article)) error {
+	q := &ArticleQuery{
+		Types:        []string{app.Conf.ArticleIndexTypes.Publish},
+		CreatedAfter: time.Unix(0, 0).UTC(),
+		Size:         size,
+	}
+	for {
+		docs, err := app.Articles.Search(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			fn(doc.Article)
+		}
+		if len(docs) < size {
+			return nil
+		}
+		q.SearchAfter = docs[len(docs)-1].Sort
+	}
+}
+
+// All published articles sorted by created_at then guid, so that the
+// sitemap pages only change where articles are added/removed instead of
+// shifting with every new article
+func loadSitemapArticles(ctx context.Context, app *AppRuntime) ([]*SitemapArticle, error) {
+	articles := make([]*SitemapArticle, 0)
+	err := scrollPublishedArticles(ctx, app, 1000, func(a *Article) {
+		articles = append(articles, newSitemapArticle(a))
+	})
+	sort.Slice(articles, func(i, j int) bool {
+		x, y := articles[i], articles[j]
+		if !x.CreatedAt.Equal(y.CreatedAt) {
+			return x.CreatedAt.Before(y.CreatedAt)
+		}
+		return x.Guid < y.Guid
+	})
+	return articles, err
+}
+
+// Articles published since the given time for the news sitemap, the
+// latest published ones if there are too many
+func loadNewsSitemapArticles(ctx context.Context, app *AppRuntime, since time.Time) ([]*SitemapArticle, error) {
+	published, err := app.Articles.ListRecentlyPublished(ctx, since, NewsSitemapMaxUrls)
+	if err != nil {
+		return nil, err
+	}
+	articles := make([]*SitemapArticle, len(published))
+	for i, a := range published {
+		articles[i] = newSitemapArticle(a)
+	}
+	return articles, nil
+}
+
+type sitemapCacheEntry struct {
+	l        *sync.Mutex
+	articles []*SitemapArticle
+	loadedAt time.Time
+}
+
+// Keeps the articles loaded for the sitemaps for a while so that
+// crawlers don't have all published articles scrolled on every request
+type SitemapCache struct {
+	ttl time.Duration
+
+	l       *sync.Mutex
+	entries map[string]*sitemapCacheEntry
+}
+
+func NewSitemapCache(ttl time.Duration) *SitemapCache {
+	return &SitemapCache{
+		ttl:     ttl,
+		l:       &sync.Mutex{},
+		entries: make(map[string]*sitemapCacheEntry),
+	}
+}
+
+func (c *SitemapCache) entry(name string) *sitemapCacheEntry {
+	c.l.Lock()
+	defer c.l.Unlock()
+	e, ok := c.entries[name]
+	if !ok {
+		e = &sitemapCacheEntry{l: &sync.Mutex{}}
+		c.entries[name] = e
+	}
+	return e
+}
+
+// Returns the cached articles of the name, or loads (and caches) them if
+// they are not there or expired. Loads of the same name are serialized
+// so that concurrent requests don't load the same articles again, those
+// of different names don't wait for each other.
+func (c *SitemapCache) Get(name string, load func() ([]*SitemapArticle, error)) ([]*SitemapArticle, error) {
+	e := c.entry(name)
+	e.l.Lock()
+	defer e.l.Unlock()
+	now := time.Now()
+	if e.articles != nil && now.Sub(e.loadedAt) < c.ttl {
+		return e.articles, nil
+	}
+	articles, err := load()
+	if err != nil {
+		return nil, err
+	}
+	e.articles, e.loadedAt = articles, now
+	return articles, nil
+}
+
+// number of sitemap pages (at least one) for n articles
+func sitemapPages(n int) int {
+	if n <= SitemapMaxUrls {
+		return 1
+	}
+	return (n + SitemapMaxUrls - 1) / SitemapMaxUrls
+}
+
+// articles of the 1-based sitemap page, nil if there is no such page
+func sitemapPage(articles []*SitemapArticle, page int) []*SitemapArticle {
+	if page < 1 || page > sitemapPages(len(articles)) {
+		return nil
+	}
+	from := (page - 1) * SitemapMaxUrls
+	to := from + SitemapMaxUrls
+	if to > len(articles) {
+		to = len(articles)
+	}
+	return articles[from:to]
+}
+
+// the latest revised time of the articles
+func sitemapLastMod(articles []*SitemapArticle) time.Time {
+	var lastMod time.Time
+	for _, a := range articles {
+		if a.RevisedAt.After(lastMod) {
+			lastMod = a.RevisedAt
+		}
+	}
+	return lastMod
+}
+
+func sitemapTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+type sitemapUrlSet struct {
+	XMLName xml.Name      `xml:"urlset"`
+	NS      string        `xml:"xmlns,attr"`
+	NewsNS  string        `xml:"xmlns:news,attr,omitempty"`
+	Url     []*sitemapUrl `xml:"url"`
+}
+
+type sitemapUrl struct {
+	Loc     string       `xml:"loc"`
+	LastMod string       `xml:"lastmod,omitempty"`
+	News    *sitemapNews `xml:"news:news,omitempty"`
+}
+
+type sitemapNews struct {
+	Publication     *sitemapNewsPublication `xml:"news:publication"`
+	PublicationDate string                  `xml:"news:publication_date"`
+	Title           string                  `xml:"news:title"`
+}
+
+type sitemapNewsPublication struct {
+	Name     string `xml:"news:name"`
+	Language string `xml:"news:language"`
+}
+
+type sitemapIndex struct {
+	XMLName xml.Name            `xml:"sitemapindex"`
+	NS      string              `xml:"xmlns,attr"`
+	Sitemap []*sitemapIndexItem `xml:"sitemap"`
+}
+
+type sitemapIndexItem struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func articlePageUrl(baseUrl string, a *SitemapArticle) string {
+	return fmt.Sprintf("%v/article?id=%v:%v", baseUrl, a.Guid, a.Version)
+}
+
+// Sitemap of the articles with lastmod from revised_at
+func BuildSitemap(baseUrl string, articles []*SitemapArticle) ([]byte, error) {
+	urlset := &sitemapUrlSet{
+		NS:  sitemapNS,
+		Url: make([]*sitemapUrl, 0, len(articles)),
+	}
+	for _, a := range articles {
+		urlset.Url = append(urlset.Url, &sitemapUrl{
+			Loc:     articlePageUrl(baseUrl, a),
+			LastMod: sitemapTime(a.RevisedAt),
+		})
+	}
+	return marshalXMLDoc(urlset)
+}
+
+// Sitemap index of the pages (at sitemapPath?page=N) of the articles
+func BuildSitemapIndex(baseUrl, sitemapPath string, articles []*SitemapArticle) ([]byte, error) {
+	pages := sitemapPages(len(articles))
+	index := &sitemapIndex{
+		NS:      sitemapNS,
+		Sitemap: make([]*sitemapIndexItem, 0, pages),
+	}
+	for page := 1; page <= pages; page++ {
+		index.Sitemap = append(index.Sitemap, &sitemapIndexItem{
+			Loc:     fmt.Sprintf("%v%v?page=%v", baseUrl, sitemapPath, page),
+			LastMod: sitemapTime(sitemapLastMod(sitemapPage(articles, page))),
+		})
+	}
+	return marshalXMLDoc(index)
+}
+
+// Google news sitemap of the articles published since the given time,
+// the latest published ones first if there are too many
+func BuildNewsSitemap(baseUrl, name, language string, articles []*SitemapArticle, since time.Time) ([]byte, error) {
+	urlset := &sitemapUrlSet{
+		NS:     sitemapNS,
+		NewsNS: newsSitemapNS,
+		Url:    make([]*sitemapUrl, 0),
+	}
+	recent := make([]*SitemapArticle, 0)
+	for _, a := range articles {
+		if a.PublishedAt.After(since) {
+			recent = append(recent, a)
+		}
+	}
+	sort.SliceStable(recent, func(i, j int) bool {
+		return recent[i].PublishedAt.After(recent[j].PublishedAt)
+	})
+	if len(recent) > NewsSitemapMaxUrls {
+		recent = recent[0:NewsSitemapMaxUrls]
+	}
+	for _, a := range recent {
+		urlset.Url = append(urlset.Url, &sitemapUrl{
+			Loc:     articlePageUrl(baseUrl, a),
+			LastMod: sitemapTime(a.RevisedAt),
+			News: &sitemapNews{
+				Publication:     &sitemapNewsPublication{Name: name, Language: language},
+				PublicationDate: sitemapTime(a.PublishedAt),
+				Title:           a.Headline,
+			},
+		})
+	}
+	return marshalXMLDoc(urlset)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSitemapIndex(t *testing.T) {
+	now := time.Date(2017, 10, 27, 8, 0, 0, 0, time.UTC)
+	articles := make([]*SitemapArticle, SitemapMaxUrls+1)
+	for i := range articles {
+		articles[i] = &SitemapArticle{Guid: "g", Version: "1", RevisedAt: now.Add(-time.Duration(i) * time.Second)}
+	}
+	if n := sitemapPages(SitemapMaxUrls); n != 1 {
+		t.Errorf("expecting 1 page, but got %v", n)
+	}
+	data, err := BuildSitemapIndex("https://news.example.com", SitemapPath, articles)
+	if err != nil {
+		t.Errorf("failed to build sitemap index, error: %v", err)
+		return
+	}
+	index := &sitemapIndex{}
+	xml.Unmarshal(data, index)
+	if len(index.Sitemap) != 2 || index.Sitemap[1].Loc != "https://news.example.com/sitemap.xml?page=2" || index.Sitemap[1].LastMod != sitemapTime(articles[SitemapMaxUrls].RevisedAt) {
+		t.Errorf("unexpected sitemap index %+v", index.Sitemap)
+	}
+	if page := sitemapPage(articles, 2); len(page) != 1 || page[0] != articles[SitemapMaxUrls] {
+		t.Errorf("unexpected last page %v", page)
+	}
+	if page := sitemapPage(articles, 3); page != nil {
+		t.Errorf("expecting no page 3, but got %v", page)
+	}
+}
+
+func TestNewsSitemap(t *testing.T) {
+	now := time.Date(2017, 10, 27, 8, 0, 0, 0, time.UTC)
+	articles := []*SitemapArticle{
+		&SitemapArticle{Guid: "old", Version: "1", Headline: "old", PublishedAt: now.Add(-49 * time.Hour)},
+		&SitemapArticle{Guid: "a", Version: "1", Headline: "a", PublishedAt: now.Add(-2 * time.Hour)},
+		&SitemapArticle{Guid: "b", Version: "2", Headline: "b & c", PublishedAt: now.Add(-1 * time.Hour)},
+	}
+	data, err := BuildNewsSitemap("https://news.example.com", "Example News", "en", articles, now.Add(-NewsSitemapMaxAge))
+	if err != nil {
+		t.Errorf("failed to build news sitemap, error: %v", err)
+		return
+	}
+	urlset := &struct {
+		Url []struct {
+			Loc   string `xml:"loc"`
+			Title string `xml:"news>title"`
+			Name  string `xml:"news>publication>name"`
+		} `xml:"url"`
+	}{}
+	if err := xml.Unmarshal(data, urlset); err != nil {
+		t.Errorf("failed to parse news sitemap, error: %v", err)
+		return
+	}
+	if len(urlset.Url) != 2 || urlset.Url[0].Loc != "https://news.example.com/article?id=b:2" || urlset.Url[0].Title != "b & c" || urlset.Url[1].Name != "Example News" {
+		t.Errorf("unexpected news sitemap %s", data)
+	}
+}
+
+func TestFESitemap(t *testing.T) {
+	app := &AppRuntime{
+		Conf:     &AppConf{ArticleIndexTypes: articleIndexTypes, PublicUrl: "https://news.example.com"},
+		Articles: NewMemoryArticleStore(articleIndexTypes),
+		Sitemaps: NewSitemapCache(time.Hour),
+	}
+	ctx := context.Background()
+	now := time.Now().UTC()
+	// z is created first, x and y at the same time
+	for guid, created := range map[string]time.Time{"y": now, "x": now, "z": now.Add(-time.Hour)} {
+		app.Articles.UpsertPublish(ctx, &Article{Id: guid, Guid: guid, Version: "1", CreatedAt: &JSONTime{created}, RevisedAt: &JSONTime{now}, PublishedAt: &JSONTime{created}})
+	}
+	d := getFESitemap(app, nil, testContentRequest(SitemapPath))
+	body, _ := ioutil.ReadAll(d.Body)
+	urlset := &sitemapUrlSet{}
+	if err := xml.Unmarshal(body, urlset); err != nil || d.Status != http.StatusOK || len(urlset.Url) != 3 {
+		t.Errorf("unexpected sitemap %v (error: %v): %s", d.Status, err, body)
+		return
+	}
+	for i, guid := range []string{"z", "x", "y"} {
+		if loc := urlset.Url[i].Loc; loc != "https://news.example.com/article?id="+guid+":1" {
+			t.Errorf("expecting %v at %v, but got %v", guid, i, loc)
+		}
+	}
+	if d = getFESitemap(app, nil, testContentRequest(SitemapPath+"?page=2")); d.Status != http.StatusNotFound {
+		t.Errorf("expecting 404 for page 2, but got %v", d.Status)
+	}
+
+	// cached
+	app.Articles.DeletePublish(ctx, "x")
+	d = getFESitemap(app, nil, testContentRequest(SitemapPath))
+	body, _ = ioutil.ReadAll(d.Body)
+	if urlset = (&sitemapUrlSet{}); xml.Unmarshal(body, urlset) != nil || len(urlset.Url) != 3 {
+		t.Errorf("expecting the cached sitemap, but got %s", body)
+	}
+	app.Sitemaps = NewSitemapCache(0)
+	d = getFESitemap(app, nil, testContentRequest(SitemapPath))
+	body, _ = ioutil.ReadAll(d.Body)
+	if urlset = (&sitemapUrlSet{}); xml.Unmarshal(body, urlset) != nil || len(urlset.Url) != 2 {
+		t.Errorf("expecting the sitemap reloaded, but got %s", body)
+	}
+	if lastModified := d.Header.Get("Last-Modified"); lastModified != "" {
+		t.Errorf("expecting no Last-Modified, but got %v", lastModified)
+	}
+	if d = getFESitemap(app, nil, testContentRequest(SitemapPath, "If-None-Match", d.Header.Get("ETag"))); d.Status != http.StatusNotModified {
+		t.Errorf("expecting 304, but got %v", d.Status)
+	}
+
+	// old is published before NewsSitemapMaxAge, y is the latest one
+	app.Articles.UpsertPublish(ctx, &Article{Id: "old", Guid: "old", Version: "1", PublishedAt: &JSONTime{now.Add(-NewsSitemapMaxAge - time.Hour)}})
+	d = getFENewsSitemap(app, nil, testContentRequest(NewsSitemapPath))
+	body, _ = ioutil.ReadAll(d.Body)
+	if urlset = (&sitemapUrlSet{}); xml.Unmarshal(body, urlset) != nil || len(urlset.Url) != 2 || urlset.Url[0].Loc != "https://news.example.com/article?id=y:1" {
+		t.Errorf("unexpected news sitemap %s", body)
+	}
+}
+
+func TestSitemapCache(t *testing.T) {
+	cache := NewSitemapCache(time.Hour)
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := cache.Get("a", func() ([]*SitemapArticle, error) {
+			close(loading)
+			<-release
+			return []*SitemapArticle{}, nil
+		})
+		done <- err
+	}()
+	<-loading
+	// b doesn't wait for a being loaded
+	articles, err := cache.Get("b", func() ([]*SitemapArticle, error) {
+		return []*SitemapArticle{&SitemapArticle{Guid: "x"}}, nil
+	})
+	if err != nil || len(articles) != 1 {
+		t.Errorf("unexpected articles %v (error: %v)", articles, err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("failed to load a, error: %v", err)
+	}
+	// a is cached
+	articles, err = cache.Get("a", func() ([]*SitemapArticle, error) {
+		return nil, fmt.Errorf("loaded again")
+	})
+	if err != nil || len(articles) != 0 {
+		t.Errorf("expecting the cached articles, but got %v (error: %v)", articles, err)
+	}
+}
+
+func TestListRecentlyPublished(t *testing.T) {
+	s := NewMemoryArticleStore(articleIndexTypes)
+	ctx := context.Background()
+	now := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		guid := fmt.Sprintf("g%v", i)
+		s.UpsertPublish(ctx, &Article{Id: guid, Guid: guid, PublishedAt: &JSONTime{now.Add(-time.Duration(i) * time.Hour)}})
+	}
+	s.UpsertPublish(ctx, &Article{Id: "none", Guid: "none"})
+	articles, err := s.ListRecentlyPublished(ctx, now.Add(-3*time.Hour), 2)
+	if err != nil || len(articles) != 2 || articles[0].Guid != "g0" || articles[1].Guid != "g1" {
+		t.Errorf("unexpected recently published articles %v (error: %v)", articles, err)
+	}
+	if articles, _ = s.ListRecentlyPublished(ctx, now.Add(-3*time.Hour), 10); len(articles) != 4 {
+		t.Errorf("expecting 4 articles published within 3 hours, but got %v", len(articles))
+	}
+}
@@ -0,0 +1,999 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/xid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Stores everything in a single bolt (https://github.com/etcd-io/bbolt) file,
+// meant for small sites which don't need an elasticsearch cluster.
+//
+// Each article type (draft/version/publish) and the users are kept in
+// their own bucket with the doc id as the key and the json doc as the value.
+
+var boltUserBucket = []byte("user")
+
+func boltArticleBucket(typ string) []byte {
+	return []byte(fmt.Sprintf("article.%v", typ))
+}
+
+func OpenBoltDB(path string) (*bolt.DB, error) {
+	return bolt.Open(path, 0600, &bolt.Options{Timeout: 3 * time.Second})
+}
+
+type BoltArticleStore struct {
+	db    *bolt.DB
+	types *ArticleIndexTypes
+}
+
+func (s *BoltArticleStore) bucket(tx *bolt.Tx, typ string) (*bolt.Bucket, error) {
+	b := tx.Bucket(boltArticleBucket(typ))
+	if b == nil {
+		return nil, fmt.Errorf("unknown article type %v!", typ)
+	}
+	return b, nil
+}
+
+func (s *BoltArticleStore) get(tx *bolt.Tx, typ, id string) (*Article, error) {
+	b, err := s.bucket(tx, typ)
+	if err != nil {
+		return nil, err
+	}
+	data := b.Get([]byte(id))
+	if data == nil {
+		return nil, ErrStoreNotFound
+	}
+	a, err := unmarshalArticle(data)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal article %v error: %v", id, err)
+	}
+	a.Id = id
+	return a, nil
+}
+
+func (s *BoltArticleStore) put(tx *bolt.Tx, typ string, a *Article) error {
+	b, err := s.bucket(tx, typ)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(a.Id), data)
+}
+
+func (s *BoltArticleStore) delete(typ, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, typ)
+		if err != nil {
+			return err
+		}
+		if b.Get([]byte(id)) == nil {
+			return ErrStoreNotFound
+		}
+		return b.Delete([]byte(id))
+	})
+}
+
+func (s *BoltArticleStore) Get(ctx context.Context, typ, id string) (*Article, error) {
+	var article *Article
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		article, err = s.get(tx, typ, id)
+		return err
+	})
+	return article, err
+}
+
+func (s *BoltArticleStore) CreateDraft(ctx context.Context, article *Article) (*Article, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if article.Id == "" {
+			article.Id = xid.New().String()
+		} else if _, err := s.get(tx, s.types.Draft, article.Id); err == nil {
+			return ErrStoreConflict
+		} else if err != ErrStoreNotFound {
+			return err
+		}
+		article.Rev = 1
+		return s.put(tx, s.types.Draft, article)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return article, nil
+}
+
+func (s *BoltArticleStore) SaveDraft(ctx context.Context, username string, article *Article, waitForRefresh bool) (*Article, error) {
+	var draft *Article
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		draft, err = s.get(tx, s.types.Draft, article.Id)
+		if err != nil {
+			return err
+		}
+		// same check as ESScriptSaveArticle does
+		if draft.LockedBy != username {
+			return ErrStoreLocked
+		}
+		if article.Rev > 0 && article.Rev != draft.Rev {
+			return ErrStoreConflict
+		}
+		draft.Guid = article.Guid
+		draft.Headline = article.Headline
+		draft.Summary = article.Summary
+		draft.Content = article.Content
+		draft.Body = article.Body
+		draft.ContentFormat = article.ContentFormat
+		draft.ContentText = article.ContentText
+		draft.LeadImage = article.LeadImage
+		draft.Tag = article.Tag
+		draft.Note = article.Note
+		draft.RevisedAt = article.RevisedAt
+		draft.LockHeartbeat = article.RevisedAt
+		draft.Rev += 1
+		return s.put(tx, s.types.Draft, draft)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+func (s *BoltArticleStore) UpdateDraftLock(ctx context.Context, article *Article, lockedBy string) (*Article, error) {
+	var draft *Article
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		draft, err = s.get(tx, s.types.Draft, article.Id)
+		if err != nil {
+			return err
+		}
+		if draft.LockedBy != lockedBy {
+			return ErrStoreConflict
+		}
+		draft.LockedBy = article.LockedBy
+		draft.LockedAt = article.LockedAt
+		draft.LockHeartbeat = article.LockHeartbeat
+		return s.put(tx, s.types.Draft, draft)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+func (s *BoltArticleStore) UpdateVersionState(ctx context.Context, article *Article, from ArticleState) (*Article, error) {
+	var version *Article
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		version, err = s.get(tx, s.types.Version, article.Id)
+		if err != nil {
+			return err
+		}
+		if version.GetState() != from {
+			return ErrStoreConflict
+		}
+		version.State = article.State
+		version.StateBy = article.StateBy
+		version.StateAt = article.StateAt
+		version.StateReason = article.StateReason
+		return s.put(tx, s.types.Version, version)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func (s *BoltArticleStore) DiscardDraft(ctx context.Context, username, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		draft, err := s.get(tx, s.types.Draft, id)
+		if err != nil {
+			return err
+		}
+		if draft.LockedBy != username {
+			return ErrStoreLocked
+		}
+		b, _ := s.bucket(tx, s.types.Draft)
+		return b.Delete([]byte(id))
+	})
+}
+
+func (s *BoltArticleStore) DeleteDraft(ctx context.Context, id string) error {
+	return s.delete(s.types.Draft, id)
+}
+
+func (s *BoltArticleStore) CreateVersion(ctx context.Context, article *Article) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, s.types.Version, article.Id); err == nil {
+			return ErrStoreConflict
+		} else if err != ErrStoreNotFound {
+			return err
+		}
+		return s.put(tx, s.types.Version, article)
+	})
+}
+
+func (s *BoltArticleStore) SubmitDraft(ctx context.Context, version *Article) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if existing, err := s.get(tx, s.types.Version, version.Id); err == ErrStoreNotFound {
+			if err := s.put(tx, s.types.Version, version); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if !sameArticleContent(existing, version) {
+			return ErrStoreConflict
+		}
+		b, err := s.bucket(tx, s.types.Draft)
+		if err != nil {
+			return err
+		}
+		return b.Delete([]byte(version.Guid))
+	})
+}
+
+func (s *BoltArticleStore) UpsertPublish(ctx context.Context, article *Article) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.put(tx, s.types.Publish, article)
+	})
+}
+
+func (s *BoltArticleStore) DeletePublish(ctx context.Context, id string) error {
+	return s.delete(s.types.Publish, id)
+}
+
+// there is no index other than the doc id, so search is a full scan
+func (s *BoltArticleStore) Search(ctx context.Context, q *ArticleQuery) ([]*ArticleDoc, error) {
+	result := make([]*ArticleDoc, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, typ := range q.Types {
+			b, err := s.bucket(tx, typ)
+			if err != nil {
+				return err
+			}
+			err = b.ForEach(func(k, v []byte) error {
+				a, err := unmarshalArticle(v)
+				if err != nil {
+					return fmt.Errorf("unmarshal article %v error: %v", string(k), err)
+				}
+				a.Id = string(k)
+				if doc := matchArticleQuery(q, typ, a); doc != nil {
+					doc.Article = a
+					result = append(result, doc)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortArticleDocs(result, q.Size), nil
+}
+
+func (s *BoltArticleStore) TextSearch(ctx context.Context, q *ArticleTextQuery) (*ArticleSearchResult, error) {
+	terms := searchTerms(q.Text)
+	result := make([]*ArticleDoc, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, typ := range q.Types {
+			b, err := s.bucket(tx, typ)
+			if err != nil {
+				return err
+			}
+			err = b.ForEach(func(k, v []byte) error {
+				a, err := unmarshalArticle(v)
+				if err != nil {
+					return fmt.Errorf("unmarshal article %v error: %v", string(k), err)
+				}
+				a.Id = string(k)
+				if doc := matchArticleTextQuery(q, terms, typ, a); doc != nil {
+					doc.Article = a
+					result = append(result, doc)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pageArticleSearchDocs(q, result), nil
+}
+
+func (s *BoltArticleStore) GetLatestVersion(ctx context.Context, guid string) (*Article, error) {
+	var latest *Article
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, s.types.Version)
+		if err != nil {
+			return err
+		}
+		prefix := []byte(fmt.Sprintf("%v:", guid))
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			a, err := unmarshalArticle(v)
+			if err != nil {
+				return fmt.Errorf("unmarshal article %v error: %v", string(k), err)
+			}
+			a.Id = string(k)
+			if latest == nil || articleVersionNumber(a) > articleVersionNumber(latest) {
+				latest = a
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	} else if latest == nil {
+		return nil, ErrStoreNotFound
+	}
+	return latest, nil
+}
+
+func (s *BoltArticleStore) GetByGuid(ctx context.Context, guid string) ([]*ArticleDoc, error) {
+	result := make([]*ArticleDoc, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// draft and publish are keyed by guid
+		for _, typ := range []string{s.types.Draft, s.types.Publish} {
+			a, err := s.get(tx, typ, guid)
+			if err == nil {
+				result = append(result, &ArticleDoc{Type: typ, Article: a})
+			} else if err != ErrStoreNotFound {
+				return err
+			}
+		}
+		// versions are keyed by "guid:version"
+		b, err := s.bucket(tx, s.types.Version)
+		if err != nil {
+			return err
+		}
+		prefix := []byte(fmt.Sprintf("%v:", guid))
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			a, err := unmarshalArticle(v)
+			if err != nil {
+				return fmt.Errorf("unmarshal article %v error: %v", string(k), err)
+			}
+			a.Id = string(k)
+			result = append(result, &ArticleDoc{Type: s.types.Version, Article: a})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *BoltArticleStore) ListPublished(ctx context.Context, size int, tags []string) ([]*Article, error) {
+	articles := make([]*Article, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, s.types.Publish)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			a, err := unmarshalArticle(v)
+			if err != nil {
+				return fmt.Errorf("unmarshal article %v error: %v", string(k), err)
+			}
+			a.Id = string(k)
+			if len(tags) == 0 || hasAnyTag(a, tags) {
+				articles = append(articles, a)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortArticlesByRevisedAt(articles, size), nil
+}
+
+func (s *BoltArticleStore) ListRecentlyPublished(ctx context.Context, since time.Time, size int) ([]*Article, error) {
+	articles := make([]*Article, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := s.bucket(tx, s.types.Publish)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			a, err := unmarshalArticle(v)
+			if err != nil {
+				return fmt.Errorf("unmarshal article %v error: %v", string(k), err)
+			}
+			a.Id = string(k)
+			articles = append(articles, a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recentlyPublishedArticles(articles, since, size), nil
+}
+
+func (s *BoltArticleStore) CountTags(ctx context.Context, types []string, size int) (map[string][]*TagCount, error) {
+	result := make(map[string][]*TagCount)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for _, typ := range types {
+			b, err := s.bucket(tx, typ)
+			if err != nil {
+				return err
+			}
+			articles := make([]*Article, 0)
+			err = b.ForEach(func(k, v []byte) error {
+				a, err := unmarshalArticle(v)
+				if err != nil {
+					return fmt.Errorf("unmarshal article %v error: %v", string(k), err)
+				}
+				articles = append(articles, a)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			result[typ] = countArticleTags(articles, size)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func NewBoltArticleStore(db *bolt.DB, types *ArticleIndexTypes) (*BoltArticleStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, typ := range []string{types.Draft, types.Version, types.Publish} {
+			if _, err := tx.CreateBucketIfNotExists(boltArticleBucket(typ)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltArticleStore{
+		db:    db,
+		types: types,
+	}, nil
+}
+
+type BoltUserStore struct {
+	db *bolt.DB
+}
+
+func (s *BoltUserStore) get(tx *bolt.Tx, username string) (*CmsUser, error) {
+	data := tx.Bucket(boltUserBucket).Get([]byte(username))
+	if data == nil {
+		return nil, ErrStoreNotFound
+	}
+	var user CmsUser
+	if err := json.Unmarshal(data, &user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cms user, error: %v", err)
+	}
+	return &user, nil
+}
+
+func (s *BoltUserStore) put(tx *bolt.Tx, user *CmsUser) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltUserBucket).Put([]byte(user.Username), data)
+}
+
+func (s *BoltUserStore) GetUser(ctx context.Context, username string) (*CmsUser, error) {
+	var user *CmsUser
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		user, err = s.get(tx, username)
+		return err
+	})
+	return user, err
+}
+
+func (s *BoltUserStore) CreateUser(ctx context.Context, user *CmsUser) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, user.Username); err == nil {
+			return ErrStoreConflict
+		} else if err != ErrStoreNotFound {
+			return err
+		}
+		return s.put(tx, user)
+	})
+}
+
+func (s *BoltUserStore) UpdateUser(ctx context.Context, username string, fields map[string]interface{}) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		user, err := s.get(tx, username)
+		if err != nil {
+			return err
+		}
+		updated, err := mergeUserFields(user, fields)
+		if err != nil {
+			return err
+		}
+		return s.put(tx, updated)
+	})
+}
+
+func (s *BoltUserStore) DeleteUser(ctx context.Context, username string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltUserBucket)
+		if b.Get([]byte(username)) == nil {
+			return ErrStoreNotFound
+		}
+		return b.Delete([]byte(username))
+	})
+}
+
+// keys are sorted in bolt so users come out sorted by username
+func (s *BoltUserStore) ListUsers(ctx context.Context) ([]*CmsUser, error) {
+	users := make([]*CmsUser, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltUserBucket).ForEach(func(k, v []byte) error {
+			user := &CmsUser{}
+			if err := json.Unmarshal(v, user); err != nil {
+				return fmt.Errorf("failed to decode user %v, error: %v", string(k), err)
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func NewBoltUserStore(db *bolt.DB) (*BoltUserStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltUserBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltUserStore{db: db}, nil
+}
+
+var boltScheduleBucket = []byte("schedule")
+
+type BoltScheduleStore struct {
+	db *bolt.DB
+}
+
+func (s *BoltScheduleStore) get(tx *bolt.Tx, id string) (*ArticleSchedule, error) {
+	data := tx.Bucket(boltScheduleBucket).Get([]byte(id))
+	if data == nil {
+		return nil, ErrStoreNotFound
+	}
+	schedule := &ArticleSchedule{}
+	if err := json.Unmarshal(data, schedule); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schedule %v, error: %v", id, err)
+	}
+	return schedule, nil
+}
+
+func (s *BoltScheduleStore) put(tx *bolt.Tx, schedule *ArticleSchedule) error {
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltScheduleBucket).Put([]byte(schedule.Id), data)
+}
+
+func (s *BoltScheduleStore) CreateSchedule(ctx context.Context, schedule *ArticleSchedule) (*ArticleSchedule, error) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if schedule.Id == "" {
+			schedule.Id = xid.New().String()
+		} else if _, err := s.get(tx, schedule.Id); err == nil {
+			return ErrStoreConflict
+		} else if err != ErrStoreNotFound {
+			return err
+		}
+		return s.put(tx, schedule)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
+func (s *BoltScheduleStore) GetSchedule(ctx context.Context, id string) (*ArticleSchedule, error) {
+	var schedule *ArticleSchedule
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		schedule, err = s.get(tx, id)
+		return err
+	})
+	return schedule, err
+}
+
+func (s *BoltScheduleStore) UpdateSchedule(ctx context.Context, schedule *ArticleSchedule) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, schedule.Id); err != nil {
+			return err
+		}
+		return s.put(tx, schedule)
+	})
+}
+
+// full scan, there shouldn't be many schedules
+func (s *BoltScheduleStore) ListSchedules(ctx context.Context, q *ScheduleQuery) ([]*ArticleSchedule, error) {
+	schedules := make([]*ArticleSchedule, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltScheduleBucket).ForEach(func(k, v []byte) error {
+			schedule := &ArticleSchedule{}
+			if err := json.Unmarshal(v, schedule); err != nil {
+				return fmt.Errorf("failed to unmarshal schedule %v, error: %v", string(k), err)
+			}
+			if matchScheduleQuery(q, schedule) {
+				schedules = append(schedules, schedule)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortSchedules(schedules, q.Size), nil
+}
+
+func NewBoltScheduleStore(db *bolt.DB) (*BoltScheduleStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltScheduleBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltScheduleStore{db: db}, nil
+}
+
+var boltTaxonomyBucket = []byte("taxonomy")
+
+type BoltTaxonomyStore struct {
+	db *bolt.DB
+}
+
+func (s *BoltTaxonomyStore) get(tx *bolt.Tx, id string) (*TaxonomyTerm, error) {
+	data := tx.Bucket(boltTaxonomyBucket).Get([]byte(id))
+	if data == nil {
+		return nil, ErrStoreNotFound
+	}
+	term := &TaxonomyTerm{}
+	if err := json.Unmarshal(data, term); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal taxonomy term %v, error: %v", id, err)
+	}
+	return term, nil
+}
+
+func (s *BoltTaxonomyStore) put(tx *bolt.Tx, term *TaxonomyTerm) error {
+	data, err := json.Marshal(term)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltTaxonomyBucket).Put([]byte(term.Id), data)
+}
+
+func (s *BoltTaxonomyStore) GetTerm(ctx context.Context, id string) (*TaxonomyTerm, error) {
+	var term *TaxonomyTerm
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		term, err = s.get(tx, id)
+		return err
+	})
+	return term, err
+}
+
+func (s *BoltTaxonomyStore) CreateTerm(ctx context.Context, term *TaxonomyTerm) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, term.Id); err == nil {
+			return ErrStoreConflict
+		} else if err != ErrStoreNotFound {
+			return err
+		}
+		return s.put(tx, term)
+	})
+}
+
+func (s *BoltTaxonomyStore) UpdateTerm(ctx context.Context, term *TaxonomyTerm) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, term.Id); err != nil {
+			return err
+		}
+		return s.put(tx, term)
+	})
+}
+
+func (s *BoltTaxonomyStore) DeleteTerm(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, id); err != nil {
+			return err
+		}
+		return tx.Bucket(boltTaxonomyBucket).Delete([]byte(id))
+	})
+}
+
+// keys are sorted in bolt so terms come sorted by id
+func (s *BoltTaxonomyStore) ListTerms(ctx context.Context) ([]*TaxonomyTerm, error) {
+	terms := make([]*TaxonomyTerm, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltTaxonomyBucket).ForEach(func(k, v []byte) error {
+			term := &TaxonomyTerm{}
+			if err := json.Unmarshal(v, term); err != nil {
+				return fmt.Errorf("failed to unmarshal taxonomy term %v, error: %v", string(k), err)
+			}
+			terms = append(terms, term)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+func NewBoltTaxonomyStore(db *bolt.DB) (*BoltTaxonomyStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltTaxonomyBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltTaxonomyStore{db: db}, nil
+}
+
+var boltMediaBucket = []byte("media")
+
+type BoltMediaStore struct {
+	db *bolt.DB
+}
+
+func (s *BoltMediaStore) get(tx *bolt.Tx, id string) (*MediaAsset, error) {
+	data := tx.Bucket(boltMediaBucket).Get([]byte(id))
+	if data == nil {
+		return nil, ErrStoreNotFound
+	}
+	asset := &MediaAsset{}
+	if err := json.Unmarshal(data, asset); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal media asset %v, error: %v", id, err)
+	}
+	return asset, nil
+}
+
+func (s *BoltMediaStore) put(tx *bolt.Tx, asset *MediaAsset) error {
+	data, err := json.Marshal(asset)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltMediaBucket).Put([]byte(asset.Id), data)
+}
+
+func (s *BoltMediaStore) GetMedia(ctx context.Context, id string) (*MediaAsset, error) {
+	var asset *MediaAsset
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		asset, err = s.get(tx, id)
+		return err
+	})
+	return asset, err
+}
+
+func (s *BoltMediaStore) CreateMedia(ctx context.Context, asset *MediaAsset) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, asset.Id); err == nil {
+			return ErrStoreConflict
+		} else if err != ErrStoreNotFound {
+			return err
+		}
+		return s.put(tx, asset)
+	})
+}
+
+func (s *BoltMediaStore) UpdateMedia(ctx context.Context, asset *MediaAsset) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, asset.Id); err != nil {
+			return err
+		}
+		return s.put(tx, asset)
+	})
+}
+
+// keys are content hashes, so all assets are scanned and sorted
+func (s *BoltMediaStore) ListMedia(ctx context.Context, size int) ([]*MediaAsset, error) {
+	assets := make([]*MediaAsset, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMediaBucket).ForEach(func(k, v []byte) error {
+			asset := &MediaAsset{}
+			if err := json.Unmarshal(v, asset); err != nil {
+				return fmt.Errorf("failed to unmarshal media asset %v, error: %v", string(k), err)
+			}
+			assets = append(assets, asset)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sortMediaAssets(assets, size), nil
+}
+
+func NewBoltMediaStore(db *bolt.DB) (*BoltMediaStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltMediaBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltMediaStore{db: db}, nil
+}
+
+var boltSessionBucket = []byte("session")
+
+type BoltSessionStore struct {
+	db *bolt.DB
+}
+
+func (s *BoltSessionStore) get(tx *bolt.Tx, id string) (*CmsSession, error) {
+	data := tx.Bucket(boltSessionBucket).Get([]byte(id))
+	if data == nil {
+		return nil, ErrStoreNotFound
+	}
+	session := &CmsSession{}
+	if err := json.Unmarshal(data, session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session %v, error: %v", id, err)
+	}
+	return session, nil
+}
+
+func (s *BoltSessionStore) put(tx *bolt.Tx, session *CmsSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(boltSessionBucket).Put([]byte(session.Id), data)
+}
+
+// calls fn with each session, it can delete the current one
+func (s *BoltSessionStore) forEach(tx *bolt.Tx, fn func(*CmsSession) error) error {
+	c := tx.Bucket(boltSessionBucket).Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		session := &CmsSession{}
+		if err := json.Unmarshal(v, session); err != nil {
+			return fmt.Errorf("failed to unmarshal session %v, error: %v", string(k), err)
+		}
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *BoltSessionStore) GetSession(ctx context.Context, id string) (*CmsSession, error) {
+	var session *CmsSession
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		session, err = s.get(tx, id)
+		return err
+	})
+	if err == nil && session.Expired(time.Now().UTC()) {
+		return nil, ErrStoreNotFound
+	}
+	return session, err
+}
+
+func (s *BoltSessionStore) CreateSession(ctx context.Context, session *CmsSession) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, session.Id); err == nil {
+			return ErrStoreConflict
+		} else if err != ErrStoreNotFound {
+			return err
+		}
+		// remove expired sessions of the user
+		now := time.Now().UTC()
+		expired := make([][]byte, 0)
+		err := s.forEach(tx, func(one *CmsSession) error {
+			if one.Username == session.Username && one.Expired(now) {
+				expired = append(expired, []byte(one.Id))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range expired {
+			if err := tx.Bucket(boltSessionBucket).Delete(id); err != nil {
+				return err
+			}
+		}
+		return s.put(tx, session)
+	})
+}
+
+func (s *BoltSessionStore) UpdateSession(ctx context.Context, session *CmsSession) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, session.Id); err != nil {
+			return err
+		}
+		return s.put(tx, session)
+	})
+}
+
+func (s *BoltSessionStore) DeleteSession(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := s.get(tx, id); err != nil {
+			return err
+		}
+		return tx.Bucket(boltSessionBucket).Delete([]byte(id))
+	})
+}
+
+func (s *BoltSessionStore) DeleteUserSessions(ctx context.Context, username string) (int, error) {
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		ids := make([][]byte, 0)
+		err := s.forEach(tx, func(session *CmsSession) error {
+			if session.Username == username {
+				ids = append(ids, []byte(session.Id))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := tx.Bucket(boltSessionBucket).Delete(id); err != nil {
+				return err
+			}
+		}
+		deleted = len(ids)
+		return nil
+	})
+	return deleted, err
+}
+
+func (s *BoltSessionStore) ListSessions(ctx context.Context, username string) ([]*CmsSession, error) {
+	sessions := make([]*CmsSession, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return s.forEach(tx, func(session *CmsSession) error {
+			sessions = append(sessions, session)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return filterSessions(sessions, username), nil
+}
+
+func NewBoltSessionStore(db *bolt.DB) (*BoltSessionStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltSessionBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltSessionStore{db: db}, nil
+}
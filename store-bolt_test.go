@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBoltArticleStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "article-api")
+	if err != nil {
+		t.Errorf("failed to create temp dir, error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+
+	db, err := OpenBoltDB(path)
+	if err != nil {
+		t.Errorf("failed to open bolt db, error: %v", err)
+		return
+	}
+	s, err := NewBoltArticleStore(db, articleIndexTypes)
+	if err != nil {
+		t.Errorf("failed to create bolt article store, error: %v", err)
+		return
+	}
+	ctx := context.Background()
+	jt := &JSONTime{time.Now().UTC()}
+	draft, err := s.CreateDraft(ctx, &Article{CreatedAt: jt, LockedBy: "alice", FromVersion: "0"})
+	if err != nil {
+		t.Errorf("failed to create draft, error: %v", err)
+		return
+	}
+	draft.Guid = draft.Id
+	draft.Headline = "headline"
+	if _, err = s.SaveDraft(ctx, "bob", draft, true); err != ErrStoreLocked {
+		t.Errorf("expecting %v, but got %v", ErrStoreLocked, err)
+		return
+	}
+	if _, err = s.SaveDraft(ctx, "alice", draft, true); err != nil {
+		t.Errorf("failed to save draft, error: %v", err)
+		return
+	}
+	version := *draft
+	version.Id = draft.Guid + ":1"
+	version.Version = "1"
+	if err = s.CreateVersion(ctx, &version); err != nil {
+		t.Errorf("failed to create version, error: %v", err)
+		return
+	}
+	if err = s.CreateVersion(ctx, &version); err != ErrStoreConflict {
+		t.Errorf("expecting %v, but got %v", ErrStoreConflict, err)
+		return
+	}
+	db.Close()
+
+	// everything should survive reopening the file
+	if db, err = OpenBoltDB(path); err != nil {
+		t.Errorf("failed to reopen bolt db, error: %v", err)
+		return
+	}
+	defer db.Close()
+	if s, err = NewBoltArticleStore(db, articleIndexTypes); err != nil {
+		t.Errorf("failed to create bolt article store, error: %v", err)
+		return
+	}
+	docs, err := s.GetByGuid(ctx, draft.Guid)
+	if err != nil {
+		t.Errorf("failed to get article %v, error: %v", draft.Guid, err)
+		return
+	}
+	if len(docs) != 2 {
+		t.Errorf("expecting draft and version, but got %v doc(s)", len(docs))
+		return
+	}
+	for _, doc := range docs {
+		if doc.Article.Headline != "headline" {
+			t.Errorf("expecting saved headline, but got %+v", doc.Article)
+			return
+		}
+	}
+	docs, err = s.Search(ctx, &ArticleQuery{
+		Types:        []string{articleIndexTypes.Draft, articleIndexTypes.Version},
+		CreatedAfter: jt.T.Add(-time.Minute),
+	})
+	if err != nil {
+		t.Errorf("failed to search, error: %v", err)
+		return
+	}
+	if len(docs) != 2 {
+		t.Errorf("expecting 2 docs from search, but got %v", len(docs))
+		return
+	}
+}
@@ -0,0 +1,1180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	//elastic "gopkg.in/olivere/elastic.v5"
+	elastic "github.com/yizha/elastic"
+)
+
+const (
+	ESScriptSaveArticle = `
+def rev = ctx._source.rev == null ? 0L : ((Number)ctx._source.rev).longValue();
+if (ctx._source.locked_by != params.username) {
+  ctx.op = "none"
+} else if (params.rev > 0 && rev != ((Number)params.rev).longValue()) {
+  ctx.op = "none"
+} else {
+  ctx._source.guid = params.guid;
+  ctx._source.headline = params.headline;
+  ctx._source.summary = params.summary;
+  ctx._source.content = params.content;
+  ctx._source.body = params.body;
+  ctx._source.content_format = params.content_format;
+  ctx._source.content_text = params.content_text;
+  ctx._source.lead_image = params.lead_image;
+  ctx._source.tag = params.tag;
+  ctx._source.note = params.note;
+  ctx._source.revised_at = params.revised_at;
+  ctx._source.lock_heartbeat = params.revised_at;
+  ctx._source.rev = rev + 1;
+}`
+
+	ESScriptLockArticle = `
+def lockedBy = ctx._source.locked_by == null ? "" : ctx._source.locked_by;
+if (lockedBy != params.from) {
+  ctx.op = "none"
+} else {
+  ctx._source.locked_by = params.locked_by;
+  ctx._source.locked_at = params.locked_at;
+  ctx._source.lock_heartbeat = params.lock_heartbeat;
+}`
+
+	ESScriptReviewArticle = `
+def state = ctx._source.state == null ? params.default_state : ctx._source.state;
+if (state != params.from) {
+  ctx.op = "none"
+} else {
+  ctx._source.state = params.state;
+  ctx._source.state_by = params.state_by;
+  ctx._source.state_at = params.state_at;
+  ctx._source.state_reason = params.state_reason;
+}`
+
+	ESScriptDiscardArticle = `
+if (ctx._source.locked_by != params.username) {
+  ctx.op = "none"
+} else {
+  ctx.op = "delete"
+}
+`
+)
+
+type ElasticArticleStore struct {
+	client *elastic.Client
+	index  string
+	types  *ArticleIndexTypes
+	logger *JsonLogger
+}
+
+func (s *ElasticArticleStore) Get(ctx context.Context, typ, id string) (*Article, error) {
+	source := elastic.NewFetchSourceContext(true).Include(
+		"guid",
+		"headline",
+		"summary",
+		"content",
+		"body",
+		"content_format",
+		"content_text",
+		"lead_image",
+		"tag",
+		"created_at",
+		"created_by",
+		"revised_at",
+		"revised_by",
+		"published_at",
+		"version",
+		"from_version",
+		"note",
+		"locked_by",
+		"locked_at",
+		"lock_heartbeat",
+		"rev",
+		"state",
+		"state_by",
+		"state_at",
+		"state_reason",
+	)
+	getService := s.client.Get()
+	getService.Index(s.index)
+	getService.Type(typ)
+	getService.Realtime(true)
+	getService.Id(id)
+	getService.FetchSourceContext(source)
+	resp, err := getService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, ErrStoreNotFound
+		} else {
+			return nil, err
+		}
+	} else if !resp.Found {
+		return nil, ErrStoreNotFound
+	}
+	article := &Article{}
+	if err := json.Unmarshal(*resp.Source, article); err != nil {
+		return nil, fmt.Errorf("unmarshal article %v error: %v", id, err)
+	}
+	article.Id = resp.Id
+	return article, nil
+}
+
+func (s *ElasticArticleStore) CreateDraft(ctx context.Context, article *Article) (*Article, error) {
+	idxService := s.client.Index()
+	idxService.Index(s.index)
+	idxService.Type(s.types.Draft)
+	// don't set Id or OpType in order to have id auto-generated by elasticsearch
+	if article.Id != "" {
+		idxService.OpType(ESIndexOpCreate)
+		idxService.Id(article.Id)
+	}
+	article.Rev = 1
+	idxService.BodyJson(article)
+	idxService.Refresh("wait_for")
+	resp, err := idxService.Do(ctx)
+	if err != nil {
+		if elastic.IsConflict(err) {
+			return nil, ErrStoreConflict
+		}
+		return nil, err
+	} else if !resp.Created {
+		return nil, fmt.Errorf("no reason but article draft %v is not created!", article.Id)
+	}
+	article.Id = resp.Id
+	return article, nil
+}
+
+func (s *ElasticArticleStore) SaveDraft(ctx context.Context, username string, article *Article, waitForRefresh bool) (*Article, error) {
+	script := elastic.NewScript(ESScriptSaveArticle)
+	script.Type("inline").Lang("painless").Params(map[string]interface{}{
+		"guid":           article.Guid,
+		"headline":       article.Headline,
+		"summary":        article.Summary,
+		"content":        article.Content,
+		"body":           article.Body,
+		"content_format": article.ContentFormat,
+		"content_text":   article.ContentText,
+		"lead_image":     article.LeadImage,
+		"tag":            article.Tag,
+		"note":           article.Note,
+		"username":       username,
+		"revised_at":     article.RevisedAt,
+		"rev":            article.Rev,
+	})
+	updService := s.client.Update()
+	updService.Index(s.index)
+	updService.Type(s.types.Draft)
+	updService.Id(article.Id)
+	updService.Script(script)
+	updService.DetectNoop(true)
+	if waitForRefresh {
+		updService.Refresh("wait_for")
+	}
+	resp, err := updService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, ErrStoreNotFound
+		} else if elastic.IsConflict(err) {
+			return nil, ErrStoreConflict
+		}
+		return nil, err
+	}
+	switch resp.Result {
+	case "noop":
+		// either not locked by the user or saved since the rev
+		draft, err := s.Get(ctx, s.types.Draft, article.Id)
+		if err != nil {
+			return nil, err
+		} else if draft.LockedBy != username {
+			return nil, ErrStoreLocked
+		}
+		return nil, ErrStoreConflict
+	case "updated":
+		return s.Get(ctx, s.types.Draft, article.Id)
+	default:
+		return nil, fmt.Errorf(`unknown "result" in update response: %v`, resp.Result)
+	}
+}
+
+func (s *ElasticArticleStore) UpdateDraftLock(ctx context.Context, article *Article, lockedBy string) (*Article, error) {
+	script := elastic.NewScript(ESScriptLockArticle)
+	script.Type("inline").Lang("painless").Params(map[string]interface{}{
+		"from":           lockedBy,
+		"locked_by":      article.LockedBy,
+		"locked_at":      article.LockedAt,
+		"lock_heartbeat": article.LockHeartbeat,
+	})
+	updService := s.client.Update()
+	updService.Index(s.index)
+	updService.Type(s.types.Draft)
+	updService.Id(article.Id)
+	updService.Script(script)
+	updService.DetectNoop(true)
+	updService.Refresh("wait_for")
+	resp, err := updService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, ErrStoreNotFound
+		}
+		return nil, err
+	}
+	switch resp.Result {
+	case "noop":
+		return nil, ErrStoreConflict
+	case "updated":
+		return s.Get(ctx, s.types.Draft, article.Id)
+	default:
+		return nil, fmt.Errorf(`unknown "result" in update response: %v`, resp.Result)
+	}
+}
+
+func (s *ElasticArticleStore) UpdateVersionState(ctx context.Context, article *Article, from ArticleState) (*Article, error) {
+	script := elastic.NewScript(ESScriptReviewArticle)
+	script.Type("inline").Lang("painless").Params(map[string]interface{}{
+		"default_state": ArticleStateSubmitted,
+		"from":          from,
+		"state":         article.State,
+		"state_by":      article.StateBy,
+		"state_at":      article.StateAt,
+		"state_reason":  article.StateReason,
+	})
+	updService := s.client.Update()
+	updService.Index(s.index)
+	updService.Type(s.types.Version)
+	updService.Id(article.Id)
+	updService.Script(script)
+	updService.DetectNoop(true)
+	updService.Refresh("wait_for")
+	resp, err := updService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, ErrStoreNotFound
+		}
+		return nil, err
+	}
+	switch resp.Result {
+	case "noop":
+		return nil, ErrStoreConflict
+	case "updated":
+		return s.Get(ctx, s.types.Version, article.Id)
+	default:
+		return nil, fmt.Errorf(`unknown "result" in update response: %v`, resp.Result)
+	}
+}
+
+func (s *ElasticArticleStore) DiscardDraft(ctx context.Context, username, id string) error {
+	script := elastic.NewScript(ESScriptDiscardArticle)
+	script.Type("inline").Lang("painless").Params(map[string]interface{}{
+		"username": username,
+	})
+	updService := s.client.Update()
+	updService.Index(s.index)
+	updService.Type(s.types.Draft)
+	updService.Id(id)
+	updService.Script(script)
+	updService.DetectNoop(true)
+	updService.Refresh("wait_for")
+	resp, err := updService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return ErrStoreNotFound
+		}
+		return err
+	}
+	switch resp.Result {
+	case "noop":
+		return ErrStoreLocked
+	case "deleted":
+		return nil
+	default:
+		return fmt.Errorf(`unknown "result" in update response: %v`, resp.Result)
+	}
+}
+
+func (s *ElasticArticleStore) delete(ctx context.Context, typ, id string) error {
+	delService := s.client.Delete()
+	delService.Index(s.index)
+	delService.Type(typ)
+	delService.Id(id)
+	delService.Refresh("wait_for")
+	_, err := delService.Do(ctx)
+	if err != nil && elastic.IsNotFound(err) {
+		return ErrStoreNotFound
+	}
+	return err
+}
+
+func (s *ElasticArticleStore) DeleteDraft(ctx context.Context, id string) error {
+	return s.delete(ctx, s.types.Draft, id)
+}
+
+func (s *ElasticArticleStore) CreateVersion(ctx context.Context, article *Article) error {
+	idxService := s.client.Index()
+	idxService.Index(s.index)
+	idxService.Type(s.types.Version)
+	idxService.OpType(ESIndexOpCreate)
+	idxService.Id(article.Id)
+	idxService.BodyJson(article)
+	resp, err := idxService.Do(ctx)
+	if err != nil {
+		if elastic.IsConflict(err) {
+			return ErrStoreConflict
+		}
+		return err
+	} else if !resp.Created {
+		return fmt.Errorf("no reason but article new version %v is not created!", article.Id)
+	}
+	return nil
+}
+
+// Elasticsearch has no transaction across documents, if the draft
+// deletion fails the draft is left for the draft reconciler to clean up.
+func (s *ElasticArticleStore) SubmitDraft(ctx context.Context, version *Article) error {
+	err := s.CreateVersion(ctx, version)
+	if err == ErrStoreConflict {
+		existing, err := s.Get(ctx, s.types.Version, version.Id)
+		if err != nil {
+			return err
+		}
+		if !sameArticleContent(existing, version) {
+			return ErrStoreConflict
+		}
+	} else if err != nil {
+		return err
+	}
+	if err = s.DeleteDraft(ctx, version.Guid); err != nil && err != ErrStoreNotFound {
+		return fmt.Errorf("created article version %v but failed to delete draft, error: %v", version.Id, err)
+	}
+	return nil
+}
+
+func (s *ElasticArticleStore) UpsertPublish(ctx context.Context, article *Article) error {
+	updService := s.client.Update()
+	updService.Index(s.index)
+	updService.Type(s.types.Publish)
+	updService.Id(article.Id)
+	updService.Doc(article)
+	updService.DocAsUpsert(true)
+	updService.Refresh("wait_for")
+	_, err := updService.Do(ctx)
+	return err
+}
+
+func (s *ElasticArticleStore) DeletePublish(ctx context.Context, id string) error {
+	return s.delete(ctx, s.types.Publish, id)
+}
+
+func esTermsQuery(field string, values []string) *elastic.TermsQuery {
+	terms := make([]interface{}, len(values))
+	for i, v := range values {
+		terms[i] = v
+	}
+	return elastic.NewTermsQuery(field, terms...)
+}
+
+func (s *ElasticArticleStore) hitsToDocs(hits []*elastic.SearchHit) []*ArticleDoc {
+	docs := make([]*ArticleDoc, 0, len(hits))
+	for _, hit := range hits {
+		one, err := unmarshalArticle(*hit.Source)
+		if err != nil {
+			s.logger.Pwarnf("failed to unmarshal article (%v): %v, error: %v", hit.Type, string(*hit.Source), err)
+			continue
+		}
+		one.Id = hit.Id
+		doc := &ArticleDoc{
+			Type:      hit.Type,
+			Article:   one,
+			Sort:      hit.Sort,
+			Highlight: hit.Highlight,
+		}
+		if hit.Score != nil {
+			doc.Score = *hit.Score
+		}
+		// content_text fragments are what's highlighted for the content
+		if h, ok := doc.Highlight["content_text"]; ok {
+			doc.Highlight["content"] = h
+			delete(doc.Highlight, "content_text")
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+func (s *ElasticArticleStore) Search(ctx context.Context, q *ArticleQuery) ([]*ArticleDoc, error) {
+	search := s.client.Search(s.index)
+	search.Type(q.Types...)
+	query := elastic.NewBoolQuery().Filter(
+		elastic.NewExistsQuery("guid"),
+		elastic.NewRangeQuery("created_at").Gte(q.CreatedAfter),
+	)
+	if len(q.Tag) > 0 {
+		query.Filter(esTermsQuery("tag", q.Tag))
+	}
+	search.Query(query)
+	search.Size(q.Size)
+	search.FetchSource(true)
+	search.SortBy(
+		elastic.NewFieldSort("created_at").Desc().UnmappedType("date"),
+		elastic.NewFieldSort("_uid"),
+	)
+	if q.SearchAfter != nil {
+		search.SearchAfter(q.SearchAfter...)
+	}
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.hitsToDocs(resp.Hits.Hits), nil
+}
+
+func (s *ElasticArticleStore) TextSearch(ctx context.Context, q *ArticleTextQuery) (*ArticleSearchResult, error) {
+	search := s.client.Search(s.index)
+	search.Type(q.Types...)
+	query := elastic.NewBoolQuery().Filter(elastic.NewExistsQuery("guid"))
+	if q.Text != "" {
+		// the stripped content_text is searched instead of the content
+		// (which may have markup), articles saved before it existed fall
+		// back to the content
+		query.Must(elastic.NewBoolQuery().
+			Should(
+				elastic.NewMultiMatchQuery(q.Text, "headline^3", "content_text"),
+				elastic.NewBoolQuery().
+					Must(elastic.NewMultiMatchQuery(q.Text, "headline^3", "content")).
+					MustNot(elastic.NewExistsQuery("content_text"))).
+			MinimumNumberShouldMatch(1))
+	}
+	if len(q.Tag) > 0 {
+		query.Filter(esTermsQuery("tag", q.Tag))
+	}
+	if q.CreatedBy != "" {
+		query.Filter(elastic.NewTermQuery("created_by", q.CreatedBy))
+	}
+	if q.RevisedBy != "" {
+		query.Filter(elastic.NewTermQuery("revised_by", q.RevisedBy))
+	}
+	for field, r := range map[string][2]time.Time{
+		"created_at": {q.CreatedFrom, q.CreatedTo},
+		"revised_at": {q.RevisedFrom, q.RevisedTo},
+	} {
+		if r[0].IsZero() && r[1].IsZero() {
+			continue
+		}
+		rq := elastic.NewRangeQuery(field)
+		if !r[0].IsZero() {
+			rq.Gte(r[0])
+		}
+		if !r[1].IsZero() {
+			rq.Lte(r[1])
+		}
+		query.Filter(rq)
+	}
+	search.Query(query)
+	search.Size(q.Size)
+	search.FetchSource(true)
+	search.SortBy(
+		elastic.NewScoreSort().Desc(),
+		elastic.NewFieldSort("created_at").Desc().UnmappedType("date"),
+		elastic.NewFieldSort("_uid"),
+	)
+	search.Highlight(elastic.NewHighlight().
+		Fields(
+			elastic.NewHighlighterField("headline").NumOfFragments(0),
+			elastic.NewHighlighterField("content").NumOfFragments(highlightMaxFragments),
+			elastic.NewHighlighterField("content_text").NumOfFragments(highlightMaxFragments),
+		).
+		PreTags(HighlightPreTag).
+		PostTags(HighlightPostTag).
+		Encoder("html"))
+	if q.SearchAfter != nil {
+		search.SearchAfter(q.SearchAfter...)
+	}
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ArticleSearchResult{
+		Total: resp.Hits.TotalHits,
+		Docs:  s.hitsToDocs(resp.Hits.Hits),
+	}, nil
+}
+
+func (s *ElasticArticleStore) GetByGuid(ctx context.Context, guid string) ([]*ArticleDoc, error) {
+	search := s.client.Search(s.index)
+	search.Type(s.types.Draft, s.types.Version, s.types.Publish)
+	search.Query(elastic.NewConstantScoreQuery(elastic.NewTermQuery("guid", guid)))
+	// all of them (up to the max result window), the latest versions
+	// first if there are more (versions are the unix nanos of revised_at)
+	search.Size(10000)
+	search.Sort("revised_at", false)
+	search.FetchSource(true)
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.hitsToDocs(resp.Hits.Hits), nil
+}
+
+// Versions are the unix nanos of their revised_at, sorting by revised_at
+// sorts them by version number
+func (s *ElasticArticleStore) GetLatestVersion(ctx context.Context, guid string) (*Article, error) {
+	search := s.client.Search(s.index)
+	search.Type(s.types.Version)
+	search.Query(elastic.NewConstantScoreQuery(elastic.NewTermQuery("guid", guid)))
+	search.Size(1)
+	search.Sort("revised_at", false)
+	search.FetchSource(true)
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	docs := s.hitsToDocs(resp.Hits.Hits)
+	if len(docs) == 0 {
+		return nil, ErrStoreNotFound
+	}
+	return docs[0].Article, nil
+}
+
+func (s *ElasticArticleStore) ListPublished(ctx context.Context, size int, tags []string) ([]*Article, error) {
+	search := s.client.Search(s.index)
+	search.Type(s.types.Publish)
+	if len(tags) == 0 {
+		search.Query(elastic.NewMatchAllQuery())
+	} else {
+		search.Query(elastic.NewConstantScoreQuery(esTermsQuery("tag", tags)))
+	}
+	search.Size(size)
+	search.FetchSource(true)
+	search.Sort("revised_at", false)
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	docs := s.hitsToDocs(resp.Hits.Hits)
+	articles := make([]*Article, len(docs))
+	for i, doc := range docs {
+		articles[i] = doc.Article
+	}
+	return articles, nil
+}
+
+func (s *ElasticArticleStore) ListRecentlyPublished(ctx context.Context, since time.Time, size int) ([]*Article, error) {
+	search := s.client.Search(s.index)
+	search.Type(s.types.Publish)
+	search.Query(elastic.NewConstantScoreQuery(elastic.NewRangeQuery("published_at").Gte(&JSONTime{since.UTC()})))
+	search.Size(size)
+	search.FetchSource(true)
+	search.Sort("published_at", false)
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	docs := s.hitsToDocs(resp.Hits.Hits)
+	articles := make([]*Article, len(docs))
+	for i, doc := range docs {
+		articles[i] = doc.Article
+	}
+	return articles, nil
+}
+
+func (s *ElasticArticleStore) CountTags(ctx context.Context, types []string, size int) (map[string][]*TagCount, error) {
+	result := make(map[string][]*TagCount)
+	for _, typ := range types {
+		result[typ] = make([]*TagCount, 0)
+	}
+	search := s.client.Search(s.index)
+	search.Type(types...)
+	search.Query(elastic.NewMatchAllQuery())
+	search.Size(0)
+	search.Aggregation("types", elastic.NewTermsAggregation().
+		Field("_type").
+		Size(len(types)).
+		SubAggregation("tags", elastic.NewTermsAggregation().
+			Field("tag").
+			Size(size).
+			OrderByCountDesc()))
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	typeBuckets, ok := resp.Aggregations.Terms("types")
+	if !ok {
+		return result, nil
+	}
+	for _, typeBucket := range typeBuckets.Buckets {
+		typ, _ := typeBucket.Key.(string)
+		tagBuckets, ok := typeBucket.Terms("tags")
+		if !ok {
+			continue
+		}
+		counts := make([]*TagCount, 0, len(tagBuckets.Buckets))
+		for _, tagBucket := range tagBuckets.Buckets {
+			tag, _ := tagBucket.Key.(string)
+			counts = append(counts, &TagCount{Tag: tag, Count: tagBucket.DocCount})
+		}
+		result[typ] = counts
+	}
+	return result, nil
+}
+
+func NewElasticArticleStore(es *Elastic, conf *AppConf, logger *JsonLogger) *ElasticArticleStore {
+	return &ElasticArticleStore{
+		client: es.Client,
+		index:  conf.ArticleIndex.Name,
+		types:  conf.ArticleIndexTypes,
+		logger: logger,
+	}
+}
+
+type ElasticUserStore struct {
+	client *elastic.Client
+	index  string
+	typ    string
+	logger *JsonLogger
+}
+
+func (s *ElasticUserStore) GetUser(ctx context.Context, username string) (*CmsUser, error) {
+	getService := s.client.Get()
+	getService.Index(s.index)
+	getService.Type(s.typ)
+	getService.FetchSource(true)
+	getService.Realtime(false)
+	getService.Id(username)
+	resp, err := getService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, ErrStoreNotFound
+		}
+		return nil, err
+	} else if !resp.Found {
+		return nil, ErrStoreNotFound
+	}
+	var user CmsUser
+	if err = json.Unmarshal(*resp.Source, &user); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cms user, error: %v", err)
+	}
+	return &user, nil
+}
+
+func (s *ElasticUserStore) CreateUser(ctx context.Context, user *CmsUser) error {
+	idxService := s.client.Index()
+	idxService.Index(s.index)
+	idxService.Type(s.typ)
+	idxService.OpType(ESIndexOpCreate)
+	idxService.Refresh("wait_for")
+	idxService.Id(user.Username)
+	idxService.BodyJson(user)
+	resp, err := idxService.Do(ctx)
+	if err != nil {
+		if elastic.IsConflict(err) {
+			return ErrStoreConflict
+		}
+		return err
+	} else if !resp.Created {
+		return fmt.Errorf("no reason but user %v is not created!", user.Username)
+	}
+	return nil
+}
+
+func (s *ElasticUserStore) UpdateUser(ctx context.Context, username string, fields map[string]interface{}) error {
+	updService := s.client.Update()
+	updService.Index(s.index)
+	updService.Type(s.typ)
+	updService.Refresh("wait_for")
+	updService.Id(username)
+	updService.Doc(fields)
+	updService.DocAsUpsert(false)
+	updService.DetectNoop(false)
+	_, err := updService.Do(ctx)
+	if err != nil && elastic.IsNotFound(err) {
+		return ErrStoreNotFound
+	}
+	return err
+}
+
+func (s *ElasticUserStore) DeleteUser(ctx context.Context, username string) error {
+	delService := s.client.Delete()
+	delService.Index(s.index)
+	delService.Type(s.typ)
+	delService.Refresh("wait_for")
+	delService.Id(username)
+	_, err := delService.Do(ctx)
+	if err != nil && elastic.IsNotFound(err) {
+		return ErrStoreNotFound
+	}
+	return err
+}
+
+func (s *ElasticUserStore) ListUsers(ctx context.Context) ([]*CmsUser, error) {
+	search := s.client.Search(s.index)
+	search.Type(s.typ)
+	search.Query(elastic.NewConstantScoreQuery(elastic.NewMatchAllQuery()))
+	search.FetchSource(true)
+	search.Sort("username", true)
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]*CmsUser, 0)
+	if resp.Hits != nil && resp.Hits.Hits != nil && len(resp.Hits.Hits) > 0 {
+		for _, h := range resp.Hits.Hits {
+			one := &CmsUser{}
+			if err := json.Unmarshal(*h.Source, one); err != nil {
+				s.logger.Pwarnf("failed to decode user %v, error: %v", h.Id, err)
+			} else {
+				users = append(users, one)
+			}
+		}
+	}
+	return users, nil
+}
+
+func NewElasticUserStore(es *Elastic, conf *AppConf, logger *JsonLogger) *ElasticUserStore {
+	return &ElasticUserStore{
+		client: es.Client,
+		index:  conf.UserIndex.Name,
+		typ:    conf.UserIndexTypes.User,
+		logger: logger,
+	}
+}
+
+type ElasticScheduleStore struct {
+	client *elastic.Client
+	index  string
+	typ    string
+	logger *JsonLogger
+}
+
+func (s *ElasticScheduleStore) CreateSchedule(ctx context.Context, schedule *ArticleSchedule) (*ArticleSchedule, error) {
+	idxService := s.client.Index()
+	idxService.Index(s.index)
+	idxService.Type(s.typ)
+	// don't set Id or OpType in order to have id auto-generated by elasticsearch
+	if schedule.Id != "" {
+		idxService.OpType(ESIndexOpCreate)
+		idxService.Id(schedule.Id)
+	}
+	idxService.BodyJson(schedule)
+	idxService.Refresh("wait_for")
+	resp, err := idxService.Do(ctx)
+	if err != nil {
+		if elastic.IsConflict(err) {
+			return nil, ErrStoreConflict
+		}
+		return nil, err
+	}
+	schedule.Id = resp.Id
+	return schedule, nil
+}
+
+func (s *ElasticScheduleStore) GetSchedule(ctx context.Context, id string) (*ArticleSchedule, error) {
+	getService := s.client.Get()
+	getService.Index(s.index)
+	getService.Type(s.typ)
+	getService.FetchSource(true)
+	getService.Realtime(true)
+	getService.Id(id)
+	resp, err := getService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, ErrStoreNotFound
+		}
+		return nil, err
+	} else if !resp.Found {
+		return nil, ErrStoreNotFound
+	}
+	schedule := &ArticleSchedule{}
+	if err = json.Unmarshal(*resp.Source, schedule); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal schedule %v, error: %v", id, err)
+	}
+	schedule.Id = resp.Id
+	return schedule, nil
+}
+
+func (s *ElasticScheduleStore) UpdateSchedule(ctx context.Context, schedule *ArticleSchedule) error {
+	updService := s.client.Update()
+	updService.Index(s.index)
+	updService.Type(s.typ)
+	updService.Id(schedule.Id)
+	updService.Doc(schedule)
+	updService.Refresh("wait_for")
+	_, err := updService.Do(ctx)
+	if err != nil && elastic.IsNotFound(err) {
+		return ErrStoreNotFound
+	}
+	return err
+}
+
+func (s *ElasticScheduleStore) ListSchedules(ctx context.Context, q *ScheduleQuery) ([]*ArticleSchedule, error) {
+	query := elastic.NewBoolQuery()
+	if q.Guid != "" {
+		query.Filter(elastic.NewTermQuery("guid", q.Guid))
+	}
+	if q.Status != "" {
+		query.Filter(elastic.NewTermQuery("status", string(q.Status)))
+	}
+	if !q.DueBefore.IsZero() {
+		query.Filter(elastic.NewRangeQuery("at").Lte(&JSONTime{q.DueBefore}))
+	}
+	size := q.Size
+	if size <= 0 {
+		size = 10000
+	}
+	search := s.client.Search(s.index)
+	search.Type(s.typ)
+	search.Query(elastic.NewConstantScoreQuery(query))
+	search.Size(size)
+	search.FetchSource(true)
+	search.Sort("at", true)
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	schedules := make([]*ArticleSchedule, 0)
+	for _, hit := range resp.Hits.Hits {
+		schedule := &ArticleSchedule{}
+		if err := json.Unmarshal(*hit.Source, schedule); err != nil {
+			s.logger.Pwarnf("failed to decode schedule %v, error: %v", hit.Id, err)
+			continue
+		}
+		schedule.Id = hit.Id
+		schedules = append(schedules, schedule)
+	}
+	return schedules, nil
+}
+
+func NewElasticScheduleStore(es *Elastic, conf *AppConf, logger *JsonLogger) *ElasticScheduleStore {
+	return &ElasticScheduleStore{
+		client: es.Client,
+		index:  conf.ScheduleIndex.Name,
+		typ:    conf.ScheduleIndexTypes.Schedule,
+		logger: logger,
+	}
+}
+
+type ElasticTaxonomyStore struct {
+	client *elastic.Client
+	index  string
+	typ    string
+	logger *JsonLogger
+}
+
+func (s *ElasticTaxonomyStore) GetTerm(ctx context.Context, id string) (*TaxonomyTerm, error) {
+	getService := s.client.Get()
+	getService.Index(s.index)
+	getService.Type(s.typ)
+	getService.FetchSource(true)
+	getService.Realtime(true)
+	getService.Id(id)
+	resp, err := getService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, ErrStoreNotFound
+		}
+		return nil, err
+	} else if !resp.Found {
+		return nil, ErrStoreNotFound
+	}
+	term := &TaxonomyTerm{}
+	if err = json.Unmarshal(*resp.Source, term); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal taxonomy term %v, error: %v", id, err)
+	}
+	return term, nil
+}
+
+func (s *ElasticTaxonomyStore) CreateTerm(ctx context.Context, term *TaxonomyTerm) error {
+	idxService := s.client.Index()
+	idxService.Index(s.index)
+	idxService.Type(s.typ)
+	idxService.OpType(ESIndexOpCreate)
+	idxService.Id(term.Id)
+	idxService.BodyJson(term)
+	idxService.Refresh("wait_for")
+	_, err := idxService.Do(ctx)
+	if err != nil && elastic.IsConflict(err) {
+		return ErrStoreConflict
+	}
+	return err
+}
+
+func (s *ElasticTaxonomyStore) UpdateTerm(ctx context.Context, term *TaxonomyTerm) error {
+	updService := s.client.Update()
+	updService.Index(s.index)
+	updService.Type(s.typ)
+	updService.Id(term.Id)
+	updService.Doc(term)
+	updService.Refresh("wait_for")
+	_, err := updService.Do(ctx)
+	if err != nil && elastic.IsNotFound(err) {
+		return ErrStoreNotFound
+	}
+	return err
+}
+
+func (s *ElasticTaxonomyStore) DeleteTerm(ctx context.Context, id string) error {
+	delService := s.client.Delete()
+	delService.Index(s.index)
+	delService.Type(s.typ)
+	delService.Refresh("wait_for")
+	delService.Id(id)
+	_, err := delService.Do(ctx)
+	if err != nil && elastic.IsNotFound(err) {
+		return ErrStoreNotFound
+	}
+	return err
+}
+
+func (s *ElasticTaxonomyStore) ListTerms(ctx context.Context) ([]*TaxonomyTerm, error) {
+	search := s.client.Search(s.index)
+	search.Type(s.typ)
+	search.Query(elastic.NewConstantScoreQuery(elastic.NewMatchAllQuery()))
+	search.Size(10000)
+	search.FetchSource(true)
+	search.Sort("id", true)
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	terms := make([]*TaxonomyTerm, 0)
+	for _, hit := range resp.Hits.Hits {
+		term := &TaxonomyTerm{}
+		if err := json.Unmarshal(*hit.Source, term); err != nil {
+			s.logger.Pwarnf("failed to decode taxonomy term %v, error: %v", hit.Id, err)
+			continue
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+func NewElasticTaxonomyStore(es *Elastic, conf *AppConf, logger *JsonLogger) *ElasticTaxonomyStore {
+	return &ElasticTaxonomyStore{
+		client: es.Client,
+		index:  conf.TaxonomyIndex.Name,
+		typ:    conf.TaxonomyIndexTypes.Term,
+		logger: logger,
+	}
+}
+
+type ElasticMediaStore struct {
+	client *elastic.Client
+	index  string
+	typ    string
+	logger *JsonLogger
+}
+
+func (s *ElasticMediaStore) GetMedia(ctx context.Context, id string) (*MediaAsset, error) {
+	getService := s.client.Get()
+	getService.Index(s.index)
+	getService.Type(s.typ)
+	getService.FetchSource(true)
+	getService.Realtime(true)
+	getService.Id(id)
+	resp, err := getService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, ErrStoreNotFound
+		}
+		return nil, err
+	} else if !resp.Found {
+		return nil, ErrStoreNotFound
+	}
+	asset := &MediaAsset{}
+	if err = json.Unmarshal(*resp.Source, asset); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal media asset %v, error: %v", id, err)
+	}
+	return asset, nil
+}
+
+func (s *ElasticMediaStore) CreateMedia(ctx context.Context, asset *MediaAsset) error {
+	idxService := s.client.Index()
+	idxService.Index(s.index)
+	idxService.Type(s.typ)
+	idxService.OpType(ESIndexOpCreate)
+	idxService.Id(asset.Id)
+	idxService.BodyJson(asset)
+	idxService.Refresh("wait_for")
+	_, err := idxService.Do(ctx)
+	if err != nil && elastic.IsConflict(err) {
+		return ErrStoreConflict
+	}
+	return err
+}
+
+func (s *ElasticMediaStore) UpdateMedia(ctx context.Context, asset *MediaAsset) error {
+	updService := s.client.Update()
+	updService.Index(s.index)
+	updService.Type(s.typ)
+	updService.Id(asset.Id)
+	updService.Doc(asset)
+	updService.Refresh("wait_for")
+	_, err := updService.Do(ctx)
+	if err != nil && elastic.IsNotFound(err) {
+		return ErrStoreNotFound
+	}
+	return err
+}
+
+func (s *ElasticMediaStore) ListMedia(ctx context.Context, size int) ([]*MediaAsset, error) {
+	search := s.client.Search(s.index)
+	search.Type(s.typ)
+	search.Query(elastic.NewConstantScoreQuery(elastic.NewMatchAllQuery()))
+	search.Size(size)
+	search.FetchSource(true)
+	search.Sort("created_at", false)
+	search.Sort("id", true)
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	assets := make([]*MediaAsset, 0)
+	for _, hit := range resp.Hits.Hits {
+		asset := &MediaAsset{}
+		if err := json.Unmarshal(*hit.Source, asset); err != nil {
+			s.logger.Pwarnf("failed to decode media asset %v, error: %v", hit.Id, err)
+			continue
+		}
+		assets = append(assets, asset)
+	}
+	return assets, nil
+}
+
+func NewElasticMediaStore(es *Elastic, conf *AppConf, logger *JsonLogger) *ElasticMediaStore {
+	return &ElasticMediaStore{
+		client: es.Client,
+		index:  conf.MediaIndex.Name,
+		typ:    conf.MediaIndexTypes.Asset,
+		logger: logger,
+	}
+}
+
+type ElasticSessionStore struct {
+	client *elastic.Client
+	index  string
+	typ    string
+	logger *JsonLogger
+}
+
+func (s *ElasticSessionStore) GetSession(ctx context.Context, id string) (*CmsSession, error) {
+	getService := s.client.Get()
+	getService.Index(s.index)
+	getService.Type(s.typ)
+	getService.FetchSource(true)
+	getService.Realtime(true)
+	getService.Id(id)
+	resp, err := getService.Do(ctx)
+	if err != nil {
+		if elastic.IsNotFound(err) {
+			return nil, ErrStoreNotFound
+		}
+		return nil, err
+	} else if !resp.Found {
+		return nil, ErrStoreNotFound
+	}
+	session := &CmsSession{}
+	if err = json.Unmarshal(*resp.Source, session); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal session %v, error: %v", id, err)
+	}
+	if session.Expired(time.Now().UTC()) {
+		return nil, ErrStoreNotFound
+	}
+	return session, nil
+}
+
+func (s *ElasticSessionStore) CreateSession(ctx context.Context, session *CmsSession) error {
+	// remove expired sessions of the user
+	delService := s.client.DeleteByQuery(s.index)
+	delService.Type(s.typ)
+	delService.Query(elastic.NewBoolQuery().Filter(
+		elastic.NewTermQuery("username", session.Username),
+		elastic.NewRangeQuery("expire_at").Lte(time.Now().UTC()),
+	))
+	if _, err := delService.Do(ctx); err != nil {
+		s.logger.Pwarnf("failed to delete expired sessions of user %v, error: %v", session.Username, err)
+	}
+
+	idxService := s.client.Index()
+	idxService.Index(s.index)
+	idxService.Type(s.typ)
+	idxService.OpType(ESIndexOpCreate)
+	idxService.Id(session.Id)
+	idxService.BodyJson(session)
+	idxService.Refresh("wait_for")
+	_, err := idxService.Do(ctx)
+	if err != nil && elastic.IsConflict(err) {
+		return ErrStoreConflict
+	}
+	return err
+}
+
+func (s *ElasticSessionStore) UpdateSession(ctx context.Context, session *CmsSession) error {
+	updService := s.client.Update()
+	updService.Index(s.index)
+	updService.Type(s.typ)
+	updService.Id(session.Id)
+	updService.Doc(session)
+	updService.Refresh("wait_for")
+	_, err := updService.Do(ctx)
+	if err != nil && elastic.IsNotFound(err) {
+		return ErrStoreNotFound
+	}
+	return err
+}
+
+func (s *ElasticSessionStore) DeleteSession(ctx context.Context, id string) error {
+	delService := s.client.Delete()
+	delService.Index(s.index)
+	delService.Type(s.typ)
+	delService.Refresh("wait_for")
+	delService.Id(id)
+	_, err := delService.Do(ctx)
+	if err != nil && elastic.IsNotFound(err) {
+		return ErrStoreNotFound
+	}
+	return err
+}
+
+func (s *ElasticSessionStore) DeleteUserSessions(ctx context.Context, username string) (int, error) {
+	delService := s.client.DeleteByQuery(s.index)
+	delService.Type(s.typ)
+	delService.Query(elastic.NewTermQuery("username", username))
+	delService.Refresh("wait_for")
+	resp, err := delService.Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return int(resp.Deleted), nil
+}
+
+func (s *ElasticSessionStore) ListSessions(ctx context.Context, username string) ([]*CmsSession, error) {
+	search := s.client.Search(s.index)
+	search.Type(s.typ)
+	search.Query(elastic.NewConstantScoreQuery(elastic.NewTermQuery("username", username)))
+	search.Size(1000)
+	search.FetchSource(true)
+	resp, err := search.Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]*CmsSession, 0)
+	for _, hit := range resp.Hits.Hits {
+		session := &CmsSession{}
+		if err := json.Unmarshal(*hit.Source, session); err != nil {
+			s.logger.Pwarnf("failed to decode session %v, error: %v", hit.Id, err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	return filterSessions(sessions, username), nil
+}
+
+func NewElasticSessionStore(es *Elastic, conf *AppConf, logger *JsonLogger) *ElasticSessionStore {
+	return &ElasticSessionStore{
+		client: es.Client,
+		index:  conf.SessionIndex.Name,
+		typ:    conf.SessionIndexTypes.Session,
+		logger: logger,
+	}
+}
@@ -0,0 +1,683 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// Keeps everything in memory, nothing survives a restart.
+// Meant for running the cms locally or in CI without elasticsearch.
+type MemoryArticleStore struct {
+	l     *sync.RWMutex
+	types *ArticleIndexTypes
+	docs  map[string]map[string]*Article
+}
+
+func copyArticle(a *Article) *Article {
+	c := *a
+	if a.Tag != nil {
+		c.Tag = make([]string, len(a.Tag))
+		copy(c.Tag, a.Tag)
+	}
+	c.Body = copyArticleBody(a.Body)
+	if a.LeadImage != nil {
+		lead := *a.LeadImage
+		c.LeadImage = &lead
+	}
+	if a.CreatedAt != nil {
+		c.CreatedAt = &JSONTime{a.CreatedAt.T}
+	}
+	if a.RevisedAt != nil {
+		c.RevisedAt = &JSONTime{a.RevisedAt.T}
+	}
+	if a.PublishedAt != nil {
+		c.PublishedAt = &JSONTime{a.PublishedAt.T}
+	}
+	if a.LockedAt != nil {
+		c.LockedAt = &JSONTime{a.LockedAt.T}
+	}
+	if a.LockHeartbeat != nil {
+		c.LockHeartbeat = &JSONTime{a.LockHeartbeat.T}
+	}
+	if a.StateAt != nil {
+		c.StateAt = &JSONTime{a.StateAt.T}
+	}
+	return &c
+}
+
+func (s *MemoryArticleStore) typeDocs(typ string) (map[string]*Article, error) {
+	docs, ok := s.docs[typ]
+	if !ok {
+		return nil, fmt.Errorf("unknown article type %v!", typ)
+	}
+	return docs, nil
+}
+
+func (s *MemoryArticleStore) Get(ctx context.Context, typ, id string) (*Article, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	docs, err := s.typeDocs(typ)
+	if err != nil {
+		return nil, err
+	}
+	if a, ok := docs[id]; ok {
+		return copyArticle(a), nil
+	}
+	return nil, ErrStoreNotFound
+}
+
+func (s *MemoryArticleStore) CreateDraft(ctx context.Context, article *Article) (*Article, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	drafts := s.docs[s.types.Draft]
+	if article.Id == "" {
+		article.Id = xid.New().String()
+	} else if _, ok := drafts[article.Id]; ok {
+		return nil, ErrStoreConflict
+	}
+	article.Rev = 1
+	drafts[article.Id] = copyArticle(article)
+	return article, nil
+}
+
+func (s *MemoryArticleStore) SaveDraft(ctx context.Context, username string, article *Article, waitForRefresh bool) (*Article, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	draft, ok := s.docs[s.types.Draft][article.Id]
+	if !ok {
+		return nil, ErrStoreNotFound
+	}
+	if draft.LockedBy != username {
+		return nil, ErrStoreLocked
+	}
+	if article.Rev > 0 && article.Rev != draft.Rev {
+		return nil, ErrStoreConflict
+	}
+	saved := copyArticle(article)
+	draft.Guid = saved.Guid
+	draft.Headline = saved.Headline
+	draft.Summary = saved.Summary
+	draft.Content = saved.Content
+	draft.Body = saved.Body
+	draft.ContentFormat = saved.ContentFormat
+	draft.ContentText = saved.ContentText
+	draft.LeadImage = saved.LeadImage
+	draft.Tag = saved.Tag
+	draft.Note = saved.Note
+	draft.RevisedAt = saved.RevisedAt
+	draft.LockHeartbeat = saved.RevisedAt
+	draft.Rev += 1
+	return copyArticle(draft), nil
+}
+
+func (s *MemoryArticleStore) UpdateDraftLock(ctx context.Context, article *Article, lockedBy string) (*Article, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	draft, ok := s.docs[s.types.Draft][article.Id]
+	if !ok {
+		return nil, ErrStoreNotFound
+	}
+	if draft.LockedBy != lockedBy {
+		return nil, ErrStoreConflict
+	}
+	locked := copyArticle(article)
+	draft.LockedBy = locked.LockedBy
+	draft.LockedAt = locked.LockedAt
+	draft.LockHeartbeat = locked.LockHeartbeat
+	return copyArticle(draft), nil
+}
+
+func (s *MemoryArticleStore) UpdateVersionState(ctx context.Context, article *Article, from ArticleState) (*Article, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	version, ok := s.docs[s.types.Version][article.Id]
+	if !ok {
+		return nil, ErrStoreNotFound
+	}
+	if version.GetState() != from {
+		return nil, ErrStoreConflict
+	}
+	updated := copyArticle(article)
+	version.State = updated.State
+	version.StateBy = updated.StateBy
+	version.StateAt = updated.StateAt
+	version.StateReason = updated.StateReason
+	return copyArticle(version), nil
+}
+
+func (s *MemoryArticleStore) DiscardDraft(ctx context.Context, username, id string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	drafts := s.docs[s.types.Draft]
+	draft, ok := drafts[id]
+	if !ok {
+		return ErrStoreNotFound
+	}
+	if draft.LockedBy != username {
+		return ErrStoreLocked
+	}
+	delete(drafts, id)
+	return nil
+}
+
+func (s *MemoryArticleStore) delete(typ, id string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	docs := s.docs[typ]
+	if _, ok := docs[id]; !ok {
+		return ErrStoreNotFound
+	}
+	delete(docs, id)
+	return nil
+}
+
+func (s *MemoryArticleStore) DeleteDraft(ctx context.Context, id string) error {
+	return s.delete(s.types.Draft, id)
+}
+
+func (s *MemoryArticleStore) CreateVersion(ctx context.Context, article *Article) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	versions := s.docs[s.types.Version]
+	if _, ok := versions[article.Id]; ok {
+		return ErrStoreConflict
+	}
+	versions[article.Id] = copyArticle(article)
+	return nil
+}
+
+func (s *MemoryArticleStore) SubmitDraft(ctx context.Context, version *Article) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	versions := s.docs[s.types.Version]
+	if existing, ok := versions[version.Id]; !ok {
+		versions[version.Id] = copyArticle(version)
+	} else if !sameArticleContent(existing, version) {
+		return ErrStoreConflict
+	}
+	delete(s.docs[s.types.Draft], version.Guid)
+	return nil
+}
+
+func (s *MemoryArticleStore) UpsertPublish(ctx context.Context, article *Article) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.docs[s.types.Publish][article.Id] = copyArticle(article)
+	return nil
+}
+
+func (s *MemoryArticleStore) DeletePublish(ctx context.Context, id string) error {
+	return s.delete(s.types.Publish, id)
+}
+
+func (s *MemoryArticleStore) Search(ctx context.Context, q *ArticleQuery) ([]*ArticleDoc, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	result := make([]*ArticleDoc, 0)
+	for _, typ := range q.Types {
+		docs, err := s.typeDocs(typ)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range docs {
+			if doc := matchArticleQuery(q, typ, a); doc != nil {
+				doc.Article = copyArticle(a)
+				result = append(result, doc)
+			}
+		}
+	}
+	return sortArticleDocs(result, q.Size), nil
+}
+
+func (s *MemoryArticleStore) TextSearch(ctx context.Context, q *ArticleTextQuery) (*ArticleSearchResult, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	terms := searchTerms(q.Text)
+	result := make([]*ArticleDoc, 0)
+	for _, typ := range q.Types {
+		docs, err := s.typeDocs(typ)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range docs {
+			if doc := matchArticleTextQuery(q, terms, typ, a); doc != nil {
+				doc.Article = copyArticle(a)
+				result = append(result, doc)
+			}
+		}
+	}
+	return pageArticleSearchDocs(q, result), nil
+}
+
+func (s *MemoryArticleStore) GetByGuid(ctx context.Context, guid string) ([]*ArticleDoc, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	result := make([]*ArticleDoc, 0)
+	for typ, docs := range s.docs {
+		for _, a := range docs {
+			if a.Guid == guid {
+				result = append(result, &ArticleDoc{
+					Type:    typ,
+					Article: copyArticle(a),
+				})
+			}
+		}
+	}
+	return result, nil
+}
+
+func (s *MemoryArticleStore) GetLatestVersion(ctx context.Context, guid string) (*Article, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	var latest *Article
+	for _, a := range s.docs[s.types.Version] {
+		if a.Guid == guid && (latest == nil || articleVersionNumber(a) > articleVersionNumber(latest)) {
+			latest = a
+		}
+	}
+	if latest == nil {
+		return nil, ErrStoreNotFound
+	}
+	return copyArticle(latest), nil
+}
+
+func (s *MemoryArticleStore) ListPublished(ctx context.Context, size int, tags []string) ([]*Article, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	articles := make([]*Article, 0)
+	for _, a := range s.docs[s.types.Publish] {
+		if len(tags) == 0 || hasAnyTag(a, tags) {
+			articles = append(articles, copyArticle(a))
+		}
+	}
+	return sortArticlesByRevisedAt(articles, size), nil
+}
+
+func (s *MemoryArticleStore) ListRecentlyPublished(ctx context.Context, since time.Time, size int) ([]*Article, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	articles := make([]*Article, 0)
+	for _, a := range s.docs[s.types.Publish] {
+		articles = append(articles, a)
+	}
+	recent := recentlyPublishedArticles(articles, since, size)
+	for i, a := range recent {
+		recent[i] = copyArticle(a)
+	}
+	return recent, nil
+}
+
+func (s *MemoryArticleStore) CountTags(ctx context.Context, types []string, size int) (map[string][]*TagCount, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	result := make(map[string][]*TagCount)
+	for _, typ := range types {
+		docs, err := s.typeDocs(typ)
+		if err != nil {
+			return nil, err
+		}
+		articles := make([]*Article, 0, len(docs))
+		for _, a := range docs {
+			articles = append(articles, a)
+		}
+		result[typ] = countArticleTags(articles, size)
+	}
+	return result, nil
+}
+
+func NewMemoryArticleStore(types *ArticleIndexTypes) *MemoryArticleStore {
+	return &MemoryArticleStore{
+		l:     &sync.RWMutex{},
+		types: types,
+		docs: map[string]map[string]*Article{
+			types.Draft:   make(map[string]*Article),
+			types.Version: make(map[string]*Article),
+			types.Publish: make(map[string]*Article),
+		},
+	}
+}
+
+type MemoryUserStore struct {
+	l     *sync.RWMutex
+	users map[string]*CmsUser
+}
+
+func (s *MemoryUserStore) GetUser(ctx context.Context, username string) (*CmsUser, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if user, ok := s.users[username]; ok {
+		u := *user
+		return &u, nil
+	}
+	return nil, ErrStoreNotFound
+}
+
+func (s *MemoryUserStore) CreateUser(ctx context.Context, user *CmsUser) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.users[user.Username]; ok {
+		return ErrStoreConflict
+	}
+	u := *user
+	s.users[user.Username] = &u
+	return nil
+}
+
+func (s *MemoryUserStore) UpdateUser(ctx context.Context, username string, fields map[string]interface{}) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	user, ok := s.users[username]
+	if !ok {
+		return ErrStoreNotFound
+	}
+	updated, err := mergeUserFields(user, fields)
+	if err != nil {
+		return err
+	}
+	s.users[username] = updated
+	return nil
+}
+
+func (s *MemoryUserStore) DeleteUser(ctx context.Context, username string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.users[username]; !ok {
+		return ErrStoreNotFound
+	}
+	delete(s.users, username)
+	return nil
+}
+
+func (s *MemoryUserStore) ListUsers(ctx context.Context) ([]*CmsUser, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	users := make([]*CmsUser, 0, len(s.users))
+	for _, user := range s.users {
+		u := *user
+		users = append(users, &u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users, nil
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		l:     &sync.RWMutex{},
+		users: make(map[string]*CmsUser),
+	}
+}
+
+type MemoryScheduleStore struct {
+	l         *sync.RWMutex
+	schedules map[string]*ArticleSchedule
+}
+
+func (s *MemoryScheduleStore) CreateSchedule(ctx context.Context, schedule *ArticleSchedule) (*ArticleSchedule, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if schedule.Id == "" {
+		schedule.Id = xid.New().String()
+	} else if _, ok := s.schedules[schedule.Id]; ok {
+		return nil, ErrStoreConflict
+	}
+	c := *schedule
+	s.schedules[schedule.Id] = &c
+	return schedule, nil
+}
+
+func (s *MemoryScheduleStore) GetSchedule(ctx context.Context, id string) (*ArticleSchedule, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if schedule, ok := s.schedules[id]; ok {
+		c := *schedule
+		return &c, nil
+	}
+	return nil, ErrStoreNotFound
+}
+
+func (s *MemoryScheduleStore) UpdateSchedule(ctx context.Context, schedule *ArticleSchedule) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.schedules[schedule.Id]; !ok {
+		return ErrStoreNotFound
+	}
+	c := *schedule
+	s.schedules[schedule.Id] = &c
+	return nil
+}
+
+func (s *MemoryScheduleStore) ListSchedules(ctx context.Context, q *ScheduleQuery) ([]*ArticleSchedule, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	schedules := make([]*ArticleSchedule, 0)
+	for _, schedule := range s.schedules {
+		if matchScheduleQuery(q, schedule) {
+			c := *schedule
+			schedules = append(schedules, &c)
+		}
+	}
+	return sortSchedules(schedules, q.Size), nil
+}
+
+func NewMemoryScheduleStore() *MemoryScheduleStore {
+	return &MemoryScheduleStore{
+		l:         &sync.RWMutex{},
+		schedules: make(map[string]*ArticleSchedule),
+	}
+}
+
+type MemoryTaxonomyStore struct {
+	l     *sync.RWMutex
+	terms map[string]*TaxonomyTerm
+}
+
+func copyTaxonomyTerm(term *TaxonomyTerm) *TaxonomyTerm {
+	c := *term
+	if term.Synonyms != nil {
+		c.Synonyms = make([]string, len(term.Synonyms))
+		copy(c.Synonyms, term.Synonyms)
+	}
+	return &c
+}
+
+func (s *MemoryTaxonomyStore) GetTerm(ctx context.Context, id string) (*TaxonomyTerm, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if term, ok := s.terms[id]; ok {
+		return copyTaxonomyTerm(term), nil
+	}
+	return nil, ErrStoreNotFound
+}
+
+func (s *MemoryTaxonomyStore) CreateTerm(ctx context.Context, term *TaxonomyTerm) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.terms[term.Id]; ok {
+		return ErrStoreConflict
+	}
+	s.terms[term.Id] = copyTaxonomyTerm(term)
+	return nil
+}
+
+func (s *MemoryTaxonomyStore) UpdateTerm(ctx context.Context, term *TaxonomyTerm) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.terms[term.Id]; !ok {
+		return ErrStoreNotFound
+	}
+	s.terms[term.Id] = copyTaxonomyTerm(term)
+	return nil
+}
+
+func (s *MemoryTaxonomyStore) DeleteTerm(ctx context.Context, id string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.terms[id]; !ok {
+		return ErrStoreNotFound
+	}
+	delete(s.terms, id)
+	return nil
+}
+
+func (s *MemoryTaxonomyStore) ListTerms(ctx context.Context) ([]*TaxonomyTerm, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	terms := make([]*TaxonomyTerm, 0, len(s.terms))
+	for _, term := range s.terms {
+		terms = append(terms, copyTaxonomyTerm(term))
+	}
+	return sortTaxonomyTerms(terms), nil
+}
+
+func NewMemoryTaxonomyStore() *MemoryTaxonomyStore {
+	return &MemoryTaxonomyStore{
+		l:     &sync.RWMutex{},
+		terms: make(map[string]*TaxonomyTerm),
+	}
+}
+
+type MemoryMediaStore struct {
+	l      *sync.RWMutex
+	assets map[string]*MediaAsset
+}
+
+func copyMediaAsset(asset *MediaAsset) *MediaAsset {
+	c := *asset
+	return &c
+}
+
+func (s *MemoryMediaStore) GetMedia(ctx context.Context, id string) (*MediaAsset, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if asset, ok := s.assets[id]; ok {
+		return copyMediaAsset(asset), nil
+	}
+	return nil, ErrStoreNotFound
+}
+
+func (s *MemoryMediaStore) CreateMedia(ctx context.Context, asset *MediaAsset) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.assets[asset.Id]; ok {
+		return ErrStoreConflict
+	}
+	s.assets[asset.Id] = copyMediaAsset(asset)
+	return nil
+}
+
+func (s *MemoryMediaStore) UpdateMedia(ctx context.Context, asset *MediaAsset) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.assets[asset.Id]; !ok {
+		return ErrStoreNotFound
+	}
+	s.assets[asset.Id] = copyMediaAsset(asset)
+	return nil
+}
+
+func (s *MemoryMediaStore) ListMedia(ctx context.Context, size int) ([]*MediaAsset, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	assets := make([]*MediaAsset, 0, len(s.assets))
+	for _, asset := range s.assets {
+		assets = append(assets, copyMediaAsset(asset))
+	}
+	return sortMediaAssets(assets, size), nil
+}
+
+func NewMemoryMediaStore() *MemoryMediaStore {
+	return &MemoryMediaStore{
+		l:      &sync.RWMutex{},
+		assets: make(map[string]*MediaAsset),
+	}
+}
+
+type MemorySessionStore struct {
+	l        *sync.RWMutex
+	sessions map[string]*CmsSession
+}
+
+func copySession(session *CmsSession) *CmsSession {
+	c := *session
+	return &c
+}
+
+func (s *MemorySessionStore) GetSession(ctx context.Context, id string) (*CmsSession, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	if session, ok := s.sessions[id]; ok && !session.Expired(time.Now().UTC()) {
+		return copySession(session), nil
+	}
+	return nil, ErrStoreNotFound
+}
+
+func (s *MemorySessionStore) CreateSession(ctx context.Context, session *CmsSession) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.sessions[session.Id]; ok {
+		return ErrStoreConflict
+	}
+	now := time.Now().UTC()
+	for id, one := range s.sessions {
+		if one.Expired(now) {
+			delete(s.sessions, id)
+		}
+	}
+	s.sessions[session.Id] = copySession(session)
+	return nil
+}
+
+func (s *MemorySessionStore) UpdateSession(ctx context.Context, session *CmsSession) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.sessions[session.Id]; !ok {
+		return ErrStoreNotFound
+	}
+	s.sessions[session.Id] = copySession(session)
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteSession(ctx context.Context, id string) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+	if _, ok := s.sessions[id]; !ok {
+		return ErrStoreNotFound
+	}
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteUserSessions(ctx context.Context, username string) (int, error) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	deleted := 0
+	for id, session := range s.sessions {
+		if session.Username == username {
+			delete(s.sessions, id)
+			deleted += 1
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemorySessionStore) ListSessions(ctx context.Context, username string) ([]*CmsSession, error) {
+	s.l.RLock()
+	defer s.l.RUnlock()
+	sessions := make([]*CmsSession, 0)
+	for _, session := range s.sessions {
+		sessions = append(sessions, copySession(session))
+	}
+	return filterSessions(sessions, username), nil
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		l:        &sync.RWMutex{},
+		sessions: make(map[string]*CmsSession),
+	}
+}
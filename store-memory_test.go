@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryArticleStoreDraft(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryArticleStore(articleIndexTypes)
+
+	draft, err := s.CreateDraft(ctx, &Article{LockedBy: "alice", FromVersion: "0"})
+	if err != nil {
+		t.Errorf("failed to create draft, error: %v", err)
+		return
+	}
+	if draft.Id == "" {
+		t.Error("expecting generated draft id, but got blank")
+		return
+	}
+	if _, err = s.CreateDraft(ctx, &Article{Id: draft.Id}); err != ErrStoreConflict {
+		t.Errorf("expecting %v, but got %v", ErrStoreConflict, err)
+		return
+	}
+
+	// save by the user who doesn't hold the lock
+	draft.Guid = draft.Id
+	draft.Headline = "headline"
+	if _, err = s.SaveDraft(ctx, "bob", draft, true); err != ErrStoreLocked {
+		t.Errorf("expecting %v, but got %v", ErrStoreLocked, err)
+		return
+	}
+	// save by the lock holder
+	saved, err := s.SaveDraft(ctx, "alice", draft, true)
+	if err != nil {
+		t.Errorf("failed to save draft, error: %v", err)
+		return
+	}
+	if saved.Headline != "headline" || saved.LockedBy != "alice" {
+		t.Errorf("unexpected saved draft %+v", saved)
+		return
+	}
+	if saved.Rev != draft.Rev+1 {
+		t.Errorf("expecting rev %v, but got %v", draft.Rev+1, saved.Rev)
+		return
+	}
+	// lock changes (e.g. heartbeats) don't change the content rev
+	heartbeat := *saved
+	heartbeat.LockHeartbeat = &JSONTime{time.Now().UTC()}
+	if _, err = s.UpdateDraftLock(ctx, &heartbeat, "bob"); err != ErrStoreConflict {
+		t.Errorf("expecting %v, but got %v", ErrStoreConflict, err)
+		return
+	}
+	locked, err := s.UpdateDraftLock(ctx, &heartbeat, "alice")
+	if err != nil || locked.Rev != saved.Rev || locked.LockHeartbeat == nil {
+		t.Errorf("unexpected draft after heartbeat %+v (error: %v)", locked, err)
+		return
+	}
+	saved.Headline = "headline"
+	if saved, err = s.SaveDraft(ctx, "alice", saved, true); err != nil {
+		t.Errorf("failed to save draft after heartbeat, error: %v", err)
+		return
+	}
+	// save based on the old rev (e.g. from another browser tab)
+	draft.Headline = "stale"
+	if _, err = s.SaveDraft(ctx, "alice", draft, true); err != ErrStoreConflict {
+		t.Errorf("expecting %v, but got %v", ErrStoreConflict, err)
+		return
+	}
+	if current, _ := s.Get(ctx, articleIndexTypes.Draft, draft.Id); current.Headline != "headline" {
+		t.Errorf("expecting draft not overwritten, but got %+v", current)
+		return
+	}
+
+	if err = s.DiscardDraft(ctx, "bob", draft.Id); err != ErrStoreLocked {
+		t.Errorf("expecting %v, but got %v", ErrStoreLocked, err)
+		return
+	}
+	if err = s.DiscardDraft(ctx, "alice", draft.Id); err != nil {
+		t.Errorf("failed to discard draft, error: %v", err)
+		return
+	}
+	if _, err = s.Get(ctx, articleIndexTypes.Draft, draft.Id); err != ErrStoreNotFound {
+		t.Errorf("expecting %v, but got %v", ErrStoreNotFound, err)
+		return
+	}
+}
+
+func TestMemoryArticleStoreCopy(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryArticleStore(articleIndexTypes)
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	draft := &Article{
+		Id:            "a",
+		LockedBy:      "alice",
+		LockedAt:      &JSONTime{now},
+		LockHeartbeat: &JSONTime{now},
+		StateAt:       &JSONTime{now},
+	}
+	if _, err := s.CreateDraft(ctx, draft); err != nil {
+		t.Errorf("failed to create draft, error: %v", err)
+		return
+	}
+	// changing the times of the given/returned articles doesn't change
+	// the stored one
+	draft.LockedAt.T = now.Add(time.Hour)
+	got, _ := s.Get(ctx, articleIndexTypes.Draft, "a")
+	got.LockHeartbeat.T = now.Add(time.Hour)
+	got.StateAt.T = now.Add(time.Hour)
+	stored, _ := s.Get(ctx, articleIndexTypes.Draft, "a")
+	for name, jt := range map[string]*JSONTime{
+		"locked_at":      stored.LockedAt,
+		"lock_heartbeat": stored.LockHeartbeat,
+		"state_at":       stored.StateAt,
+	} {
+		if !jt.T.Equal(now) {
+			t.Errorf("expecting stored %v %v, but got %v", name, now, jt.T)
+		}
+	}
+}
+
+func TestMemoryArticleStoreSearch(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryArticleStore(articleIndexTypes)
+
+	now := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		jt := &JSONTime{now.Add(time.Duration(-i) * time.Minute)}
+		_, err := s.CreateDraft(ctx, &Article{Guid: "x", CreatedAt: jt})
+		if err != nil {
+			t.Errorf("failed to create draft, error: %v", err)
+			return
+		}
+	}
+
+	q := &ArticleQuery{
+		Types:        []string{articleIndexTypes.Draft},
+		CreatedAfter: now.Add(-time.Hour),
+		Size:         2,
+	}
+	seen := 0
+	var last *Article
+	for {
+		docs, err := s.Search(ctx, q)
+		if err != nil {
+			t.Errorf("failed to search, error: %v", err)
+			return
+		}
+		if len(docs) == 0 {
+			break
+		}
+		for _, doc := range docs {
+			if last != nil && doc.Article.CreatedAt.T.After(last.CreatedAt.T) {
+				t.Errorf("expecting created_at desc, but got %v after %v", doc.Article.CreatedAt.T, last.CreatedAt.T)
+				return
+			}
+			last = doc.Article
+			seen += 1
+		}
+		// cursor mark goes through json just like over http
+		bytes, _ := json.Marshal(docs[len(docs)-1].Sort)
+		q.SearchAfter = nil
+		json.Unmarshal(bytes, &q.SearchAfter)
+	}
+	if seen != 5 {
+		t.Errorf("expecting 5 articles, but got %v", seen)
+	}
+}
+
+func TestMemoryUserStoreUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryUserStore()
+
+	user := &CmsUser{Username: "alice", Password: "x", Role: CmsRoleArticleCreate}
+	if err := s.CreateUser(ctx, user); err != nil {
+		t.Errorf("failed to create user, error: %v", err)
+		return
+	}
+	if err := s.CreateUser(ctx, user); err != ErrStoreConflict {
+		t.Errorf("expecting %v, but got %v", ErrStoreConflict, err)
+		return
+	}
+	fields := map[string]interface{}{
+		"role": Role2Names(CmsRoleArticlePublish),
+	}
+	if err := s.UpdateUser(ctx, "alice", fields); err != nil {
+		t.Errorf("failed to update user, error: %v", err)
+		return
+	}
+	actual, err := s.GetUser(ctx, "alice")
+	if err != nil {
+		t.Errorf("failed to get user, error: %v", err)
+		return
+	}
+	if actual.Role != CmsRoleArticlePublish || actual.Password != "x" {
+		t.Errorf("unexpected updated user %+v", actual)
+		return
+	}
+}
+
+func TestMemoryArticleStoreTags(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryArticleStore(articleIndexTypes)
+
+	now := time.Now().UTC()
+	for i, tags := range [][]string{{"a", "b"}, {"b"}, {"b", "c", "b"}, nil} {
+		jt := &JSONTime{now.Add(time.Duration(-i) * time.Minute)}
+		guid := fmt.Sprintf("guid%v", i)
+		a := &Article{Id: guid, Guid: guid, Tag: tags, CreatedAt: jt, RevisedAt: jt}
+		if err := s.UpsertPublish(ctx, a); err != nil {
+			t.Errorf("failed to publish article, error: %v", err)
+			return
+		}
+	}
+
+	counts, err := s.CountTags(ctx, []string{articleIndexTypes.Draft, articleIndexTypes.Publish}, 2)
+	if err != nil {
+		t.Errorf("failed to count tags, error: %v", err)
+		return
+	}
+	if n := len(counts[articleIndexTypes.Draft]); n != 0 {
+		t.Errorf("expecting no draft tags, but got %v", n)
+	}
+	publish := counts[articleIndexTypes.Publish]
+	if len(publish) != 2 || *publish[0] != (TagCount{"b", 3}) || *publish[1] != (TagCount{"a", 1}) {
+		t.Errorf("unexpected publish tag counts %v", publish)
+	}
+
+	articles, err := s.ListPublished(ctx, 10, []string{"b"})
+	if err != nil || len(articles) != 3 {
+		t.Errorf("expecting 3 articles with tag b, but got %v (error: %v)", len(articles), err)
+	}
+	docs, err := s.Search(ctx, &ArticleQuery{
+		Types:        []string{articleIndexTypes.Publish},
+		CreatedAfter: now.Add(-time.Hour),
+		Tag:          []string{"a", "c"},
+	})
+	if err != nil || len(docs) != 2 {
+		t.Errorf("expecting 2 articles with tag a or c, but got %v (error: %v)", len(docs), err)
+	}
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	// document doesn't exist
+	ErrStoreNotFound = errors.New("not found")
+
+	// document exists but is locked by another user
+	ErrStoreLocked = errors.New("locked by another user")
+
+	// document to create already exists
+	ErrStoreConflict = errors.New("already exists")
+)
+
+// An article document along with its type (draft/version/publish)
+// and the sort values used to build the cursor mark.
+type ArticleDoc struct {
+	Type    string
+	Article *Article
+	Sort    []interface{}
+
+	// relevance score and highlight fragments (field --> fragments)
+	// of a full-text search hit
+	Score     float64
+	Highlight map[string][]string
+}
+
+// the same "_uid" elasticsearch uses as the tie-breaker sort
+func articleDocUid(typ, id string) string {
+	return fmt.Sprintf("%v#%v", typ, id)
+}
+
+// sort values of a doc as elasticsearch returns them (after json decoding)
+// for sorting by created_at desc then _uid asc
+func articleDocSort(typ string, a *Article) []interface{} {
+	var millis float64
+	if a.CreatedAt != nil {
+		millis = float64(a.CreatedAt.T.UnixNano() / 1000000)
+	}
+	return []interface{}{millis, articleDocUid(typ, a.Id)}
+}
+
+// returns true if sort values x come before y
+func articleDocSortLess(x, y []interface{}) bool {
+	xt, _ := x[0].(float64)
+	yt, _ := y[0].(float64)
+	if xt != yt {
+		return xt > yt
+	}
+	xu, _ := x[1].(string)
+	yu, _ := y[1].(string)
+	return xu < yu
+}
+
+// Returns a doc (without the article set) with its sort values if
+// the article matches the query, nil otherwise. Used by stores which
+// have to scan through all articles.
+func matchArticleQuery(q *ArticleQuery, typ string, a *Article) *ArticleDoc {
+	if a.Guid == "" || a.CreatedAt == nil || a.CreatedAt.T.Before(q.CreatedAfter) {
+		return nil
+	}
+	if len(q.Tag) > 0 && !hasAnyTag(a, q.Tag) {
+		return nil
+	}
+	sortValues := articleDocSort(typ, a)
+	if q.SearchAfter != nil && len(q.SearchAfter) == 2 && !articleDocSortLess(q.SearchAfter, sortValues) {
+		return nil
+	}
+	return &ArticleDoc{
+		Type: typ,
+		Sort: sortValues,
+	}
+}
+
+func sortArticleDocs(docs []*ArticleDoc, size int) []*ArticleDoc {
+	sort.Slice(docs, func(i, j int) bool {
+		return articleDocSortLess(docs[i].Sort, docs[j].Sort)
+	})
+	if size > 0 && len(docs) > size {
+		docs = docs[0:size]
+	}
+	return docs
+}
+
+// Number of articles with the tag
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int64  `json:"count"`
+}
+
+// Counts tags of the articles, returns the first size of them sorted
+// by count desc then tag. Used by stores which have to scan through all
+// articles.
+func countArticleTags(articles []*Article, size int) []*TagCount {
+	counts := make(map[string]int64)
+	for _, a := range articles {
+		seen := make(map[string]bool)
+		for _, t := range a.Tag {
+			if !seen[t] {
+				seen[t] = true
+				counts[t] += 1
+			}
+		}
+	}
+	result := make([]*TagCount, 0, len(counts))
+	for t, n := range counts {
+		result = append(result, &TagCount{Tag: t, Count: n})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Tag < result[j].Tag
+	})
+	if size > 0 && len(result) > size {
+		result = result[0:size]
+	}
+	return result
+}
+
+// version number of an article version, -1 if it isn't a number
+func articleVersionNumber(a *Article) int64 {
+	n, err := strconv.ParseInt(a.Version, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func sortArticlesByRevisedAt(articles []*Article, size int) []*Article {
+	sort.Slice(articles, func(i, j int) bool {
+		if articles[i].RevisedAt == nil {
+			return false
+		} else if articles[j].RevisedAt == nil {
+			return true
+		}
+		return articles[i].RevisedAt.T.After(articles[j].RevisedAt.T)
+	})
+	if size > 0 && len(articles) > size {
+		articles = articles[0:size]
+	}
+	return articles
+}
+
+// published (at or after since) articles sorted by published_at desc
+func recentlyPublishedArticles(articles []*Article, since time.Time, size int) []*Article {
+	recent := make([]*Article, 0)
+	for _, a := range articles {
+		if a.PublishedAt != nil && !a.PublishedAt.T.Before(since) {
+			recent = append(recent, a)
+		}
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].PublishedAt.T.After(recent[j].PublishedAt.T)
+	})
+	if size > 0 && len(recent) > size {
+		recent = recent[0:size]
+	}
+	return recent
+}
+
+// Returns true if both articles have the same (editable) content
+func sameArticleContent(a, b *Article) bool {
+	if a.Headline != b.Headline ||
+		a.Summary != b.Summary ||
+		a.Content != b.Content ||
+		!sameArticleBody(a.Body, b.Body) ||
+		a.ContentFormat != b.ContentFormat ||
+		!sameArticleMedia(a.LeadImage, b.LeadImage) ||
+		a.Note != b.Note ||
+		len(a.Tag) != len(b.Tag) {
+		return false
+	}
+	for i := 0; i < len(a.Tag); i++ {
+		if a.Tag[i] != b.Tag[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge (json) fields into the user just like a partial
+// document update does in elasticsearch
+func mergeUserFields(user *CmsUser, fields map[string]interface{}) (*CmsUser, error) {
+	bytes, err := json.Marshal(user)
+	if err != nil {
+		return nil, err
+	}
+	doc := make(map[string]interface{})
+	if err := json.Unmarshal(bytes, &doc); err != nil {
+		return nil, err
+	}
+	for k, v := range fields {
+		doc[k] = v
+	}
+	if bytes, err = json.Marshal(doc); err != nil {
+		return nil, err
+	}
+	updated := &CmsUser{}
+	if err := json.Unmarshal(bytes, updated); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Query used to list articles for the cms
+type ArticleQuery struct {
+	// article types to search in, draft/version/publish
+	Types []string
+
+	// only articles created at or after this time
+	CreatedAfter time.Time
+
+	// only articles with any of the tags, nil for all
+	Tag []string
+
+	// sort values of the last article from the previous page
+	SearchAfter []interface{}
+
+	// max number of articles to return
+	Size int
+}
+
+// Full-text query over the article headline and content
+type ArticleTextQuery struct {
+	// text to search for, "" to match all articles
+	Text string
+
+	// article types to search in, draft/version/publish
+	Types []string
+
+	// only articles with any of the tags
+	Tag []string
+
+	// only articles created/revised by the user
+	CreatedBy string
+	RevisedBy string
+
+	// only articles created/revised within the time range,
+	// zero time for no limit
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	RevisedFrom time.Time
+	RevisedTo   time.Time
+
+	// sort values of the last article from the previous page
+	SearchAfter []interface{}
+
+	// max number of articles to return
+	Size int
+}
+
+type ArticleSearchResult struct {
+	// number of all matched articles
+	Total int64
+
+	// articles of the page sorted by relevance score desc,
+	// created_at desc then _uid
+	Docs []*ArticleDoc
+}
+
+// ArticleStore persists article drafts, versions and published articles.
+//
+// Draft, version and publish are kept apart and identified by the type
+// name (see ArticleIndexTypes). A draft/publish id is the article guid,
+// a version id is "guid:version".
+type ArticleStore interface {
+
+	// Get an article of the given type by id
+	Get(ctx context.Context, typ, id string) (*Article, error)
+
+	// Create a draft, an id is generated when article.Id is empty,
+	// otherwise ErrStoreConflict is returned if the draft already exists
+	CreateDraft(ctx context.Context, article *Article) (*Article, error)
+
+	// Save draft content (guid, headline, summary, content, tag, note
+	// and revised_at) only if it is locked by the given user, returns
+	// ErrStoreLocked otherwise. The full saved draft is returned.
+	SaveDraft(ctx context.Context, username string, article *Article, waitForRefresh bool) (*Article, error)
+
+	// Set the draft lock (locked_by, locked_at and lock_heartbeat) to the
+	// given one if the draft is still locked by lockedBy, returns
+	// ErrStoreConflict otherwise. The content revision (rev) is left as
+	// is. The full updated draft is returned.
+	UpdateDraftLock(ctx context.Context, draft *Article, lockedBy string) (*Article, error)
+
+	// Set the review state (state, state_by, state_at and state_reason) of
+	// a version if it is still in the given state, returns ErrStoreConflict
+	// otherwise. The full updated version is returned.
+	UpdateVersionState(ctx context.Context, version *Article, from ArticleState) (*Article, error)
+
+	// Delete a draft only if it is locked by the given user,
+	// returns ErrStoreLocked otherwise
+	DiscardDraft(ctx context.Context, username, id string) error
+
+	// Delete a draft regardless of who locked it
+	DeleteDraft(ctx context.Context, id string) error
+
+	// Create a version, returns ErrStoreConflict if it already exists
+	CreateVersion(ctx context.Context, article *Article) error
+
+	// Create the version submitted from a draft then delete the draft
+	// (version.Guid), atomically if the store supports it. It is safe
+	// to retry: an existing version with the same content is not an
+	// error and neither is a draft which is already gone. Returns
+	// ErrStoreConflict if a version with different content exists.
+	SubmitDraft(ctx context.Context, version *Article) error
+
+	// Create or replace the published article (id is the article guid)
+	UpsertPublish(ctx context.Context, article *Article) error
+
+	// Delete the published article
+	DeletePublish(ctx context.Context, id string) error
+
+	// Search articles sorted by created_at desc
+	Search(ctx context.Context, q *ArticleQuery) ([]*ArticleDoc, error)
+
+	// Full-text search articles sorted by relevance, the highlight
+	// fragments are html-escaped with matched terms wrapped in <em>
+	TextSearch(ctx context.Context, q *ArticleTextQuery) (*ArticleSearchResult, error)
+
+	// Get all drafts/versions/publish of an article
+	GetByGuid(ctx context.Context, guid string) ([]*ArticleDoc, error)
+
+	// Get the latest (highest version number) version of an article,
+	// returns ErrStoreNotFound if it has none
+	GetLatestVersion(ctx context.Context, guid string) (*Article, error)
+
+	// Get the latest published articles sorted by revised_at desc,
+	// only those with any of the tags unless there is none
+	ListPublished(ctx context.Context, size int, tags []string) ([]*Article, error)
+
+	// Get at most size published articles with published_at at or after
+	// since, sorted by published_at desc
+	ListRecentlyPublished(ctx context.Context, since time.Time, size int) ([]*Article, error)
+
+	// Count tags of articles for each of the given types (type --> tag
+	// counts), at most size tags per type sorted by count desc
+	CountTags(ctx context.Context, types []string, size int) (map[string][]*TagCount, error)
+}
+
+// UserStore persists cms users.
+type UserStore interface {
+
+	// Get user by username
+	GetUser(ctx context.Context, username string) (*CmsUser, error)
+
+	// Create user, returns ErrStoreConflict if it already exists
+	CreateUser(ctx context.Context, user *CmsUser) error
+
+	// Partially update user with the given (json) fields
+	UpdateUser(ctx context.Context, username string, fields map[string]interface{}) error
+
+	// Delete user
+	DeleteUser(ctx context.Context, username string) error
+
+	// Get all users sorted by username
+	ListUsers(ctx context.Context) ([]*CmsUser, error)
+}
+
+type ScheduleQuery struct {
+	// only schedules of this article, "" for all articles
+	Guid string
+
+	// only schedules in this status, "" for all
+	Status ScheduleStatus
+
+	// only schedules due at or before this time, zero time for no limit
+	DueBefore time.Time
+
+	Size int
+}
+
+func matchScheduleQuery(q *ScheduleQuery, s *ArticleSchedule) bool {
+	if q.Guid != "" && s.Guid != q.Guid {
+		return false
+	}
+	if q.Status != "" && s.Status != q.Status {
+		return false
+	}
+	if !q.DueBefore.IsZero() && (s.At == nil || s.At.T.After(q.DueBefore)) {
+		return false
+	}
+	return true
+}
+
+// sorts schedules by due time asc and returns the first size of them
+func sortSchedules(schedules []*ArticleSchedule, size int) []*ArticleSchedule {
+	sort.SliceStable(schedules, func(i, j int) bool {
+		return schedules[i].At.T.Before(schedules[j].At.T)
+	})
+	if size > 0 && len(schedules) > size {
+		schedules = schedules[:size]
+	}
+	return schedules
+}
+
+// ScheduleStore persists scheduled publish/unpublish of articles.
+type ScheduleStore interface {
+
+	// Create schedule, the id is generated if not set
+	CreateSchedule(ctx context.Context, s *ArticleSchedule) (*ArticleSchedule, error)
+
+	// Get schedule by id
+	GetSchedule(ctx context.Context, id string) (*ArticleSchedule, error)
+
+	// Replace the schedule
+	UpdateSchedule(ctx context.Context, s *ArticleSchedule) error
+
+	// Search schedules sorted by due time (at) asc
+	ListSchedules(ctx context.Context, q *ScheduleQuery) ([]*ArticleSchedule, error)
+}
+
+func sortTaxonomyTerms(terms []*TaxonomyTerm) []*TaxonomyTerm {
+	sort.Slice(terms, func(i, j int) bool {
+		return terms[i].Id < terms[j].Id
+	})
+	return terms
+}
+
+// TaxonomyStore persists the managed tag/category vocabulary.
+type TaxonomyStore interface {
+
+	// Get term by id
+	GetTerm(ctx context.Context, id string) (*TaxonomyTerm, error)
+
+	// Create term, returns ErrStoreConflict if it already exists
+	CreateTerm(ctx context.Context, term *TaxonomyTerm) error
+
+	// Replace the term
+	UpdateTerm(ctx context.Context, term *TaxonomyTerm) error
+
+	// Delete term
+	DeleteTerm(ctx context.Context, id string) error
+
+	// Get all terms sorted by id
+	ListTerms(ctx context.Context) ([]*TaxonomyTerm, error)
+}
+
+// MediaStore persists the metadata of media assets, their content is kept
+// in a MediaStorage.
+type MediaStore interface {
+
+	// Get asset by id
+	GetMedia(ctx context.Context, id string) (*MediaAsset, error)
+
+	// Create asset, returns ErrStoreConflict if it already exists
+	CreateMedia(ctx context.Context, asset *MediaAsset) error
+
+	// Replace the asset
+	UpdateMedia(ctx context.Context, asset *MediaAsset) error
+
+	// Get the latest size assets sorted by created_at desc
+	ListMedia(ctx context.Context, size int) ([]*MediaAsset, error)
+}
+
+// SessionStore persists login sessions (see CmsSession).
+type SessionStore interface {
+
+	// Get session by id, expired sessions are not found
+	GetSession(ctx context.Context, id string) (*CmsSession, error)
+
+	// Create session, expired sessions of the user may be removed
+	CreateSession(ctx context.Context, session *CmsSession) error
+
+	// Replace the session, returns ErrStoreNotFound if it is gone
+	UpdateSession(ctx context.Context, session *CmsSession) error
+
+	// Delete session
+	DeleteSession(ctx context.Context, id string) error
+
+	// Delete all sessions of the user, returns how many are deleted
+	DeleteUserSessions(ctx context.Context, username string) (int, error)
+
+	// Get unexpired sessions of the user sorted by created_at desc
+	ListSessions(ctx context.Context, username string) ([]*CmsSession, error)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+type TaxonomyKind string
+
+const (
+	TaxonomyKindCategory TaxonomyKind = "category"
+	TaxonomyKindTag      TaxonomyKind = "tag"
+)
+
+// how long the taxonomy is cached for resolving tags, changes made through
+// another api server are picked up after it
+const taxonomyCacheTTL = time.Minute
+
+// A category or tag of the managed vocabulary. The id is the normalized
+// name and is what gets stored in the article tag field, synonyms are
+// normalized too and resolve to the id.
+type TaxonomyTerm struct {
+	Id        string       `json:"id"`
+	Name      string       `json:"name"`
+	Kind      TaxonomyKind `json:"kind"`
+	Parent    string       `json:"parent,omitempty"`
+	Synonyms  []string     `json:"synonyms"`
+	CreatedAt *JSONTime    `json:"created_at"`
+	CreatedBy string       `json:"created_by"`
+	RevisedAt *JSONTime    `json:"revised_at"`
+	RevisedBy string       `json:"revised_by"`
+}
+
+// Lower-cases the tag and turns each run of characters other than
+// letters and digits into a single "-", e.g. " Elections 2026" becomes
+// "elections-2026".
+func NormalizeTag(tag string) string {
+	parts := strings.FieldsFunc(strings.ToLower(tag), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(parts, "-")
+}
+
+// normalized, non-empty and distinct tags in their original order
+func normalizeTags(tags []string) []string {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, t := range tags {
+		if t = NormalizeTag(t); t != "" && !seen[t] {
+			seen[t] = true
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// Taxonomy resolves tags (and their synonyms) to terms
+type Taxonomy struct {
+	terms map[string]*TaxonomyTerm
+
+	// normalized id/synonym --> term id
+	lookup map[string]string
+}
+
+func NewTaxonomy(terms []*TaxonomyTerm) *Taxonomy {
+	t := &Taxonomy{
+		terms:  make(map[string]*TaxonomyTerm),
+		lookup: make(map[string]string),
+	}
+	for _, term := range terms {
+		t.terms[term.Id] = term
+		t.lookup[term.Id] = term.Id
+	}
+	// ids take precedence over synonyms
+	for _, term := range terms {
+		for _, s := range term.Synonyms {
+			if _, ok := t.lookup[s]; !ok {
+				t.lookup[s] = term.Id
+			}
+		}
+	}
+	return t
+}
+
+func loadTaxonomy(ctx context.Context, store TaxonomyStore) (*Taxonomy, error) {
+	terms, err := store.ListTerms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewTaxonomy(terms), nil
+}
+
+// loadTaxonomy from the cache if the store keeps one, for resolving tags
+// only, checks of term changes need the taxonomy as stored
+func loadCachedTaxonomy(ctx context.Context, store TaxonomyStore) (*Taxonomy, error) {
+	if c, ok := store.(*CachedTaxonomyStore); ok {
+		return c.Taxonomy(ctx)
+	}
+	return loadTaxonomy(ctx, store)
+}
+
+// TaxonomyStore which keeps the taxonomy loaded so that the tags of every
+// article save and tag filter aren't resolved against all terms listed
+// from the store again. It is dropped on term changes and after ttl.
+type CachedTaxonomyStore struct {
+	TaxonomyStore
+
+	ttl time.Duration
+
+	l        *sync.Mutex
+	taxonomy *Taxonomy
+	loadedAt time.Time
+
+	// bumped on term changes so that a load started before isn't cached
+	generation int64
+}
+
+func NewCachedTaxonomyStore(store TaxonomyStore, ttl time.Duration) *CachedTaxonomyStore {
+	return &CachedTaxonomyStore{
+		TaxonomyStore: store,
+		ttl:           ttl,
+		l:             &sync.Mutex{},
+	}
+}
+
+// Returns the cached taxonomy, or loads (and caches) it if it is not
+// there or expired. It is loaded without the lock held so that a slow
+// store doesn't hold up the requests which have it cached.
+func (s *CachedTaxonomyStore) Taxonomy(ctx context.Context) (*Taxonomy, error) {
+	s.l.Lock()
+	taxonomy, generation := s.taxonomy, s.generation
+	fresh := taxonomy != nil && time.Since(s.loadedAt) < s.ttl
+	s.l.Unlock()
+	if fresh {
+		return taxonomy, nil
+	}
+	now := time.Now()
+	taxonomy, err := loadTaxonomy(ctx, s.TaxonomyStore)
+	if err != nil {
+		return nil, err
+	}
+	s.l.Lock()
+	defer s.l.Unlock()
+	if s.generation == generation {
+		s.taxonomy, s.loadedAt = taxonomy, now
+	}
+	return taxonomy, nil
+}
+
+func (s *CachedTaxonomyStore) invalidate() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	s.taxonomy = nil
+	s.generation += 1
+}
+
+func (s *CachedTaxonomyStore) CreateTerm(ctx context.Context, term *TaxonomyTerm) error {
+	defer s.invalidate()
+	return s.TaxonomyStore.CreateTerm(ctx, term)
+}
+
+func (s *CachedTaxonomyStore) UpdateTerm(ctx context.Context, term *TaxonomyTerm) error {
+	defer s.invalidate()
+	return s.TaxonomyStore.UpdateTerm(ctx, term)
+}
+
+func (s *CachedTaxonomyStore) DeleteTerm(ctx context.Context, id string) error {
+	defer s.invalidate()
+	return s.TaxonomyStore.DeleteTerm(ctx, id)
+}
+
+// Returns the id of the term the tag (or its synonym) resolves to
+func (t *Taxonomy) Resolve(tag string) (string, bool) {
+	id, ok := t.lookup[NormalizeTag(tag)]
+	return id, ok
+}
+
+// Resolves the tags to term ids, tags not in the taxonomy are kept
+// normalized and returned as unknown as well
+func (t *Taxonomy) NormalizeTags(tags []string) ([]string, []string) {
+	result := make([]string, 0, len(tags))
+	unknown := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, tag := range normalizeTags(tags) {
+		id, ok := t.lookup[tag]
+		if !ok {
+			id = tag
+			unknown = append(unknown, tag)
+		}
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, id)
+		}
+	}
+	return result, unknown
+}
+
+// Tags to match articles on for the (filter) tags: the term ids they
+// resolve to (unknown ones normalized) and, as articles saved before the
+// taxonomy keep their tags as they were entered, the names and synonyms
+// of the terms and the tags as given.
+func (t *Taxonomy) MatchTags(tags []string) []string {
+	ids, _ := t.NormalizeTags(tags)
+	result := make([]string, 0, len(ids))
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	for _, id := range ids {
+		add(id)
+	}
+	for _, id := range ids {
+		if term, ok := t.terms[id]; ok {
+			add(term.Name)
+			for _, s := range term.Synonyms {
+				add(s)
+			}
+		}
+	}
+	for _, tag := range tags {
+		add(strings.TrimSpace(tag))
+	}
+	return result
+}
+
+// ids of the parent, the parent's parent and so on up to the root
+func (t *Taxonomy) Ancestors(id string) []string {
+	ancestors := make([]string, 0)
+	seen := map[string]bool{id: true}
+	for term := t.terms[id]; term != nil && term.Parent != ""; term = t.terms[term.Parent] {
+		if seen[term.Parent] { // broken hierarchy, shouldn't happen
+			break
+		}
+		seen[term.Parent] = true
+		ancestors = append(ancestors, term.Parent)
+	}
+	return ancestors
+}
+
+// ids of the terms whose parent is the given one, sorted
+func (t *Taxonomy) Children(id string) []string {
+	children := make([]string, 0)
+	for _, term := range t.terms {
+		if term.Parent == id {
+			children = append(children, term.Id)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// Checks a term to be created/updated (already normalized) against the
+// others: the parent must exist and not be the term itself or one of its
+// descendants, synonyms must not be used by other terms.
+func (t *Taxonomy) CheckTerm(term *TaxonomyTerm) error {
+	if term.Parent != "" {
+		if _, ok := t.terms[term.Parent]; !ok {
+			return fmt.Errorf("parent %v not found!", term.Parent)
+		}
+		if term.Parent == term.Id {
+			return fmt.Errorf("term %v can't be its own parent!", term.Id)
+		}
+		for _, a := range t.Ancestors(term.Parent) {
+			if a == term.Id {
+				return fmt.Errorf("parent %v is a descendant of term %v!", term.Parent, term.Id)
+			}
+		}
+	}
+	if id, ok := t.lookup[term.Id]; ok && id != term.Id {
+		return fmt.Errorf("%v is already a synonym of term %v!", term.Id, id)
+	}
+	for _, s := range term.Synonyms {
+		if s == term.Id {
+			continue
+		}
+		if id, ok := t.lookup[s]; ok && id != term.Id {
+			return fmt.Errorf("synonym %v is already used by term %v!", s, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	for tag, expected := range map[string]string{
+		"Elections":         "elections",
+		" Elections  2026 ": "elections-2026",
+		"elections_2026":    "elections-2026",
+		"U.S. -- Politics!": "u-s-politics",
+		"Ünïcode Tag":       "ünïcode-tag",
+		"***":               "",
+	} {
+		if n := NormalizeTag(tag); n != expected {
+			t.Errorf("expecting %q normalized to %q, but got %q", tag, expected, n)
+		}
+	}
+}
+
+func testTaxonomy() *Taxonomy {
+	return NewTaxonomy([]*TaxonomyTerm{
+		&TaxonomyTerm{Id: "news", Kind: TaxonomyKindCategory},
+		&TaxonomyTerm{Id: "politics", Kind: TaxonomyKindCategory, Parent: "news"},
+		&TaxonomyTerm{Id: "elections", Parent: "politics", Synonyms: []string{"election", "elections-2026"}},
+	})
+}
+
+func TestTaxonomyNormalizeTags(t *testing.T) {
+	taxonomy := testTaxonomy()
+	tags, unknown := taxonomy.NormalizeTags([]string{"Elections", "election", "Elections 2026", "Sports", "politics"})
+	if strings.Join(tags, ",") != "elections,sports,politics" {
+		t.Errorf("unexpected normalized tags %v", tags)
+	}
+	if strings.Join(unknown, ",") != "sports" {
+		t.Errorf("unexpected unknown tags %v", unknown)
+	}
+	if a := taxonomy.Ancestors("elections"); strings.Join(a, ",") != "politics,news" {
+		t.Errorf("unexpected ancestors %v", a)
+	}
+	if c := taxonomy.Children("news"); strings.Join(c, ",") != "politics" {
+		t.Errorf("unexpected children %v", c)
+	}
+}
+
+func TestTaxonomyCheckTerm(t *testing.T) {
+	taxonomy := testTaxonomy()
+	for _, term := range []*TaxonomyTerm{
+		&TaxonomyTerm{Id: "sports", Parent: "unknown"},
+		&TaxonomyTerm{Id: "news", Parent: "news"},
+		&TaxonomyTerm{Id: "news", Parent: "elections"}, // cycle
+		&TaxonomyTerm{Id: "voting", Synonyms: []string{"election"}},
+		&TaxonomyTerm{Id: "election"},
+	} {
+		if err := taxonomy.CheckTerm(term); err == nil {
+			t.Errorf("expecting term %+v rejected, but it isn't", term)
+		}
+	}
+	for _, term := range []*TaxonomyTerm{
+		&TaxonomyTerm{Id: "sports", Parent: "news", Synonyms: []string{"sport"}},
+		&TaxonomyTerm{Id: "elections", Parent: "news", Synonyms: []string{"election"}},
+	} {
+		if err := taxonomy.CheckTerm(term); err != nil {
+			t.Errorf("expecting term %+v accepted, but got %v", term, err)
+		}
+	}
+}
+
+func TestNormalizeArticleTags(t *testing.T) {
+	app := &AppRuntime{
+		Conf:     &AppConf{},
+		Taxonomy: NewMemoryTaxonomyStore(),
+	}
+	logger := NewJsonLogger(ioutil.Discard)
+	term := &TaxonomyTerm{Id: "elections", Synonyms: []string{"election"}}
+	if err := app.Taxonomy.CreateTerm(context.Background(), term); err != nil {
+		t.Errorf("failed to create term, error: %v", err)
+		return
+	}
+
+	article := &Article{Tag: []string{"Election", "Sports"}}
+	if d := normalizeArticleTags(app, article, logger); d != nil {
+		t.Errorf("expecting unknown tags allowed, but got %v", d.Status)
+		return
+	}
+	if strings.Join(article.Tag, ",") != "elections,sports" {
+		t.Errorf("unexpected normalized tags %v", article.Tag)
+	}
+
+	app.Conf.StrictTags = true
+	article = &Article{Tag: []string{"Election", "Sports"}}
+	if d := normalizeArticleTags(app, article, logger); d == nil || d.Status != http.StatusBadRequest {
+		t.Errorf("expecting unknown tags rejected, but got %v", d)
+	}
+	article = &Article{Tag: []string{"Election"}}
+	if d := normalizeArticleTags(app, article, logger); d != nil || article.Tag[0] != "elections" {
+		t.Errorf("expecting known tags accepted, but got %v (tags: %v)", d, article.Tag)
+	}
+}
+
+func TestResolveQueryTags(t *testing.T) {
+	app := &AppRuntime{
+		Conf:     &AppConf{},
+		Taxonomy: NewMemoryTaxonomyStore(),
+	}
+	logger := NewJsonLogger(ioutil.Discard)
+	term := &TaxonomyTerm{Id: "elections", Name: "Elections", Synonyms: []string{"election"}}
+	if err := app.Taxonomy.CreateTerm(context.Background(), term); err != nil {
+		t.Errorf("failed to create term, error: %v", err)
+		return
+	}
+
+	// the ids first, then the raw forms articles saved before the taxonomy may have
+	tags, d := resolveQueryTags(app, []string{"Election", "elections", " Sports "}, logger)
+	if d != nil || strings.Join(tags, ",") != "elections,sports,Elections,election,Election,Sports" {
+		t.Errorf("unexpected resolved tags %v (response: %v)", tags, d)
+	}
+	// unknown tags aren't rejected even with strict tags
+	app.Conf.StrictTags = true
+	if tag, tags, d := resolveQueryTag(app, "Sports", logger); d != nil || tag != "sports" || strings.Join(tags, ",") != "sports,Sports" {
+		t.Errorf("unexpected resolved tag %v %v (response: %v)", tag, tags, d)
+	}
+	if tag, _, d := resolveQueryTag(app, "ELECTION", logger); d != nil || tag != "elections" {
+		t.Errorf("unexpected resolved tag %v (response: %v)", tag, d)
+	}
+	if tag, tags, d := resolveQueryTag(app, "", logger); d != nil || tag != "" || tags != nil {
+		t.Errorf("unexpected resolved tag %v %v (response: %v)", tag, tags, d)
+	}
+}
+
+func TestHasAnyTagRaw(t *testing.T) {
+	taxonomy := NewTaxonomy([]*TaxonomyTerm{{Id: "elections", Name: "Elections", Synonyms: []string{"election"}}})
+	tags := taxonomy.MatchTags([]string{"elections"})
+	for _, raw := range [][]string{{"elections"}, {"Elections"}, {"ELECTION"}, {"sports", " election "}} {
+		if !hasAnyTag(&Article{Tag: raw}, tags) {
+			t.Errorf("expecting article tags %v matched by %v", raw, tags)
+		}
+	}
+	if hasAnyTag(&Article{Tag: []string{"Sports"}}, tags) {
+		t.Errorf("expecting article tag Sports not matched by %v", tags)
+	}
+}
+
+func TestCachedTaxonomyStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewCachedTaxonomyStore(NewMemoryTaxonomyStore(), time.Hour)
+	app := &AppRuntime{Conf: &AppConf{}, Taxonomy: store}
+	logger := NewJsonLogger(ioutil.Discard)
+	resolve := func(tag string) string {
+		article := &Article{Tag: []string{tag}}
+		if d := normalizeArticleTags(app, article, logger); d != nil {
+			t.Fatalf("failed to normalize tag %v, status %v", tag, d.Status)
+		}
+		return article.Tag[0]
+	}
+	if tag := resolve("Election"); tag != "election" {
+		t.Errorf("expecting election without a term, but got %v", tag)
+	}
+	taxonomy, _ := store.Taxonomy(ctx)
+	if cached, _ := store.Taxonomy(ctx); cached != taxonomy {
+		t.Errorf("expecting the taxonomy cached")
+	}
+	// term changes drop the cache
+	term := &TaxonomyTerm{Id: "elections", Synonyms: []string{"election"}}
+	if err := store.CreateTerm(ctx, term); err != nil {
+		t.Fatalf("failed to create term, error: %v", err)
+	}
+	if tag := resolve("Election"); tag != "elections" {
+		t.Errorf("expecting elections after the term is created, but got %v", tag)
+	}
+	term.Synonyms = []string{}
+	if err := store.UpdateTerm(ctx, term); err != nil {
+		t.Fatalf("failed to update term, error: %v", err)
+	}
+	if tag := resolve("Election"); tag != "election" {
+		t.Errorf("expecting election after the synonym is removed, but got %v", tag)
+	}
+	if err := store.DeleteTerm(ctx, "elections"); err != nil {
+		t.Fatalf("failed to delete term, error: %v", err)
+	}
+	if taxonomy, _ := store.Taxonomy(ctx); len(taxonomy.terms) != 0 {
+		t.Errorf("expecting no terms after the term is deleted, but got %v", taxonomy.terms)
+	}
+}
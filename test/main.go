@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
@@ -74,7 +75,11 @@ func RunTestCaseGroup(grp TestCaseGroup) (int, int, int) {
 }
 
 func GetAuthToken(client *http.Client, host, username, password string) (string, error) {
-	resp, err := client.Get(fmt.Sprintf("http://%s/api/login?username=%s&password=%s", host, username, password))
+	body, err := json.Marshal(map[string]string{"username": username, "password": password})
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Post(fmt.Sprintf("http://%s/api/login", host), "application/json", bytes.NewReader(body))
 	if err != nil {
 		return "", err
 	}
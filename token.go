@@ -0,0 +1,11 @@
+package main
+
+const RefreshTokenCookieName = "refresh-token"
+
+// Payload of a refresh token. It is encoded with its own securecookie
+// (under a different name) so that it can't be used as an access token
+// and vice versa.
+type RefreshToken struct {
+	Session  string `json:"session"`
+	Username string `json:"username"`
+}